@@ -0,0 +1,143 @@
+package domaindb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"syscall"
+)
+
+// archiveVersion is the version of the format written by ExportAll.
+// It must be incremented whenever the format changes incompatibly.
+const archiveVersion = 1
+
+// archive is the format written by ExportAll and read by ImportAll.
+type archive struct {
+	// The version of the format.
+	Version int `json:"version"`
+
+	// The checkpoints of the exported databases.
+	Checkpoints map[string]Checkpoint `json:"checkpoints"`
+
+	// The cached copies of the exported databases, decompressed if they were stored compressed.
+	Databases map[string]string `json:"databases"`
+}
+
+// ExportAll writes the cached copies of all databases and their checkpoints to w as a single JSON document.
+// The result can be restored with ImportAll, including into a DomainDb with a different StorageDriver, which makes it useful for backups and for migrating between storage backends.
+//
+// Databases without a cached copy are skipped, as are category databases, since they are part of their source database.
+// Each database is read into memory in full while it is exported.
+//
+// If the DomainDb instance has been closed, returns ErrDbClosed.
+func (s *DomainDb) ExportAll(w io.Writer) error {
+	if !s.isRunning.Load() {
+		return ErrDbClosed
+	}
+
+	a := archive{
+		Version:     archiveVersion,
+		Checkpoints: make(map[string]Checkpoint),
+		Databases:   make(map[string]string),
+	}
+
+	for _, name := range s.dbOrder {
+		reader, err := s.readCache(name)
+		if err != nil {
+			if errors.Is(err, syscall.ENOENT) {
+				continue
+			}
+			return fmt.Errorf(`failed to read cached copy of database with name "%s" for export: %w`, name, err)
+		}
+
+		content, err := io.ReadAll(reader)
+		_ = reader.Close()
+		if err != nil {
+			return fmt.Errorf(`failed to read cached copy of database with name "%s" for export: %w`, name, err)
+		}
+
+		a.Databases[name] = string(content)
+	}
+
+	s.checkpointsMu.Lock()
+	for name := range a.Databases {
+		if chkPnt, has := s.checkpoints.Checkpoints[name]; has {
+			a.Checkpoints[name] = chkPnt
+		}
+	}
+	s.checkpointsMu.Unlock()
+
+	if err := json.NewEncoder(w).Encode(a); err != nil {
+		return fmt.Errorf("failed to write export: %w", err)
+	}
+
+	return nil
+}
+
+// ImportAll reads a JSON document written by ExportAll from r, and restores the databases and checkpoints in it.
+// Each database is loaded into memory, written to storage as its cached copy, and has its checkpoint restored.
+// Updaters that are already scheduled keep their schedule; the restored checkpoints take effect the next time the DomainDb is created.
+//
+// Databases in the archive that are not configured in this DomainDb are skipped with a warning.
+// A database that fails to load is not written to storage and keeps its current contents; errors for individual databases do not stop the remaining databases from being imported, and are joined and returned together.
+// The whole archive is read into memory before anything is imported.
+//
+// If the archive is malformed or has an unsupported version, returns an error without importing anything.
+// If the DomainDb instance has been closed, returns ErrDbClosed.
+func (s *DomainDb) ImportAll(r io.Reader) error {
+	if !s.isRunning.Load() {
+		return ErrDbClosed
+	}
+
+	var a archive
+	if err := json.NewDecoder(r).Decode(&a); err != nil {
+		return fmt.Errorf("failed to read import: %w", err)
+	}
+	if a.Version != archiveVersion {
+		return fmt.Errorf("unsupported import version %d (expected %d)", a.Version, archiveVersion)
+	}
+
+	var errs []error
+	imported := make([]string, 0, len(a.Databases))
+
+	for _, name := range sortedNames(a.Databases) {
+		data, has := s.dbs[name]
+		if !has || data.Parent != "" {
+			s.logger.Log(context.Background(), slog.LevelWarn, "skipping import of database that is not configured",
+				"database_name", name,
+			)
+			continue
+		}
+
+		// Load first, so that a malformed database does not replace a good cached copy.
+		if err := s.loadDomainsFromReader(strings.NewReader(a.Databases[name]), name, LoadSourceCache); err != nil {
+			errs = append(errs, fmt.Errorf(`failed to load imported database with name "%s": %w`, name, err))
+			continue
+		}
+
+		if err := s.writeCache(name, io.NopCloser(strings.NewReader(a.Databases[name]))); err != nil {
+			errs = append(errs, fmt.Errorf(`failed to write imported database with name "%s" to storage: %w`, name, err))
+			continue
+		}
+
+		imported = append(imported, name)
+	}
+
+	s.checkpointsMu.Lock()
+	for _, name := range imported {
+		if chkPnt, has := a.Checkpoints[name]; has {
+			s.checkpoints.Checkpoints[name] = chkPnt
+		}
+	}
+	err := s.storage.WriteCheckpoints(s.checkpoints)
+	s.checkpointsMu.Unlock()
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to save checkpoints after import: %w", err))
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,254 @@
+package domaindb
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"slices"
+)
+
+// ArchiveFormat is the archive format of a data source whose list is bundled in an archive.
+type ArchiveFormat int
+
+const (
+	// ArchiveFormatNone means the source is not an archive.
+	ArchiveFormatNone ArchiveFormat = iota
+
+	// ArchiveFormatTar means the source is a tar archive.
+	// Compressed tar archives, such as ".tar.gz" files, are supported with DataSource.Compression.
+	ArchiveFormatTar
+
+	// ArchiveFormatZip means the source is a zip archive.
+	// Zip archives cannot be read as a stream, so each download is written to a temporary file in Options.TempDir before it is extracted.
+	ArchiveFormatZip
+)
+
+func (f ArchiveFormat) String() string {
+	switch f {
+	case ArchiveFormatNone:
+		return "none"
+	case ArchiveFormatTar:
+		return "tar"
+	case ArchiveFormatZip:
+		return "zip"
+	default:
+		return "unknown"
+	}
+}
+
+// maxTarPaddingSize is the maximum number of bytes read after the end-of-archive marker of a tar archive.
+// Archives are padded to a multiple of the record size, which is 10240 bytes by default, so this leaves plenty of room for archives with larger records.
+// Anything beyond it is left unread, so a download with trailing data still fails the Content-Length check.
+const maxTarPaddingSize = 1 << 20
+
+// validateArchiveMembers returns an error if the data source has an archive format but its archive member patterns are missing or invalid.
+func validateArchiveMembers(src *DataSource) error {
+	if src.Archive == ArchiveFormatNone {
+		return nil
+	}
+
+	if len(src.ArchiveMembers) == 0 {
+		return errors.New("data source has an archive format but no archive members")
+	}
+	for _, pattern := range src.ArchiveMembers {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf(`invalid archive member pattern "%s": %w`, pattern, err)
+		}
+	}
+
+	return nil
+}
+
+// matchesArchiveMember returns whether the archive member path matches any of the patterns.
+// Patterns are validated when the DomainDb is created, so match errors are ignored.
+func matchesArchiveMember(patterns []string, name string) bool {
+	return slices.ContainsFunc(patterns, func(pattern string) bool {
+		matched, _ := path.Match(pattern, name)
+		return matched
+	})
+}
+
+// extractArchive returns a reader that reads the members of the archive read from r that match DataSource.ArchiveMembers, concatenated in archive order.
+// A newline is inserted between members that do not end in one, so that the last line of a member is not joined with the first line of the next.
+// If no members match, reading fails with ErrNoArchiveMembers once the whole archive has been read.
+// Closing the returned reader releases the archive's resources, but does not close r.
+func (s *DomainDb) extractArchive(r io.Reader, src *DataSource) (io.ReadCloser, error) {
+	switch src.Archive {
+	case ArchiveFormatNone:
+		return noOpReadCloser{r}, nil
+	case ArchiveFormatTar:
+		tr := tar.NewReader(r)
+		return &archiveMemberReader{
+			next: func() (io.Reader, error) {
+				for {
+					header, err := tr.Next()
+					if err == io.EOF {
+						// Tar archives are padded to a whole record after the end-of-archive marker, which the tar reader does not read.
+						// Read it, so that the whole download is consumed and can be checked against its Content-Length.
+						if _, err = io.CopyN(io.Discard, r, maxTarPaddingSize); err != nil && err != io.EOF {
+							return nil, fmt.Errorf("failed to read tar archive padding: %w", err)
+						}
+						return nil, io.EOF
+					}
+					if err != nil {
+						return nil, err
+					}
+					if header.Typeflag == tar.TypeReg && matchesArchiveMember(src.ArchiveMembers, header.Name) {
+						return tr, nil
+					}
+				}
+			},
+		}, nil
+	case ArchiveFormatZip:
+		return s.extractZip(r, src.ArchiveMembers)
+	default:
+		return nil, fmt.Errorf("unknown archive format %d", src.Archive)
+	}
+}
+
+// extractArchiveOwned is like extractArchive, but closing the returned reader also closes r.
+// If it fails, r is closed.
+func (s *DomainDb) extractArchiveOwned(r io.ReadCloser, src *DataSource) (io.ReadCloser, error) {
+	if src.Archive == ArchiveFormatNone {
+		return r, nil
+	}
+
+	reader, err := s.extractArchive(r, src)
+	if err != nil {
+		_ = r.Close()
+		return nil, err
+	}
+
+	return &decompressReadCloser{ReadCloser: reader, underlying: r}, nil
+}
+
+// extractZip is the ArchiveFormatZip case of extractArchive.
+// The archive is written to a temporary file first, since reading a zip archive requires random access.
+func (s *DomainDb) extractZip(r io.Reader, patterns []string) (io.ReadCloser, error) {
+	tmpFile, err := os.CreateTemp(s.tempDir, "domaindb-*.zip")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary file for zip archive: %w", err)
+	}
+	closeTmp := func() error {
+		closeErr := tmpFile.Close()
+		_ = os.Remove(tmpFile.Name())
+		return closeErr
+	}
+
+	size, err := io.Copy(tmpFile, r)
+	if err != nil {
+		_ = closeTmp()
+		return nil, fmt.Errorf("failed to write zip archive to temporary file: %w", err)
+	}
+
+	zr, err := zip.NewReader(tmpFile, size)
+	if err != nil {
+		_ = closeTmp()
+		return nil, fmt.Errorf("failed to read zip archive: %w", err)
+	}
+
+	files := zr.File
+	var member io.ReadCloser
+	return &archiveMemberReader{
+		next: func() (io.Reader, error) {
+			if member != nil {
+				_ = member.Close()
+				member = nil
+			}
+
+			for len(files) > 0 {
+				file := files[0]
+				files = files[1:]
+
+				if !file.Mode().IsRegular() || !matchesArchiveMember(patterns, file.Name) {
+					continue
+				}
+
+				opened, err := file.Open()
+				if err != nil {
+					return nil, fmt.Errorf(`failed to open zip archive member "%s": %w`, file.Name, err)
+				}
+				member = opened
+				return member, nil
+			}
+
+			return nil, io.EOF
+		},
+		close: func() error {
+			if member != nil {
+				_ = member.Close()
+			}
+			return closeTmp()
+		},
+	}, nil
+}
+
+// archiveMemberReader concatenates the matching members of an archive.
+type archiveMemberReader struct {
+	// next returns the next matching member, or io.EOF if there are no more.
+	next func() (io.Reader, error)
+
+	// close releases the archive's resources, or is nil if there are none.
+	close func() error
+
+	cur     io.Reader
+	matched int
+
+	// The last byte read from the current member, or 0 if nothing has been read from it.
+	last byte
+
+	err error
+}
+
+func (r *archiveMemberReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	for r.err == nil {
+		if r.cur == nil {
+			member, err := r.next()
+			if err == io.EOF && r.matched == 0 {
+				err = ErrNoArchiveMembers
+			}
+			if err != nil {
+				r.err = err
+				break
+			}
+
+			r.cur = member
+			r.matched++
+			r.last = 0
+		}
+
+		n, err := r.cur.Read(p)
+		if n > 0 {
+			r.last = p[n-1]
+			return n, nil
+		}
+		if err == io.EOF {
+			r.cur = nil
+			if r.last != 0 && r.last != '\n' {
+				p[0] = '\n'
+				return 1, nil
+			}
+			continue
+		}
+		if err != nil {
+			r.err = err
+		}
+	}
+
+	return 0, r.err
+}
+
+func (r *archiveMemberReader) Close() error {
+	if r.close == nil {
+		return nil
+	}
+	return r.close()
+}
@@ -0,0 +1,207 @@
+package domaindb
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ReadRawDatabase opens the cached copy of the database with the specified name, exactly as it is stored, without parsing it.
+// This is useful for debugging, since it does not require knowing how the storage driver names or stores its files.
+// If Options.CompressCache is true, the cached copy may be compressed with zstd.
+// The caller must close the returned reader.
+// If the database does not exist, returns a NoSuchDatabaseError.
+// If there is no cached copy of the database, returns an error wrapping syscall.ENOENT.
+// If the DomainDb instance has been closed, returns ErrDbClosed.
+func (s *DomainDb) ReadRawDatabase(name string) (io.ReadCloser, error) {
+	if !s.isRunning.Load() {
+		return nil, ErrDbClosed
+	}
+
+	if _, has := s.dbs[name]; !has {
+		return nil, NewNoSuchDatabaseError(name)
+	}
+
+	reader, err := s.storage.ReadDatabase(name)
+	if err != nil {
+		return nil, fmt.Errorf(`failed to read cached copy of database with name "%s": %w`, name, err)
+	}
+
+	return reader, nil
+}
+
+// ClearCache deletes the cached copy of the database with the specified name and resets its checkpoint.
+// Changed URLs are detected automatically (see Options.KeepCacheOnSourceChange), but changes to a Get method's implementation are not, so call this after making them.
+// The loaded domains are kept in memory, and scheduled updates are not affected.
+// The next time the database is initialized, it is downloaded rather than loaded from cache, and the next call to DownloadAndLoadDatabase is not skipped because of DataSource.MinDownloadInterval.
+// If the database does not exist, returns a NoSuchDatabaseError.
+// If the database is a category database (see DataSource.Categories), returns an error wrapping ErrCategoryDatabase.
+// If the storage driver does not implement DatabaseDeleter, returns an error wrapping ErrUnsupportedByStorageDriver.
+// If the DomainDb instance has been closed, returns ErrDbClosed.
+func (s *DomainDb) ClearCache(name string) error {
+	if !s.isRunning.Load() {
+		return ErrDbClosed
+	}
+
+	data, has := s.dbs[name]
+	if !has {
+		return NewNoSuchDatabaseError(name)
+	}
+	if err := checkNotCategoryDb(name, data); err != nil {
+		return err
+	}
+	deleter, err := s.databaseDeleter()
+	if err != nil {
+		return err
+	}
+
+	if err := s.clearCache(deleter, name, data); err != nil {
+		return err
+	}
+
+	return s.saveClearedCheckpoints()
+}
+
+// ClearAllCache is like ClearCache, but clears the cached copies of all databases.
+// Category databases share the cache of their parent database, so they are cleared along with it.
+// All databases are cleared even if some fail, and the errors are joined.
+// If the storage driver does not implement DatabaseDeleter, returns an error wrapping ErrUnsupportedByStorageDriver.
+// If the DomainDb instance has been closed, returns ErrDbClosed.
+func (s *DomainDb) ClearAllCache() error {
+	if !s.isRunning.Load() {
+		return ErrDbClosed
+	}
+
+	deleter, err := s.databaseDeleter()
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	// Category databases are not in dbOrder.
+	for _, name := range s.dbOrder {
+		if err := s.clearCache(deleter, name, s.dbs[name]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if err := s.saveClearedCheckpoints(); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
+
+// databaseDeleter returns the storage driver as a DatabaseDeleter, or an error wrapping ErrUnsupportedByStorageDriver if it does not implement it.
+func (s *DomainDb) databaseDeleter() (DatabaseDeleter, error) {
+	deleter, ok := s.storage.(DatabaseDeleter)
+	if !ok {
+		return nil, fmt.Errorf("storage driver %T does not implement DatabaseDeleter: %w", s.storage, ErrUnsupportedByStorageDriver)
+	}
+
+	return deleter, nil
+}
+
+// clearCache deletes the cached copy of a database and removes its checkpoint, without saving the checkpoints.
+func (s *DomainDb) clearCache(deleter DatabaseDeleter, name string, data *dbSrcMap) error {
+	if err := deleter.DeleteDatabase(name); err != nil {
+		return fmt.Errorf(`failed to delete cached copy of database with name "%s": %w`, name, err)
+	}
+
+	s.checkpointsMu.Lock()
+	delete(s.checkpoints.Checkpoints, name)
+	s.checkpointsMu.Unlock()
+
+	data.LastDownloadNano.Store(0)
+
+	return nil
+}
+
+// saveClearedCheckpoints writes the checkpoints to storage after caches have been cleared.
+func (s *DomainDb) saveClearedCheckpoints() error {
+	s.checkpointsMu.Lock()
+	err := s.storage.WriteCheckpoints(s.checkpoints)
+	s.checkpointsMu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to save checkpoints after clearing cache: %w", err)
+	}
+
+	return nil
+}
+
+// ReadCachedDatabases reads every cached database in storage, including ones that are not configured, and returns them as a read-only snapshot.
+// This is meant for debugging and migrating data, such as inspecting caches left over from a previous configuration; it is separate from the configured databases.
+// The databases in the DomainDb are not changed, nothing is written to storage, and no updates are scheduled for the returned databases.
+//
+// Cached databases that are configured are parsed with their DataSource parsing options, including their categories, so the snapshot also has their category databases.
+// Cached databases that are not configured are parsed as domain lists with the default options.
+// The snapshot uses the DomainDb's normalizer and Options.DomainTransform for lookups, but not temporary overrides, and all of its databases are enabled.
+//
+// Databases that fail to read or parse are left out of the snapshot, and their errors are joined and returned along with it.
+// If the storage driver does not implement DatabaseLister, returns an error wrapping ErrUnsupportedByStorageDriver.
+// If the DomainDb instance has been closed, returns ErrDbClosed.
+func (s *DomainDb) ReadCachedDatabases() (*DomainDbSnapshot, error) {
+	if !s.isRunning.Load() {
+		return nil, ErrDbClosed
+	}
+
+	lister, ok := s.storage.(DatabaseLister)
+	if !ok {
+		return nil, fmt.Errorf("storage driver %T does not implement DatabaseLister: %w", s.storage, ErrUnsupportedByStorageDriver)
+	}
+
+	names, err := lister.ListDatabases()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list cached databases: %w", err)
+	}
+
+	snapshot := &DomainDbSnapshot{
+		normalizer: s.normalizer,
+		transform:  s.transform,
+		takenAt:    time.Now(),
+
+		lookupCache: s.lookupCache,
+
+		dbs: make(map[string]snapshotDb, len(names)),
+	}
+
+	var errs []error
+	for _, name := range names {
+		if err := s.readCachedDatabase(name, snapshot.dbs); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return snapshot, errors.Join(errs...)
+}
+
+// readCachedDatabase parses the cached copy of the database with the specified name and adds it to dbs, along with its category databases.
+// The database is parsed into a detached dbSrcMap, so the DomainDb's databases are not affected.
+func (s *DomainDb) readCachedDatabase(name string, dbs map[string]snapshotDb) error {
+	src := &DataSource{}
+	if configured, has := s.dbs[name]; has && configured.Parent == "" {
+		src = configured.Src
+	}
+	data := newDetachedDbSrcMap(name, src)
+
+	reader, err := s.readCache(name)
+	if err != nil {
+		return fmt.Errorf(`failed to read cached database with name "%s": %w`, name, err)
+	}
+	defer func() {
+		_ = reader.Close()
+	}()
+
+	builder, _, err := s.parseDomains(reader, name, data)
+	if err != nil {
+		return fmt.Errorf(`failed to parse cached database with name "%s": %w`, name, err)
+	}
+
+	dbs[name] = snapshotDb{Has: true, Negate: data.Src.Negate, Domains: builder.domains}
+	for category, domains := range builder.categories {
+		dbs[categoryDbName(name, category)] = snapshotDb{Has: true, Negate: data.Src.Negate, Domains: domains}
+	}
+
+	return nil
+}
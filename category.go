@@ -0,0 +1,50 @@
+package domaindb
+
+import (
+	"fmt"
+	"strings"
+)
+
+// categorySeparator separates the name of a source database from a category in the names of category databases.
+const categorySeparator = "/"
+
+// categoryDbName returns the name of the category database for a category of the source database with the specified name.
+func categoryDbName(name string, category string) string {
+	return name + categorySeparator + category
+}
+
+// ParseCommaCategory parses a line in the form "domain,category", which is the default format for sources with DataSource.Categories.
+// Whitespace around the domain and category is removed.
+// Lines without a comma have an empty category.
+func ParseCommaCategory(line string) (domain string, category string) {
+	domain, category, _ = strings.Cut(line, ",")
+	return strings.TrimSpace(domain), strings.TrimSpace(category)
+}
+
+// parseCategory splits a line into its domain and category using the source's CategoryParser, or ParseCommaCategory if it is nil.
+func (src *DataSource) parseCategory(line string) (string, string) {
+	if src.CategoryParser != nil {
+		return src.CategoryParser(line)
+	}
+
+	return ParseCommaCategory(line)
+}
+
+// checkCategoriesWritable returns an error wrapping ErrCategoriesNotWritable if the in-memory contents of the database with the specified name cannot be written to storage without losing its categories.
+func checkCategoriesWritable(name string, src *DataSource) error {
+	if len(src.Categories) > 0 && src.CategoryParser != nil {
+		return fmt.Errorf(`database with name "%s" has a custom DataSource.CategoryParser: %w`, name, ErrCategoriesNotWritable)
+	}
+
+	return nil
+}
+
+// checkNotCategoryDb returns an error wrapping ErrCategoryDatabase if the database is a category database.
+// Category databases are loaded along with their source database, so they cannot be loaded on their own.
+func checkNotCategoryDb(name string, data *dbSrcMap) error {
+	if data.Parent != "" {
+		return fmt.Errorf(`database "%s" is a category of database "%s", load that database instead: %w`, name, data.Parent, ErrCategoryDatabase)
+	}
+
+	return nil
+}
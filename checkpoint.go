@@ -1,10 +1,23 @@
 package domaindb
 
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"slices"
+)
+
 // Checkpoint is checkpoint information for a database.
 type Checkpoint struct {
 	// When the database was last updated from source.
 	// Unix epoch second timestamp.
 	LastUpdatedUnix int64 `json:"last_updated_unix"`
+
+	// The fingerprint of the data source configuration that the cached copy was downloaded with.
+	// It is used to detect sources that changed since the database was cached (see Options.KeepCacheOnSourceChange).
+	// Empty if the checkpoint was saved by a version that did not record fingerprints.
+	SourceFingerprint string `json:"source_fingerprint,omitempty"`
 }
 
 // AllCheckpoints is information for all database checkpoints.
@@ -13,3 +26,33 @@ type AllCheckpoints struct {
 	// Key is the database name, value is the checkpoint.
 	Checkpoints map[string]Checkpoint `json:"checkpoints"`
 }
+
+// sourceFingerprint returns a hash of the parts of a data source that determine where its data comes from.
+// These are its URLs (regardless of order), URL mode, method, body, archive members and whether it has a Get method.
+// Options that only change how the data is parsed, such as comment handling, are not included, since caches store the data unparsed.
+func sourceFingerprint(src *DataSource) string {
+	urls := make([]string, 0, len(src.Urls))
+	for _, srcUrl := range src.Urls {
+		urls = append(urls, srcUrl.String())
+	}
+	slices.Sort(urls)
+
+	method := src.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	members := slices.Clone(src.ArchiveMembers)
+	slices.Sort(members)
+
+	// Values are quoted so that they cannot be confused with the separators between them.
+	h := sha256.New()
+	_, _ = fmt.Fprintf(h, "get=%t\n", src.Get != nil)
+	_, _ = fmt.Fprintf(h, "urls=%q\n", urls)
+	_, _ = fmt.Fprintf(h, "url_mode=%d\n", src.UrlMode)
+	_, _ = fmt.Fprintf(h, "method=%q\n", method)
+	_, _ = fmt.Fprintf(h, "body=%q\n", src.Body)
+	_, _ = fmt.Fprintf(h, "archive=%d members=%q\n", src.Archive, members)
+
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/termermc/go-domaindb"
+)
+
+// checkResult is the result of checking a single domain.
+type checkResult struct {
+	Domain  string `json:"domain"`
+	Matched bool   `json:"matched"`
+	Error   string `json:"error,omitempty"`
+}
+
+// runCheck runs the check command.
+// Domains are read from args, or from stdin (one per line) if there are no args.
+func runCheck(args []string, stdin io.Reader, stdout io.Writer) error {
+	flags := flag.NewFlagSet("check", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), "Usage: domaindb-cli check -dir <data directory> -db <database name> [-json] [domain...]\n\nChecks domains against a cached database without downloading anything.\nIf no domains are specified, they are read from stdin, one per line.\n\nFlags:\n")
+		flags.PrintDefaults()
+	}
+	dir := flags.String("dir", "", "the data directory used by FsStorageDriver (required)")
+	dbName := flags.String("db", "", "the name of the database to check domains against (required)")
+	jsonOut := flags.Bool("json", false, "print results as JSON")
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	if *dir == "" || *dbName == "" {
+		flags.Usage()
+		return errors.New("-dir and -db are required")
+	}
+
+	domains := flags.Args()
+	if len(domains) == 0 {
+		scanner := bufio.NewScanner(stdin)
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" {
+				domains = append(domains, line)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return fmt.Errorf("failed to read domains from stdin: %w", err)
+		}
+	}
+
+	storage, err := domaindb.NewFsStorageDriver(*dir)
+	if err != nil {
+		return fmt.Errorf("failed to open data directory: %w", err)
+	}
+
+	// Only the cached copy is used, so the source does not need any URLs.
+	db, err := domaindb.NewDomainDb(domaindb.Options{
+		StorageDriver:   storage,
+		Logger:          newLogger(os.Stderr),
+		DisableDownload: true,
+		Sources: map[string]*domaindb.DataSource{
+			*dbName: {},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to load cached database: %w", err)
+	}
+	defer func() {
+		_ = db.Close()
+	}()
+
+	results := make([]checkResult, 0, len(domains))
+	for _, domain := range domains {
+		res := checkResult{
+			Domain: domain,
+		}
+
+		res.Matched, err = db.DoesDbHaveDomain(*dbName, domain)
+		if err != nil {
+			res.Error = err.Error()
+		}
+
+		results = append(results, res)
+	}
+
+	if *jsonOut {
+		encoder := json.NewEncoder(stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(results)
+	}
+
+	for _, res := range results {
+		if res.Error != "" {
+			fmt.Fprintf(stdout, "%s\terror: %s\n", res.Domain, res.Error)
+		} else {
+			fmt.Fprintf(stdout, "%s\t%t\n", res.Domain, res.Matched)
+		}
+	}
+
+	return nil
+}
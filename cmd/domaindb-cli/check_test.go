@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/termermc/go-domaindb"
+)
+
+// newTestDataDir creates a data directory with a cached database named "test".
+func newTestDataDir(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	storage, err := domaindb.NewFsStorageDriver(dir)
+	if err != nil {
+		t.Fatalf("failed to create storage driver: %v", err)
+	}
+
+	db, err := domaindb.NewDomainDb(domaindb.Options{
+		StorageDriver: storage,
+		Logger:        slog.New(slog.DiscardHandler),
+		Sources: map[string]*domaindb.DataSource{
+			"test": {
+				RefreshInterval: time.Hour,
+				Get: func() (io.ReadCloser, error) {
+					return io.NopCloser(strings.NewReader("foo.com\n")), nil
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create DomainDb: %v", err)
+	}
+	if err = db.Close(); err != nil {
+		t.Fatalf("failed to close DomainDb: %v", err)
+	}
+
+	return dir
+}
+
+func TestRunCheck_Args(t *testing.T) {
+	dir := newTestDataDir(t)
+
+	var out bytes.Buffer
+	if err := runCheck([]string{"-dir", dir, "-db", "test", "FOO.com", "bar.com"}, strings.NewReader(""), &out); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if want := "FOO.com\ttrue\nbar.com\tfalse\n"; out.String() != want {
+		t.Fatalf("got %q, want %q", out.String(), want)
+	}
+}
+
+func TestRunCheck_StdinJson(t *testing.T) {
+	dir := newTestDataDir(t)
+
+	var out bytes.Buffer
+	if err := runCheck([]string{"-dir", dir, "-db", "test", "--json"}, strings.NewReader("foo.com\n\nbad_domain!\n"), &out); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	var results []checkResult
+	if err := json.Unmarshal(out.Bytes(), &results); err != nil {
+		t.Fatalf("failed to decode output: %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("got %d results, want 2", len(results))
+	}
+	if !results[0].Matched || results[0].Error != "" {
+		t.Fatalf("unexpected result: %+v", results[0])
+	}
+	if results[1].Matched || results[1].Error == "" {
+		t.Fatalf("expected error for invalid domain, got %+v", results[1])
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/termermc/go-domaindb"
+)
+
+// config is the sources configuration file used by commands that download databases.
+//
+// Example:
+//
+//	{
+//	  "sources": {
+//	    "disposable": {
+//	      "urls": ["https://example.com/disposable.txt"]
+//	    },
+//	    "blocklist": {
+//	      "urls": ["https://mirror1.example.com/list.txt", "https://mirror2.example.com/list.txt"],
+//	      "failover": true
+//	    }
+//	  }
+//	}
+type config struct {
+	// A mapping of database names to their sources.
+	Sources map[string]configSource `json:"sources"`
+}
+
+// configSource is the configuration of a single source.
+type configSource struct {
+	// The URLs the database is downloaded from.
+	Urls []string `json:"urls"`
+
+	// If true, the URLs are mirrors of the same list (domaindb.UrlModeFailover) rather than separate lists.
+	Failover bool `json:"failover"`
+}
+
+// readConfig reads and parses the config file at the specified path.
+func readConfig(path string) (*config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var cfg config
+	if err = json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse config file: %w", err)
+	}
+
+	return &cfg, nil
+}
+
+// dataSources converts the config's sources to domaindb data sources.
+func (cfg *config) dataSources() (map[string]*domaindb.DataSource, error) {
+	sources := make(map[string]*domaindb.DataSource, len(cfg.Sources))
+	for name, src := range cfg.Sources {
+		urls := make([]*url.URL, 0, len(src.Urls))
+		for _, str := range src.Urls {
+			u, err := url.Parse(str)
+			if err != nil {
+				return nil, fmt.Errorf(`invalid URL for source "%s": %w`, name, err)
+			}
+			urls = append(urls, u)
+		}
+
+		mode := domaindb.UrlModeConcatenate
+		if src.Failover {
+			mode = domaindb.UrlModeFailover
+		}
+
+		sources[name] = &domaindb.DataSource{
+			Urls:    urls,
+			UrlMode: mode,
+
+			// The CLI exits before any scheduled update would happen.
+			RefreshInterval: 24 * time.Hour,
+		}
+	}
+
+	return sources, nil
+}
@@ -0,0 +1,60 @@
+// Command domaindb-cli is a tool for working with domaindb caches without writing Go.
+//
+// Usage:
+//
+//	domaindb-cli <command> [flags] [args]
+//
+// Commands:
+//
+//	check  checks domains against a cached database
+//	warm   downloads sources into a data directory for offline use
+//
+// Run "domaindb-cli <command> -h" for the flags of a command.
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+)
+
+const usage = `Usage: domaindb-cli <command> [flags] [args]
+
+Commands:
+  check  checks domains against a cached database
+  warm   downloads sources into a data directory for offline use
+
+Run "domaindb-cli <command> -h" for the flags of a command.
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "check":
+		err = runCheck(os.Args[2:], os.Stdin, os.Stdout)
+	case "warm":
+		err = runWarm(os.Args[2:], os.Stdout)
+	case "-h", "-help", "--help", "help":
+		fmt.Fprint(os.Stdout, usage)
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// newLogger creates a logger that writes warnings and errors to w.
+// Debug and info messages from DomainDb are not useful for one-off commands.
+func newLogger(w io.Writer) *slog.Logger {
+	return slog.New(slog.NewTextHandler(w, &slog.HandlerOptions{Level: slog.LevelWarn}))
+}
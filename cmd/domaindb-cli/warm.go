@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/termermc/go-domaindb"
+)
+
+// runWarm runs the warm command.
+// It downloads every source in the config into the data directory, so that the directory can be used by hosts with downloading disabled.
+func runWarm(args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("warm", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), "Usage: domaindb-cli warm -dir <data directory> -config <config file>\n\nDownloads every source in the config file into the data directory.\nThe data directory can then be used by hosts that run with downloading disabled.\n\nFlags:\n")
+		flags.PrintDefaults()
+	}
+	dir := flags.String("dir", "", "the data directory used by FsStorageDriver; created if it does not exist (required)")
+	configPath := flags.String("config", "", "the JSON config file listing the sources to download (required)")
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	if *dir == "" || *configPath == "" {
+		flags.Usage()
+		return errors.New("-dir and -config are required")
+	}
+
+	cfg, err := readConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	sources, err := cfg.dataSources()
+	if err != nil {
+		return err
+	}
+
+	// Force every source to be downloaded during initialization, even if there is already a cached copy.
+	for _, src := range sources {
+		src.MaxCacheAge = time.Nanosecond
+	}
+
+	if err = os.MkdirAll(*dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create data directory: %w", err)
+	}
+	storage, err := domaindb.NewFsStorageDriver(*dir)
+	if err != nil {
+		return fmt.Errorf("failed to open data directory: %w", err)
+	}
+
+	db, err := domaindb.NewDomainDb(domaindb.Options{
+		StorageDriver: storage,
+		Logger:        newLogger(os.Stderr),
+		Sources:       sources,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to download databases: %w", err)
+	}
+
+	// A source whose download fails falls back to its existing cached copy, so check that every database was actually downloaded.
+	var errs []error
+	for _, name := range db.DatabaseNames() {
+		stats, err := db.LastLoadStats(name)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if stats.Source != domaindb.LoadSourceDownload {
+			errs = append(errs, fmt.Errorf(`failed to download database with name "%s"; the existing cached copy was kept`, name))
+			continue
+		}
+
+		fmt.Fprintf(stdout, "%s\t%d domains\n", name, stats.UniqueDomains)
+	}
+
+	// Closing saves the checkpoints, so it must happen even if some downloads failed.
+	if err = db.Close(); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
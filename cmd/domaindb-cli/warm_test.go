@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRunWarm(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		_, _ = w.Write([]byte("foo.com\nbar.com\n"))
+	}))
+	defer server.Close()
+
+	configPath := filepath.Join(t.TempDir(), "config.json")
+	cfg := fmt.Sprintf(`{"sources": {"test": {"urls": ["%s/list.txt"]}}}`, server.URL)
+	if err := os.WriteFile(configPath, []byte(cfg), 0o644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	dir := filepath.Join(t.TempDir(), "data")
+
+	// Warming an existing cache must download again.
+	for i := range 2 {
+		var out bytes.Buffer
+		if err := runWarm([]string{"-dir", dir, "-config", configPath}, &out); err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		if want := "test\t2 domains\n"; out.String() != want {
+			t.Fatalf("got %q, want %q", out.String(), want)
+		}
+		if got := requests.Load(); got != int32(i+1) {
+			t.Fatalf("got %d requests, want %d", got, i+1)
+		}
+	}
+
+	// The cache must be usable with downloading disabled.
+	var out bytes.Buffer
+	if err := runCheck([]string{"-dir", dir, "-db", "test", "bar.com"}, strings.NewReader(""), &out); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if want := "bar.com\ttrue\n"; out.String() != want {
+		t.Fatalf("got %q, want %q", out.String(), want)
+	}
+}
+
+func TestRunWarm_DownloadFailure(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	configPath := filepath.Join(t.TempDir(), "config.json")
+	cfg := fmt.Sprintf(`{"sources": {"test": {"urls": ["%s/list.txt"]}}}`, server.URL)
+	if err := os.WriteFile(configPath, []byte(cfg), 0o644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := runWarm([]string{"-dir", t.TempDir(), "-config", configPath}, &out); err == nil {
+		t.Fatal("expected error, got nil")
+	}
+}
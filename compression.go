@@ -0,0 +1,198 @@
+package domaindb
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression is the compression format of a data source.
+type Compression int
+
+const (
+	// CompressionNone means the source is not compressed.
+	CompressionNone Compression = iota
+
+	// CompressionAuto detects the compression format of the source.
+	// URLs whose path ends in ".gz" are treated as gzip, and URLs whose path ends in ".zst" or ".zstd" are treated as zstd.
+	// Otherwise, the format is detected from the first bytes of the data, falling back to uncompressed.
+	CompressionAuto
+
+	// CompressionGzip means the source is compressed with gzip.
+	CompressionGzip
+
+	// CompressionZstd means the source is compressed with zstd.
+	CompressionZstd
+)
+
+func (c Compression) String() string {
+	switch c {
+	case CompressionNone:
+		return "none"
+	case CompressionAuto:
+		return "auto"
+	case CompressionGzip:
+		return "gzip"
+	case CompressionZstd:
+		return "zstd"
+	default:
+		return "unknown"
+	}
+}
+
+// The magic bytes at the start of gzip and zstd data.
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// detectCompression detects the compression format of data read from r by its magic bytes.
+// Returns the detected format, along with a reader that still produces all the data.
+func detectCompression(r io.Reader) (Compression, io.Reader) {
+	buffered := bufio.NewReader(r)
+
+	// Peek returns fewer bytes along with an error if the data is shorter, which is fine since it then cannot match.
+	magic, _ := buffered.Peek(len(zstdMagic))
+	switch {
+	case bytes.HasPrefix(magic, zstdMagic):
+		return CompressionZstd, buffered
+	case bytes.HasPrefix(magic, gzipMagic):
+		return CompressionGzip, buffered
+	default:
+		return CompressionNone, buffered
+	}
+}
+
+// decompress returns a reader that decompresses data read from r according to the compression format.
+// path is the URL path the data was downloaded from, which is used by CompressionAuto, or empty if there is none.
+// Closing the returned reader releases the decompressor, but does not close r.
+func decompress(r io.Reader, compression Compression, path string) (io.ReadCloser, error) {
+	if compression == CompressionAuto {
+		switch {
+		case strings.HasSuffix(path, ".gz"):
+			compression = CompressionGzip
+		case strings.HasSuffix(path, ".zst"), strings.HasSuffix(path, ".zstd"):
+			compression = CompressionZstd
+		default:
+			compression, r = detectCompression(r)
+		}
+	}
+
+	switch compression {
+	case CompressionNone:
+		return noOpReadCloser{r}, nil
+	case CompressionGzip:
+		reader, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read gzip header: %w", err)
+		}
+		return reader, nil
+	case CompressionZstd:
+		decoder, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create zstd decoder: %w", err)
+		}
+		return decoder.IOReadCloser(), nil
+	default:
+		return nil, fmt.Errorf("unknown compression %d", compression)
+	}
+}
+
+// decodeContentEncoding returns a reader that decodes the Content-Encoding of an HTTP response, reading the raw body from r.
+// Only gzip is supported, since it is the only encoding requested; a response without a Content-Encoding, or with "identity", is passed through.
+// Closing the returned reader releases the decoder, but does not close r.
+func decodeContentEncoding(r io.Reader, resp *http.Response) (io.ReadCloser, error) {
+	encoding := strings.TrimSpace(resp.Header.Get("Content-Encoding"))
+	switch {
+	case encoding == "", strings.EqualFold(encoding, "identity"):
+		return noOpReadCloser{r}, nil
+	case strings.EqualFold(encoding, "gzip"), strings.EqualFold(encoding, "x-gzip"):
+		reader, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read gzip Content-Encoding header: %w", err)
+		}
+		return reader, nil
+	default:
+		return nil, fmt.Errorf(`unsupported Content-Encoding "%s"`, encoding)
+	}
+}
+
+// decompressReadCloser is a decompressing reader that also closes the underlying reader when it is closed.
+type decompressReadCloser struct {
+	io.ReadCloser
+
+	underlying io.Closer
+}
+
+func (r *decompressReadCloser) Close() error {
+	_ = r.ReadCloser.Close()
+	return r.underlying.Close()
+}
+
+// decompressOwned is like decompress, but closing the returned reader also closes r.
+// If it fails, r is closed.
+func decompressOwned(r io.ReadCloser, compression Compression, path string) (io.ReadCloser, error) {
+	if compression == CompressionNone {
+		return r, nil
+	}
+
+	reader, err := decompress(r, compression, path)
+	if err != nil {
+		_ = r.Close()
+		return nil, err
+	}
+
+	return &decompressReadCloser{ReadCloser: reader, underlying: r}, nil
+}
+
+// readCache opens the cached copy of the database with the specified name, decompressing it if it is compressed.
+// Compression is detected from the data, so caches written with and without Options.CompressCache can both be read.
+// The caller must close the returned reader.
+func (s *DomainDb) readCache(name string) (io.ReadCloser, error) {
+	reader, err := s.storage.ReadDatabase(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return decompressOwned(reader, CompressionAuto, "")
+}
+
+// writeCache writes the cached copy of the database with the specified name, compressing it with zstd if Options.CompressCache is true.
+// Closes input.
+func (s *DomainDb) writeCache(name string, input io.ReadCloser) error {
+	if !s.compressCache {
+		return s.storage.WriteDatabase(name, input)
+	}
+
+	pipeReader, pipeWriter := io.Pipe()
+	go func() {
+		defer func() {
+			_ = input.Close()
+		}()
+
+		encoder, err := zstd.NewWriter(pipeWriter, zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			_ = pipeWriter.CloseWithError(fmt.Errorf("failed to create zstd encoder: %w", err))
+			return
+		}
+
+		_, err = io.Copy(encoder, input)
+		if closeErr := encoder.Close(); err == nil {
+			err = closeErr
+		}
+		_ = pipeWriter.CloseWithError(err)
+	}()
+
+	err := s.storage.WriteDatabase(name, pipeReader)
+
+	// Stop the compressing goroutine if the storage driver returned without reading everything.
+	_ = pipeReader.CloseWithError(io.ErrClosedPipe)
+
+	return err
+}
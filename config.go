@@ -0,0 +1,306 @@
+package domaindb
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"time"
+)
+
+// ConfigSnapshot is the effective configuration of a DomainDb, after defaults have been applied.
+// It is useful for logging and for confirming that a service is running with the intended configuration.
+//
+// Get an instance with DomainDb.Config.
+type ConfigSnapshot struct {
+	// The timeout of the HTTP client used to download sources.
+	// If 0, the client has no timeout.
+	HttpTimeout time.Duration
+
+	// Whether a custom HTTP client was provided with Options.HttpClient.
+	CustomHttpClient bool
+
+	// The proxy used to download sources, or nil if there is none.
+	// Always nil if CustomHttpClient is true, since the proxy of a custom client is not known.
+	ProxyUrl *url.URL
+
+	// Whether downloading is disabled.
+	DisableDownload bool
+
+	// Whether requests do not advertise gzip support with Accept-Encoding.
+	DisableHttpCompression bool
+
+	// Whether cached copies are compressed with zstd.
+	CompressCache bool
+
+	// The directory where downloads are spooled while they are parsed.
+	TempDir string
+
+	// The maximum number of queried domains whose normalized form is cached, or 0 if they are not cached.
+	LookupCacheSize int
+
+	// The maximum number of requests per second to each host, or 0 if requests are not limited.
+	HostRequestsPerSecond float64
+
+	// The maximum number of requests to each host that may be made at once, or 0 if requests are not limited.
+	HostRequestBurst int
+
+	// The maximum number of databases loaded concurrently during initialization.
+	LoadConcurrency int
+
+	// Whether the databases were initially loaded in the background.
+	LoadDatabasesInBackground bool
+
+	// Whether databases loaded from cache are refreshed on startup.
+	RefreshOnStartup bool
+
+	// The fraction of the refresh interval within which caches are not refreshed on startup, or 0 if RefreshOnStartup is false.
+	RefreshOnStartupFreshFraction float64
+
+	// Whether corrupt checkpoints fail initialization.
+	StrictCheckpoints bool
+
+	// Whether databases whose sources changed since they were cached are still loaded from cache.
+	KeepCacheOnSourceChange bool
+
+	// Whether a database that fails its initial load fails initialization.
+	// Never StartupPolicyDefault, since the default is resolved.
+	StartupPolicy StartupPolicy
+
+	// Whether StartupPolicy is StartupPolicyBestEffort.
+	//
+	// Deprecated: Use StartupPolicy instead.
+	PartialInit bool
+
+	// The time between retries of databases that failed their initial load, or 0 if StartupPolicy is not StartupPolicyBestEffort.
+	PartialInitRetryInterval time.Duration
+
+	// Database names in order of precedence for deduplication, or nil if databases are not deduplicated.
+	Dedupe []string
+
+	// The configuration of each database, in the order the databases were loaded.
+	Sources []SourceConfig
+}
+
+// SourceConfig is the effective configuration of a single database, after defaults have been applied.
+// See ConfigSnapshot.
+type SourceConfig struct {
+	// The name of the database.
+	Name string
+
+	// The URLs the database is downloaded from.
+	// URLs may contain credentials, so use url.URL.Redacted when logging them.
+	Urls []*url.URL
+
+	// How multiple URLs are combined.
+	UrlMode UrlMode
+
+	// The compression format of the source's data.
+	Compression Compression
+
+	// The archive format of the source's data.
+	Archive ArchiveFormat
+
+	// The paths of the archive members that make up the list, or nil if the source is not an archive.
+	ArchiveMembers []string
+
+	// The HTTP method used to request the URLs.
+	Method string
+
+	// Whether the source has a custom Get method, which takes precedence over the URLs.
+	HasGet bool
+
+	// The time between updates.
+	RefreshInterval time.Duration
+
+	// The maximum age of a cached copy before it is downloaded during initialization, or 0 if there is none.
+	MaxCacheAge time.Duration
+
+	// The minimum time between successful downloads, or 0 if downloads are never skipped.
+	MinDownloadInterval time.Duration
+
+	// The maximum time spent downloading each URL, or 0 if only the HTTP client's timeout applies.
+	Timeout time.Duration
+
+	// The maximum number of pages downloaded for each URL, or 0 if the source is not paginated.
+	MaxPages int
+
+	// The maximum number of unique domains, or 0 if there is no limit.
+	MaxDomains int
+
+	// Whether loads that exceed MaxDomains are truncated rather than failing.
+	TruncateAtMaxDomains bool
+
+	// Whether match results are inverted.
+	Negate bool
+
+	// Whether the database stores domain names or email addresses.
+	Mode DatabaseMode
+
+	// Whether normalization results are cached between loads.
+	CacheNormalization bool
+
+	// The expected number of unique domains, used to size the first load, or 0 if there is no hint.
+	DomainCountHint int
+
+	// Whether the database has a custom matcher (see DataSource.NewMatcher).
+	HasMatcher bool
+
+	// The categories of a categorized list, or nil if the list is not categorized.
+	Categories []string
+}
+
+// newConfigSnapshot creates the ConfigSnapshot of a DomainDb from its options and the values resolved from them.
+func newConfigSnapshot(options Options, httpClient *http.Client, loadConcurrency int, sources []NamedSource) ConfigSnapshot {
+	c := ConfigSnapshot{
+		HttpTimeout:               httpClient.Timeout,
+		CustomHttpClient:          options.HttpClient != nil,
+		DisableDownload:           options.DisableDownload,
+		DisableHttpCompression:    options.DisableHttpCompression,
+		CompressCache:             options.CompressCache,
+		TempDir:                   options.TempDir,
+		LookupCacheSize:           max(options.LookupCacheSize, 0),
+		LoadConcurrency:           loadConcurrency,
+		LoadDatabasesInBackground: options.LoadDatabasesInBackground,
+		RefreshOnStartup:          options.RefreshOnStartup,
+		StrictCheckpoints:         options.StrictCheckpoints,
+		KeepCacheOnSourceChange:   options.KeepCacheOnSourceChange,
+		StartupPolicy:             StartupPolicyFailOnAnyError,
+		Dedupe:                    slices.Clone(options.Dedupe),
+		Sources:                   make([]SourceConfig, 0, len(sources)),
+	}
+	if c.TempDir == "" {
+		c.TempDir = os.TempDir()
+	}
+	if options.RefreshOnStartup {
+		c.RefreshOnStartupFreshFraction = max(options.RefreshOnStartupFreshFraction, 0)
+	}
+	if options.PartialInit {
+		c.StartupPolicy = StartupPolicyBestEffort
+		c.PartialInit = true
+		c.PartialInitRetryInterval = options.PartialInitRetryInterval
+		if c.PartialInitRetryInterval <= 0 {
+			c.PartialInitRetryInterval = defaultPartialInitRetryInterval
+		}
+	}
+	if options.HostRequestsPerSecond > 0 {
+		c.HostRequestsPerSecond = options.HostRequestsPerSecond
+		c.HostRequestBurst = max(options.HostRequestBurst, 1)
+	}
+	if options.HttpClient == nil && options.ProxyUrl != nil {
+		c.ProxyUrl = cloneUrl(options.ProxyUrl)
+	}
+
+	for _, named := range sources {
+		src := named.Source
+
+		method := src.Method
+		if method == "" {
+			method = http.MethodGet
+		}
+
+		maxPages := 0
+		if src.NextPage != nil {
+			maxPages = src.MaxPages
+			if maxPages <= 0 {
+				maxPages = defaultMaxPages
+			}
+		}
+
+		urls := make([]*url.URL, 0, len(src.Urls))
+		for _, srcUrl := range src.Urls {
+			urls = append(urls, cloneUrl(srcUrl))
+		}
+
+		c.Sources = append(c.Sources, SourceConfig{
+			Name:                 named.Name,
+			Urls:                 urls,
+			UrlMode:              src.UrlMode,
+			Compression:          src.Compression,
+			Archive:              src.Archive,
+			ArchiveMembers:       slices.Clone(src.ArchiveMembers),
+			Method:               method,
+			HasGet:               src.Get != nil,
+			RefreshInterval:      src.RefreshInterval,
+			MaxCacheAge:          src.MaxCacheAge,
+			MinDownloadInterval:  src.MinDownloadInterval,
+			Timeout:              src.Timeout,
+			MaxPages:             maxPages,
+			MaxDomains:           src.MaxDomains,
+			TruncateAtMaxDomains: src.TruncateAtMaxDomains,
+			Negate:               src.Negate,
+			Mode:                 src.Mode,
+			CacheNormalization:   src.CacheNormalization,
+			HasMatcher:           src.NewMatcher != nil,
+			DomainCountHint:      max(src.DomainCountHint, 0),
+			Categories:           slices.Clone(src.Categories),
+		})
+	}
+
+	return c
+}
+
+// clone returns a deep copy of the snapshot, so that callers cannot modify the copy held by the DomainDb.
+func (c ConfigSnapshot) clone() ConfigSnapshot {
+	if c.ProxyUrl != nil {
+		c.ProxyUrl = cloneUrl(c.ProxyUrl)
+	}
+
+	c.Dedupe = slices.Clone(c.Dedupe)
+	c.Sources = slices.Clone(c.Sources)
+	for i := range c.Sources {
+		urls := make([]*url.URL, len(c.Sources[i].Urls))
+		for j, srcUrl := range c.Sources[i].Urls {
+			urls[j] = cloneUrl(srcUrl)
+		}
+		c.Sources[i].Urls = urls
+		c.Sources[i].Categories = slices.Clone(c.Sources[i].Categories)
+		c.Sources[i].ArchiveMembers = slices.Clone(c.Sources[i].ArchiveMembers)
+	}
+
+	return c
+}
+
+// cloneUrl returns a copy of a URL.
+func cloneUrl(u *url.URL) *url.URL {
+	c := *u
+	return &c
+}
+
+// Config returns a copy of the effective configuration of the DomainDb, after defaults have been applied.
+// The configuration does not change after NewDomainDb returns, so the result can be cached.
+// Modifying the returned value has no effect on the DomainDb.
+func (s *DomainDb) Config() ConfigSnapshot {
+	return s.config.clone()
+}
+
+// SourceOf returns a copy of the data source configured for the database with the specified name, as it was passed in Options.Sources or Options.OrderedSources.
+// It is meant for introspection, such as showing where each list comes from on a status page; use Config for the configuration after defaults have been applied.
+// The URLs and other slices are copied, so modifying the returned value has no effect on the DomainDb, but function fields like Get are shared with the original.
+// Category databases (see DataSource.Categories) return the data source of their parent database.
+// If the database does not exist, returns a NoSuchDatabaseError.
+// If the DomainDb instance has been closed, returns ErrDbClosed.
+func (s *DomainDb) SourceOf(dbName string) (*DataSource, error) {
+	if !s.isRunning.Load() {
+		return nil, ErrDbClosed
+	}
+
+	data, has := s.dbs[dbName]
+	if !has {
+		return nil, NewNoSuchDatabaseError(dbName)
+	}
+
+	src := *data.Src
+	if src.Urls != nil {
+		src.Urls = make([]*url.URL, len(data.Src.Urls))
+		for i, srcUrl := range data.Src.Urls {
+			src.Urls[i] = cloneUrl(srcUrl)
+		}
+	}
+	src.Body = slices.Clone(src.Body)
+	src.CommentPrefixes = slices.Clone(src.CommentPrefixes)
+	src.ArchiveMembers = slices.Clone(src.ArchiveMembers)
+	src.Categories = slices.Clone(src.Categories)
+
+	return &src, nil
+}
@@ -1,4 +1,5 @@
 package domaindb
 
 // DbNameMaxSize is the max size of the database name, in bytes.
+// NewDomainDb rejects database names that are longer than this.
 const DbNameMaxSize = 64
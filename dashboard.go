@@ -0,0 +1,109 @@
+package domaindb
+
+import (
+	"time"
+)
+
+// downloadFailure is a failed download of a database.
+type downloadFailure struct {
+	Err error
+	At  time.Time
+}
+
+// DatabaseInfo is the state of a single database, as returned by DomainDb.DashboardSnapshot.
+// It only contains values, so it is safe to keep and to serialize to JSON.
+type DatabaseInfo struct {
+	// The database name.
+	Name string `json:"name"`
+
+	// If this is a category database (see DataSource.Categories), the name of the source database it is derived from.
+	// Empty otherwise.
+	Parent string `json:"parent,omitempty"`
+
+	// Whether the database has been initialized.
+	Initialized bool `json:"initialized"`
+
+	// Whether the database is enabled (see DomainDb.SetDatabaseEnabled).
+	Enabled bool `json:"enabled"`
+
+	// Whether background updates are paused (see DomainDb.PauseUpdates).
+	// This applies to all databases, but is included so that each entry is self-contained.
+	UpdatesPaused bool `json:"updates_paused"`
+
+	// The number of unique domains loaded.
+	DomainCount int `json:"domain_count"`
+
+	// When the database was last updated from its source, according to its checkpoint.
+	// Zero if it has never been updated.
+	LastUpdated time.Time `json:"last_updated,omitzero"`
+
+	// Where the database was last loaded from.
+	LastLoadSource string `json:"last_load_source"`
+
+	// The error of the last download if it failed, or empty if it succeeded or the database has not been downloaded.
+	LastError string `json:"last_error,omitempty"`
+
+	// When the last download failed.
+	// Zero if LastError is empty.
+	LastErrorAt time.Time `json:"last_error_at,omitzero"`
+
+	// When the database is next scheduled to be updated, or its failed initial load retried (see StartupPolicyBestEffort).
+	// Zero if nothing is scheduled, such as when downloading is disabled.
+	// Updates that are skipped because the database is disabled or updates are paused are still scheduled.
+	NextUpdate time.Time `json:"next_update,omitzero"`
+}
+
+// DashboardSnapshot returns the state of every database in one call, sorted by database name.
+// It is meant to back status pages and dashboards, which would otherwise need to call several methods for each database.
+// The state of each database is read separately, so the entries are not a consistent snapshot of all databases at a single instant.
+//
+// Category databases have the LastUpdated, LastError and NextUpdate of their source database, since they are updated along with it.
+func (s *DomainDb) DashboardSnapshot() []DatabaseInfo {
+	updatesPaused := s.updatesPaused.Load()
+
+	names := sortedNames(s.dbs)
+	infos := make([]DatabaseInfo, 0, len(names))
+	for _, name := range names {
+		data := s.dbs[name]
+
+		// Update state is tracked by the source database.
+		updateName := name
+		updateData := data
+		if data.Parent != "" {
+			updateName = data.Parent
+			updateData = s.dbs[data.Parent]
+		}
+
+		tok := data.Mu.RLock()
+		info := DatabaseInfo{
+			Name:           name,
+			Parent:         data.Parent,
+			Initialized:    data.Has,
+			Enabled:        !data.Disabled.Load(),
+			UpdatesPaused:  updatesPaused,
+			DomainCount:    len(data.Domains),
+			LastLoadSource: data.LastLoad.Source.String(),
+		}
+		data.Mu.RUnlock(tok)
+
+		s.checkpointsMu.Lock()
+		if s.checkpoints != nil {
+			if lastUpdatedUnix := s.checkpoints.Checkpoints[updateName].LastUpdatedUnix; lastUpdatedUnix != 0 {
+				info.LastUpdated = time.Unix(lastUpdatedUnix, 0)
+			}
+		}
+		s.checkpointsMu.Unlock()
+
+		if failure := updateData.LastDownloadFailure.Load(); failure != nil {
+			info.LastError = failure.Err.Error()
+			info.LastErrorAt = failure.At
+		}
+		if next := updateData.NextUpdateNano.Load(); next != 0 {
+			info.NextUpdate = time.Unix(0, next)
+		}
+
+		infos = append(infos, info)
+	}
+
+	return infos
+}
@@ -0,0 +1,104 @@
+package domaindb
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+)
+
+// newDedupeRanks validates Options.Dedupe and returns the position of each database in it.
+// Returns nil if Options.Dedupe is empty.
+func newDedupeRanks(order []string, dbs map[string]*dbSrcMap) (map[string]int, error) {
+	if len(order) == 0 {
+		return nil, nil
+	}
+
+	ranks := make(map[string]int, len(order))
+	for i, name := range order {
+		data, has := dbs[name]
+		if !has {
+			return nil, fmt.Errorf(`dedupe order contains database with name "%s", which does not exist`, name)
+		}
+		if data.Parent != "" {
+			return nil, fmt.Errorf(`dedupe order contains database with name "%s": %w`, name, ErrCategoryDatabase)
+		}
+		if data.Src.NewMatcher != nil {
+			return nil, fmt.Errorf(`dedupe order contains database with name "%s", which has a custom matcher (see DataSource.NewMatcher)`, name)
+		}
+		if _, has := ranks[name]; has {
+			return nil, fmt.Errorf(`dedupe order contains database with name "%s" more than once`, name)
+		}
+
+		ranks[name] = i
+	}
+
+	return ranks, nil
+}
+
+// dedupeEarlier removes the domains that are in databases before the database with the specified name in Options.Dedupe from domains, which must not be shared yet.
+// Returns the number of domains removed.
+// The caller must hold dedupeMu.
+func (s *DomainDb) dedupeEarlier(name string, domains map[string]struct{}) int {
+	removed := 0
+	for _, earlierName := range s.dedupeOrder[:s.dedupeRanks[name]] {
+		earlier := s.dbs[earlierName]
+
+		tok := earlier.Mu.RLock()
+		earlierDomains := earlier.Domains
+		earlier.Mu.RUnlock(tok)
+
+		for domain := range earlierDomains {
+			if _, has := domains[domain]; has {
+				delete(domains, domain)
+				removed++
+			}
+		}
+	}
+
+	return removed
+}
+
+// dedupeLater removes the domains in domains, which were just loaded into the database with the specified name, from the databases after it in Options.Dedupe.
+// Since domain sets must not be modified after they are assigned, affected databases get a filtered copy of their set.
+// The caller must hold dedupeMu.
+func (s *DomainDb) dedupeLater(name string, domains map[string]struct{}) {
+	for _, laterName := range s.dedupeOrder[s.dedupeRanks[name]+1:] {
+		later := s.dbs[laterName]
+
+		tok := later.Mu.RLock()
+		laterDomains := later.Domains
+		later.Mu.RUnlock(tok)
+
+		// Only copy the set if it overlaps, since most updates do not introduce overlaps.
+		overlaps := false
+		for domain := range domains {
+			if _, has := laterDomains[domain]; has {
+				overlaps = true
+				break
+			}
+		}
+		if !overlaps {
+			continue
+		}
+
+		filtered := maps.Clone(laterDomains)
+		maps.DeleteFunc(filtered, func(domain string, _ struct{}) bool {
+			_, has := domains[domain]
+			return has
+		})
+		removed := len(laterDomains) - len(filtered)
+
+		later.Mu.Lock()
+		later.Domains = filtered
+		later.LastLoad.UniqueDomains -= removed
+		later.LastLoad.DedupedDomains += removed
+		later.Mu.Unlock()
+
+		s.logger.Log(context.Background(), slog.LevelDebug, "removed domains from database that are now in an earlier database in the dedupe order",
+			"database_name", laterName,
+			"earlier_database_name", name,
+			"removed", removed,
+		)
+	}
+}
@@ -2,14 +2,20 @@ package domaindb
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"math/rand/v2"
 	"net/http"
 	"net/url"
+	"os"
 	"runtime"
+	"slices"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -21,16 +27,81 @@ var emptyMap = make(map[string]struct{})
 
 const defaultHttpClientTimeout = 10 * time.Second
 
+// defaultMaxIdleConnsPerHost is the maximum number of idle connections per host kept by the default HTTP client.
+const defaultMaxIdleConnsPerHost = 16
+
+// refreshOnStartupMaxJitter is the maximum delay before databases are refreshed when Options.RefreshOnStartup is true.
+const refreshOnStartupMaxJitter = 30 * time.Second
+
+// defaultPartialInitRetryInterval is the default value of Options.PartialInitRetryInterval.
+const defaultPartialInitRetryInterval = time.Minute
+
+// defaultMaxPages is the default value of DataSource.MaxPages.
+const defaultMaxPages = 1000
+
+// maxNormalizeFailureCallbacks is the maximum number of times Options.OnNormalizeFailure is called per load.
+const maxNormalizeFailureCallbacks = 100
+
 type dbUpdate struct {
 	Ts   time.Time
 	Name string
 }
 type dbSrcMap struct {
-	Has             bool
-	Src             *DataSource
-	Mu              *xsync.RBMutex
-	Domains         map[string]struct{}
+	Has bool
+	Src *DataSource
+	Mu  *xsync.RBMutex
+
+	// The loaded domain set.
+	// The map must never be modified after it is assigned; it is replaced entirely on update so that snapshots can share it.
+	// Because of this, a reference obtained while holding Mu can be read after releasing it.
+	Domains map[string]struct{}
+
 	LastUpdatedUnix int64
+
+	// The fingerprint of Src, which is saved in checkpoints to detect sources that changed since the database was cached.
+	// See sourceFingerprint.
+	SourceFingerprint string
+
+	// Statistics about the last successful load.
+	LastLoad LoadStats
+
+	// The custom matcher built by the last successful load, or nil if the source has no DataSource.NewMatcher.
+	// Like Domains, it is replaced entirely on update.
+	Matcher Matcher
+
+	// Normalization results of the last successful load, mapping entries to their normalized form.
+	// Only populated if DataSource.CacheNormalization is true.
+	// Like Domains, the map must never be modified after it is assigned.
+	NormCache map[string]string
+
+	// The category databases derived from this database, by category.
+	// Only populated if DataSource.Categories is not empty.
+	// The map is never modified after the DomainDb is created.
+	CategoryDbs map[string]*dbSrcMap
+
+	// If this is a category database, the name of the source database it is derived from.
+	// Empty otherwise.
+	Parent string
+
+	// Whether the database has been disabled with DomainDb.SetDatabaseEnabled.
+	Disabled atomic.Bool
+
+	// Unexpired temporary overrides by normalized domain, see DomainDb.AddTemporaryOverride.
+	// Like Domains, the map must never be modified after it is assigned.
+	// Nil if there are no overrides.
+	Overrides map[string]temporaryOverride
+
+	// The time of the last successful download, in Unix nanoseconds, or 0 if the database has not been downloaded.
+	LastDownloadNano atomic.Int64
+
+	// Coalesces concurrent downloads of the database.
+	Download downloadFlight
+
+	// The failure of the last download, or nil if it succeeded or the database has not been downloaded.
+	LastDownloadFailure atomic.Pointer[downloadFailure]
+
+	// The time of the next scheduled update or initial load retry, in Unix nanoseconds, or 0 if none is scheduled.
+	NextUpdateNano atomic.Int64
 }
 
 // DomainDb stores and updates domain databases.
@@ -41,7 +112,8 @@ type dbSrcMap struct {
 // Databases are cached on disk and updated periodically from data sources.
 // At runtime, databases are stored in-memory.
 //
-// Caches are not aware of which data sources were used to create them, so adding, removing or changing data source URLs or Get method implementations should be followed by clearing the cache.
+// Checkpoints record a fingerprint of the data sources that caches were downloaded with, so databases whose URLs changed are downloaded again during initialization (see Options.KeepCacheOnSourceChange).
+// The fingerprint cannot see inside Get methods, so changing a Get method's implementation should be followed by clearing the cache with ClearCache or ClearAllCache.
 //
 // Create an instance with NewDomainDb; do not create an empty DomainDb struct and attempt to use it.
 //
@@ -55,9 +127,71 @@ type DomainDb struct {
 	normalizer *normalize.DomainNormalizer
 	updates    chan dbUpdate
 
+	// Whether Accept-Encoding is not sent, see Options.DisableHttpCompression.
+	disableHttpCompression bool
+
+	onNormalizeFailure func(dbName string, rawLine string, err error)
+	transform          func(string) (string, bool)
+
+	// Caches the normalized form of queried domains, or nil if Options.LookupCacheSize is 0.
+	lookupCache *lookupCache
+
+	// The directory for temporary files, see Options.TempDir.
+	tempDir string
+
+	// Whether cached copies are compressed, see Options.CompressCache.
+	compressCache bool
+
+	// Limits requests to each host, or nil if there is no limit.
+	hostLimiters *hostLimiters
+
 	dbs map[string]*dbSrcMap
 
-	isRunning bool
+	// The names of all databases, in the order they are loaded and their updaters are started.
+	dbOrder []string
+
+	// The effective configuration, returned by Config.
+	config ConfigSnapshot
+
+	// The checkpoints for all databases.
+	// Must only be accessed while holding checkpointsMu.
+	checkpoints   *AllCheckpoints
+	checkpointsMu sync.Mutex
+
+	// Tracks the goroutine that saves checkpoint updates, so that Close can wait for it to finish.
+	checkpointWriter sync.WaitGroup
+
+	// Tracks the goroutine that initializes the DomainDb when Options.LoadDatabasesInBackground is true, so that Close can wait for it to stop.
+	initializer sync.WaitGroup
+
+	// Tracks updater goroutines, so that Close can wait for in-progress updates to finish before closing the updates channel.
+	updaters sync.WaitGroup
+
+	// Closed by Close to stop updaters.
+	closing chan struct{}
+
+	// Whether background updates are paused, see PauseUpdates.
+	updatesPaused atomic.Bool
+
+	// Held while starting background goroutines and while closing, so that goroutines are never started after Close has begun waiting for them.
+	lifecycleMu sync.Mutex
+
+	// The order of Options.Dedupe, and the position of each database in it.
+	// Both are nil if databases are not deduplicated.
+	dedupeOrder []string
+	dedupeRanks map[string]int
+
+	// Held while deduplicating and swapping in the domains of a database in the dedupe order, so that concurrent loads see each other's results.
+	dedupeMu sync.Mutex
+
+	// Whether the goroutine that removes expired temporary overrides has been started.
+	// Protected by lifecycleMu.
+	overrideSweeperStarted bool
+
+	// Whether the DomainDb instance has not been closed.
+	// Public methods check it and return ErrDbClosed once it is false.
+	// Only set to false while holding lifecycleMu.
+	isRunning atomic.Bool
 }
 
 // DataSource stores source information for domain data.
@@ -68,16 +202,204 @@ type DataSource struct {
 	// Any URLs that cannot be fetched will result in an error log and be skipped.
 	Urls []*url.URL
 
+	// UrlMode determines how multiple Urls are combined.
+	// Defaults to UrlModeConcatenate.
+	UrlMode UrlMode
+
+	// Method is the HTTP method used to request Urls.
+	// If empty, defaults to GET.
+	Method string
+
+	// NextPage is used for sources that split their list across multiple pages.
+	// It is called after each page of a URL has been downloaded successfully, and returns the URL of the next page and whether there is one.
+	// The response body has already been read when it is called, so it must determine the next page from the response headers (such as a Link header) or the request URL.
+	// A relative URL is resolved against the URL of the current page.
+	// The pages of each URL are concatenated into a single list.
+	// If any page after the first fails to download, the whole download fails, so that a partial list is never loaded.
+	// If nil, each URL is a single page.
+	NextPage func(resp *http.Response) (*url.URL, bool)
+
+	// MaxPages is the maximum number of pages downloaded for each URL when NextPage is set.
+	// If a URL has more pages, the download fails with ErrTooManyPages.
+	// If 0, defaults to 1000.
+	MaxPages int
+
+	// Body is the request body sent to each of the Urls.
+	// This is useful for list services that require a query to be sent with a POST request.
+	// If nil, no body is sent.
+	Body []byte
+
 	// Get is a function to get the domain data.
 	// Either Get or Url must be provided; Get takes precedence over Url.
 	Get func() (io.ReadCloser, error)
 
 	// RefreshInterval is the interval between updating the data from the source.
 	RefreshInterval time.Duration
+
+	// MaxCacheAge is the maximum age of a cached copy of the database that will be loaded during initialization.
+	// If the cached copy was last updated longer ago than MaxCacheAge, it is downloaded during initialization instead of waiting for the next scheduled update.
+	// If that download fails, the cached copy is loaded anyway.
+	// Has no effect if downloading is disabled.
+	// If 0, cached copies are always loaded regardless of their age.
+	MaxCacheAge time.Duration
+
+	// MinDownloadInterval is the minimum time between successful downloads of the database.
+	// Downloads requested within MinDownloadInterval of the last successful download are skipped and logged, and the database keeps its current contents.
+	// This avoids downloading the same list twice in quick succession when a manual refresh and a scheduled update overlap.
+	// If 0, downloads are never skipped.
+	MinDownloadInterval time.Duration
+
+	// Timeout is the maximum amount of time to spend downloading each of the source's URLs, including reading the response body.
+	// It is enforced independently of the HTTP client's timeout, so whichever is shorter applies.
+	// It does not apply to Get.
+	// If 0, only the HTTP client's timeout applies.
+	Timeout time.Duration
+
+	// CommentPrefixes are the prefixes that mark a line as a comment.
+	// Lines that start with any of the prefixes (ignoring leading whitespace) are skipped.
+	// If nil, the prefixes returned by DefaultCommentPrefixes are used.
+	CommentPrefixes []string
+
+	// If true, comments that follow a domain name on the same line are stripped (e.g. "example.com # note").
+	// A comment prefix is only treated as the start of an inline comment if it is preceded by whitespace.
+	StripInlineComments bool
+
+	// If true, no lines are treated as comments, regardless of CommentPrefixes and StripInlineComments.
+	// Use this for lists where entries may legitimately start with a comment prefix.
+	// Comment skipping is enabled by default.
+	DisableComments bool
+
+	// MaxDomains is the maximum number of unique domains the database may hold.
+	// This protects against running out of memory if a source unexpectedly grows, for example if an upstream list accidentally merges in a huge dataset.
+	// By default, a load that exceeds MaxDomains fails with ErrTooManyDomains, and the previously loaded domains are kept.
+	// If TruncateAtMaxDomains is true, the first MaxDomains unique domains are loaded instead, and a warning is logged.
+	// If 0, there is no limit.
+	MaxDomains int
+
+	// If true, loads that exceed MaxDomains are truncated rather than failing.
+	// See MaxDomains.
+	TruncateAtMaxDomains bool
+
+	// If true, the database's match results are inverted, so a domain is found only if it is NOT in the list.
+	// This gives "allow only these domains" semantics without callers having to invert the results themselves.
+	// It applies to every lookup method, including CheckDomain and Explain.
+	// Disabled and uninitialized databases still never match, even if they are negated.
+	Negate bool
+
+	// If true, only the first whitespace-delimited token of each line is used as the domain name, and the rest of the line is ignored.
+	// This is useful for lists that annotate entries after the domain name (e.g. "example.com  added 2023").
+	// It also handles hosts files: if the first token is an IP address and is followed by another token (e.g. "0.0.0.0 example.com"), the second token is used instead.
+	// Only the first domain name of a hosts line is used, so lines that list several names should be split up beforehand.
+	FirstTokenOnly bool
+
+	// MaxLineSize is the maximum length of a single line in the source data, in bytes.
+	// Lines longer than this cause the load to fail with bufio.ErrTooLong.
+	// If 0, defaults to bufio.MaxScanTokenSize (64KiB).
+	MaxLineSize int
+
+	// Compression is the compression format of the source's data.
+	// It applies to each URL's response body separately, and to the data returned by Get.
+	// Use CompressionAuto to detect the format from URL suffixes and magic bytes.
+	// Defaults to CompressionNone.
+	Compression Compression
+
+	// Archive is the archive format of the source's data, for lists that are bundled in an archive.
+	// Like Compression, it applies to each URL's response body separately, and to the data returned by Get.
+	// The data is decompressed according to Compression before it is read as an archive.
+	// Defaults to ArchiveFormatNone.
+	Archive ArchiveFormat
+
+	// ArchiveMembers are the paths of the archive members that make up the list, if Archive is set.
+	// Paths are matched with path.Match, so they may be glob patterns like "lists/*.txt".
+	// The matching members are concatenated in the order they appear in the archive; directories and other non-regular members are skipped.
+	// If no members match, the download fails with ErrNoArchiveMembers.
+	// Required if Archive is set.
+	ArchiveMembers []string
+
+	// NewMatcher creates a custom membership backend for the database, which answers lookups instead of the built-in domain set.
+	// A new Matcher is created for each load, filled with Matcher.Add, and swapped in once the load succeeds, so a failed load keeps the previous one.
+	// Downloading, caching, normalization and updates work the same as for any other database.
+	//
+	// The built-in domain set is still kept, since it backs features like SearchDomains, domain counts and the cache written by Flush.
+	// Temporary overrides, DataSource.Negate and disabling the database still apply on top of the Matcher.
+	// Category databases (see DataSource.Categories) use the built-in domain set, and a database with a Matcher cannot be in Options.Dedupe.
+	// If nil, lookups use the built-in domain set.
+	NewMatcher func() Matcher
+
+	// OnProbableMatch confirms a match reported by the database's Matcher before a lookup reports it, for Matchers that are approximate, such as Bloom filters.
+	// It is called with the normalized domain, only when the Matcher reports a match, and returns whether the domain is really in the database.
+	// This lets an approximate Matcher save memory without exposing its false positives, by checking probable matches against a smaller exact set or the origin.
+	// If it returns an error, the lookup fails with an error wrapping it.
+	//
+	// It is called concurrently from multiple goroutines, without holding any locks, so it may be slow, but every positive lookup waits for it.
+	// Temporary overrides take precedence, so it is not called for overridden domains.
+	// It can only be set together with NewMatcher.
+	// If nil, matches reported by the Matcher are returned as-is.
+	OnProbableMatch func(domain string) (bool, error)
+
+	// Mode determines whether the database stores domain names or email addresses.
+	// Defaults to DatabaseModeDomain.
+	Mode DatabaseMode
+
+	// EmailRules determine how the local parts of email addresses are normalized.
+	// Only used if Mode is DatabaseModeEmail.
+	EmailRules EmailRules
+
+	// Categories are the categories of a categorized list, where each line has a domain and a category, like "example.com,phishing".
+	// Each category becomes a separate database named after the source database and the category, like "threats/phishing", which can be queried like any other database.
+	// The source database itself contains the domains of every category, including categories that are not listed.
+	// This allows a large categorized feed to be downloaded and parsed once for all of its categories.
+	//
+	// Category databases are loaded, cached and updated along with their source database; they cannot be loaded on their own.
+	// They share the source's other options, such as Negate, and their LoadStats only count the lines of their own category.
+	Categories []string
+
+	// CategoryParser splits a line of a categorized list into its domain and category.
+	// It receives lines after comments have been removed.
+	// If nil, ParseCommaCategory is used.
+	// Only used if Categories is not empty.
+	CategoryParser func(line string) (domain string, category string)
+
+	// If true, normalization results are cached between loads, so entries that are unchanged since the previous load skip normalization.
+	// This reduces the CPU cost of reloading large lists that change slowly.
+	// Entries that are not present in the latest load are evicted from the cache, so it holds at most the entries of one load.
+	// The cache roughly doubles the memory used by the database, so it is disabled by default.
+	CacheNormalization bool
+
+	// DomainCountHint is the expected number of unique domains in the database.
+	// The domain set of the first load is allocated with room for this many domains, which avoids repeatedly growing it while a large list loads.
+	// Later loads are sized from the number of unique domains in the previous load instead.
+	// It only affects performance, so an inaccurate hint is harmless.
+	// If 0, the first load starts with an empty set.
+	DomainCountHint int
+}
+
+// UrlMode determines how a DataSource with multiple URLs uses them.
+type UrlMode int
+
+const (
+	// UrlModeConcatenate downloads all URLs and combines them into a single list.
+	// Use this when each URL is a different list.
+	UrlModeConcatenate UrlMode = iota
+
+	// UrlModeFailover treats the URLs as mirrors of the same list, in priority order.
+	// URLs are tried in order, and the first one that downloads and parses successfully is used; the remaining URLs are not downloaded.
+	UrlModeFailover
+)
+
+// NamedSource is a data source along with the name of its database.
+// See Options.OrderedSources.
+type NamedSource struct {
+	// The name of the database.
+	Name string
+
+	// The database's source.
+	Source *DataSource
 }
 
 // Options are options for creating an DomainDb instance.
 // Any omitted DataSource fields will be disabled and unavailable, even if cached files for them exist.
+// To inspect such cached files, use DomainDb.ReadCachedDatabases.
 type Options struct {
 	// The storage driver used to store cached databases and checkpoint information.
 	// Unless you have a custom driver you want to use, you should most likely use FsStorageDriver.
@@ -86,12 +408,33 @@ type Options struct {
 
 	// By default, DomainDb uses slog.Default.
 	// If Logger is specified, it will use it instead.
+	//
+	// Attributes already added to the logger with slog.Logger.With (such as an instance ID or environment) are kept on every log line.
+	// DomainDb only adds its own attributes on top of them: "service" with the value "domaindb.DomainDb", and message-specific attributes like "database_name".
 	Logger *slog.Logger
 
 	// Overrides the default HTTP client if not nil.
 	// If nil, uses a default HTTP client with a 10-second timeout.
+	// The default client supports HTTP/2 and reuses connections, which reduces latency when a source has many URLs on the same host.
+	// A provided HttpClient takes full precedence over other HTTP options such as ProxyUrl.
 	HttpClient *http.Client
 
+	// The URL of an HTTP proxy to send source requests through.
+	// Only applies to the default HTTP client; it is ignored if HttpClient is provided.
+	// If nil, the default HTTP client uses the proxy specified by the environment (see http.ProxyFromEnvironment).
+	ProxyUrl *url.URL
+
+	// If true, requests to source URLs do not advertise gzip support with an Accept-Encoding header.
+	// By default, gzip is requested explicitly, so that responses are compressed in transit regardless of the HTTP client's transport; custom transports do not request it on their own.
+	// Responses with a gzip Content-Encoding are decoded either way.
+	// This is independent of DataSource.Compression, which applies to the list after any Content-Encoding is decoded.
+	DisableHttpCompression bool
+
+	// Overrides the default domain normalizer if not nil.
+	// The normalizer is used for both loaded domain names and queried domain names, so matching stays symmetric.
+	// If nil, uses normalize.NewDomainNormalizer with no options.
+	Normalizer *normalize.DomainNormalizer
+
 	// If true, disables downloading from sources and only uses cached database files.
 	//
 	// Important: You must still provide sources for the databases you want to use, regardless of whether download is disabled.
@@ -102,24 +445,170 @@ type Options struct {
 	// This can be useful if you're developing and don't want database loading to block startup.
 	// It is NOT recommended for production.
 	//
+	// If false (the default), NewDomainDb blocks only until every database has been loaded once, either from cache or by downloading it.
+	// Lookups are correct as soon as NewDomainDb returns, and all subsequent scheduled updates run in the background without blocking.
+	// If any database fails to load, NewDomainDb returns an error, unless StartupPolicy is StartupPolicyBestEffort.
+	//
 	// Important: Any methods on DomainDb that require databases to be initialized will fail until the databases have loaded.
 	LoadDatabasesInBackground bool
 
+	// If true, databases that fail their initial load do not fail initialization.
+	// This is the same as setting StartupPolicy to StartupPolicyBestEffort.
+	// If StartupPolicy is StartupPolicyFailOnAnyError, NewDomainDb returns an error, since the two contradict each other.
+	//
+	// Deprecated: Use StartupPolicy instead.
+	PartialInit bool
+
+	// StartupPolicy determines whether a database that fails its initial load fails NewDomainDb.
+	// With StartupPolicyBestEffort, NewDomainDb starts with the databases that loaded, and retries the rest in the background every PartialInitRetryInterval.
+	// Use DomainDb.NotReadyDatabases to find out which databases have not loaded yet.
+	// With StartupPolicyFailOnAnyError, any failure fails NewDomainDb.
+	// If StartupPolicyDefault, the policy is StartupPolicyFailOnAnyError, unless the deprecated PartialInit is true.
+	StartupPolicy StartupPolicy
+
+	// The time between retries of databases that failed their initial load with StartupPolicyBestEffort.
+	// If 0, defaults to 1 minute.
+	PartialInitRetryInterval time.Duration
+
+	// If true, databases that were loaded from cache during initialization are refreshed in the background right away, rather than waiting until their next scheduled update.
+	// Refreshes are randomly delayed by up to 30 seconds so that all databases do not download at once.
+	// Initialization still only waits for the cached copies to load, so startup is not slowed down.
+	// Has no effect if downloading is disabled.
+	//
+	// By default, a database's first scheduled update is at its last update time (from its checkpoint) plus its RefreshInterval, regardless of when the process started.
+	// RefreshOnStartup overrides that schedule for every cached database; use RefreshOnStartupFreshFraction to keep it for caches that are still fresh.
+	RefreshOnStartup bool
+
+	// The fraction of a database's RefreshInterval within which its cached copy is considered fresh, so that RefreshOnStartup does not refresh it.
+	// For example, with 0.5 and a RefreshInterval of 24 hours, a cache that was updated less than 12 hours before startup keeps its normal schedule of last update plus 24 hours, and older caches are refreshed on startup.
+	// This prevents frequent restarts from causing premature refreshes.
+	// If 0, all cached databases are refreshed on startup.
+	// Has no effect if RefreshOnStartup is false.
+	RefreshOnStartupFreshFraction float64
+
+	// If true, NewDomainDb fails if the saved checkpoints are corrupt.
+	// By default, corrupt checkpoints are logged as a warning and discarded; cached databases are still loaded, and they are refreshed as if they had never been updated.
+	StrictCheckpoints bool
+
+	// If true, databases whose sources changed since they were cached are still loaded from cache during initialization, and the change is only logged as a warning.
+	// Sources are considered changed if their URLs, URL mode, method, body, archive members, or whether they have a Get method changed.
+	// By default, such databases are downloaded instead, since their cached copies may be from a list that is no longer configured.
+	// Either way, the change is no longer detected once the database has been downloaded.
+	// Checkpoints saved before source changes were tracked are assumed to match the current sources.
+	KeepCacheOnSourceChange bool
+
 	// A mapping of database names to their underlying sources.
 	// Each source's URL must point to a file containing a newline-separated list of domain names.
-	// Empty lines are ignored.
+	// Empty lines and comments are ignored.
+	// Entries like "*.gov" are wildcards that match every subdomain of "gov" at any depth, but not "gov" itself.
 	Sources map[string]*DataSource
+
+	// Sources in the order they should be loaded during initialization.
+	// Databases are loaded and their updaters are started in this order, which makes startup logs and behavior reproducible.
+	// If LoadConcurrency is greater than 1, loads are started in this order, but they may finish in any order.
+	// May be used together with Sources, in which case the databases in OrderedSources are loaded first, followed by the databases in Sources sorted by name.
+	// A database name must not appear more than once across OrderedSources and Sources.
+	OrderedSources []NamedSource
+
+	// The maximum number of databases to load concurrently during initialization.
+	// Databases are independent, so loading them concurrently reduces startup time when there are many large databases.
+	// Set to 1 to load databases one at a time.
+	// If 0, defaults to runtime.GOMAXPROCS(0).
+	LoadConcurrency int
+
+	// If not nil, called for lines that fail normalization while loading a database.
+	// rawLine is the line as it appeared in the source, and err is the normalization error.
+	// To avoid flooding the callback with a list that is entirely malformed, it is called for at most the first 100 failures of each load; the total is still available in LoadStats.FailedLines.
+	// It is called synchronously from the goroutine loading the database, so it should return quickly.
+	OnNormalizeFailure func(dbName string, rawLine string, err error)
+
+	// If not nil, applied to every domain after it is normalized, both when loading databases and when looking up domains.
+	// Because the same transform is applied to both, matching stays symmetric.
+	// It receives the normalized domain and returns the domain to use, and whether to keep it.
+	// Loaded domains that are not kept are skipped, and queried domains that are not kept do not match any database (negated databases still match them).
+	// It must be deterministic and safe to call from multiple goroutines.
+	//
+	// It must also be idempotent: transforming a domain that it already returned must return the same domain.
+	// DomainDb.Flush and DomainDb.ReplaceDomains write the already-transformed domains to storage, and the transform is applied again when that cached copy is loaded.
+	// For example, stripping a single leading label is not idempotent, since it strips another label after a restart, while stripping every leading "www." label is.
+	DomainTransform func(domain string) (string, bool)
+
+	// The maximum number of queried domains whose normalized form is cached, so that repeated lookups of the same domain skip normalization.
+	// Domains are cached as they are queried, exactly as they were passed in, until the cache is full; after that, new domains are normalized on every lookup.
+	// Use DomainDb.WarmCache to fill the cache with the domains that are queried most often.
+	// Only lookups of domains use the cache; loading databases and looking up email addresses do not.
+	// If 0, queried domains are not cached.
+	LookupCacheSize int
+
+	// If true, cached copies of databases are compressed with zstd before they are written to storage.
+	// This greatly reduces the disk space used by large lists, at the cost of some CPU when caching and loading.
+	// Cached copies are decompressed based on their contents, so this can be turned on or off without clearing the cache.
+	CompressCache bool
+
+	// The directory where downloads are spooled while they are parsed, before they are written to the cache.
+	// If empty, os.TempDir is used.
+	TempDir string
+
+	// Database names in order of precedence for deduplication.
+	// A domain that is in more than one of these databases is only kept in the first one, which saves memory when lists overlap heavily.
+	// For example, with ["primary", "secondary"], domains in "primary" are removed from "secondary".
+	//
+	// Deduplication is applied whenever one of the databases is loaded: its new domains are removed from the databases after it, and the domains of the databases before it are removed from its new domains.
+	// Domains that are removed from an earlier database are not restored to later databases until they are next loaded.
+	// Only exact entries are compared, so a domain covered by a wildcard entry in an earlier database is kept.
+	// LoadStats.DedupedDomains counts the domains each database lost to deduplication.
+	//
+	// Each name must be a configured database, and category databases (see DataSource.Categories) are not allowed.
+	// The category databases of a deduplicated database keep all of their domains.
+	// If empty, databases are not deduplicated.
+	Dedupe []string
+
+	// The maximum number of requests per second to each host, across all sources.
+	// Requests that would exceed the limit are delayed, which is logged.
+	// This avoids tripping the rate limits of providers that host many lists, such as GitHub, when many sources refresh together.
+	// Applies to every request to a source URL, including each page of paginated sources, but not to sources with a Get method.
+	// If 0, requests are not limited.
+	HostRequestsPerSecond float64
+
+	// The maximum number of requests to each host that may be made at once before HostRequestsPerSecond applies.
+	// If 0, defaults to 1.
+	// Has no effect if HostRequestsPerSecond is 0.
+	HostRequestBurst int
 }
 
 // NewDomainDb creates a new DomainDb instance.
-// Blocks until the databases are loaded, unless Options.LoadDatabasesInBackground is true.
+// If any database name in Options.Sources is longer than DbNameMaxSize, returns an error wrapping ErrDbNameTooLong.
+// Blocks until the databases are initially loaded, unless Options.LoadDatabasesInBackground is true.
+// Subsequent updates always happen in the background.
 // There should only be one instance of DomainDb per storage driver or storage location, and ideally only one per process.
 // If error is nil, the returned DomainDb instance will never be nil.
 func NewDomainDb(options Options) (*DomainDb, error) {
+	switch options.StartupPolicy {
+	case StartupPolicyDefault:
+	case StartupPolicyFailOnAnyError:
+		if options.PartialInit {
+			return nil, errors.New("Options.PartialInit cannot be true with StartupPolicyFailOnAnyError (see Options.StartupPolicy)")
+		}
+	case StartupPolicyBestEffort:
+		options.PartialInit = true
+	default:
+		return nil, fmt.Errorf(`unknown startup policy %d (see Options.StartupPolicy)`, options.StartupPolicy)
+	}
+
 	var httpClient *http.Client
 	if options.HttpClient == nil {
+		// Sources often have many URLs on the same host, so allow more idle connections per host to be kept for reuse.
+		// The cloned default transport already uses keep-alive and attempts HTTP/2.
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.ForceAttemptHTTP2 = true
+		transport.MaxIdleConnsPerHost = defaultMaxIdleConnsPerHost
+		if options.ProxyUrl != nil {
+			transport.Proxy = http.ProxyURL(options.ProxyUrl)
+		}
+
 		httpClient = &http.Client{
-			Timeout: defaultHttpClientTimeout,
+			Transport: transport,
+			Timeout:   defaultHttpClientTimeout,
 		}
 	} else {
 		httpClient = options.HttpClient
@@ -132,36 +621,133 @@ func NewDomainDb(options Options) (*DomainDb, error) {
 		logger = options.Logger
 	}
 
+	// Add the service attribute once, on top of any attributes the logger already has.
+	logger = logger.With("service", "domaindb.DomainDb")
+
+	normalizer := options.Normalizer
+	if normalizer == nil {
+		normalizer = normalize.NewDomainNormalizer()
+	}
+
+	sources := make([]NamedSource, 0, len(options.OrderedSources)+len(options.Sources))
+	sources = append(sources, options.OrderedSources...)
+	for _, name := range sortedNames(options.Sources) {
+		sources = append(sources, NamedSource{
+			Name:   name,
+			Source: options.Sources[name],
+		})
+	}
+
+	// Validate sources before doing anything else, so that misconfiguration fails fast.
+	for _, named := range sources {
+		if len(named.Name) > DbNameMaxSize {
+			return nil, fmt.Errorf(`invalid database name "%s": %w`, named.Name, ErrDbNameTooLong)
+		}
+		if named.Source == nil {
+			return nil, fmt.Errorf(`data source for database with name "%s" is nil`, named.Name)
+		}
+		if err := validateArchiveMembers(named.Source); err != nil {
+			return nil, fmt.Errorf(`data source for database with name "%s" is invalid: %w`, named.Name, err)
+		}
+		if named.Source.OnProbableMatch != nil && named.Source.NewMatcher == nil {
+			return nil, fmt.Errorf(`data source for database with name "%s" has OnProbableMatch but no NewMatcher`, named.Name)
+		}
+	}
+
 	// Create source maps.
-	dbs := make(map[string]*dbSrcMap)
-	for name, src := range options.Sources {
-		dbs[name] = &dbSrcMap{
+	dbs := make(map[string]*dbSrcMap, len(sources))
+	dbOrder := make([]string, 0, len(sources))
+	for _, named := range sources {
+		if _, has := dbs[named.Name]; has {
+			return nil, fmt.Errorf(`database with name "%s" was specified more than once`, named.Name)
+		}
+
+		data := &dbSrcMap{
 			Has:             false,
-			Src:             src,
+			Src:             named.Source,
 			Mu:              xsync.NewRBMutex(),
 			Domains:         make(map[string]struct{}),
 			LastUpdatedUnix: 0,
+
+			SourceFingerprint: sourceFingerprint(named.Source),
+		}
+		dbs[named.Name] = data
+		dbOrder = append(dbOrder, named.Name)
+
+		// Category databases are not part of dbOrder, since they are loaded and updated along with their source database.
+		if len(named.Source.Categories) > 0 {
+			data.CategoryDbs = make(map[string]*dbSrcMap, len(named.Source.Categories))
+		}
+		for _, category := range named.Source.Categories {
+			if category == "" {
+				return nil, fmt.Errorf(`data source for database with name "%s" has an empty category`, named.Name)
+			}
+			if _, has := data.CategoryDbs[category]; has {
+				return nil, fmt.Errorf(`data source for database with name "%s" has category "%s" more than once`, named.Name, category)
+			}
+
+			name := categoryDbName(named.Name, category)
+			if len(name) > DbNameMaxSize {
+				return nil, fmt.Errorf(`invalid category database name "%s": %w`, name, ErrDbNameTooLong)
+			}
+			if _, has := dbs[name]; has {
+				return nil, fmt.Errorf(`database with name "%s" was specified more than once`, name)
+			}
+
+			categoryData := &dbSrcMap{
+				Has:     false,
+				Src:     named.Source,
+				Mu:      xsync.NewRBMutex(),
+				Domains: make(map[string]struct{}),
+				Parent:  named.Name,
+			}
+			data.CategoryDbs[category] = categoryData
+			dbs[name] = categoryData
 		}
 	}
 
+	dedupeRanks, err := newDedupeRanks(options.Dedupe, dbs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Options.Dedupe: %w", err)
+	}
+
+	loadConcurrency := options.LoadConcurrency
+	if loadConcurrency <= 0 {
+		loadConcurrency = runtime.GOMAXPROCS(0)
+	}
+
 	s := &DomainDb{
 		storage:    options.StorageDriver,
 		disableDl:  options.DisableDownload,
 		httpClient: httpClient,
 		logger:     logger,
-		normalizer: normalize.NewDomainNormalizer(),
+		normalizer: normalizer,
 		updates:    make(chan dbUpdate, 8),
+		closing:    make(chan struct{}),
+
+		disableHttpCompression: options.DisableHttpCompression,
+
+		onNormalizeFailure: options.OnNormalizeFailure,
+		transform:          options.DomainTransform,
+		lookupCache:        newLookupCache(options.LookupCacheSize),
+
+		tempDir: options.TempDir,
 
-		dbs: dbs,
+		dedupeOrder:   slices.Clone(options.Dedupe),
+		dedupeRanks:   dedupeRanks,
+		compressCache: options.CompressCache,
+		hostLimiters:  newHostLimiters(options.HostRequestsPerSecond, options.HostRequestBurst),
 
-		isRunning: true,
+		dbs:     dbs,
+		dbOrder: dbOrder,
+
+		config: newConfigSnapshot(options, httpClient, loadConcurrency, sources),
 	}
+	s.isRunning.Store(true)
 
 	ctx := context.Background()
 
-	s.logger.Log(ctx, slog.LevelInfo, "initializing DomainDb",
-		"service", "domaindb.DomainDb",
-	)
+	s.logger.Log(ctx, slog.LevelInfo, "initializing DomainDb")
 
 	alreadyHadCheckpoints := false
 	checkpoints, err := s.storage.ReadCheckpoints()
@@ -172,69 +758,170 @@ func NewDomainDb(options Options) (*DomainDb, error) {
 			checkpoints = &AllCheckpoints{
 				Checkpoints: make(map[string]Checkpoint),
 			}
+		} else if errors.Is(err, ErrCorruptCheckpoints) && !options.StrictCheckpoints {
+			s.logger.Log(ctx, slog.LevelWarn, "saved checkpoints are corrupt, discarding them",
+				"error", err,
+			)
+
+			// The cached databases may still be fine, so try to load them.
+			// Since there are no checkpoints, they will be refreshed as soon as their updaters start.
+			alreadyHadCheckpoints = true
+			checkpoints = &AllCheckpoints{
+				Checkpoints: make(map[string]Checkpoint),
+			}
 		} else {
 			return nil, fmt.Errorf("failed to load checkpoints during initialization: %w", err)
 		}
 	}
+	s.checkpoints = checkpoints
 
-	setup := func() error {
-		var err error
+	// loadInitial loads the database with the specified name during initialization, either from cache or by downloading it.
+	loadInitial := func(name string) error {
+		data := dbs[name]
 
-		toClose := make([]io.Closer, 0, len(dbs))
-		defer func() {
-			for _, c := range toClose {
-				if c != nil {
-					_ = c.Close()
+		if !s.isRunning.Load() {
+			return nil
+		}
+
+		// Databases are loaded concurrently, and retried after the checkpoint writer has started, so the checkpoints must be read under the lock.
+		s.checkpointsMu.Lock()
+		chkPnt := checkpoints.Checkpoints[name]
+		s.checkpointsMu.Unlock()
+
+		if stored := chkPnt.SourceFingerprint; alreadyHadCheckpoints && stored != "" && stored != data.SourceFingerprint {
+			if options.KeepCacheOnSourceChange || s.disableDl {
+				s.logger.Log(ctx, slog.LevelWarn, "sources of database changed since it was cached, but loading it from cache anyway",
+					"database_name", name,
+				)
+			} else {
+				s.logger.Log(ctx, slog.LevelInfo, "sources of database changed since it was cached, downloading it",
+					"database_name", name,
+				)
+
+				// The cached copy may be from a list that is no longer configured, so unlike with MaxCacheAge, there is no fallback to it.
+				err := s.DownloadAndLoadDatabase(name)
+				if err != nil {
+					return fmt.Errorf(`failed to download database with name "%s" whose sources changed during initialization: %w`, name, err)
 				}
-			}
-		}()
 
-		for name, data := range dbs {
-			// Read databases.
-			if !s.isRunning {
+				data.LastUpdatedUnix = time.Now().Unix()
 				return nil
 			}
+		}
 
-			var reader io.ReadCloser
-			if alreadyHadCheckpoints {
-				s.logger.Log(ctx, slog.LevelDebug, "reading database from cache",
-					"service", "domaindb.DomainDb",
+		if alreadyHadCheckpoints && !s.disableDl && data.Src.MaxCacheAge > 0 {
+			lastUpdated := time.Unix(chkPnt.LastUpdatedUnix, 0)
+			if time.Since(lastUpdated) > data.Src.MaxCacheAge {
+				s.logger.Log(ctx, slog.LevelInfo, "cached database is older than its max cache age, downloading it",
 					"database_name", name,
+					"last_updated", lastUpdated,
+					"max_cache_age", data.Src.MaxCacheAge,
 				)
 
-				reader, err = s.storage.ReadDatabase(name)
-				if err != nil && !errors.Is(err, syscall.ENOENT) {
-					return fmt.Errorf(`failed to read database with name "%s" during initialization: %w`, name, err)
+				err := s.DownloadAndLoadDatabase(name)
+				if err == nil {
+					data.LastUpdatedUnix = time.Now().Unix()
+					return nil
 				}
-				toClose = append(toClose, reader)
+
+				// Stale data is better than no data, so fall back to the cache.
+				s.logger.Log(ctx, slog.LevelWarn, "failed to download database that exceeded its max cache age, falling back to cache",
+					"database_name", name,
+					"error", err,
+				)
 			}
-			if reader == nil {
-				// No cached database.
-				if s.disableDl {
-					return fmt.Errorf(`cannot download database with name "%s" during initialization: %w`, name, ErrNoCacheAndNoDownload)
-				}
+		}
 
-				// Try downloading it.
-				err = s.DownloadAndLoadDatabase(name)
-				if err != nil {
-					return fmt.Errorf(`failed to download database with name "%s" during initialization: %w`, name, err)
-				}
+		var reader io.ReadCloser
+		if alreadyHadCheckpoints {
+			s.logger.Log(ctx, slog.LevelDebug, "reading database from cache",
+				"database_name", name,
+			)
 
-				data.LastUpdatedUnix = time.Now().Unix()
-			} else {
-				err = s.loadDomainsFromReader(reader, name)
-				if err != nil {
-					return fmt.Errorf(`failed to load database with name "%s" during initialization: %w`, name, err)
+			var err error
+			reader, err = s.readCache(name)
+			if err != nil && !errors.Is(err, syscall.ENOENT) {
+				return fmt.Errorf(`failed to read database with name "%s" during initialization: %w`, name, err)
+			}
+		}
+		if reader == nil {
+			// No cached database.
+			if s.disableDl {
+				return fmt.Errorf(`cannot download database with name "%s" during initialization: %w`, name, ErrNoCacheAndNoDownload)
+			}
+
+			// Try downloading it.
+			err := s.DownloadAndLoadDatabase(name)
+			if err != nil {
+				return fmt.Errorf(`failed to download database with name "%s" during initialization: %w`, name, err)
+			}
+
+			data.LastUpdatedUnix = time.Now().Unix()
+			return nil
+		}
+
+		defer func() {
+			_ = reader.Close()
+		}()
+
+		err := s.loadDomainsFromReader(reader, name, LoadSourceCache)
+		if err != nil {
+			return fmt.Errorf(`failed to load database with name "%s" during initialization: %w`, name, err)
+		}
+
+		return nil
+	}
+
+	setup := func() error {
+		var err error
+
+		// Load databases concurrently, with at most loadConcurrency loads in progress at once.
+		// Loads are started in order, and errors are reported in the same order.
+		loadErrs := make([]error, len(dbOrder))
+		sem := make(chan struct{}, loadConcurrency)
+		var loaders sync.WaitGroup
+		for i, name := range dbOrder {
+			sem <- struct{}{}
+			loaders.Go(func() {
+				defer func() {
+					<-sem
+				}()
+
+				loadErrs[i] = loadInitial(name)
+			})
+		}
+		loaders.Wait()
+
+		// With StartupPolicyBestEffort, failed databases are retried in the background instead of failing initialization.
+		failedLoads := make(map[string]struct{})
+		if options.PartialInit {
+			for i, name := range dbOrder {
+				if loadErrs[i] == nil {
+					continue
 				}
+
+				s.logger.Log(ctx, slog.LevelWarn, "database failed its initial load, it will be retried in the background",
+					"database_name", name,
+					"error", loadErrs[i],
+				)
+				failedLoads[name] = struct{}{}
+				loadErrs[i] = nil
 			}
 		}
 
-		if !s.isRunning {
+		if err = errors.Join(loadErrs...); err != nil {
+			return err
+		}
+
+		if !s.isRunning.Load() {
 			return nil
 		}
 
 		// Populate checkpoints as needed.
-		for name, data := range dbs {
+		s.checkpointsMu.Lock()
+		for _, name := range dbOrder {
+			data := dbs[name]
+
 			var chkPnt Checkpoint
 			var has bool
 			chkPnt, has = checkpoints.Checkpoints[name]
@@ -248,23 +935,34 @@ func NewDomainDb(options Options) (*DomainDb, error) {
 				chkPnt.LastUpdatedUnix = data.LastUpdatedUnix
 			}
 
+			// A database that was loaded from cache keeps the fingerprint of the sources it was downloaded with, so that a change is still detected until it is downloaded.
+			if data.LastUpdatedUnix != 0 || chkPnt.SourceFingerprint == "" {
+				chkPnt.SourceFingerprint = data.SourceFingerprint
+			}
+
 			checkpoints.Checkpoints[name] = chkPnt
 		}
 
 		// Save checkpoints.
 		// This is necessary because there could have been database downloads, or checkpoints have never been saved.
 		err = s.storage.WriteCheckpoints(checkpoints)
+		s.checkpointsMu.Unlock()
 		if err != nil {
 			return fmt.Errorf("failed to save checkpoints after initial load: %w", err)
 		}
 
-		if !s.isRunning {
+		s.lifecycleMu.Lock()
+		defer s.lifecycleMu.Unlock()
+
+		if !s.isRunning.Load() {
 			return nil
 		}
 
 		// In the background, save checkpoint updates.
-		go func() {
+		s.checkpointWriter.Go(func() {
 			for update := range s.updates {
+				s.checkpointsMu.Lock()
+
 				var chkPnt Checkpoint
 				var has bool
 				chkPnt, has = checkpoints.Checkpoints[update.Name]
@@ -275,80 +973,202 @@ func NewDomainDb(options Options) (*DomainDb, error) {
 						LastUpdatedUnix: update.Ts.Unix(),
 					}
 				}
+				chkPnt.SourceFingerprint = dbs[update.Name].SourceFingerprint
 				checkpoints.Checkpoints[update.Name] = chkPnt
 
 				err := s.storage.WriteCheckpoints(checkpoints)
+				s.checkpointsMu.Unlock()
 				if err != nil {
 					s.logger.Log(ctx, slog.LevelError, "failed to save checkpoints after receiving checkpoint update",
-						"service", "domaindb.DomainDb",
 						"database_name", update.Name,
 						"error", err,
 					)
 				}
 			}
-		}()
+		})
+
+		retryInterval := options.PartialInitRetryInterval
+		if retryInterval <= 0 {
+			retryInterval = defaultPartialInitRetryInterval
+		}
+
+		// Start updaters for enabled databases, and retry databases that failed their initial load.
+		for _, name := range dbOrder {
+			data := dbs[name]
+
+			if _, failed := failedLoads[name]; failed {
+				s.updaters.Go(func() {
+					if !s.retryInitialLoad(name, retryInterval, loadInitial) {
+						return
+					}
+
+					s.checkpointsMu.Lock()
+					lastUpdatedUnix := checkpoints.Checkpoints[name].LastUpdatedUnix
+					s.checkpointsMu.Unlock()
+
+					if data.LastUpdatedUnix != 0 {
+						// The database was downloaded, so its checkpoint must be saved.
+						lastUpdatedUnix = data.LastUpdatedUnix
+						s.updates <- dbUpdate{
+							Ts:   time.Unix(lastUpdatedUnix, 0),
+							Name: name,
+						}
+					}
+
+					if !s.disableDl {
+						s.runUpdater(
+							name,
+							time.Unix(lastUpdatedUnix, 0).Add(data.Src.RefreshInterval),
+							data.Src.RefreshInterval,
+						)
+					}
+				})
+				continue
+			}
 
-		if !s.disableDl {
-			// Start updaters for enabled databases.
-			for name, data := range dbs {
-				chkPnt := checkpoints.Checkpoints[name]
-				go s.runUpdater(
+			if s.disableDl {
+				continue
+			}
+
+			s.checkpointsMu.Lock()
+			chkPnt := checkpoints.Checkpoints[name]
+			s.checkpointsMu.Unlock()
+
+			firstUpdateTs := time.Unix(chkPnt.LastUpdatedUnix, 0).Add(data.Src.RefreshInterval)
+
+			// Databases that were downloaded during initialization are already fresh.
+			if options.RefreshOnStartup && data.LastLoad.Source == LoadSourceCache && !isCacheFresh(chkPnt, data.Src.RefreshInterval, options.RefreshOnStartupFreshFraction) {
+				// Jitter the refreshes so that all databases do not download at once.
+				firstUpdateTs = time.Now().Add(rand.N(refreshOnStartupMaxJitter))
+			}
+
+			s.updaters.Go(func() {
+				s.runUpdater(
 					name,
-					time.Unix(chkPnt.LastUpdatedUnix, 0),
+					firstUpdateTs,
 					data.Src.RefreshInterval,
 				)
-			}
+			})
 		}
 
-		s.logger.Log(ctx, slog.LevelInfo, "finished initializing DomainDb",
-			"service", "domaindb.DomainDb",
-		)
+		s.logger.Log(ctx, slog.LevelInfo, "finished initializing DomainDb")
 
 		return nil
 	}
 
 	if options.LoadDatabasesInBackground {
-		s.logger.Log(ctx, slog.LevelDebug, "loading databases in the background, as requested by DomainDb options",
-			"service", "domaindb.DomainDb",
-		)
-		go func() {
-			if err := setup(); err != nil {
+		s.logger.Log(ctx, slog.LevelDebug, "loading databases in the background, as requested by DomainDb options")
+		s.initializer.Go(func() {
+			// Initialization stops early if the DomainDb instance is closed, which is not a failure.
+			if err := setup(); err != nil && !errors.Is(err, ErrDbClosed) {
 				s.logger.Log(ctx, slog.LevelError, "failed to initialize DomainDb in the background",
-					"service", "domaindb.DomainDb",
 					"error", err,
 				)
 			}
-		}()
+		})
 	} else {
 		if err := setup(); err != nil {
-			return nil, nil
+			return nil, err
 		}
 	}
 
 	return s, nil
 }
 
-// runUpdater runs the updater for the specified DB type.
-func (s *DomainDb) runUpdater(name string, lastUpdate time.Time, updateInterval time.Duration) {
-	if !s.isRunning {
-		return
+// isCacheFresh returns whether a cached copy that was last updated at the checkpoint is younger than fraction of the refresh interval.
+// Always false if fraction is not positive or the database has never been updated.
+func isCacheFresh(chkPnt Checkpoint, refreshInterval time.Duration, fraction float64) bool {
+	if fraction <= 0 || chkPnt.LastUpdatedUnix == 0 {
+		return false
 	}
 
+	freshFor := time.Duration(float64(refreshInterval) * fraction)
+	return time.Since(time.Unix(chkPnt.LastUpdatedUnix, 0)) < freshFor
+}
+
+// retryInitialLoad retries the initial load of a database that failed it, every retryInterval, until it succeeds.
+// Returns true once the database has loaded, or false if the DomainDb instance was closed first.
+func (s *DomainDb) retryInitialLoad(name string, retryInterval time.Duration, load func(name string) error) bool {
+	ctx := context.Background()
+
+	data := s.dbs[name]
+	defer data.NextUpdateNano.Store(0)
+
+	ticker := time.NewTicker(retryInterval)
+	defer ticker.Stop()
+	data.NextUpdateNano.Store(time.Now().Add(retryInterval).UnixNano())
+	for attempt := 1; ; attempt++ {
+		select {
+		case tickTs := <-ticker.C:
+			data.NextUpdateNano.Store(tickTs.Add(retryInterval).UnixNano())
+		case <-s.closing:
+			return false
+		}
+
+		if s.updatesPaused.Load() {
+			s.logger.Log(ctx, slog.LevelDebug, "skipping retry of initial load of database because updates are paused",
+				"database_name", name,
+			)
+			continue
+		}
+
+		err := load(name)
+		if err == nil {
+			s.logger.Log(ctx, slog.LevelInfo, "database that failed its initial load has now loaded",
+				"database_name", name,
+				"attempts", attempt,
+			)
+			return true
+		}
+
+		s.logger.Log(ctx, slog.LevelWarn, "failed to retry initial load of database",
+			"database_name", name,
+			"attempts", attempt,
+			"error", err,
+		)
+	}
+}
+
+// runUpdater runs the updater for the specified DB type.
+// The first update happens at firstUpdateTs, and subsequent updates happen every updateInterval.
+func (s *DomainDb) runUpdater(name string, firstUpdateTs time.Time, updateInterval time.Duration) {
 	ctx := context.Background()
 
 	s.logger.Log(ctx, slog.LevelDebug, "running updater for database",
-		"service", "domaindb.DomainDb",
 		"database_name", name,
 	)
 
+	data := s.dbs[name]
+
 	update := func() error {
-		if err := s.DownloadAndLoadDatabase(name); err != nil {
-			return err
+		if data.Disabled.Load() {
+			s.logger.Log(ctx, slog.LevelDebug, "skipping scheduled update of disabled database",
+				"database_name", name,
+			)
+			return nil
 		}
 
-		if !s.isRunning {
-			return ErrDbClosed
+		if s.updatesPaused.Load() {
+			s.logger.Log(ctx, slog.LevelDebug, "skipping scheduled update of database because updates are paused",
+				"database_name", name,
+			)
+			return nil
 		}
+
+		if err := s.downloadAndLoadDatabaseOrSkip(ctx, name); err != nil {
+			if errors.Is(err, ErrDbClosed) {
+				// Close was called after the update was due, so there is nothing to save.
+				return nil
+			}
+			if errors.Is(err, errDownloadSkipped) {
+				// Nothing was downloaded, so the checkpoint is unchanged and there is no garbage to collect.
+				return nil
+			}
+			return err
+		}
+
+		// The updates channel is not closed until all updaters have returned, so this is safe even if Close has been called.
+		// This ensures the checkpoint for an update that finished during Close is still saved.
 		s.updates <- dbUpdate{
 			Ts:   time.Now(),
 			Name: name,
@@ -361,35 +1181,41 @@ func (s *DomainDb) runUpdater(name string, lastUpdate time.Time, updateInterval
 		return nil
 	}
 
-	firstUpdateTs := lastUpdate.Add(updateInterval)
+	defer data.NextUpdateNano.Store(0)
+
 	firstTimeout := time.NewTimer(firstUpdateTs.Sub(time.Now()))
+	defer firstTimeout.Stop()
+	data.NextUpdateNano.Store(firstUpdateTs.UnixNano())
 
 	// Wait for next update time.
-	<-firstTimeout.C
-	if !s.isRunning {
+	select {
+	case <-firstTimeout.C:
+	case <-s.closing:
 		return
 	}
 
 	err := update()
 	if err != nil {
 		s.logger.Log(ctx, slog.LevelError, "failed to do first scheduled update of database",
-			"service", "domaindb.DomainDb",
 			"database_name", name,
 			"error", err,
 		)
 	}
 
 	ticker := time.NewTicker(updateInterval)
-	for s.isRunning {
-		<-ticker.C
-		if !s.isRunning {
+	defer ticker.Stop()
+	data.NextUpdateNano.Store(time.Now().Add(updateInterval).UnixNano())
+	for {
+		select {
+		case tickTs := <-ticker.C:
+			data.NextUpdateNano.Store(tickTs.Add(updateInterval).UnixNano())
+		case <-s.closing:
 			return
 		}
 
 		err = update()
 		if err != nil {
 			s.logger.Log(ctx, slog.LevelError, "failed to do scheduled update of database",
-				"service", "domaindb.DomainDb",
 				"database_name", name,
 				"error", err,
 			)
@@ -398,95 +1224,281 @@ func (s *DomainDb) runUpdater(name string, lastUpdate time.Time, updateInterval
 }
 
 // openDataSource opens a data source.
+// Requests are made with ctx, so cancelling it aborts the download.
 // The caller must close the returned reader.
 // If the data source has no sources, ErrDataSourceNoSource is returned.
-func (s *DomainDb) openDataSource(src *DataSource) (io.ReadCloser, error) {
-	ctx := context.Background()
-
-	var reader io.ReadCloser
-
+func (s *DomainDb) openDataSource(ctx context.Context, src *DataSource) (io.ReadCloser, error) {
 	if src.Get != nil {
-		s.logger.Log(ctx, slog.LevelDebug, "starting download of database with source Get function",
-			"service", "domaindb.DomainDb",
-		)
+		s.logger.Log(ctx, slog.LevelDebug, "starting download of database with source Get function")
 
-		var err error
-		reader, err = src.Get()
+		reader, err := src.Get()
 		if err != nil {
 			return nil, fmt.Errorf(`failed to get database (source Get function): %w`, err)
 		}
 
-		s.logger.Log(ctx, slog.LevelDebug, "finished download of database with source Get function",
-			"service", "domaindb.DomainDb",
-		)
-	} else if len(src.Urls) > 0 {
+		reader, err = decompressOwned(reader, src.Compression, "")
+		if err != nil {
+			return nil, fmt.Errorf(`failed to decompress database (source Get function): %w`, err)
+		}
+
+		reader, err = s.extractArchiveOwned(reader, src)
+		if err != nil {
+			return nil, fmt.Errorf(`failed to extract database from archive (source Get function): %w`, err)
+		}
+
+		s.logger.Log(ctx, slog.LevelDebug, "finished download of database with source Get function")
+
+		return reader, nil
+	}
+
+	return s.openUrls(ctx, src, src.Urls)
+}
+
+// openUrls opens the specified URLs of a data source and concatenates their bodies into a single reader.
+// URLs that fail are skipped; if all of them fail, the returned reader fails with ErrAllUrlsFailed.
+// Requests are made with ctx; if it is cancelled, the download is aborted and the returned reader fails with the context's error.
+// The caller must close the returned reader.
+// If there are no URLs, ErrDataSourceNoSource is returned.
+func (s *DomainDb) openUrls(ctx context.Context, src *DataSource, urls []*url.URL) (io.ReadCloser, error) {
+	var reader io.ReadCloser
+
+	if len(urls) > 0 {
 		pipeReader, pipeWriter := io.Pipe()
 
 		go func() {
 			var err error
 			var resp *http.Response
 
-			failures := make([]error, 0, len(src.Urls))
+			failures := make([]error, 0, len(urls))
 
-			for _, srcUrl := range src.Urls {
-				func() {
-					s.logger.Log(ctx, slog.LevelDebug, "starting download of database",
-						"service", "domaindb.DomainDb",
-						"source_url", srcUrl,
+			// Set when the download must be aborted entirely, rather than skipping a single URL.
+			var abortErr error
+
+			// downloadPage downloads a single page of a URL and writes its body to the pipe.
+			// Returns the URL of the next page, or nil if there are no more pages or the download failed.
+			// If the download failed, the error is appended to failures, or abortErr is set if the whole download must be aborted.
+			downloadPage := func(pageUrl *url.URL) (next *url.URL) {
+				if err := s.waitForHost(ctx, pageUrl); err != nil {
+					abortErr = err
+					return nil
+				}
+
+				s.logger.Log(ctx, slog.LevelDebug, "starting download of database",
+					"source_url", pageUrl,
+				)
+				startTs := time.Now()
+				method := src.Method
+				if method == "" {
+					method = http.MethodGet
+				}
+				reqCtx := ctx
+				if src.Timeout > 0 {
+					// The deadline covers reading the body as well, which happens before this function returns.
+					var cancel context.CancelFunc
+					reqCtx, cancel = context.WithTimeout(ctx, src.Timeout)
+					defer cancel()
+				}
+				var reqBody io.Reader
+				if src.Body != nil {
+					// NewRequestWithContext sets ContentLength and GetBody for a bytes.Reader, so the body can be resent on redirects and retries.
+					reqBody = bytes.NewReader(src.Body)
+				}
+				var req *http.Request
+				req, err = http.NewRequestWithContext(reqCtx, method, pageUrl.String(), reqBody)
+				if err != nil {
+					failures = append(failures, fmt.Errorf(`failed to create request for database (source URL "%s"): %w`, pageUrl, err))
+					s.logger.Log(ctx, slog.LevelError, "failed to create request for database",
+						"source_url", pageUrl,
+						"error", err,
 					)
-					req := &http.Request{
-						Method: http.MethodGet,
-						URL:    srcUrl,
+					return nil
+				}
+				if !s.disableHttpCompression {
+					// Setting the header explicitly stops the transport from decoding the response itself, so it is decoded below regardless of the transport.
+					req.Header.Set("Accept-Encoding", "gzip")
+				}
+				resp, err = s.httpClient.Do(req)
+				if err != nil {
+					if ctx.Err() != nil {
+						abortErr = fmt.Errorf(`download of database (source URL "%s") was cancelled: %w`, pageUrl, ctx.Err())
+						return nil
 					}
-					resp, err = s.httpClient.Do(req)
-					if err != nil {
-						failures = append(failures, fmt.Errorf(`failed to download database (source URL "%s"): %w`, srcUrl, err))
-						s.logger.Log(ctx, slog.LevelError, "failed to download database",
-							"service", "domaindb.DomainDb",
-							"source_url", srcUrl,
-							"error", err,
-						)
-						return
+
+					failures = append(failures, fmt.Errorf(`failed to download database (source URL "%s"): %w`, pageUrl, err))
+					s.logger.Log(ctx, slog.LevelError, "failed to download database",
+						"source_url", pageUrl,
+						"error", err,
+					)
+					return nil
+				}
+
+				defer func() {
+					_ = resp.Body.Close()
+				}()
+
+				if resp.StatusCode != http.StatusOK {
+					const bodyPreviewBytes = 1024
+					// Try to read first N bytes of body to get a better error message.
+					bodyBytes, _ := io.ReadAll(io.LimitReader(resp.Body, bodyPreviewBytes))
+
+					// Drain the rest of the body so the connection can be reused.
+					// The drain is bounded so that a huge error body cannot stall the download.
+					const maxDrainBytes = 256 * 1024
+					_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, maxDrainBytes))
+
+					bodyStr := string(bodyBytes)
+					failures = append(failures, fmt.Errorf(`failed to download database (source URL "%s") because status code was %d (expected 200): %s`, pageUrl, resp.StatusCode, bodyStr))
+					s.logger.Log(ctx, slog.LevelError, "failed to download database because status code was not 200",
+						"source_url", pageUrl,
+						"status_code", resp.StatusCode,
+						"body", bodyStr,
+					)
+					return nil
+				}
+
+				// Count the bytes received before decompression, so they can be checked against Content-Length.
+				counter := &countingReader{Reader: resp.Body}
+				encoded, err := decodeContentEncoding(counter, resp)
+				if err != nil {
+					failures = append(failures, fmt.Errorf(`failed to decode database (source URL "%s"): %w`, pageUrl, err))
+					s.logger.Log(ctx, slog.LevelError, "failed to decode database",
+						"source_url", pageUrl,
+						"content_encoding", resp.Header.Get("Content-Encoding"),
+						"error", err,
+					)
+					return nil
+				}
+				defer func() {
+					_ = encoded.Close()
+				}()
+
+				body, err := decompress(encoded, src.Compression, pageUrl.Path)
+				if err != nil {
+					failures = append(failures, fmt.Errorf(`failed to decompress database (source URL "%s"): %w`, pageUrl, err))
+					s.logger.Log(ctx, slog.LevelError, "failed to decompress database",
+						"source_url", pageUrl,
+						"compression", src.Compression.String(),
+						"error", err,
+					)
+					return nil
+				}
+				defer func() {
+					_ = body.Close()
+				}()
+
+				extracted, err := s.extractArchive(body, src)
+				if err != nil {
+					failures = append(failures, fmt.Errorf(`failed to extract database from archive (source URL "%s"): %w`, pageUrl, err))
+					s.logger.Log(ctx, slog.LevelError, "failed to extract database from archive",
+						"source_url", pageUrl,
+						"archive", src.Archive.String(),
+						"error", err,
+					)
+					return nil
+				}
+				defer func() {
+					_ = extracted.Close()
+				}()
+
+				bytesWritten, err := io.Copy(pipeWriter, extracted)
+				if err != nil {
+					if ctx.Err() != nil {
+						// Part of the body was already passed on, so the whole download must be aborted.
+						abortErr = fmt.Errorf(`download of database (source URL "%s", bytes written: %d) was cancelled: %w`, pageUrl, bytesWritten, ctx.Err())
+						return nil
 					}
 
-					defer func() {
-						_ = resp.Body.Close()
-					}()
-
-					if resp.StatusCode != http.StatusOK {
-						const bodyPreviewBytes = 1024
-						// Try to read first N bytes of body to get a better error message.
-						bodyBytes, _ := io.ReadAll(io.LimitReader(resp.Body, bodyPreviewBytes))
-
-						bodyStr := string(bodyBytes)
-						failures = append(failures, fmt.Errorf(`failed to download database (source URL "%s") because status code was %d (expected 200): %s`, srcUrl, resp.StatusCode, bodyStr))
-						s.logger.Log(ctx, slog.LevelError, "failed to download database because status code was not 200",
-							"service", "domaindb.DomainDb",
-							"source_url", srcUrl,
-							"status_code", resp.StatusCode,
-							"body", bodyStr,
-						)
-						return
+					failures = append(failures, fmt.Errorf(`failed to download database (source URL "%s", bytes written: %d): %w`, pageUrl, bytesWritten, err))
+					s.logger.Log(ctx, slog.LevelError, "failed to download database",
+						"source_url", pageUrl,
+						"bytes_written", bytesWritten,
+						"error", err,
+					)
+					return nil
+				}
+
+				if resp.ContentLength >= 0 && counter.N != resp.ContentLength {
+					// Part of the body was already passed on, so the whole download must be aborted to avoid loading a truncated list.
+					abortErr = fmt.Errorf(`failed to download database (source URL "%s", expected bytes: %d, bytes received: %d): %w`, pageUrl, resp.ContentLength, counter.N, ErrContentLengthMismatch)
+					s.logger.Log(ctx, slog.LevelError, "failed to download database because the number of bytes received did not match Content-Length",
+						"source_url", pageUrl,
+						"expected_bytes", resp.ContentLength,
+						"bytes_received", counter.N,
+					)
+					return nil
+				}
+
+				s.logger.Log(ctx, slog.LevelDebug, "finished download of database",
+					"source_url", pageUrl,
+					"bytes_written", bytesWritten,
+					"duration", time.Since(startTs),
+				)
+
+				if src.NextPage != nil {
+					if nextUrl, ok := src.NextPage(resp); ok && nextUrl != nil {
+						next = pageUrl.ResolveReference(nextUrl)
 					}
+				}
+				return next
+			}
 
-					bytesWritten, err := io.Copy(pipeWriter, resp.Body)
-					if err != nil {
-						failures = append(failures, fmt.Errorf(`failed to download database (source URL "%s", bytes written: %d): %w`, srcUrl, bytesWritten, err))
-						s.logger.Log(ctx, slog.LevelError, "failed to download database",
-							"service", "domaindb.DomainDb",
-							"source_url", srcUrl,
-							"bytes_written", bytesWritten,
-							"error", err,
-						)
-						return
+			maxPages := src.MaxPages
+			if maxPages <= 0 {
+				maxPages = defaultMaxPages
+			}
+
+			for _, srcUrl := range urls {
+				failuresBefore := len(failures)
+
+				pageUrl := srcUrl
+				visited := make(map[string]struct{})
+				for page := 1; ; page++ {
+					visited[pageUrl.String()] = struct{}{}
+
+					next := downloadPage(pageUrl)
+					if abortErr != nil {
+						break
 					}
-				}()
+					if len(failures) > failuresBefore {
+						if page > 1 {
+							// Earlier pages were already passed on, so the whole download must be aborted to avoid loading a partial list.
+							abortErr = fmt.Errorf(`failed to download page %d of database (source URL "%s"): %w`, page, srcUrl, failures[len(failures)-1])
+						}
+						break
+					}
+					if next == nil {
+						break
+					}
+
+					if _, has := visited[next.String()]; has {
+						abortErr = fmt.Errorf(`failed to download database (source URL "%s") because page %d links to already downloaded page "%s": %w`, srcUrl, page, next, ErrPaginationLoop)
+						break
+					}
+					if page >= maxPages {
+						abortErr = fmt.Errorf(`failed to download database (source URL "%s") because it has more than %d pages (see DataSource.MaxPages): %w`, srcUrl, maxPages, ErrTooManyPages)
+						break
+					}
+
+					// Write a newline to ensure the next page body is read on a new line.
+					_, _ = pipeWriter.Write([]byte("\n"))
+
+					pageUrl = next
+				}
+
+				if abortErr != nil {
+					s.logger.Log(ctx, slog.LevelError, "aborted download of database",
+						"source_url", srcUrl,
+						"error", abortErr,
+					)
+					_ = pipeWriter.CloseWithError(abortErr)
+					return
+				}
 
 				// Write a newline to ensure the next URL body is read on a new line.
 				_, _ = pipeWriter.Write([]byte("\n"))
 			}
 
-			if len(failures) == len(src.Urls) {
+			if len(failures) == len(urls) {
 				// All URLs failed; close the pipe writer with ErrAllUrlsFailed and the errors.
 				failures = append(failures, ErrAllUrlsFailed)
 				_ = pipeWriter.CloseWithError(errors.Join(failures...))
@@ -504,79 +1516,195 @@ func (s *DomainDb) openDataSource(src *DataSource) (io.ReadCloser, error) {
 }
 
 // loadDomainsFromReader reads all domain names from the reader and loads them to the database with the specified name.
+// The source is recorded in the database's load stats.
 // Domain names with Unicode and non-uppercase are normalized.
 // Does not close the reader.
 // Assumes the database name exists, panics if not; checking the database name is the responsibility of the caller.
-func (s *DomainDb) loadDomainsFromReader(reader io.Reader, name string) error {
-	ctx := context.Background()
-
-	data := s.dbs[name]
-
-	domains := make(map[string]struct{})
+func (s *DomainDb) loadDomainsFromReader(reader io.Reader, name string, source LoadSource) error {
+	builder, bytesRead, err := s.parseDomains(reader, name, s.dbs[name])
+	if err != nil {
+		return err
+	}
 
-	const maxFailures = 10
-	failures := make([]error, 0, maxFailures)
+	builder.finish(source, bytesRead)
 
-	goodLines := 0
+	return nil
+}
 
-	scanner := bufio.NewScanner(reader)
-	for scanner.Scan() && len(failures) < maxFailures {
-		line := scanner.Text()
+// parseDomains parses a list of domains from reader into a new domainSetBuilder for data, using data's DataSource parsing options, without swapping it in.
+// Returns the builder and the number of bytes read.
+// data does not need to be one of the DomainDb's databases; see newDomainSetBuilderFor.
+func (s *DomainDb) parseDomains(reader io.Reader, name string, data *dbSrcMap) (*domainSetBuilder, int64, error) {
+	counter := &countingReader{Reader: reader}
+	builder := s.newDomainSetBuilderFor(name, data)
 
+	scanner := bufio.NewScanner(counter)
+	if data.Src.MaxLineSize > 0 {
+		scanner.Buffer(make([]byte, 0, min(data.Src.MaxLineSize, bufio.MaxScanTokenSize)), data.Src.MaxLineSize)
+	}
+	for scanner.Scan() {
 		// Skip empty lines and comments.
-		if line == "" || line[0] == '#' {
+		rawLine := scanner.Text()
+		line, ok := data.Src.parseLine(rawLine)
+		if !ok {
 			continue
 		}
 
-		// Normalize the domain before putting it into the map.
-		normalized, err := s.normalizer.NormalizeDomain(line)
-		if err != nil {
-			s.logger.Log(ctx, slog.LevelError, "failed to normalize domain name",
-				"service", "domaindb.DomainDb",
-				"domain_name", line,
-				"error", err,
-			)
-			failures = append(failures, fmt.Errorf(`failed to normalize domain name "%s": %w`, line, err))
-			continue
+		if err := builder.add(rawLine, line); err != nil {
+			return nil, 0, err
 		}
 
-		domains[normalized] = struct{}{}
-
-		goodLines++
+		if builder.mostlyFailed() && len(builder.failures) == maxKeptLoadFailures {
+			// The file is mostly failures so far; it is most likely not a list of domain names, so stop reading it.
+			break
+		}
 	}
 
-	if len(failures) > goodLines {
-		return fmt.Errorf(`encountered %d parse failures while loading datacenter ranges, but only %d lines were successfully parsed. file is probably malformed; expected newline-separated list of domain names. this error wraps the encountered parse errors: %w`,
-			len(failures),
-			goodLines,
-			errors.Join(failures...),
+	if builder.mostlyFailed() {
+		return nil, 0, fmt.Errorf(`encountered %d parse failures while loading database, but only %d lines were successfully parsed. file is probably malformed; expected newline-separated list of domain names. this error wraps the first encountered parse errors: %w`,
+			builder.failureCount,
+			builder.goodLines,
+			errors.Join(builder.failures...),
 		)
 	}
 
-	data.Mu.Lock()
-	data.Has = true
-	data.Domains = domains
-	data.Mu.Unlock()
+	// A read error means the list is incomplete, so it must not replace the currently loaded list.
+	if err := scanner.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			return nil, 0, fmt.Errorf(`failed to read database after %d lines were successfully parsed because a line exceeded the max line size (see DataSource.MaxLineSize): %w`, builder.goodLines, err)
+		}
+		return nil, 0, fmt.Errorf(`failed to read database after %d lines were successfully parsed: %w`, builder.goodLines, err)
+	}
 
-	return nil
+	return builder, counter.N, nil
 }
 
 // DownloadAndLoadDatabase downloads the database with the specified name and loads it into memory.
 // You most likely do not need to call this function, as loading databases is handled automatically by the DomainDb instance.
+// It is equivalent to DownloadAndLoadDatabaseContext with context.Background().
+// If the database is a category database (see DataSource.Categories), returns an error wrapping ErrCategoryDatabase.
 func (s *DomainDb) DownloadAndLoadDatabase(name string) error {
-	ctx := context.Background()
+	return s.DownloadAndLoadDatabaseContext(context.Background(), name)
+}
+
+// DownloadAndLoadDatabaseContext is like DownloadAndLoadDatabase, but the download is made with ctx.
+// Cancelling ctx, or reaching its deadline, aborts the download and returns an error wrapping the context's error.
+// An aborted download is not loaded, and the database keeps its current contents and cached copy.
+//
+// The context is checked before a source's Get method is called and while its data is read, but is not passed to Get.
+// If the database was successfully downloaded within DataSource.MinDownloadInterval, the download is skipped and nil is returned.
+//
+// If a download of the same database is already in progress, no new download is started; the call waits for the download in progress and returns its result.
+// If ctx is done first, the call returns the context's error, but the download in progress continues.
+// If the database is a category database (see DataSource.Categories), returns an error wrapping ErrCategoryDatabase.
+// If the DomainDb instance has been closed, returns ErrDbClosed.
+func (s *DomainDb) DownloadAndLoadDatabaseContext(ctx context.Context, name string) error {
+	err := s.downloadAndLoadDatabaseOrSkip(ctx, name)
+	if errors.Is(err, errDownloadSkipped) {
+		return nil
+	}
+
+	return err
+}
+
+// downloadAndLoadDatabaseOrSkip is DownloadAndLoadDatabaseContext, but returns errDownloadSkipped if the download was skipped because of DataSource.MinDownloadInterval.
+// This lets the updater tell a skipped download apart from a successful one.
+func (s *DomainDb) downloadAndLoadDatabaseOrSkip(ctx context.Context, name string) error {
+	if !s.isRunning.Load() {
+		return ErrDbClosed
+	}
 
 	data, has := s.dbs[name]
 	if !has {
 		return NewNoSuchDatabaseError(name)
 	}
+	if err := checkNotCategoryDb(name, data); err != nil {
+		return err
+	}
+
+	return data.Download.do(ctx, func() error {
+		if interval := data.Src.MinDownloadInterval; interval > 0 {
+			if last := data.LastDownloadNano.Load(); last != 0 {
+				if since := time.Since(time.Unix(0, last)); since < interval {
+					s.logger.Log(ctx, slog.LevelInfo, "skipping download of database because it was downloaded recently (see DataSource.MinDownloadInterval)",
+						"database_name", name,
+						"since_last_download", since,
+						"min_download_interval", interval,
+					)
+					return errDownloadSkipped
+				}
+			}
+		}
+
+		if err := s.downloadAndLoadDatabase(ctx, name, data); err != nil {
+			data.LastDownloadFailure.Store(&downloadFailure{Err: err, At: time.Now()})
+			return err
+		}
+
+		data.LastDownloadFailure.Store(nil)
+		data.LastDownloadNano.Store(time.Now().UnixNano())
+		return nil
+	})
+}
 
+// downloadAndLoadDatabase downloads the database with the specified name and loads it into memory, without checking DataSource.MinDownloadInterval.
+func (s *DomainDb) downloadAndLoadDatabase(ctx context.Context, name string, data *dbSrcMap) error {
 	s.logger.Log(ctx, slog.LevelDebug, "downloading and loading database",
-		"service", "domaindb.DomainDb",
 		"database_name", name,
 	)
 
-	reader, err := s.openDataSource(data.Src)
+	return s.downloadDatabase(ctx, name, data, func(reader io.Reader) error {
+		return s.loadAndCacheDatabase(name, reader)
+	})
+}
+
+// downloadDatabase downloads the database with the specified name from its source and passes the data to consume.
+// With UrlModeFailover, each URL is tried in order until consume succeeds for one of them, so consume may be called more than once.
+// The reader passed to consume fails once ctx is done, and must not be used after consume returns.
+func (s *DomainDb) downloadDatabase(ctx context.Context, name string, data *dbSrcMap, consume func(reader io.Reader) error) error {
+	if data.Src.Get == nil && data.Src.UrlMode == UrlModeFailover {
+		// Try each URL in order, stopping at the first one that downloads and parses successfully.
+		failures := make([]error, 0, len(data.Src.Urls))
+		for _, srcUrl := range data.Src.Urls {
+			err := func() error {
+				reader, err := s.openUrls(ctx, data.Src, []*url.URL{srcUrl})
+				if err != nil {
+					return err
+				}
+				defer func() {
+					_ = reader.Close()
+				}()
+
+				return consume(&contextReader{ctx: ctx, Reader: reader})
+			}()
+			if err == nil {
+				return nil
+			}
+			if ctx.Err() != nil {
+				// The remaining mirrors would fail the same way.
+				return fmt.Errorf(`download of database with name "%s" was cancelled: %w`, name, err)
+			}
+
+			failures = append(failures, err)
+			s.logger.Log(ctx, slog.LevelError, "failed to download and load database from mirror URL, trying next URL",
+				"database_name", name,
+				"source_url", srcUrl,
+				"error", err,
+			)
+		}
+		if len(failures) == 0 {
+			return fmt.Errorf(`failed to read from source of data with name "%s": %w`, name, ErrDataSourceNoSource)
+		}
+
+		failures = append(failures, ErrAllUrlsFailed)
+		return fmt.Errorf(`failed to download and load database with name "%s" from any mirror URL: %w`, name, errors.Join(failures...))
+	}
+
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf(`download of database with name "%s" was cancelled: %w`, name, err)
+	}
+
+	reader, err := s.openDataSource(ctx, data.Src)
 	defer func() {
 		if reader != nil {
 			_ = reader.Close()
@@ -586,73 +1714,293 @@ func (s *DomainDb) DownloadAndLoadDatabase(name string) error {
 		return fmt.Errorf(`failed to read from source of data with name "%s": %w`, name, err)
 	}
 
-	pipeReader, pipeWriter := io.Pipe()
+	return consume(&contextReader{ctx: ctx, Reader: reader})
+}
 
-	writeErrChan := make(chan error, 1)
-	go func() {
-		writeErrChan <- s.storage.WriteDatabase(name, pipeReader)
-	}()
+// loadAndCacheDatabase loads the database with the specified name from the reader, and writes the data it reads to the cache.
+// The data is spooled to a temporary file while it is parsed, and the cache is only written from that file after parsing succeeds.
+// This guarantees that the cache always holds a parseable copy, regardless of how the storage driver handles failed writes.
+// If the temporary file cannot be written, the database is still loaded, but an error is returned because it could not be cached.
+// Does not close the reader.
+func (s *DomainDb) loadAndCacheDatabase(name string, reader io.Reader) error {
+	tmpFile, tmpErr := os.CreateTemp(s.tempDir, "domaindb-*.tmp")
+	spool := &spoolWriter{}
+	if tmpErr == nil {
+		defer func() {
+			_ = tmpFile.Close()
+			_ = os.Remove(tmpFile.Name())
+		}()
 
-	parseReader := noOpReadCloser{io.TeeReader(reader, pipeWriter)}
+		spool.w = tmpFile
+		reader = io.TeeReader(reader, spool)
+	}
 
-	err = s.loadDomainsFromReader(parseReader, name)
+	err := s.loadDomainsFromReader(reader, name, LoadSourceDownload)
 	if err != nil {
-		wrapped := fmt.Errorf(`failed to parse database with name "%s": %w`, name, err)
-		_ = pipeWriter.CloseWithError(wrapped)
-		return wrapped
+		return fmt.Errorf(`failed to parse database with name "%s": %w`, name, err)
 	}
 
-	_ = pipeWriter.Close()
+	if tmpErr != nil {
+		return fmt.Errorf(`failed to create temporary file to cache database with name "%s": %w`, name, tmpErr)
+	}
+	if spool.err != nil {
+		return fmt.Errorf(`failed to write temporary file to cache database with name "%s": %w`, name, spool.err)
+	}
 
-	if err := <-writeErrChan; err != nil {
+	if _, err = tmpFile.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf(`failed to rewind temporary file to cache database with name "%s": %w`, name, err)
+	}
+	if err = s.writeCache(name, noOpReadCloser{tmpFile}); err != nil {
 		return fmt.Errorf(`failed to write database with name "%s": %w`, name, err)
 	}
 
 	return nil
 }
 
+// Close stops all background updates and frees all databases.
+// Before returning, it waits for background initialization and in-progress updates to finish and for all pending checkpoint updates to be saved, then saves the checkpoints one final time.
+// This guarantees that the last update time of every database is durably stored.
+// If saving the checkpoints fails, the error is returned, but the DomainDb instance is still closed.
+// The DomainDb instance is no longer usable after it is closed, and its methods return ErrDbClosed.
+// If the DomainDb instance has already been closed, returns ErrDbClosed.
 func (s *DomainDb) Close() error {
+	s.lifecycleMu.Lock()
+	if !s.isRunning.Swap(false) {
+		s.lifecycleMu.Unlock()
+		return ErrDbClosed
+	}
+	close(s.closing)
+	s.lifecycleMu.Unlock()
+
+	// Wait for background initialization to stop, since it may still be loading databases.
+	// It does not start any updaters once closing has begun.
+	s.initializer.Wait()
+
+	// Wait for updaters to stop, including any that are in the middle of an update.
+	// Only then is it safe to close the updates channel.
+	s.updaters.Wait()
 	close(s.updates)
 
-	s.isRunning = false
+	var errs []error
+
+	// Wait for the checkpoint writer to drain the updates channel, then save the final state.
+	s.checkpointWriter.Wait()
+	s.checkpointsMu.Lock()
+	err := s.storage.WriteCheckpoints(s.checkpoints)
+	s.checkpointsMu.Unlock()
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to save checkpoints while closing: %w", err))
+	}
 
 	// Assign empty maps to all databases to allow the original ones to be freed by the GC.
 	for _, data := range s.dbs {
 		data.Mu.Lock()
 		data.Domains = emptyMap
+		data.Matcher = nil
 		data.Mu.Unlock()
 	}
 	runtime.GC()
 
-	return nil
+	return errors.Join(errs...)
+}
+
+// DatabaseNames returns the names of all databases, sorted by name.
+func (s *DomainDb) DatabaseNames() []string {
+	return sortedNames(s.dbs)
 }
 
 // DoesDbHaveDomain returns whether a domain was found in the specified domain database.
+// It is equivalent to DoesDbHaveDomainContext with context.Background().
 // If the database does not exist, returns a NoSuchDatabaseError.
 // If the database has not been initialized, returns a NotInitializedError.
 // If the DomainDb instance has been closed, returns ErrDbClosed.
 func (s *DomainDb) DoesDbHaveDomain(dbName string, domain string) (bool, error) {
-	if !s.isRunning {
+	return s.DoesDbHaveDomainContext(context.Background(), dbName, domain)
+}
+
+// DoesDbHaveDomainContext is like DoesDbHaveDomain, but the lookup is made with ctx.
+// This matters for databases with DataSource.OnProbableMatch, which may be slow; use it to stop waiting on lookups for requests that were cancelled.
+// Cancelling ctx, or reaching its deadline, makes the lookup return an error wrapping the context's error.
+//
+// The context is checked before the lookup and while waiting for OnProbableMatch, but is not passed to OnProbableMatch.
+// If ctx is done while waiting, the call returns, but the call to OnProbableMatch continues in the background and its result is discarded.
+// If the DomainDb instance has been closed, returns ErrDbClosed.
+func (s *DomainDb) DoesDbHaveDomainContext(ctx context.Context, dbName string, domain string) (bool, error) {
+	if !s.isRunning.Load() {
 		return false, ErrDbClosed
 	}
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
 
 	data, has := s.dbs[dbName]
 	if !has {
 		return false, NewNoSuchDatabaseError(dbName)
 	}
 
-	normalized, err := s.normalizer.NormalizeDomain(domain)
+	normalized, err := s.lookupCache.normalize(s.normalizer, s.transform, domain)
 	if err != nil {
 		return false, err
 	}
 
-	tok := data.Mu.RLock()
-	defer data.Mu.RUnlock(tok)
+	_, found, err := data.lookupNormalized(ctx, dbName, normalized)
+	return found, err
+}
+
+// DoesDbHaveDomainDetailed is like DoesDbHaveDomain, but also returns the normalized form of the domain that was looked up.
+// The normalized form comes from the same normalizer and Options.DomainTransform as the lookup, so it is suitable for logging and auditing.
+// Unlike NormalizeDomainName, it reflects the DomainDb's normalization options.
+// The normalized form is empty if normalization failed or if Options.DomainTransform dropped the domain.
+// It is returned even if the lookup fails, for example because the database has not been initialized.
+// If the database does not exist, returns a NoSuchDatabaseError.
+// If the database has not been initialized, returns a NotInitializedError.
+// If the DomainDb instance has been closed, returns ErrDbClosed.
+func (s *DomainDb) DoesDbHaveDomainDetailed(dbName string, domain string) (found bool, normalized string, err error) {
+	if !s.isRunning.Load() {
+		return false, "", ErrDbClosed
+	}
+
+	data, has := s.dbs[dbName]
+	if !has {
+		return false, "", NewNoSuchDatabaseError(dbName)
+	}
+
+	normalized, err = s.lookupCache.normalize(s.normalizer, s.transform, domain)
+	if err != nil {
+		return false, "", err
+	}
+
+	_, found, err = data.lookupNormalized(context.Background(), dbName, normalized)
+	return found, normalized, err
+}
+
+// LookupDomain returns the stored entry in the specified domain database that the domain matched, and whether it matched at all.
+// The matched entry is useful for explaining why a domain was matched, for example in logs.
+// If the domain was not found, the returned entry is empty.
+// If the database does not exist, returns a NoSuchDatabaseError.
+// If the database has not been initialized, returns a NotInitializedError.
+// If the DomainDb instance has been closed, returns ErrDbClosed.
+func (s *DomainDb) LookupDomain(dbName string, domain string) (matched string, found bool, err error) {
+	if !s.isRunning.Load() {
+		return "", false, ErrDbClosed
+	}
+
+	data, has := s.dbs[dbName]
+	if !has {
+		return "", false, NewNoSuchDatabaseError(dbName)
+	}
+
+	normalized, err := s.lookupCache.normalize(s.normalizer, s.transform, domain)
+	if err != nil {
+		return "", false, err
+	}
+
+	return data.lookupNormalized(context.Background(), dbName, normalized)
+}
+
+// CheckDomain normalizes a domain once and returns whether it was found in each of the specified domain databases.
+// The returned map's keys are the database names and the values are whether the domain was found in them.
+// If no database names are specified, all databases are checked.
+// If any of the databases do not exist, returns a NoSuchDatabaseError.
+// If any of the databases have not been initialized, returns a NotInitializedError.
+// If the DomainDb instance has been closed, returns ErrDbClosed.
+func (s *DomainDb) CheckDomain(domain string, dbNames ...string) (map[string]bool, error) {
+	if !s.isRunning.Load() {
+		return nil, ErrDbClosed
+	}
+
+	if len(dbNames) == 0 {
+		dbNames = s.DatabaseNames()
+	}
+
+	for _, name := range dbNames {
+		if _, has := s.dbs[name]; !has {
+			return nil, NewNoSuchDatabaseError(name)
+		}
+	}
+
+	normalized, err := s.lookupCache.normalize(s.normalizer, s.transform, domain)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make(map[string]bool, len(dbNames))
+	for _, name := range dbNames {
+		_, has, err := s.dbs[name].lookupNormalized(context.Background(), name, normalized)
+		if err != nil {
+			return nil, err
+		}
+
+		res[name] = has
+	}
+
+	return res, nil
+}
 
-	if !data.Has || data.Domains == nil {
-		return false, NewNotInitializedError(dbName)
+// lookupNormalized looks up the already-normalized domain in the database.
+// Returns the stored entry that matched and whether there was a match.
+// Disabled databases never match.
+// Temporary overrides take precedence over the list.
+// Matches are inverted if the database's source has DataSource.Negate set.
+// If the database has not been initialized, returns a NotInitializedError.
+func (data *dbSrcMap) lookupNormalized(ctx context.Context, name string, normalized string) (string, bool, error) {
+	// The view is taken while holding the lock, so DataSource.OnProbableMatch, which may be slow, is called without holding it.
+	match, found, err := data.view().match(ctx, name, normalized)
+	return match.Matched, found, err
+}
+
+// DisplayForm converts a domain name, which may be in Punycode form, to its Unicode form for display to users.
+// The domain is normalized with the same normalizer used for lookups before it is converted, so it is safe to pass already-ASCII domain names.
+// The result is meant for display in UIs and logs only, not for lookups.
+// If the DomainDb instance has been closed, returns ErrDbClosed.
+func (s *DomainDb) DisplayForm(domain string) (string, error) {
+	if !s.isRunning.Load() {
+		return "", ErrDbClosed
+	}
+
+	return s.normalizer.DisplayForm(domain)
+}
+
+// SetDatabaseEnabled enables or disables the database with the specified name at runtime.
+// A disabled database does not match any domain, so lookups on it return false without an error.
+// Scheduled updates of a disabled database are skipped until it is enabled again, at which point updates resume on their normal schedule.
+// The loaded domains are kept in memory while the database is disabled.
+// Databases are enabled by default.
+// If the database does not exist, returns a NoSuchDatabaseError.
+// If the DomainDb instance has been closed, returns ErrDbClosed.
+func (s *DomainDb) SetDatabaseEnabled(name string, enabled bool) error {
+	if !s.isRunning.Load() {
+		return ErrDbClosed
+	}
+
+	data, has := s.dbs[name]
+	if !has {
+		return NewNoSuchDatabaseError(name)
+	}
+
+	data.Disabled.Store(!enabled)
+
+	s.logger.Log(context.Background(), slog.LevelInfo, "changed database enabled state",
+		"database_name", name,
+		"enabled", enabled,
+	)
+
+	return nil
+}
+
+// IsDatabaseEnabled returns whether the database with the specified name is enabled.
+// See SetDatabaseEnabled for details.
+// If the database does not exist, returns a NoSuchDatabaseError.
+// If the DomainDb instance has been closed, returns ErrDbClosed.
+func (s *DomainDb) IsDatabaseEnabled(name string) (bool, error) {
+	if !s.isRunning.Load() {
+		return false, ErrDbClosed
+	}
+
+	data, has := s.dbs[name]
+	if !has {
+		return false, NewNoSuchDatabaseError(name)
 	}
 
-	_, has = data.Domains[normalized]
-	return has, nil
+	return !data.Disabled.Load(), nil
 }
@@ -0,0 +1,4062 @@
+package domaindb
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// newTestDb creates a DomainDb with a database for each entry in lists, using a temporary directory for storage.
+// The key is the database name and the value is the contents of its source.
+func newTestDb(t *testing.T, lists map[string]string) *DomainDb {
+	t.Helper()
+
+	storage, err := NewFsStorageDriver(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create storage driver: %v", err)
+	}
+
+	sources := make(map[string]*DataSource, len(lists))
+	for name, contents := range lists {
+		sources[name] = &DataSource{
+			RefreshInterval: time.Hour,
+			Get: func() (io.ReadCloser, error) {
+				return io.NopCloser(strings.NewReader(contents)), nil
+			},
+		}
+	}
+
+	db, err := NewDomainDb(Options{
+		StorageDriver: storage,
+		Logger:        slog.New(slog.DiscardHandler),
+		Sources:       sources,
+	})
+	if err != nil {
+		t.Fatalf("failed to create DomainDb: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	return db
+}
+
+// failingReader returns its data and then fails with err instead of io.EOF.
+type failingReader struct {
+	data io.Reader
+	err  error
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	n, err := r.data.Read(p)
+	if err == io.EOF {
+		return n, r.err
+	}
+	return n, err
+}
+
+func TestLoadDomainsFromReader_ReadErrorMidStream(t *testing.T) {
+	db := newTestDb(t, map[string]string{
+		"test": "old.example.com\n",
+	})
+
+	errBoom := errors.New("connection reset")
+	reader := &failingReader{
+		data: strings.NewReader("a.example.com\nb.example.com\nc.exam"),
+		err:  errBoom,
+	}
+
+	err := db.loadDomainsFromReader(reader, "test", LoadSourceDownload)
+	if !errors.Is(err, errBoom) {
+		t.Fatalf("expected read error to be returned, got %v", err)
+	}
+
+	// The previously loaded list must be kept.
+	has, err := db.DoesDbHaveDomain("test", "old.example.com")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if !has {
+		t.Fatal("previously loaded domain was lost after failed load")
+	}
+	has, err = db.DoesDbHaveDomain("test", "a.example.com")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if has {
+		t.Fatal("partially read list was loaded")
+	}
+}
+
+func TestLoadDomainsFromReader_LineTooLong(t *testing.T) {
+	db := newTestDb(t, map[string]string{
+		"test": "old.example.com\n",
+	})
+	db.dbs["test"].Src.MaxLineSize = 32
+
+	reader := strings.NewReader("a.example.com\n" + strings.Repeat("a", 64) + "\n")
+
+	err := db.loadDomainsFromReader(reader, "test", LoadSourceDownload)
+	if !errors.Is(err, bufio.ErrTooLong) {
+		t.Fatalf("expected bufio.ErrTooLong, got %v", err)
+	}
+}
+
+func TestLoadDomainsFromReader_ManyFailuresDoNotTruncate(t *testing.T) {
+	db := newTestDb(t, map[string]string{
+		"test": "",
+	})
+
+	var b strings.Builder
+	for range 20 {
+		b.WriteString("good.example.com\n")
+	}
+	for range 15 {
+		b.WriteString("bad_domain!\n")
+	}
+	b.WriteString("last.example.com\n")
+
+	if err := db.loadDomainsFromReader(strings.NewReader(b.String()), "test", LoadSourceDownload); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	has, err := db.DoesDbHaveDomain("test", "last.example.com")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if !has {
+		t.Fatal("domain after parse failures was not loaded")
+	}
+}
+
+func TestNewDomainDb_CorruptCheckpoints(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, checkpointsFilename), []byte(`{"checkpoints":`), fsPermBits); err != nil {
+		t.Fatalf("failed to write corrupt checkpoints: %v", err)
+	}
+
+	storage, err := NewFsStorageDriver(dir)
+	if err != nil {
+		t.Fatalf("failed to create storage driver: %v", err)
+	}
+
+	options := Options{
+		StorageDriver: storage,
+		Logger:        slog.New(slog.DiscardHandler),
+		Sources: map[string]*DataSource{
+			"test": {
+				RefreshInterval: time.Hour,
+				Get: func() (io.ReadCloser, error) {
+					return io.NopCloser(strings.NewReader("example.com\n")), nil
+				},
+			},
+		},
+	}
+
+	options.StrictCheckpoints = true
+	if _, err = NewDomainDb(options); !errors.Is(err, ErrCorruptCheckpoints) {
+		t.Fatalf("expected ErrCorruptCheckpoints with StrictCheckpoints, got %v", err)
+	}
+
+	options.StrictCheckpoints = false
+	db, err := NewDomainDb(options)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	defer func() {
+		_ = db.Close()
+	}()
+
+	has, err := db.DoesDbHaveDomain("test", "example.com")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if !has {
+		t.Fatal("expected database to be loaded despite corrupt checkpoints")
+	}
+}
+
+func TestFlush(t *testing.T) {
+	db := newTestDb(t, map[string]string{
+		"test": "b.example.com\nA.example.com\n# comment\n",
+	})
+
+	if err := db.Flush(); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	reader, err := db.storage.ReadDatabase("test")
+	if err != nil {
+		t.Fatalf("failed to read flushed database: %v", err)
+	}
+	defer func() {
+		_ = reader.Close()
+	}()
+
+	got, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("failed to read flushed database: %v", err)
+	}
+	if want := "a.example.com\nb.example.com\n"; string(got) != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestFlush_CategoriesSurviveRestart(t *testing.T) {
+	storage := NewMemoryStorageDriver()
+	newDb := func(parser func(string) (string, string)) *DomainDb {
+		db, err := NewDomainDb(Options{
+			StorageDriver:   storage,
+			TempDir:         t.TempDir(),
+			Logger:          slog.New(slog.DiscardHandler),
+			DisableDownload: true,
+			Sources: map[string]*DataSource{
+				"feed": {
+					RefreshInterval: time.Hour,
+					Categories:      []string{"ads", "malware"},
+					CategoryParser:  parser,
+				},
+			},
+		})
+		if err != nil {
+			t.Fatalf("failed to create DomainDb: %v", err)
+		}
+		return db
+	}
+	assertCategory := func(db *DomainDb, name string, domain string, want bool) {
+		t.Helper()
+		has, err := db.DoesDbHaveDomain(name, domain)
+		if err != nil {
+			t.Fatalf("%s: unexpected err: %v", name, err)
+		}
+		if has != want {
+			t.Fatalf("%s: got %v for %s, want %v", name, has, domain, want)
+		}
+	}
+
+	if err := storage.WriteDatabase("feed", io.NopCloser(strings.NewReader("ads.example.com,ads\nboth.example.com,ads\nboth.example.com,malware\nplain.example.com\n"))); err != nil {
+		t.Fatalf("failed to write cached copy: %v", err)
+	}
+	if err := storage.WriteCheckpoints(&AllCheckpoints{Checkpoints: map[string]Checkpoint{}}); err != nil {
+		t.Fatalf("failed to write checkpoints: %v", err)
+	}
+
+	db := newDb(nil)
+	if err := db.Flush(); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	_ = db.Close()
+
+	db = newDb(nil)
+	assertCategory(db, "feed/ads", "ads.example.com", true)
+	assertCategory(db, "feed/ads", "both.example.com", true)
+	assertCategory(db, "feed/malware", "both.example.com", true)
+	assertCategory(db, "feed/ads", "plain.example.com", false)
+	assertCategory(db, "feed", "plain.example.com", true)
+
+	if err := db.ReplaceDomains("feed", []string{"new.example.com,malware", "other.example.com"}); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	_ = db.Close()
+
+	db = newDb(nil)
+	assertCategory(db, "feed/malware", "new.example.com", true)
+	assertCategory(db, "feed/ads", "ads.example.com", false)
+	assertCategory(db, "feed", "other.example.com", true)
+	_ = db.Close()
+
+	// Categories parsed by a custom parser cannot be written back in a format it reads.
+	db = newDb(func(line string) (string, string) {
+		domain, category, _ := strings.Cut(line, " ")
+		return domain, category
+	})
+	defer func() {
+		_ = db.Close()
+	}()
+	if err := db.Flush(); !errors.Is(err, ErrCategoriesNotWritable) {
+		t.Fatalf("got err %v, want ErrCategoriesNotWritable", err)
+	}
+	if err := db.ReplaceDomains("feed", []string{"x.example.com ads"}); !errors.Is(err, ErrCategoriesNotWritable) {
+		t.Fatalf("got err %v, want ErrCategoriesNotWritable", err)
+	}
+}
+
+func TestClose_PersistsCheckpointsOfActiveUpdaters(t *testing.T) {
+	storage, err := NewFsStorageDriver(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create storage driver: %v", err)
+	}
+
+	var calls atomic.Int32
+	db, err := NewDomainDb(Options{
+		StorageDriver: storage,
+		Logger:        slog.New(slog.DiscardHandler),
+		Sources: map[string]*DataSource{
+			"test": {
+				RefreshInterval: 5 * time.Millisecond,
+				Get: func() (io.ReadCloser, error) {
+					calls.Add(1)
+					return io.NopCloser(strings.NewReader("example.com\n")), nil
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create DomainDb: %v", err)
+	}
+	defer func() {
+		_ = db.Close()
+	}()
+
+	checkpoints, err := storage.ReadCheckpoints()
+	if err != nil {
+		t.Fatalf("failed to read checkpoints after startup: %v", err)
+	}
+	startupUnix := checkpoints.Checkpoints["test"].LastUpdatedUnix
+	if startupUnix == 0 {
+		t.Fatal("checkpoint was not saved after startup")
+	}
+
+	// Checkpoints have a resolution of one second, so wait until the next second, and then until an update that started after it has finished.
+	// Updates run one after another, so once Get has been called twice more, the first of those updates has finished.
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Unix() <= startupUnix {
+		time.Sleep(5 * time.Millisecond)
+	}
+	base := calls.Load()
+	for calls.Load() < base+2 {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for scheduled updates")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if err = db.Close(); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	checkpoints, err = storage.ReadCheckpoints()
+	if err != nil {
+		t.Fatalf("failed to read checkpoints: %v", err)
+	}
+	if got := checkpoints.Checkpoints["test"].LastUpdatedUnix; got <= startupUnix {
+		t.Fatalf("got checkpoint %d after scheduled updates, want it to be later than the checkpoint %d saved after startup", got, startupUnix)
+	}
+}
+
+func TestLogger_KeepsUserAttributes(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})).With("instance_id", "abc123")
+
+	storage, err := NewFsStorageDriver(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create storage driver: %v", err)
+	}
+
+	db, err := NewDomainDb(Options{
+		StorageDriver: storage,
+		Logger:        logger,
+	})
+	if err != nil {
+		t.Fatalf("failed to create DomainDb: %v", err)
+	}
+	_ = db.Close()
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) == 0 {
+		t.Fatal("expected log output")
+	}
+	for _, line := range lines {
+		if !strings.Contains(line, `"instance_id":"abc123"`) {
+			t.Fatalf("log line is missing user attribute: %s", line)
+		}
+		if strings.Count(line, `"service":`) != 1 {
+			t.Fatalf("log line should have exactly one service attribute: %s", line)
+		}
+	}
+}
+
+func TestNewDomainDb_NameTooLong(t *testing.T) {
+	storage, err := NewFsStorageDriver(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create storage driver: %v", err)
+	}
+
+	_, err = NewDomainDb(Options{
+		StorageDriver: storage,
+		Logger:        slog.New(slog.DiscardHandler),
+		Sources: map[string]*DataSource{
+			strings.Repeat("a", DbNameMaxSize+1): {
+				RefreshInterval: time.Hour,
+				Get: func() (io.ReadCloser, error) {
+					return io.NopCloser(strings.NewReader("")), nil
+				},
+			},
+		},
+	})
+	if !errors.Is(err, ErrDbNameTooLong) {
+		t.Fatalf("expected ErrDbNameTooLong, got %v", err)
+	}
+}
+
+func TestNewDomainDb_OrderedSources(t *testing.T) {
+	storage, err := NewFsStorageDriver(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create storage driver: %v", err)
+	}
+
+	var loaded []string
+	newSource := func(name string) NamedSource {
+		return NamedSource{
+			Name: name,
+			Source: &DataSource{
+				RefreshInterval: time.Hour,
+				Get: func() (io.ReadCloser, error) {
+					loaded = append(loaded, name)
+					return io.NopCloser(strings.NewReader("example.com\n")), nil
+				},
+			},
+		}
+	}
+
+	names := []string{"c", "a", "d", "b"}
+	sources := make([]NamedSource, 0, len(names))
+	for _, name := range names {
+		sources = append(sources, newSource(name))
+	}
+
+	db, err := NewDomainDb(Options{
+		StorageDriver:   storage,
+		Logger:          slog.New(slog.DiscardHandler),
+		OrderedSources:  sources,
+		LoadConcurrency: 1,
+	})
+	if err != nil {
+		t.Fatalf("failed to create DomainDb: %v", err)
+	}
+	defer func() {
+		_ = db.Close()
+	}()
+
+	if strings.Join(loaded, ",") != strings.Join(names, ",") {
+		t.Fatalf("databases were loaded in order %v, want %v", loaded, names)
+	}
+}
+
+func TestNewDomainDb_DuplicateName(t *testing.T) {
+	storage, err := NewFsStorageDriver(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create storage driver: %v", err)
+	}
+
+	src := &DataSource{
+		RefreshInterval: time.Hour,
+		Get: func() (io.ReadCloser, error) {
+			return io.NopCloser(strings.NewReader("")), nil
+		},
+	}
+
+	_, err = NewDomainDb(Options{
+		StorageDriver:  storage,
+		Logger:         slog.New(slog.DiscardHandler),
+		Sources:        map[string]*DataSource{"test": src},
+		OrderedSources: []NamedSource{{Name: "test", Source: src}},
+	})
+	if err == nil {
+		t.Fatal("expected error for duplicate database name, got nil")
+	}
+}
+
+func TestDatabaseNames_Sorted(t *testing.T) {
+	db := newTestDb(t, map[string]string{
+		"c": "",
+		"a": "",
+		"b": "",
+	})
+
+	for range 5 {
+		if got := strings.Join(db.DatabaseNames(), ","); got != "a,b,c" {
+			t.Fatalf("got %q, want %q", got, "a,b,c")
+		}
+	}
+}
+
+func TestNegate(t *testing.T) {
+	db := newTestDb(t, map[string]string{
+		"allow": "example.com\n",
+	})
+	db.dbs["allow"].Src.Negate = true
+
+	cases := map[string]bool{
+		"example.com": false,
+		"example.org": true,
+	}
+	for domain, want := range cases {
+		has, err := db.DoesDbHaveDomain("allow", domain)
+		if err != nil {
+			t.Fatalf("%q: unexpected err: %v", domain, err)
+		}
+		if has != want {
+			t.Fatalf("%q: got %v, want %v", domain, has, want)
+		}
+
+		snapshot, err := db.Snapshot()
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		has, err = snapshot.DoesDbHaveDomain("allow", domain)
+		if err != nil {
+			t.Fatalf("%q: unexpected err: %v", domain, err)
+		}
+		if has != want {
+			t.Fatalf("%q: snapshot got %v, want %v", domain, has, want)
+		}
+
+		res, err := db.Explain(domain)
+		if err != nil {
+			t.Fatalf("%q: unexpected err: %v", domain, err)
+		}
+		if res.Databases[0].Found != want {
+			t.Fatalf("%q: explain got %v, want %v", domain, res.Databases[0].Found, want)
+		}
+	}
+}
+
+func TestNewDomainDb_LoadConcurrency(t *testing.T) {
+	storage, err := NewFsStorageDriver(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create storage driver: %v", err)
+	}
+
+	const loadConcurrency = 2
+	var inProgress, maxInProgress atomic.Int32
+
+	errBoom := errors.New("boom")
+	sources := make(map[string]*DataSource)
+	for _, name := range []string{"a", "b", "c", "d", "e", "f"} {
+		sources[name] = &DataSource{
+			RefreshInterval: time.Hour,
+			Get: func() (io.ReadCloser, error) {
+				n := inProgress.Add(1)
+				defer inProgress.Add(-1)
+				for {
+					cur := maxInProgress.Load()
+					if n <= cur || maxInProgress.CompareAndSwap(cur, n) {
+						break
+					}
+				}
+				time.Sleep(10 * time.Millisecond)
+
+				if name == "b" || name == "e" {
+					return nil, errBoom
+				}
+				return io.NopCloser(strings.NewReader("example.com\n")), nil
+			},
+		}
+	}
+
+	_, err = NewDomainDb(Options{
+		StorageDriver:   storage,
+		Logger:          slog.New(slog.DiscardHandler),
+		Sources:         sources,
+		LoadConcurrency: loadConcurrency,
+	})
+	if !errors.Is(err, errBoom) {
+		t.Fatalf("expected load error, got %v", err)
+	}
+	for _, name := range []string{`"b"`, `"e"`} {
+		if !strings.Contains(err.Error(), name) {
+			t.Fatalf("expected error to include failure of database %s, got %v", name, err)
+		}
+	}
+
+	if got := maxInProgress.Load(); got > loadConcurrency {
+		t.Fatalf("got %d concurrent loads, want at most %d", got, loadConcurrency)
+	}
+	if got := maxInProgress.Load(); got < 2 {
+		t.Fatalf("got %d concurrent loads, expected databases to load concurrently", got)
+	}
+}
+
+func TestEstimateMemory(t *testing.T) {
+	db := newTestDb(t, map[string]string{
+		"small": "a.example.com\n",
+		"large": "a.example.com\nb.example.com\nc.example.com\nd.example.com\n",
+	})
+
+	small, err := db.EstimateMemory("small")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	large, err := db.EstimateMemory("large")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	if small < int64(len("a.example.com")) {
+		t.Fatalf("estimate %d is smaller than the domain bytes", small)
+	}
+	if large <= small {
+		t.Fatalf("estimate for larger database (%d) is not greater than estimate for smaller database (%d)", large, small)
+	}
+
+	var noSuchDb *NoSuchDatabaseError
+	if _, err = db.EstimateMemory("missing"); !errors.As(err, &noSuchDb) {
+		t.Fatalf("expected NoSuchDatabaseError, got %v", err)
+	}
+}
+
+func TestOnNormalizeFailure(t *testing.T) {
+	db := newTestDb(t, map[string]string{
+		"test": "",
+	})
+
+	type failure struct {
+		dbName  string
+		rawLine string
+	}
+	var failures []failure
+	db.onNormalizeFailure = func(dbName string, rawLine string, err error) {
+		if err == nil {
+			t.Error("expected non-nil error")
+		}
+		failures = append(failures, failure{dbName, rawLine})
+	}
+
+	var b strings.Builder
+	for range maxNormalizeFailureCallbacks * 2 {
+		b.WriteString("good.example.com\n")
+	}
+	b.WriteString("  bad_domain! \n")
+	for range maxNormalizeFailureCallbacks {
+		b.WriteString("bad_domain!\n")
+	}
+
+	if err := db.loadDomainsFromReader(strings.NewReader(b.String()), "test", LoadSourceDownload); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	if len(failures) != maxNormalizeFailureCallbacks {
+		t.Fatalf("got %d callbacks, want %d", len(failures), maxNormalizeFailureCallbacks)
+	}
+	if want := (failure{"test", "  bad_domain! "}); failures[0] != want {
+		t.Fatalf("got %+v, want %+v", failures[0], want)
+	}
+}
+
+func TestMaxDomains(t *testing.T) {
+	db := newTestDb(t, map[string]string{
+		"test": "old.example.com\n",
+	})
+	db.dbs["test"].Src.MaxDomains = 2
+
+	list := "a.example.com\na.example.com\nb.example.com\nc.example.com\n"
+
+	err := db.loadDomainsFromReader(strings.NewReader(list), "test", LoadSourceDownload)
+	if !errors.Is(err, ErrTooManyDomains) {
+		t.Fatalf("expected ErrTooManyDomains, got %v", err)
+	}
+	has, err := db.DoesDbHaveDomain("test", "old.example.com")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if !has {
+		t.Fatal("previously loaded domain was lost after failed load")
+	}
+
+	db.dbs["test"].Src.TruncateAtMaxDomains = true
+	if err = db.loadDomainsFromReader(strings.NewReader(list), "test", LoadSourceDownload); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	res, err := db.CheckDomain("a.example.com", "test")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if !res["test"] {
+		t.Fatal("expected domain before the limit to be loaded")
+	}
+	res, err = db.CheckDomain("c.example.com", "test")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if res["test"] {
+		t.Fatal("expected domain after the limit to be truncated")
+	}
+
+	stats, err := db.LastLoadStats("test")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if stats.UniqueDomains != 2 || stats.TruncatedLines != 1 {
+		t.Fatalf("got %d unique domains and %d truncated lines, want 2 and 1", stats.UniqueDomains, stats.TruncatedLines)
+	}
+}
+
+func TestDomainTransform(t *testing.T) {
+	db := newTestDb(t, map[string]string{
+		"test": "example.com.tracking.example\nexample.org\ndrop.example.net\n",
+	})
+	db.transform = func(domain string) (string, bool) {
+		if strings.HasPrefix(domain, "drop.") {
+			return "", false
+		}
+		return strings.TrimSuffix(domain, ".tracking.example"), true
+	}
+	if err := db.DownloadAndLoadDatabase("test"); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	cases := map[string]bool{
+		"example.com":                  true,
+		"example.com.tracking.example": true,
+		"example.org":                  true,
+		"drop.example.net":             false,
+	}
+	for domain, want := range cases {
+		has, err := db.DoesDbHaveDomain("test", domain)
+		if err != nil {
+			t.Fatalf("%q: unexpected err: %v", domain, err)
+		}
+		if has != want {
+			t.Fatalf("%q: got %v, want %v", domain, has, want)
+		}
+	}
+
+	stats, err := db.LastLoadStats("test")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if stats.UniqueDomains != 2 {
+		t.Fatalf("got %d unique domains, want 2", stats.UniqueDomains)
+	}
+}
+
+func TestWildcard(t *testing.T) {
+	db := newTestDb(t, map[string]string{
+		"block": "*.gov\n*.b.example.com\nexact.b.example.com\n",
+	})
+
+	cases := []struct {
+		domain    string
+		matched   string
+		matchType MatchType
+	}{
+		{"a.gov", "*.gov", MatchWildcard},
+		{"a.b.c.gov", "*.gov", MatchWildcard},
+		{"gov", "", MatchNone},
+		{"a.b.example.com", "*.b.example.com", MatchWildcard},
+		{"exact.b.example.com", "exact.b.example.com", MatchExact},
+		{"b.example.com", "", MatchNone},
+		{"example.com", "", MatchNone},
+	}
+	for _, c := range cases {
+		res, err := db.Explain(c.domain)
+		if err != nil {
+			t.Fatalf("%q: unexpected err: %v", c.domain, err)
+		}
+		got := res.Databases[0]
+		if got.Matched != c.matched || got.MatchType != c.matchType || got.Found != (c.matchType != MatchNone) {
+			t.Fatalf("%q: got %q (%s, found: %v), want %q (%s)", c.domain, got.Matched, got.MatchType, got.Found, c.matched, c.matchType)
+		}
+	}
+}
+
+func TestLoad_PunycodeAndUnicodeVariantsCollapse(t *testing.T) {
+	db := newTestDb(t, map[string]string{
+		"test": "bücher.de\nxn--bcher-kva.de\nдé.net\n",
+	})
+
+	stats, err := db.LastLoadStats("test")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if stats.UniqueDomains != 2 || stats.DuplicateLines != 1 {
+		t.Fatalf("got %d unique domains and %d duplicate lines, want 2 and 1", stats.UniqueDomains, stats.DuplicateLines)
+	}
+
+	for _, domain := range []string{"bücher.de", "xn--bcher-kva.de", "дé.net", "xn--9ca99n.net"} {
+		matched, found, err := db.LookupDomain("test", domain)
+		if err != nil {
+			t.Fatalf("%q: unexpected err: %v", domain, err)
+		}
+		if !found {
+			t.Fatalf("%q: expected domain to be found", domain)
+		}
+		if !strings.HasPrefix(matched, "xn--") {
+			t.Fatalf("%q: expected matched entry to be in Punycode form, got %q", domain, matched)
+		}
+	}
+}
+
+func TestReplaceDomains(t *testing.T) {
+	db := newTestDb(t, map[string]string{
+		"test": "old.example.com\n",
+	})
+
+	err := db.ReplaceDomains("test", []string{"New.example.com", " *.example.org ", "bad_domain!", ""})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	for domain, want := range map[string]bool{
+		"old.example.com": false,
+		"new.example.com": true,
+		"a.example.org":   true,
+	} {
+		has, err := db.DoesDbHaveDomain("test", domain)
+		if err != nil {
+			t.Fatalf("%q: unexpected err: %v", domain, err)
+		}
+		if has != want {
+			t.Fatalf("%q: got %v, want %v", domain, has, want)
+		}
+	}
+
+	stats, err := db.LastLoadStats("test")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if stats.Source != LoadSourceManual || stats.UniqueDomains != 2 || stats.FailedLines != 2 {
+		t.Fatalf("got source %s, %d unique domains and %d failed lines, want manual, 2 and 2", stats.Source, stats.UniqueDomains, stats.FailedLines)
+	}
+
+	reader, err := db.storage.ReadDatabase("test")
+	if err != nil {
+		t.Fatalf("failed to read cached database: %v", err)
+	}
+	defer func() {
+		_ = reader.Close()
+	}()
+
+	got, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("failed to read cached database: %v", err)
+	}
+	if want := "*.example.org\nnew.example.com\n"; string(got) != want {
+		t.Fatalf("got cached database %q, want %q", got, want)
+	}
+
+	if err := db.ReplaceDomains("missing", nil); !errors.As(err, new(*NoSuchDatabaseError)) {
+		t.Fatalf("got err %v, want NoSuchDatabaseError", err)
+	}
+}
+
+func TestLoadFromReader(t *testing.T) {
+	db := newTestDb(t, map[string]string{
+		"test": "old.example.com\n",
+	})
+
+	if err := db.LoadFromReader("test", strings.NewReader("# comment\nNew.example.com\n")); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	if has, _ := db.DoesDbHaveDomain("test", "old.example.com"); has {
+		t.Fatal("expected old domain to be replaced")
+	}
+	if has, _ := db.DoesDbHaveDomain("test", "new.example.com"); !has {
+		t.Fatal("expected new domain to be loaded")
+	}
+
+	// The cached copy must not be touched.
+	reader, err := db.storage.ReadDatabase("test")
+	if err != nil {
+		t.Fatalf("failed to read cached database: %v", err)
+	}
+	defer func() {
+		_ = reader.Close()
+	}()
+	got, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("failed to read cached database: %v", err)
+	}
+	if want := "old.example.com\n"; string(got) != want {
+		t.Fatalf("got cached database %q, want %q", got, want)
+	}
+
+	// A failed load keeps the current contents.
+	readErr := errors.New("read failed")
+	err = db.LoadFromReader("test", &failingReader{data: strings.NewReader("other.example.com\n"), err: readErr})
+	if !errors.Is(err, readErr) {
+		t.Fatalf("got err %v, want %v", err, readErr)
+	}
+	if has, _ := db.DoesDbHaveDomain("test", "new.example.com"); !has {
+		t.Fatal("expected contents to be kept after failed load")
+	}
+}
+
+func TestCacheNormalization(t *testing.T) {
+	var mu sync.Mutex
+	var transformed []string
+
+	db, err := NewDomainDb(Options{
+		StorageDriver: NewMemoryStorageDriver(),
+		Logger:        slog.New(slog.DiscardHandler),
+		Sources: map[string]*DataSource{
+			"test": {
+				RefreshInterval:    time.Hour,
+				CacheNormalization: true,
+				Get: func() (io.ReadCloser, error) {
+					return io.NopCloser(strings.NewReader("")), nil
+				},
+			},
+		},
+		// The transform is only called for entries that are actually normalized, so it records cache misses.
+		DomainTransform: func(domain string) (string, bool) {
+			mu.Lock()
+			transformed = append(transformed, domain)
+			mu.Unlock()
+			return domain, true
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create DomainDb: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	load := func(list string) []string {
+		t.Helper()
+
+		mu.Lock()
+		transformed = nil
+		mu.Unlock()
+
+		if err := db.LoadFromReader("test", strings.NewReader(list)); err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		return slices.Clone(transformed)
+	}
+
+	if got := load("a.example.com\nb.example.com\n"); !slices.Equal(got, []string{"a.example.com", "b.example.com"}) {
+		t.Fatalf("first load: got normalized %v", got)
+	}
+	if got := load("a.example.com\nb.example.com\nc.example.com\n"); !slices.Equal(got, []string{"c.example.com"}) {
+		t.Fatalf("second load: got normalized %v, want only the new entry", got)
+	}
+
+	if got := load("c.example.com\n"); !slices.Equal(got, []string(nil)) {
+		t.Fatalf("third load: got normalized %v, want none", got)
+	}
+	// a.example.com was not in the previous load, so it was evicted from the cache.
+	if got := load("a.example.com\n"); !slices.Equal(got, []string{"a.example.com"}) {
+		t.Fatalf("fourth load: got normalized %v, want evicted entry", got)
+	}
+
+	if has, _ := db.DoesDbHaveDomain("test", "a.example.com"); !has {
+		t.Fatal("expected domain to be loaded from cache")
+	}
+}
+
+func TestLoad_WarnsOnEmptyList(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelWarn}))
+
+	db, err := NewDomainDb(Options{
+		StorageDriver: NewMemoryStorageDriver(),
+		Logger:        logger,
+		Sources: map[string]*DataSource{
+			"empty": {
+				RefreshInterval: time.Hour,
+				Get: func() (io.ReadCloser, error) {
+					return io.NopCloser(strings.NewReader("# only comments\n\n")), nil
+				},
+			},
+			"nonempty": {
+				RefreshInterval: time.Hour,
+				Get: func() (io.ReadCloser, error) {
+					return io.NopCloser(strings.NewReader("example.com\n")), nil
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create DomainDb: %v", err)
+	}
+	_ = db.Close()
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 || !strings.Contains(lines[0], "contains no domains") || !strings.Contains(lines[0], `"database_name":"empty"`) {
+		t.Fatalf("expected a single warning for the empty database, got: %s", buf.String())
+	}
+}
+
+func TestDoesDbHaveEmail(t *testing.T) {
+	db, err := NewDomainDb(Options{
+		StorageDriver: NewMemoryStorageDriver(),
+		Logger:        slog.New(slog.DiscardHandler),
+		Sources: map[string]*DataSource{
+			"emails": {
+				RefreshInterval: time.Hour,
+				Mode:            DatabaseModeEmail,
+				Get: func() (io.ReadCloser, error) {
+					return io.NopCloser(strings.NewReader("Spammer@Example.com\nabuse@bücher.de\n")), nil
+				},
+			},
+			"gmail": {
+				RefreshInterval: time.Hour,
+				Mode:            DatabaseModeEmail,
+				EmailRules: EmailRules{
+					StripPlusTag: true,
+					IgnoreDots:   true,
+				},
+				Get: func() (io.ReadCloser, error) {
+					return io.NopCloser(strings.NewReader("first.last@gmail.com\n")), nil
+				},
+			},
+			"strict": {
+				RefreshInterval: time.Hour,
+				Mode:            DatabaseModeEmail,
+				EmailRules: EmailRules{
+					CaseSensitiveLocalPart: true,
+				},
+				Get: func() (io.ReadCloser, error) {
+					return io.NopCloser(strings.NewReader("User@example.com\n")), nil
+				},
+			},
+			"domains": {
+				RefreshInterval: time.Hour,
+				Get: func() (io.ReadCloser, error) {
+					return io.NopCloser(strings.NewReader("example.com\n")), nil
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create DomainDb: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	for _, tc := range []struct {
+		db    string
+		email string
+		want  bool
+	}{
+		{"emails", "spammer@example.com", true},
+		{"emails", " SPAMMER@EXAMPLE.COM ", true},
+		{"emails", "abuse@xn--bcher-kva.de", true},
+		{"emails", "other@example.com", false},
+		{"emails", "spammer+tag@example.com", false},
+		{"gmail", "firstlast+promo@gmail.com", true},
+		{"gmail", "F.i.r.s.t.L.a.s.t@GMAIL.com", true},
+		{"strict", "User@EXAMPLE.com", true},
+		{"strict", "user@example.com", false},
+	} {
+		got, err := db.DoesDbHaveEmail(tc.db, tc.email)
+		if err != nil {
+			t.Fatalf("%s %q: unexpected err: %v", tc.db, tc.email, err)
+		}
+		if got != tc.want {
+			t.Fatalf("%s %q: got %v, want %v", tc.db, tc.email, got, tc.want)
+		}
+	}
+
+	if _, err := db.DoesDbHaveEmail("emails", "no-at-sign.example.com"); err == nil {
+		t.Fatal("expected error for address without @")
+	}
+	if _, err := db.DoesDbHaveEmail("domains", "user@example.com"); !errors.Is(err, ErrNotEmailDatabase) {
+		t.Fatalf("got err %v, want ErrNotEmailDatabase", err)
+	}
+	if has, _ := db.DoesDbHaveDomain("emails", "example.com"); has {
+		t.Fatal("expected domain lookup not to match an email database")
+	}
+}
+
+func TestTemporaryOverride_EmailDatabase(t *testing.T) {
+	db, err := NewDomainDb(Options{
+		StorageDriver: NewMemoryStorageDriver(),
+		Logger:        slog.New(slog.DiscardHandler),
+		Sources: map[string]*DataSource{
+			"gmail": {
+				RefreshInterval: time.Hour,
+				Mode:            DatabaseModeEmail,
+				EmailRules: EmailRules{
+					StripPlusTag: true,
+					IgnoreDots:   true,
+				},
+				Get: func() (io.ReadCloser, error) {
+					return io.NopCloser(strings.NewReader("first.last@gmail.com\n")), nil
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create DomainDb: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	assertEmail := func(email string, want bool) {
+		t.Helper()
+
+		has, err := db.DoesDbHaveEmail("gmail", email)
+		if err != nil {
+			t.Fatalf("%q: unexpected err: %v", email, err)
+		}
+		if has != want {
+			t.Fatalf("%q: got %t, want %t", email, has, want)
+		}
+	}
+
+	// Overrides are normalized with the database's email rules, so they match every form of the address.
+	if err = db.AddTemporaryOverride("gmail", "First.Last+promo@Gmail.com", false, time.Hour); err != nil {
+		t.Fatalf("failed to add override: %v", err)
+	}
+	if err = db.AddTemporaryOverride("gmail", "other@gmail.com", true, time.Hour); err != nil {
+		t.Fatalf("failed to add override: %v", err)
+	}
+	assertEmail("firstlast@gmail.com", false)
+	assertEmail("o.t.h.e.r+x@gmail.com", true)
+
+	if err = db.RemoveTemporaryOverride("gmail", "FIRSTLAST@gmail.com"); err != nil {
+		t.Fatalf("failed to remove override: %v", err)
+	}
+	assertEmail("first.last@gmail.com", true)
+
+	if err = db.AddTemporaryOverride("gmail", "gmail.com", true, time.Hour); err == nil {
+		t.Fatal("expected error for override of a domain in an email database")
+	}
+}
+func TestConfig(t *testing.T) {
+	srcUrl, _ := url.Parse("https://example.com/list.txt")
+
+	// Downloading is disabled, so the databases are loaded from cached copies.
+	storage := NewMemoryStorageDriver()
+	for _, name := range []string{"a", "b"} {
+		if err := storage.WriteDatabase(name, io.NopCloser(strings.NewReader("example.com\n"))); err != nil {
+			t.Fatalf("failed to write cached database: %v", err)
+		}
+	}
+	if err := storage.WriteCheckpoints(&AllCheckpoints{Checkpoints: map[string]Checkpoint{}}); err != nil {
+		t.Fatalf("failed to write checkpoints: %v", err)
+	}
+
+	db, err := NewDomainDb(Options{
+		StorageDriver:   storage,
+		Logger:          slog.New(slog.DiscardHandler),
+		DisableDownload: true,
+		LoadConcurrency: 3,
+		Sources: map[string]*DataSource{
+			"b": {
+				RefreshInterval: 2 * time.Hour,
+				Get: func() (io.ReadCloser, error) {
+					return io.NopCloser(strings.NewReader("example.com\n")), nil
+				},
+			},
+		},
+		OrderedSources: []NamedSource{
+			{
+				Name: "a",
+				Source: &DataSource{
+					RefreshInterval: time.Hour,
+					Urls:            []*url.URL{srcUrl},
+					NextPage: func(*http.Response) (*url.URL, bool) {
+						return nil, false
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create DomainDb: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	config := db.Config()
+	if config.HttpTimeout != defaultHttpClientTimeout || config.CustomHttpClient || !config.DisableDownload || config.LoadConcurrency != 3 {
+		t.Fatalf("unexpected config: %+v", config)
+	}
+	if len(config.Sources) != 2 || config.Sources[0].Name != "a" || config.Sources[1].Name != "b" {
+		t.Fatalf("unexpected sources: %+v", config.Sources)
+	}
+
+	a, b := config.Sources[0], config.Sources[1]
+	if a.Method != http.MethodGet || a.MaxPages != defaultMaxPages || a.HasGet || len(a.Urls) != 1 || a.Urls[0].String() != srcUrl.String() {
+		t.Fatalf("unexpected config for a: %+v", a)
+	}
+	if b.RefreshInterval != 2*time.Hour || !b.HasGet || b.MaxPages != 0 {
+		t.Fatalf("unexpected config for b: %+v", b)
+	}
+
+	// Modifying the returned config must not affect the DomainDb.
+	a.Urls[0].Host = "modified.example.com"
+	if got := db.Config().Sources[0].Urls[0].Host; got != "example.com" {
+		t.Fatalf("config was modified through returned copy: got host %q", got)
+	}
+	if srcUrl.Host != "example.com" {
+		t.Fatalf("source URL was modified through returned copy: got host %q", srcUrl.Host)
+	}
+}
+
+func TestCategories(t *testing.T) {
+	db, err := NewDomainDb(Options{
+		StorageDriver: NewMemoryStorageDriver(),
+		Logger:        slog.New(slog.DiscardHandler),
+		Sources: map[string]*DataSource{
+			"threats": {
+				RefreshInterval: time.Hour,
+				Categories:      []string{"phishing", "malware"},
+				Get: func() (io.ReadCloser, error) {
+					return io.NopCloser(strings.NewReader("# domain,category\nphish.example.com,phishing\nbad.example.com, malware\n*.evil.example,malware\nboth.example.com,phishing\nboth.example.com,malware\nspam.example.com,spam\n")), nil
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create DomainDb: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	if got, want := db.DatabaseNames(), []string{"threats", "threats/malware", "threats/phishing"}; !slices.Equal(got, want) {
+		t.Fatalf("got database names %v, want %v", got, want)
+	}
+
+	for _, tc := range []struct {
+		db     string
+		domain string
+		want   bool
+	}{
+		{"threats/phishing", "phish.example.com", true},
+		{"threats/phishing", "bad.example.com", false},
+		{"threats/phishing", "both.example.com", true},
+		{"threats/malware", "bad.example.com", true},
+		{"threats/malware", "a.evil.example", true},
+		{"threats/malware", "both.example.com", true},
+		{"threats/malware", "spam.example.com", false},
+		{"threats", "spam.example.com", true},
+		{"threats", "phish.example.com", true},
+	} {
+		got, err := db.DoesDbHaveDomain(tc.db, tc.domain)
+		if err != nil {
+			t.Fatalf("%s %q: unexpected err: %v", tc.db, tc.domain, err)
+		}
+		if got != tc.want {
+			t.Fatalf("%s %q: got %v, want %v", tc.db, tc.domain, got, tc.want)
+		}
+	}
+
+	stats, err := db.LastLoadStats("threats/malware")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if stats.DomainLines != 3 || stats.UniqueDomains != 3 {
+		t.Fatalf("got %d domain lines and %d unique domains, want 3 and 3", stats.DomainLines, stats.UniqueDomains)
+	}
+
+	if err := db.LoadFromReader("threats/malware", strings.NewReader("example.com\n")); !errors.Is(err, ErrCategoryDatabase) {
+		t.Fatalf("got err %v, want ErrCategoryDatabase", err)
+	}
+
+	// Loading the source database updates its categories.
+	if err := db.LoadFromReader("threats", strings.NewReader("new.example.com,malware\n")); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if has, _ := db.DoesDbHaveDomain("threats/malware", "new.example.com"); !has {
+		t.Fatal("expected category database to be updated along with its source")
+	}
+	if has, _ := db.DoesDbHaveDomain("threats/phishing", "phish.example.com"); has {
+		t.Fatal("expected category database to be replaced along with its source")
+	}
+}
+
+func TestPartialInit(t *testing.T) {
+	var attempts atomic.Int32
+
+	db, err := NewDomainDb(Options{
+		StorageDriver:            NewMemoryStorageDriver(),
+		Logger:                   slog.New(slog.DiscardHandler),
+		PartialInit:              true,
+		PartialInitRetryInterval: 10 * time.Millisecond,
+		Sources: map[string]*DataSource{
+			"ok": {
+				RefreshInterval: time.Hour,
+				Get: func() (io.ReadCloser, error) {
+					return io.NopCloser(strings.NewReader("ok.example.com\n")), nil
+				},
+			},
+			"flaky": {
+				RefreshInterval: time.Hour,
+				Get: func() (io.ReadCloser, error) {
+					// Fail the initial load and the first retry.
+					if attempts.Add(1) <= 2 {
+						return nil, errors.New("source is down")
+					}
+					return io.NopCloser(strings.NewReader("flaky.example.com\n")), nil
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create DomainDb: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	if has, err := db.DoesDbHaveDomain("ok", "ok.example.com"); err != nil || !has {
+		t.Fatalf("got %v, %v; want true, nil", has, err)
+	}
+	if _, err := db.DoesDbHaveDomain("flaky", "flaky.example.com"); !errors.As(err, new(*NotInitializedError)) {
+		t.Fatalf("got err %v, want NotInitializedError", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		has, err := db.DoesDbHaveDomain("flaky", "flaky.example.com")
+		if err == nil && has {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("database was not loaded by retries: got %v, %v", has, err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := attempts.Load(); got != 3 {
+		t.Fatalf("got %d attempts, want 3", got)
+	}
+}
+
+func TestPartialInit_RetriesDuringUpdates(t *testing.T) {
+	var attempts atomic.Int32
+
+	// Retries read the checkpoints while the frequent updates of "busy" are being saved, which must not race.
+	db, err := NewDomainDb(Options{
+		StorageDriver:            NewMemoryStorageDriver(),
+		Logger:                   slog.New(slog.DiscardHandler),
+		PartialInit:              true,
+		PartialInitRetryInterval: time.Millisecond,
+		Sources: map[string]*DataSource{
+			"busy": {
+				RefreshInterval: time.Millisecond,
+				Get: func() (io.ReadCloser, error) {
+					return io.NopCloser(strings.NewReader("busy.example.com\n")), nil
+				},
+			},
+			"flaky": {
+				RefreshInterval: time.Hour,
+				Get: func() (io.ReadCloser, error) {
+					if attempts.Add(1) <= 20 {
+						return nil, errors.New("source is down")
+					}
+					return io.NopCloser(strings.NewReader("flaky.example.com\n")), nil
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create DomainDb: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		has, err := db.DoesDbHaveDomain("flaky", "flaky.example.com")
+		if err == nil && has {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("database was not loaded by retries: got %v, %v", has, err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestPartialInit_Disabled(t *testing.T) {
+	_, err := NewDomainDb(Options{
+		StorageDriver: NewMemoryStorageDriver(),
+		Logger:        slog.New(slog.DiscardHandler),
+		Sources: map[string]*DataSource{
+			"broken": {
+				RefreshInterval: time.Hour,
+				Get: func() (io.ReadCloser, error) {
+					return nil, errors.New("source is down")
+				},
+			},
+		},
+	})
+	if err == nil {
+		t.Fatal("expected initialization to fail without PartialInit")
+	}
+}
+
+func TestExportAndImportAll(t *testing.T) {
+	src := newTestDb(t, map[string]string{
+		"a": "a.example.com\n# comment\n",
+		"b": "b.example.com\n",
+	})
+
+	var buf bytes.Buffer
+	if err := src.ExportAll(&buf); err != nil {
+		t.Fatalf("unexpected export err: %v", err)
+	}
+
+	storage := NewMemoryStorageDriver()
+	dst, err := NewDomainDb(Options{
+		StorageDriver: storage,
+		Logger:        slog.New(slog.DiscardHandler),
+		Sources: map[string]*DataSource{
+			"a": {
+				RefreshInterval: time.Hour,
+				Get: func() (io.ReadCloser, error) {
+					return io.NopCloser(strings.NewReader("")), nil
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create DomainDb: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = dst.Close()
+	})
+
+	// "b" is not configured in the destination, so it is skipped.
+	if err := dst.ImportAll(&buf); err != nil {
+		t.Fatalf("unexpected import err: %v", err)
+	}
+
+	if has, _ := dst.DoesDbHaveDomain("a", "a.example.com"); !has {
+		t.Fatal("expected imported domain to be loaded")
+	}
+
+	reader, err := storage.ReadDatabase("a")
+	if err != nil {
+		t.Fatalf("failed to read imported database: %v", err)
+	}
+	defer func() {
+		_ = reader.Close()
+	}()
+	got, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("failed to read imported database: %v", err)
+	}
+	if want := "a.example.com\n# comment\n"; string(got) != want {
+		t.Fatalf("got imported database %q, want %q", got, want)
+	}
+
+	checkpoints, err := storage.ReadCheckpoints()
+	if err != nil {
+		t.Fatalf("failed to read checkpoints: %v", err)
+	}
+	src.checkpointsMu.Lock()
+	want := src.checkpoints.Checkpoints["a"]
+	src.checkpointsMu.Unlock()
+	if got := checkpoints.Checkpoints["a"]; got != want {
+		t.Fatalf("got checkpoint %+v, want %+v", got, want)
+	}
+
+	if err := dst.ImportAll(strings.NewReader(`{"version":99}`)); err == nil {
+		t.Fatal("expected error for unsupported version")
+	}
+}
+
+func TestCompressCache(t *testing.T) {
+	storage := NewMemoryStorageDriver()
+
+	newDb := func(compressCache bool, disableDownload bool) *DomainDb {
+		t.Helper()
+
+		db, err := NewDomainDb(Options{
+			StorageDriver:   storage,
+			Logger:          slog.New(slog.DiscardHandler),
+			CompressCache:   compressCache,
+			DisableDownload: disableDownload,
+			Sources: map[string]*DataSource{
+				"test": {
+					RefreshInterval: time.Hour,
+					Get: func() (io.ReadCloser, error) {
+						return io.NopCloser(strings.NewReader("example.com\n")), nil
+					},
+				},
+			},
+		})
+		if err != nil {
+			t.Fatalf("failed to create DomainDb: %v", err)
+		}
+		return db
+	}
+
+	db := newDb(true, false)
+	_ = db.Close()
+
+	reader, err := storage.ReadDatabase("test")
+	if err != nil {
+		t.Fatalf("failed to read cached database: %v", err)
+	}
+	raw, err := io.ReadAll(reader)
+	_ = reader.Close()
+	if err != nil {
+		t.Fatalf("failed to read cached database: %v", err)
+	}
+	if !bytes.HasPrefix(raw, zstdMagic) {
+		t.Fatalf("expected cached database to be compressed with zstd, got %q", raw)
+	}
+
+	// The compressed cache must still load after compression is turned off.
+	db = newDb(false, true)
+	defer func() {
+		_ = db.Close()
+	}()
+	if has, _ := db.DoesDbHaveDomain("test", "example.com"); !has {
+		t.Fatal("expected domain to be loaded from compressed cache")
+	}
+}
+
+func TestPauseUpdates(t *testing.T) {
+	var downloads atomic.Int32
+
+	db, err := NewDomainDb(Options{
+		StorageDriver: NewMemoryStorageDriver(),
+		Logger:        slog.New(slog.DiscardHandler),
+		Sources: map[string]*DataSource{
+			"test": {
+				RefreshInterval: 10 * time.Millisecond,
+				Get: func() (io.ReadCloser, error) {
+					downloads.Add(1)
+					return io.NopCloser(strings.NewReader("example.com\n")), nil
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create DomainDb: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	db.PauseUpdates()
+	if !db.UpdatesPaused() {
+		t.Fatal("expected updates to be paused")
+	}
+
+	// Let any update that started before the pause finish.
+	time.Sleep(20 * time.Millisecond)
+	before := downloads.Load()
+	time.Sleep(100 * time.Millisecond)
+	if got := downloads.Load(); got != before {
+		t.Fatalf("got %d downloads while paused, want %d", got, before)
+	}
+	if has, _ := db.DoesDbHaveDomain("test", "example.com"); !has {
+		t.Fatal("expected loaded domains to be kept while paused")
+	}
+
+	db.ResumeUpdates()
+	deadline := time.Now().Add(5 * time.Second)
+	for downloads.Load() == before {
+		if time.Now().After(deadline) {
+			t.Fatal("expected updates to resume")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestDownloadAndLoadDatabase_ParseErrorKeepsCache(t *testing.T) {
+	storage, err := NewFsStorageDriver(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create storage driver: %v", err)
+	}
+
+	var downloads atomic.Int32
+	db, err := NewDomainDb(Options{
+		StorageDriver: storage,
+		Logger:        slog.New(slog.DiscardHandler),
+		Sources: map[string]*DataSource{
+			"test": {
+				RefreshInterval: time.Hour,
+				MaxLineSize:     64,
+				Get: func() (io.ReadCloser, error) {
+					if downloads.Add(1) == 1 {
+						return io.NopCloser(strings.NewReader("good.example.com\n")), nil
+					}
+					// The line that is too long fails parsing after the first line was already written to the cache.
+					return io.NopCloser(strings.NewReader("new.example.com\n" + strings.Repeat("a", 100) + "\n")), nil
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create DomainDb: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	if err := db.DownloadAndLoadDatabase("test"); !errors.Is(err, bufio.ErrTooLong) {
+		t.Fatalf("got err %v, want bufio.ErrTooLong", err)
+	}
+
+	reader, err := storage.ReadDatabase("test")
+	if err != nil {
+		t.Fatalf("failed to read cached database: %v", err)
+	}
+	defer func() {
+		_ = reader.Close()
+	}()
+	got, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("failed to read cached database: %v", err)
+	}
+	if want := "good.example.com\n"; string(got) != want {
+		t.Fatalf("got cached database %q, want %q", got, want)
+	}
+
+	if has, _ := db.DoesDbHaveDomain("test", "good.example.com"); !has {
+		t.Fatal("expected previously loaded domains to be kept")
+	}
+}
+
+// writeCountingStorageDriver is a StorageDriver that counts the database writes it receives.
+type writeCountingStorageDriver struct {
+	*MemoryStorageDriver
+
+	writes atomic.Int32
+}
+
+func (s *writeCountingStorageDriver) WriteDatabase(name string, input io.ReadCloser) error {
+	s.writes.Add(1)
+	return s.MemoryStorageDriver.WriteDatabase(name, input)
+}
+
+func TestDownloadAndLoadDatabase_ParseErrorDoesNotWriteCache(t *testing.T) {
+	storage := &writeCountingStorageDriver{MemoryStorageDriver: NewMemoryStorageDriver()}
+
+	var downloads atomic.Int32
+	db, err := NewDomainDb(Options{
+		StorageDriver: storage,
+		TempDir:       t.TempDir(),
+		Logger:        slog.New(slog.DiscardHandler),
+		Sources: map[string]*DataSource{
+			"test": {
+				RefreshInterval: time.Hour,
+				MaxLineSize:     64,
+				Get: func() (io.ReadCloser, error) {
+					if downloads.Add(1) == 1 {
+						return io.NopCloser(strings.NewReader("good.example.com\n")), nil
+					}
+					// Fails parsing partway through, after some data has already been read.
+					return io.NopCloser(strings.NewReader("new.example.com\n" + strings.Repeat("a", 100) + "\n")), nil
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create DomainDb: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	if got := storage.writes.Load(); got != 1 {
+		t.Fatalf("got %d cache writes after initial download, want 1", got)
+	}
+
+	if err := db.DownloadAndLoadDatabase("test"); !errors.Is(err, bufio.ErrTooLong) {
+		t.Fatalf("got err %v, want bufio.ErrTooLong", err)
+	}
+
+	if got := storage.writes.Load(); got != 1 {
+		t.Fatalf("got %d cache writes after failed download, want 1", got)
+	}
+
+	reader, err := storage.ReadDatabase("test")
+	if err != nil {
+		t.Fatalf("failed to read cached database: %v", err)
+	}
+	defer func() {
+		_ = reader.Close()
+	}()
+	got, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("failed to read cached database: %v", err)
+	}
+	if want := "good.example.com\n"; string(got) != want {
+		t.Fatalf("got cached database %q, want %q", got, want)
+	}
+
+	if has, _ := db.DoesDbHaveDomain("test", "new.example.com"); has {
+		t.Fatal("expected domains from the failed download not to be loaded")
+	}
+	if has, _ := db.DoesDbHaveDomain("test", "good.example.com"); !has {
+		t.Fatal("expected previously loaded domains to be kept")
+	}
+}
+
+func TestMinDownloadInterval(t *testing.T) {
+	var downloads atomic.Int32
+	db, err := NewDomainDb(Options{
+		StorageDriver: NewMemoryStorageDriver(),
+		TempDir:       t.TempDir(),
+		Logger:        slog.New(slog.DiscardHandler),
+		Sources: map[string]*DataSource{
+			"test": {
+				RefreshInterval:     time.Hour,
+				MinDownloadInterval: time.Hour,
+				Get: func() (io.ReadCloser, error) {
+					downloads.Add(1)
+					return io.NopCloser(strings.NewReader("example.com\n")), nil
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create DomainDb: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	if err := db.DownloadAndLoadDatabase("test"); err != nil {
+		t.Fatalf("failed to download database: %v", err)
+	}
+	if got := downloads.Load(); got != 1 {
+		t.Fatalf("got %d downloads, want 1 because the second download is within the cooldown", got)
+	}
+
+	// Move the last download out of the cooldown.
+	db.dbs["test"].LastDownloadNano.Store(time.Now().Add(-2 * time.Hour).UnixNano())
+
+	if err := db.DownloadAndLoadDatabase("test"); err != nil {
+		t.Fatalf("failed to download database: %v", err)
+	}
+	if got := downloads.Load(); got != 2 {
+		t.Fatalf("got %d downloads, want 2", got)
+	}
+}
+
+// checkpointCountingStorageDriver wraps a StorageDriver and counts calls to WriteCheckpoints.
+type checkpointCountingStorageDriver struct {
+	StorageDriver
+	writes atomic.Int32
+}
+
+func (s *checkpointCountingStorageDriver) WriteCheckpoints(checkpoints *AllCheckpoints) error {
+	s.writes.Add(1)
+	return s.StorageDriver.WriteCheckpoints(checkpoints)
+}
+
+func TestMinDownloadInterval_SkippedUpdatesDoNotWriteCheckpoints(t *testing.T) {
+	storage := &checkpointCountingStorageDriver{StorageDriver: NewMemoryStorageDriver()}
+	var downloads atomic.Int32
+	db, err := NewDomainDb(Options{
+		StorageDriver: storage,
+		TempDir:       t.TempDir(),
+		Logger:        slog.New(slog.DiscardHandler),
+		Sources: map[string]*DataSource{
+			"test": {
+				RefreshInterval:     5 * time.Millisecond,
+				MinDownloadInterval: time.Hour,
+				Get: func() (io.ReadCloser, error) {
+					downloads.Add(1)
+					return io.NopCloser(strings.NewReader("example.com\n")), nil
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create DomainDb: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	writesAfterStartup := storage.writes.Load()
+
+	// Let scheduled updates come due; all of them are within the cooldown of the initial download.
+	time.Sleep(100 * time.Millisecond)
+
+	if got := downloads.Load(); got != 1 {
+		t.Fatalf("got %d downloads, want 1 because scheduled updates are within the cooldown", got)
+	}
+	if got := storage.writes.Load() - writesAfterStartup; got != 0 {
+		t.Fatalf("got %d checkpoint writes from skipped updates, want 0", got)
+	}
+}
+
+// sharedCount returns the number of callers sharing the download in progress, not counting the caller that started it.
+func (f *downloadFlight) sharedCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.call == nil {
+		return 0
+	}
+	return f.call.shared
+}
+
+func TestDownloadAndLoadDatabase_Coalesced(t *testing.T) {
+	const callers = 8
+
+	var downloads atomic.Int32
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	db, err := NewDomainDb(Options{
+		StorageDriver: NewMemoryStorageDriver(),
+		TempDir:       t.TempDir(),
+		Logger:        slog.New(slog.DiscardHandler),
+		Sources: map[string]*DataSource{
+			"test": {
+				RefreshInterval: time.Hour,
+				Get: func() (io.ReadCloser, error) {
+					if downloads.Add(1) > 1 {
+						started <- struct{}{}
+						<-release
+					}
+					return io.NopCloser(strings.NewReader("example.com\n")), nil
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create DomainDb: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	errs := make(chan error, callers)
+	for range callers {
+		go func() {
+			errs <- db.DownloadAndLoadDatabase("test")
+		}()
+	}
+
+	<-started
+	deadline := time.Now().Add(5 * time.Second)
+	for db.dbs["test"].Download.sharedCount() < callers-1 {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for callers to join the download in progress")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+
+	for range callers {
+		if err := <-errs; err != nil {
+			t.Fatalf("failed to download database: %v", err)
+		}
+	}
+
+	// One download during initialization and one shared by all callers.
+	if got := downloads.Load(); got != 2 {
+		t.Fatalf("got %d downloads, want 2", got)
+	}
+}
+
+func TestDoesDbHaveDomainTimed(t *testing.T) {
+	db, err := NewDomainDb(Options{
+		StorageDriver: NewMemoryStorageDriver(),
+		TempDir:       t.TempDir(),
+		Logger:        slog.New(slog.DiscardHandler),
+		Sources: map[string]*DataSource{
+			"test": {
+				RefreshInterval: time.Hour,
+				Get: func() (io.ReadCloser, error) {
+					return io.NopCloser(strings.NewReader("example.com\n")), nil
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create DomainDb: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	for domain, want := range map[string]bool{
+		"EXAMPLE.com": true,
+		"example.org": false,
+	} {
+		found, timing, err := db.DoesDbHaveDomainTimed("test", domain)
+		if err != nil {
+			t.Fatalf("failed to look up %q: %v", domain, err)
+		}
+		if found != want {
+			t.Errorf("got found %t for %q, want %t", found, domain, want)
+		}
+		if timing.Normalize < 0 || timing.Lookup < 0 || timing.Total() != timing.Normalize+timing.Lookup {
+			t.Errorf("got invalid timing %+v for %q", timing, domain)
+		}
+	}
+
+	_, timing, err := db.DoesDbHaveDomainTimed("test", "not a domain!")
+	if err == nil {
+		t.Fatal("expected normalization error")
+	}
+	if timing.Lookup != 0 {
+		t.Errorf("got lookup time %v after failed normalization, want 0", timing.Lookup)
+	}
+}
+
+func TestDoesDbHaveDomainDetailed(t *testing.T) {
+	db := newTestDb(t, map[string]string{
+		"test": "xn--bcher-kva.example\n",
+	})
+
+	for _, c := range []struct {
+		domain     string
+		found      bool
+		normalized string
+	}{
+		{"Bücher.Example", true, "xn--bcher-kva.example"},
+		{"WWW.example.org.", false, "www.example.org"},
+	} {
+		found, normalized, err := db.DoesDbHaveDomainDetailed("test", c.domain)
+		if err != nil {
+			t.Fatalf("failed to look up %q: %v", c.domain, err)
+		}
+		if found != c.found || normalized != c.normalized {
+			t.Errorf("got (%t, %q) for %q, want (%t, %q)", found, normalized, c.domain, c.found, c.normalized)
+		}
+	}
+
+	if _, normalized, err := db.DoesDbHaveDomainDetailed("test", "not a domain!"); err == nil || normalized != "" {
+		t.Errorf("got (%q, %v) for invalid domain, want empty normalized form and an error", normalized, err)
+	}
+
+	if _, _, err := db.DoesDbHaveDomainDetailed("missing", "example.com"); !errors.As(err, new(*NoSuchDatabaseError)) {
+		t.Errorf("got error %v for missing database, want NoSuchDatabaseError", err)
+	}
+}
+
+func TestTemporaryOverride(t *testing.T) {
+	db, err := NewDomainDb(Options{
+		StorageDriver: NewMemoryStorageDriver(),
+		TempDir:       t.TempDir(),
+		Logger:        slog.New(slog.DiscardHandler),
+		Sources: map[string]*DataSource{
+			"test": {
+				RefreshInterval: time.Hour,
+				Get: func() (io.ReadCloser, error) {
+					return io.NopCloser(strings.NewReader("listed.example.com\n")), nil
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create DomainDb: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	assertFound := func(domain string, want bool) {
+		t.Helper()
+
+		found, err := db.DoesDbHaveDomain("test", domain)
+		if err != nil {
+			t.Fatalf("failed to look up %q: %v", domain, err)
+		}
+		if found != want {
+			t.Fatalf("got found %t for %q, want %t", found, domain, want)
+		}
+	}
+
+	if err := db.AddTemporaryOverride("test", "LISTED.example.com", false, time.Hour); err != nil {
+		t.Fatalf("failed to add override: %v", err)
+	}
+	if err := db.AddTemporaryOverride("test", "unlisted.example.com", true, time.Hour); err != nil {
+		t.Fatalf("failed to add override: %v", err)
+	}
+	assertFound("listed.example.com", false)
+	assertFound("unlisted.example.com", true)
+
+	snapshot, err := db.Snapshot()
+	if err != nil {
+		t.Fatalf("failed to take snapshot: %v", err)
+	}
+	if found, _ := snapshot.DoesDbHaveDomain("test", "listed.example.com"); found {
+		t.Fatal("expected override to apply to snapshot")
+	}
+
+	res, err := db.Explain("listed.example.com")
+	if err != nil {
+		t.Fatalf("failed to explain: %v", err)
+	}
+	if got := res.Databases[0]; got.Found || !got.Overridden || got.MatchType != MatchExact {
+		t.Fatalf("got explanation %+v, want overridden to not found with an exact list match", got)
+	}
+
+	if err := db.RemoveTemporaryOverride("test", "unlisted.example.com"); err != nil {
+		t.Fatalf("failed to remove override: %v", err)
+	}
+	assertFound("unlisted.example.com", false)
+
+	// Expired overrides are ignored even before they are swept.
+	db.dbs["test"].Overrides = map[string]temporaryOverride{
+		"listed.example.com": {Present: false, ExpiresAt: time.Now()},
+	}
+	assertFound("listed.example.com", true)
+
+	db.sweepOverrides(time.Now())
+	if got := db.dbs["test"].Overrides; got != nil {
+		t.Fatalf("got overrides %v after sweep, want nil", got)
+	}
+
+	if err := db.AddTemporaryOverride("test", "listed.example.com", false, 0); err == nil {
+		t.Fatal("expected error for non-positive TTL")
+	}
+	var noSuchDb *NoSuchDatabaseError
+	if err := db.AddTemporaryOverride("missing", "listed.example.com", false, time.Hour); !errors.As(err, &noSuchDb) {
+		t.Fatalf("got err %v, want NoSuchDatabaseError", err)
+	}
+}
+
+func TestDashboardSnapshot(t *testing.T) {
+	var flakyDownloads atomic.Int32
+	db, err := NewDomainDb(Options{
+		StorageDriver: NewMemoryStorageDriver(),
+		TempDir:       t.TempDir(),
+		Logger:        slog.New(slog.DiscardHandler),
+		Sources: map[string]*DataSource{
+			"good": {
+				RefreshInterval: time.Hour,
+				Get: func() (io.ReadCloser, error) {
+					return io.NopCloser(strings.NewReader("a.example.com\nb.example.com\n")), nil
+				},
+			},
+			"flaky": {
+				RefreshInterval: time.Hour,
+				Get: func() (io.ReadCloser, error) {
+					if flakyDownloads.Add(1) > 1 {
+						return nil, errors.New("source is down")
+					}
+					return io.NopCloser(strings.NewReader("c.example.com\n")), nil
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create DomainDb: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	if err := db.DownloadAndLoadDatabase("flaky"); err == nil {
+		t.Fatal("expected download of flaky database to fail")
+	}
+	if err := db.SetDatabaseEnabled("good", false); err != nil {
+		t.Fatalf("failed to disable database: %v", err)
+	}
+	db.PauseUpdates()
+
+	// Updaters record their next update when they start, which happens in the background.
+	deadline := time.Now().Add(5 * time.Second)
+	var infos []DatabaseInfo
+	for {
+		infos = db.DashboardSnapshot()
+		if !infos[0].NextUpdate.IsZero() && !infos[1].NextUpdate.IsZero() {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for next updates to be scheduled, got %+v", infos)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if len(infos) != 2 || infos[0].Name != "flaky" || infos[1].Name != "good" {
+		t.Fatalf("got %+v, want flaky and good sorted by name", infos)
+	}
+
+	flaky, good := infos[0], infos[1]
+	if !strings.Contains(flaky.LastError, "source is down") || flaky.LastErrorAt.IsZero() {
+		t.Errorf("got flaky last error %q at %v, want download failure", flaky.LastError, flaky.LastErrorAt)
+	}
+	if flaky.DomainCount != 1 || !flaky.Enabled {
+		t.Errorf("got flaky %+v, want 1 domain and enabled", flaky)
+	}
+	if good.LastError != "" || good.DomainCount != 2 || good.Enabled || !good.UpdatesPaused || !good.Initialized {
+		t.Errorf("got good %+v, want no error, 2 domains, disabled, paused and initialized", good)
+	}
+	if good.LastUpdated.IsZero() || good.LastLoadSource != "download" {
+		t.Errorf("got good last updated %v from %q, want a download time", good.LastUpdated, good.LastLoadSource)
+	}
+
+	if _, err := json.Marshal(infos); err != nil {
+		t.Fatalf("failed to marshal dashboard snapshot: %v", err)
+	}
+}
+
+func TestDoesDbHaveDomain_DecomposedUnicode(t *testing.T) {
+	db := newTestDb(t, map[string]string{
+		"test": "caf\u00e9.example\n",
+	})
+
+	for _, domain := range []string{"caf\u00e9.example", "cafe\u0301.example", "xn--caf-dma.example"} {
+		has, err := db.DoesDbHaveDomain("test", domain)
+		if err != nil {
+			t.Fatalf("failed to look up %q: %v", domain, err)
+		}
+		if !has {
+			t.Errorf("expected %q to match the precomposed entry", domain)
+		}
+	}
+}
+
+func TestDoesDbHaveDomain_SingleLabel(t *testing.T) {
+	db := newTestDb(t, map[string]string{
+		"test": "localhost\nintranet\n",
+	})
+
+	for domain, want := range map[string]bool{
+		"localhost":      true,
+		"LocalHost.":     true,
+		"intranet":       true,
+		"intranet.local": false,
+		"other":          false,
+	} {
+		has, err := db.DoesDbHaveDomain("test", domain)
+		if err != nil {
+			t.Fatalf("failed to look up %q: %v", domain, err)
+		}
+		if has != want {
+			t.Errorf("got %t for %q, want %t", has, domain, want)
+		}
+	}
+}
+
+func TestDedupe(t *testing.T) {
+	lists := map[string]string{
+		"primary":   "a.example.com\nb.example.com\n",
+		"secondary": "b.example.com\nc.example.com\n",
+		"tertiary":  "a.example.com\nc.example.com\nd.example.com\n",
+	}
+	sources := make(map[string]*DataSource, len(lists))
+	for name, contents := range lists {
+		sources[name] = &DataSource{
+			RefreshInterval: time.Hour,
+			Get: func() (io.ReadCloser, error) {
+				return io.NopCloser(strings.NewReader(contents)), nil
+			},
+		}
+	}
+
+	db, err := NewDomainDb(Options{
+		StorageDriver: NewMemoryStorageDriver(),
+		TempDir:       t.TempDir(),
+		Logger:        slog.New(slog.DiscardHandler),
+		Sources:       sources,
+		Dedupe:        []string{"primary", "secondary", "tertiary"},
+	})
+	if err != nil {
+		t.Fatalf("failed to create DomainDb: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	assertDomains := func(name string, want ...string) {
+		t.Helper()
+
+		got := slices.Sorted(maps.Keys(db.dbs[name].Domains))
+		if !slices.Equal(got, want) {
+			t.Fatalf("got %s domains %v, want %v", name, got, want)
+		}
+	}
+
+	assertDomains("primary", "a.example.com", "b.example.com")
+	assertDomains("secondary", "c.example.com")
+	assertDomains("tertiary", "d.example.com")
+
+	stats, err := db.LastLoadStats("tertiary")
+	if err != nil {
+		t.Fatalf("failed to get load stats: %v", err)
+	}
+	if stats.DedupedDomains != 2 || stats.UniqueDomains != 1 {
+		t.Fatalf("got tertiary stats %+v, want 2 deduped domains and 1 unique domain", stats)
+	}
+
+	// A domain that newly appears in an earlier database is removed from later ones.
+	if err := db.ReplaceDomains("primary", []string{"c.example.com"}); err != nil {
+		t.Fatalf("failed to replace domains: %v", err)
+	}
+	assertDomains("primary", "c.example.com")
+	assertDomains("secondary")
+	assertDomains("tertiary", "d.example.com")
+
+	for _, dedupe := range [][]string{
+		{"primary", "missing"},
+		{"primary", "primary"},
+	} {
+		_, err := NewDomainDb(Options{
+			StorageDriver: NewMemoryStorageDriver(),
+			Logger:        slog.New(slog.DiscardHandler),
+			Sources:       sources,
+			Dedupe:        dedupe,
+		})
+		if err == nil {
+			t.Fatalf("expected error for dedupe order %v", dedupe)
+		}
+	}
+}
+
+func TestRefreshOnStartupFreshFraction(t *testing.T) {
+	now := time.Now()
+	storage := NewMemoryStorageDriver()
+	for _, name := range []string{"fresh", "stale"} {
+		if err := storage.WriteDatabase(name, io.NopCloser(strings.NewReader("example.com\n"))); err != nil {
+			t.Fatalf("failed to write cached database: %v", err)
+		}
+	}
+	if err := storage.WriteCheckpoints(&AllCheckpoints{Checkpoints: map[string]Checkpoint{
+		"fresh": {LastUpdatedUnix: now.Add(-time.Minute).Unix()},
+		"stale": {LastUpdatedUnix: now.Add(-40 * time.Minute).Unix()},
+	}}); err != nil {
+		t.Fatalf("failed to write checkpoints: %v", err)
+	}
+
+	sources := make(map[string]*DataSource, 2)
+	for _, name := range []string{"fresh", "stale"} {
+		sources[name] = &DataSource{
+			RefreshInterval: time.Hour,
+			Get: func() (io.ReadCloser, error) {
+				return io.NopCloser(strings.NewReader("example.com\n")), nil
+			},
+		}
+	}
+
+	db, err := NewDomainDb(Options{
+		StorageDriver:                 storage,
+		TempDir:                       t.TempDir(),
+		Logger:                        slog.New(slog.DiscardHandler),
+		Sources:                       sources,
+		RefreshOnStartup:              true,
+		RefreshOnStartupFreshFraction: 0.5,
+	})
+	if err != nil {
+		t.Fatalf("failed to create DomainDb: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	// Updaters record their first update when they start, which happens in the background.
+	nextUpdate := func(name string) time.Time {
+		t.Helper()
+
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			if next := db.dbs[name].NextUpdateNano.Load(); next != 0 {
+				return time.Unix(0, next)
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s to be scheduled", name)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	// The fresh cache keeps its normal schedule, relative to its last update.
+	if got, want := nextUpdate("fresh"), now.Add(-time.Minute).Add(time.Hour); got.Sub(want).Abs() > time.Second {
+		t.Errorf("got fresh next update %v, want %v", got, want)
+	}
+
+	// The stale cache is refreshed on startup.
+	// The jittered refresh may already have happened, in which case the next update is a full interval later.
+	if got := nextUpdate("stale"); got.After(now.Add(refreshOnStartupMaxJitter + time.Second)) {
+		if stats, _ := db.LastLoadStats("stale"); stats.Source != LoadSourceDownload {
+			t.Errorf("got stale next update %v, want within %v of startup", got, refreshOnStartupMaxJitter)
+		}
+	}
+}
+
+// suffixMatcher is a Matcher that matches entries and all of their subdomains, recording every entry added to it.
+type suffixMatcher struct {
+	entries []string
+}
+
+func (m *suffixMatcher) Add(entry string) {
+	m.entries = append(m.entries, entry)
+}
+
+func (m *suffixMatcher) Has(domain string) bool {
+	for _, entry := range m.entries {
+		if domain == entry || strings.HasSuffix(domain, "."+entry) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestNewMatcher(t *testing.T) {
+	contents := "example.com\nexample.com\nexample.org\n"
+	var matchers []*suffixMatcher
+
+	db, err := NewDomainDb(Options{
+		StorageDriver: NewMemoryStorageDriver(),
+		TempDir:       t.TempDir(),
+		Logger:        slog.New(slog.DiscardHandler),
+		Sources: map[string]*DataSource{
+			"custom": {
+				RefreshInterval: time.Hour,
+				Get: func() (io.ReadCloser, error) {
+					return io.NopCloser(strings.NewReader(contents)), nil
+				},
+				NewMatcher: func() Matcher {
+					m := &suffixMatcher{}
+					matchers = append(matchers, m)
+					return m
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create DomainDb: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	if len(matchers) != 1 {
+		t.Fatalf("got %d matchers, want 1", len(matchers))
+	}
+	if want := []string{"example.com", "example.org"}; !slices.Equal(matchers[0].entries, want) {
+		t.Fatalf("got entries %v, want %v", matchers[0].entries, want)
+	}
+
+	for domain, want := range map[string]bool{
+		"example.com":          true,
+		"sub.example.com":      true,
+		"deep.sub.Example.ORG": true,
+		"example.net":          false,
+	} {
+		has, err := db.DoesDbHaveDomain("custom", domain)
+		if err != nil {
+			t.Fatalf("failed to look up %s: %v", domain, err)
+		}
+		if has != want {
+			t.Errorf("got %v for %s, want %v", has, domain, want)
+		}
+	}
+
+	res, err := db.Explain("sub.example.com")
+	if err != nil {
+		t.Fatalf("failed to explain: %v", err)
+	}
+	if exp := res.Databases[0]; !exp.Found || exp.MatchType != MatchCustom || exp.Matched != "sub.example.com" {
+		t.Fatalf("got explanation %+v, want custom match", exp)
+	}
+
+	// Each load builds a new matcher, which replaces the previous one.
+	contents = "example.net\n"
+	if err := db.DownloadAndLoadDatabase("custom"); err != nil {
+		t.Fatalf("failed to reload database: %v", err)
+	}
+	if len(matchers) != 2 {
+		t.Fatalf("got %d matchers, want 2", len(matchers))
+	}
+	for domain, want := range map[string]bool{
+		"sub.example.com": false,
+		"example.net":     true,
+	} {
+		if has, _ := db.DoesDbHaveDomain("custom", domain); has != want {
+			t.Errorf("got %v for %s after reload, want %v", has, domain, want)
+		}
+	}
+
+	if !db.Config().Sources[0].HasMatcher {
+		t.Error("expected HasMatcher to be true")
+	}
+}
+
+func TestMethodsAfterClose(t *testing.T) {
+	db := newTestDb(t, map[string]string{
+		"test": "example.com\n",
+	})
+	if err := db.Close(); err != nil {
+		t.Fatalf("failed to close DomainDb: %v", err)
+	}
+
+	calls := map[string]func() error{
+		"Close": db.Close,
+		"DownloadAndLoadDatabase": func() error {
+			return db.DownloadAndLoadDatabase("test")
+		},
+		"DoesDbHaveDomain": func() error {
+			_, err := db.DoesDbHaveDomain("test", "example.com")
+			return err
+		},
+		"DoesDbHaveDomainContext": func() error {
+			_, err := db.DoesDbHaveDomainContext(context.Background(), "test", "example.com")
+			return err
+		},
+		"DoesDbHaveDomainDetailed": func() error {
+			_, _, err := db.DoesDbHaveDomainDetailed("test", "example.com")
+			return err
+		},
+		"DoesDbHaveDomainTimed": func() error {
+			_, _, err := db.DoesDbHaveDomainTimed("test", "example.com")
+			return err
+		},
+		"DoesDbHaveEmail": func() error {
+			_, err := db.DoesDbHaveEmail("test", "user@example.com")
+			return err
+		},
+		"LookupDomain": func() error {
+			_, _, err := db.LookupDomain("test", "example.com")
+			return err
+		},
+		"CheckDomain": func() error {
+			_, err := db.CheckDomain("example.com")
+			return err
+		},
+		"Explain": func() error {
+			_, err := db.Explain("example.com")
+			return err
+		},
+		"DisplayForm": func() error {
+			_, err := db.DisplayForm("example.com")
+			return err
+		},
+		"SetDatabaseEnabled": func() error {
+			return db.SetDatabaseEnabled("test", false)
+		},
+		"IsDatabaseEnabled": func() error {
+			_, err := db.IsDatabaseEnabled("test")
+			return err
+		},
+		"AddTemporaryOverride": func() error {
+			return db.AddTemporaryOverride("test", "example.org", true, time.Minute)
+		},
+		"RemoveTemporaryOverride": func() error {
+			return db.RemoveTemporaryOverride("test", "example.org")
+		},
+		"LoadFromReader": func() error {
+			return db.LoadFromReader("test", strings.NewReader("example.org\n"))
+		},
+		"ReplaceDomains": func() error {
+			return db.ReplaceDomains("test", []string{"example.org"})
+		},
+		"SearchDomains": func() error {
+			_, err := db.SearchDomains("test", "example", 10)
+			return err
+		},
+		"LastLoadStats": func() error {
+			_, err := db.LastLoadStats("test")
+			return err
+		},
+		"EstimateMemory": func() error {
+			_, err := db.EstimateMemory("test")
+			return err
+		},
+		"Snapshot": func() error {
+			_, err := db.Snapshot()
+			return err
+		},
+		"Flush": db.Flush,
+		"ReadRawDatabase": func() error {
+			_, err := db.ReadRawDatabase("test")
+			return err
+		},
+		"ExportAll": func() error {
+			return db.ExportAll(io.Discard)
+		},
+		"ImportAll": func() error {
+			return db.ImportAll(strings.NewReader(""))
+		},
+		"ClearCache": func() error {
+			return db.ClearCache("test")
+		},
+		"ClearAllCache": db.ClearAllCache,
+		"ReadCachedDatabases": func() error {
+			_, err := db.ReadCachedDatabases()
+			return err
+		},
+		"SourceOf": func() error {
+			_, err := db.SourceOf("test")
+			return err
+		},
+		"PreviewUpdate": func() error {
+			_, _, err := db.PreviewUpdate("test")
+			return err
+		},
+		"WarmCache": func() error {
+			return db.WarmCache([]string{"example.com"})
+		},
+		"MatchAll": func() error {
+			_, err := db.MatchAll("example.com")
+			return err
+		},
+	}
+	for name, call := range calls {
+		if err := call(); !errors.Is(err, ErrDbClosed) {
+			t.Errorf("got error %v from %s after close, want ErrDbClosed", err, name)
+		}
+	}
+}
+
+// TestCloseDuringActivity closes DomainDb instances while they are initializing in the background, updating and serving lookups.
+// It is meant to be run with -race.
+func TestCloseDuringActivity(t *testing.T) {
+	for range 20 {
+		sources := make(map[string]*DataSource, 3)
+		for _, name := range []string{"a", "b", "c"} {
+			sources[name] = &DataSource{
+				RefreshInterval: time.Millisecond,
+				Get: func() (io.ReadCloser, error) {
+					return io.NopCloser(strings.NewReader(name + ".example.com\nexample.org\n")), nil
+				},
+			}
+		}
+
+		db, err := NewDomainDb(Options{
+			StorageDriver:             NewMemoryStorageDriver(),
+			TempDir:                   t.TempDir(),
+			Logger:                    slog.New(slog.DiscardHandler),
+			Sources:                   sources,
+			LoadDatabasesInBackground: true,
+		})
+		if err != nil {
+			t.Fatalf("failed to create DomainDb: %v", err)
+		}
+
+		var wg sync.WaitGroup
+		for _, name := range db.DatabaseNames() {
+			wg.Go(func() {
+				for {
+					_, err := db.DoesDbHaveDomain(name, "example.org")
+					if errors.Is(err, ErrDbClosed) {
+						return
+					}
+					var notInit *NotInitializedError
+					if err != nil && !errors.As(err, &notInit) {
+						t.Errorf("unexpected lookup error: %v", err)
+						return
+					}
+				}
+			})
+			wg.Go(func() {
+				for {
+					err := db.DownloadAndLoadDatabase(name)
+					if errors.Is(err, ErrDbClosed) {
+						return
+					}
+					if err != nil {
+						t.Errorf("unexpected download error: %v", err)
+						return
+					}
+				}
+			})
+		}
+
+		time.Sleep(5 * time.Millisecond)
+		if err := db.Close(); err != nil {
+			t.Fatalf("failed to close DomainDb: %v", err)
+		}
+		wg.Wait()
+	}
+}
+
+func TestClearCache(t *testing.T) {
+	storage := NewMemoryStorageDriver()
+	var downloads atomic.Int32
+	options := Options{
+		StorageDriver: storage,
+		TempDir:       t.TempDir(),
+		Logger:        slog.New(slog.DiscardHandler),
+		Sources: map[string]*DataSource{
+			"a": {
+				RefreshInterval:     time.Hour,
+				MinDownloadInterval: time.Hour,
+				Get: func() (io.ReadCloser, error) {
+					downloads.Add(1)
+					return io.NopCloser(strings.NewReader("example.com\n")), nil
+				},
+			},
+			"b": {
+				RefreshInterval: time.Hour,
+				Get: func() (io.ReadCloser, error) {
+					return io.NopCloser(strings.NewReader("example.org\n")), nil
+				},
+			},
+		},
+	}
+
+	db, err := NewDomainDb(options)
+	if err != nil {
+		t.Fatalf("failed to create DomainDb: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	if err := db.ClearCache("a"); err != nil {
+		t.Fatalf("failed to clear cache: %v", err)
+	}
+	if _, err := db.ReadRawDatabase("a"); !errors.Is(err, syscall.ENOENT) {
+		t.Fatalf("expected syscall.ENOENT for cleared cache, got %v", err)
+	}
+	if _, err := db.ReadRawDatabase("b"); err != nil {
+		t.Fatalf("expected cache of other database to be kept, got %v", err)
+	}
+	checkpoints, err := storage.ReadCheckpoints()
+	if err != nil {
+		t.Fatalf("failed to read checkpoints: %v", err)
+	}
+	if _, has := checkpoints.Checkpoints["a"]; has {
+		t.Fatal("expected checkpoint of cleared database to be removed")
+	}
+
+	// The loaded domains are kept.
+	if has, _ := db.DoesDbHaveDomain("a", "example.com"); !has {
+		t.Fatal("expected cleared database to keep its domains")
+	}
+
+	// The minimum download interval no longer applies.
+	if err := db.DownloadAndLoadDatabase("a"); err != nil {
+		t.Fatalf("failed to download database: %v", err)
+	}
+	if got := downloads.Load(); got != 2 {
+		t.Fatalf("got %d downloads, want 2", got)
+	}
+
+	if err := db.ClearAllCache(); err != nil {
+		t.Fatalf("failed to clear all caches: %v", err)
+	}
+	for _, name := range []string{"a", "b"} {
+		if _, err := db.ReadRawDatabase(name); !errors.Is(err, syscall.ENOENT) {
+			t.Fatalf("expected syscall.ENOENT for cleared cache of %s, got %v", name, err)
+		}
+	}
+
+	if err := db.ClearCache("missing"); !errors.As(err, new(*NoSuchDatabaseError)) {
+		t.Fatalf("got error %v for missing database, want NoSuchDatabaseError", err)
+	}
+}
+
+func TestSourceFingerprint(t *testing.T) {
+	a, _ := url.Parse("https://a.test/list.txt")
+	b, _ := url.Parse("https://b.test/list.txt")
+
+	base := sourceFingerprint(&DataSource{Urls: []*url.URL{a, b}})
+	if got := sourceFingerprint(&DataSource{Urls: []*url.URL{b, a}, Method: http.MethodGet, RefreshInterval: time.Hour}); got != base {
+		t.Fatalf("got different fingerprint %s for reordered URLs, explicit method and other options, want %s", got, base)
+	}
+
+	for name, src := range map[string]*DataSource{
+		"fewer urls": {Urls: []*url.URL{a}},
+		"method":     {Urls: []*url.URL{a, b}, Method: http.MethodPost},
+		"body":       {Urls: []*url.URL{a, b}, Body: []byte("q=1")},
+		"url mode":   {Urls: []*url.URL{a, b}, UrlMode: UrlModeFailover},
+		"get": {
+			Urls: []*url.URL{a, b},
+			Get: func() (io.ReadCloser, error) {
+				return nil, nil
+			},
+		},
+	} {
+		if sourceFingerprint(src) == base {
+			t.Errorf("%s: expected fingerprint to change", name)
+		}
+	}
+}
+
+func TestSourceOf(t *testing.T) {
+	srcUrl, _ := url.Parse("https://example.com/list.txt")
+	db, err := NewDomainDb(Options{
+		StorageDriver:   NewMemoryStorageDriver(),
+		TempDir:         t.TempDir(),
+		Logger:          slog.New(slog.DiscardHandler),
+		DisableDownload: true,
+		PartialInit:     true,
+		Sources: map[string]*DataSource{
+			"test": {
+				RefreshInterval: time.Hour,
+				Urls:            []*url.URL{srcUrl},
+				Categories:      []string{"ads"},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create DomainDb: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	src, err := db.SourceOf("test")
+	if err != nil {
+		t.Fatalf("failed to get source: %v", err)
+	}
+	if len(src.Urls) != 1 || src.Urls[0].String() != srcUrl.String() || src.RefreshInterval != time.Hour {
+		t.Fatalf("got source %+v, want the configured source", src)
+	}
+
+	// Modifying the copy does not affect the DomainDb.
+	src.Urls[0].Host = "modified.example.com"
+	src.Categories[0] = "modified"
+	again, _ := db.SourceOf("test")
+	if again.Urls[0].Host != "example.com" || again.Categories[0] != "ads" {
+		t.Fatalf("got source %+v after modifying a copy, want it unchanged", again)
+	}
+
+	category, err := db.SourceOf(categoryDbName("test", "ads"))
+	if err != nil {
+		t.Fatalf("failed to get source of category database: %v", err)
+	}
+	if category.Urls[0].String() != srcUrl.String() {
+		t.Fatalf("got category source %+v, want the parent's source", category)
+	}
+
+	if _, err := db.SourceOf("missing"); !errors.As(err, new(*NoSuchDatabaseError)) {
+		t.Fatalf("got error %v for missing database, want NoSuchDatabaseError", err)
+	}
+}
+
+func TestReadCachedDatabases(t *testing.T) {
+	storage := NewMemoryStorageDriver()
+	for name, contents := range map[string]string{
+		"leftover": "old.example.com\n*.wild.example.com\n",
+		"broken":   "not a domain!\nstill not a domain!\n",
+	} {
+		if err := storage.WriteDatabase(name, io.NopCloser(strings.NewReader(contents))); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+
+	db, err := NewDomainDb(Options{
+		StorageDriver: storage,
+		TempDir:       t.TempDir(),
+		Logger:        slog.New(slog.DiscardHandler),
+		Sources: map[string]*DataSource{
+			"emails": {
+				RefreshInterval: time.Hour,
+				Mode:            DatabaseModeEmail,
+				Get: func() (io.ReadCloser, error) {
+					return io.NopCloser(strings.NewReader("user@example.com\n")), nil
+				},
+			},
+			"categorized": {
+				RefreshInterval: time.Hour,
+				Categories:      []string{"ads"},
+				Get: func() (io.ReadCloser, error) {
+					return io.NopCloser(strings.NewReader("ads.example.com,ads\n")), nil
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create DomainDb: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	snapshot, err := db.ReadCachedDatabases()
+	if err == nil || !strings.Contains(err.Error(), `"broken"`) {
+		t.Fatalf("got error %v, want an error for the broken cache", err)
+	}
+
+	if want := []string{"categorized", "categorized/ads", "emails", "leftover"}; !slices.Equal(snapshot.DatabaseNames(), want) {
+		t.Fatalf("got databases %q, want %q", snapshot.DatabaseNames(), want)
+	}
+	for _, c := range []struct {
+		db     string
+		domain string
+	}{
+		{"leftover", "old.example.com"},
+		{"leftover", "sub.wild.example.com"},
+		{"categorized/ads", "ads.example.com"},
+	} {
+		if has, err := snapshot.DoesDbHaveDomain(c.db, c.domain); err != nil || !has {
+			t.Errorf("got (%t, %v) for %s in %s, want true", has, err, c.domain, c.db)
+		}
+	}
+	if _, has := snapshot.dbs["emails"].Domains["user@example.com"]; !has {
+		t.Error("expected configured email database to be parsed as emails")
+	}
+
+	// The configured databases are not affected.
+	if _, err := db.DoesDbHaveDomain("leftover", "old.example.com"); !errors.As(err, new(*NoSuchDatabaseError)) {
+		t.Fatalf("got error %v for leftover database in DomainDb, want NoSuchDatabaseError", err)
+	}
+}
+
+func TestStartupPolicy(t *testing.T) {
+	newOptions := func(policy StartupPolicy) Options {
+		return Options{
+			StorageDriver: NewMemoryStorageDriver(),
+			TempDir:       t.TempDir(),
+			Logger:        slog.New(slog.DiscardHandler),
+			StartupPolicy: policy,
+			Sources: map[string]*DataSource{
+				"good": {
+					RefreshInterval: time.Hour,
+					Get: func() (io.ReadCloser, error) {
+						return io.NopCloser(strings.NewReader("example.com\n")), nil
+					},
+				},
+				"bad": {
+					RefreshInterval: time.Hour,
+					Get: func() (io.ReadCloser, error) {
+						return nil, errors.New("source is down")
+					},
+				},
+			},
+		}
+	}
+
+	if _, err := NewDomainDb(newOptions(StartupPolicyFailOnAnyError)); err == nil {
+		t.Fatal("expected error with StartupPolicyFailOnAnyError")
+	}
+	if _, err := NewDomainDb(newOptions(StartupPolicy(99))); err == nil {
+		t.Fatal("expected error for unknown startup policy")
+	}
+	contradictory := newOptions(StartupPolicyFailOnAnyError)
+	contradictory.PartialInit = true
+	if _, err := NewDomainDb(contradictory); err == nil {
+		t.Fatal("expected error for PartialInit with StartupPolicyFailOnAnyError")
+	}
+	if _, err := NewDomainDb(newOptions(StartupPolicyDefault)); err == nil {
+		t.Fatal("expected error with the default startup policy")
+	}
+	deprecated := newOptions(StartupPolicyDefault)
+	deprecated.PartialInit = true
+	if db, err := NewDomainDb(deprecated); err != nil {
+		t.Fatalf("expected PartialInit to select StartupPolicyBestEffort, got %v", err)
+	} else {
+		if got := db.Config().StartupPolicy; got != StartupPolicyBestEffort {
+			t.Fatalf("got startup policy %v with PartialInit, want best effort", got)
+		}
+		_ = db.Close()
+	}
+
+	db, err := NewDomainDb(newOptions(StartupPolicyBestEffort))
+	if err != nil {
+		t.Fatalf("failed to create DomainDb: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	if got, want := db.NotReadyDatabases(), []string{"bad"}; !slices.Equal(got, want) {
+		t.Fatalf("got not ready databases %q, want %q", got, want)
+	}
+	if has, err := db.DoesDbHaveDomain("good", "example.com"); err != nil || !has {
+		t.Fatalf("got (%t, %v) for ready database, want true", has, err)
+	}
+	if config := db.Config(); config.StartupPolicy != StartupPolicyBestEffort || !config.PartialInit {
+		t.Fatalf("got startup policy %v and partial init %t, want best effort", config.StartupPolicy, config.PartialInit)
+	}
+
+	// Once the failed database loads, every database is ready.
+	if err := db.ReplaceDomains("bad", []string{"example.org"}); err != nil {
+		t.Fatalf("failed to replace domains: %v", err)
+	}
+	if got := db.NotReadyDatabases(); len(got) != 0 {
+		t.Fatalf("got not ready databases %q, want none", got)
+	}
+}
+
+// alwaysMatcher is a Matcher that reports every domain as a match, like a Bloom filter that is full of false positives.
+type alwaysMatcher struct{}
+
+func (alwaysMatcher) Add(string) {}
+
+func (alwaysMatcher) Has(string) bool {
+	return true
+}
+
+func TestOnProbableMatch(t *testing.T) {
+	var calls atomic.Int32
+	db, err := NewDomainDb(Options{
+		StorageDriver: NewMemoryStorageDriver(),
+		TempDir:       t.TempDir(),
+		Logger:        slog.New(slog.DiscardHandler),
+		Sources: map[string]*DataSource{
+			"approx": {
+				RefreshInterval: time.Hour,
+				Get: func() (io.ReadCloser, error) {
+					return io.NopCloser(strings.NewReader("example.com\n")), nil
+				},
+				NewMatcher: func() Matcher {
+					return alwaysMatcher{}
+				},
+				OnProbableMatch: func(domain string) (bool, error) {
+					calls.Add(1)
+					if domain == "broken.example.com" {
+						return false, errors.New("origin is down")
+					}
+					return domain == "example.com", nil
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create DomainDb: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	for domain, want := range map[string]bool{
+		"Example.com":        true,
+		"false.positive.com": false,
+	} {
+		has, err := db.DoesDbHaveDomain("approx", domain)
+		if err != nil {
+			t.Fatalf("failed to look up %s: %v", domain, err)
+		}
+		if has != want {
+			t.Errorf("got %t for %s, want %t", has, domain, want)
+		}
+	}
+	if got := calls.Load(); got != 2 {
+		t.Fatalf("got %d calls to OnProbableMatch, want 2", got)
+	}
+
+	if _, err := db.DoesDbHaveDomain("approx", "broken.example.com"); err == nil || !strings.Contains(err.Error(), "origin is down") {
+		t.Fatalf("got error %v, want the error from OnProbableMatch", err)
+	}
+
+	res, err := db.Explain("broken.example.com")
+	if err != nil {
+		t.Fatalf("failed to explain: %v", err)
+	}
+	if exp := res.Databases[0]; exp.Found || exp.MatchType != MatchNone || exp.ConfirmErr == nil {
+		t.Fatalf("got explanation %+v, want an unconfirmed match with an error", exp)
+	}
+
+	// Overrides are not confirmed.
+	calls.Store(0)
+	if err := db.AddTemporaryOverride("approx", "broken.example.com", true, time.Minute); err != nil {
+		t.Fatalf("failed to add override: %v", err)
+	}
+	if has, err := db.DoesDbHaveDomain("approx", "broken.example.com"); err != nil || !has {
+		t.Fatalf("got (%t, %v) for overridden domain, want true", has, err)
+	}
+	res, err = db.Explain("broken.example.com")
+	if err != nil {
+		t.Fatalf("failed to explain: %v", err)
+	}
+	if exp := res.Databases[0]; !exp.Found || !exp.Overridden || exp.ConfirmErr != nil {
+		t.Fatalf("got explanation %+v for overridden domain, want an overridden match without a confirm error", exp)
+	}
+	if got := calls.Load(); got != 0 {
+		t.Fatalf("got %d calls to OnProbableMatch for overridden domain, want 0", got)
+	}
+
+	_, err = NewDomainDb(Options{
+		StorageDriver: NewMemoryStorageDriver(),
+		Logger:        slog.New(slog.DiscardHandler),
+		Sources: map[string]*DataSource{
+			"exact": {
+				RefreshInterval: time.Hour,
+				OnProbableMatch: func(string) (bool, error) {
+					return true, nil
+				},
+			},
+		},
+	})
+	if err == nil {
+		t.Fatal("expected error for OnProbableMatch without NewMatcher")
+	}
+}
+
+func TestDoesDbHaveDomainContext(t *testing.T) {
+	release := make(chan struct{})
+	t.Cleanup(func() {
+		close(release)
+	})
+
+	db, err := NewDomainDb(Options{
+		StorageDriver: NewMemoryStorageDriver(),
+		TempDir:       t.TempDir(),
+		Logger:        slog.New(slog.DiscardHandler),
+		Sources: map[string]*DataSource{
+			"approx": {
+				RefreshInterval: time.Hour,
+				Get: func() (io.ReadCloser, error) {
+					return io.NopCloser(strings.NewReader("example.com\n")), nil
+				},
+				NewMatcher: func() Matcher {
+					return alwaysMatcher{}
+				},
+				OnProbableMatch: func(domain string) (bool, error) {
+					if domain == "slow.example.com" {
+						<-release
+					}
+					return domain == "example.com", nil
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create DomainDb: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	if has, err := db.DoesDbHaveDomainContext(context.Background(), "approx", "example.com"); err != nil || !has {
+		t.Fatalf("got (%t, %v), want true", has, err)
+	}
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := db.DoesDbHaveDomainContext(cancelled, "approx", "example.com"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got error %v for cancelled context, want context.Canceled", err)
+	}
+
+	// The lookup stops waiting for a blocked OnProbableMatch once the deadline is reached.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := db.DoesDbHaveDomainContext(ctx, "approx", "slow.example.com"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got error %v for blocked lookup, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestPreviewUpdate(t *testing.T) {
+	contents := "a.example.com\nb.example.com\n"
+	var mu sync.Mutex
+	getContents := func() string {
+		mu.Lock()
+		defer mu.Unlock()
+		return contents
+	}
+
+	storage := NewMemoryStorageDriver()
+	db, err := NewDomainDb(Options{
+		StorageDriver: storage,
+		TempDir:       t.TempDir(),
+		Logger:        slog.New(slog.DiscardHandler),
+		Sources: map[string]*DataSource{
+			"test": {
+				RefreshInterval: time.Hour,
+				Get: func() (io.ReadCloser, error) {
+					return io.NopCloser(strings.NewReader(getContents())), nil
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create DomainDb: %v", err)
+	}
+	defer func() {
+		_ = db.Close()
+	}()
+
+	before, err := db.LastLoadStats("test")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	mu.Lock()
+	contents = "B.example.com\nc.example.com\n*.example.org\n"
+	mu.Unlock()
+
+	added, removed, err := db.PreviewUpdate("test")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if want := []string{"*.example.org", "c.example.com"}; !slices.Equal(added, want) {
+		t.Fatalf("got added %q, want %q", added, want)
+	}
+	if want := []string{"a.example.com"}; !slices.Equal(removed, want) {
+		t.Fatalf("got removed %q, want %q", removed, want)
+	}
+
+	// Nothing may have been swapped in or cached.
+	if has, _ := db.DoesDbHaveDomain("test", "a.example.com"); !has {
+		t.Fatal("expected previous contents to still be loaded")
+	}
+	if has, _ := db.DoesDbHaveDomain("test", "c.example.com"); has {
+		t.Fatal("expected previewed contents not to be loaded")
+	}
+	after, err := db.LastLoadStats("test")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if after != before {
+		t.Fatalf("expected load stats to be unchanged, got %+v, want %+v", after, before)
+	}
+	reader, err := storage.ReadDatabase("test")
+	if err != nil {
+		t.Fatalf("failed to read cached database: %v", err)
+	}
+	cached, err := io.ReadAll(reader)
+	_ = reader.Close()
+	if err != nil {
+		t.Fatalf("failed to read cached database: %v", err)
+	}
+	if strings.Contains(string(cached), "c.example.com") {
+		t.Fatalf("expected cached copy to be unchanged, got %q", cached)
+	}
+
+	if _, _, err = db.PreviewUpdate("missing"); !errors.As(err, new(*NoSuchDatabaseError)) {
+		t.Fatalf("expected NoSuchDatabaseError, got %v", err)
+	}
+}
+
+func TestLookupCache(t *testing.T) {
+	var transforms atomic.Int64
+	db, err := NewDomainDb(Options{
+		StorageDriver:   NewMemoryStorageDriver(),
+		TempDir:         t.TempDir(),
+		Logger:          slog.New(slog.DiscardHandler),
+		LookupCacheSize: 2,
+		DomainTransform: func(domain string) (string, bool) {
+			transforms.Add(1)
+			return domain, domain != "dropped.example.com"
+		},
+		Sources: map[string]*DataSource{
+			"test": {
+				RefreshInterval: time.Hour,
+				Get: func() (io.ReadCloser, error) {
+					return io.NopCloser(strings.NewReader("a.example.com\n*.example.org\n")), nil
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create DomainDb: %v", err)
+	}
+	defer func() {
+		_ = db.Close()
+	}()
+
+	if err = db.WarmCache([]string{"A.example.com", "bad_domain!", "dropped.example.com", "b.example.org"}); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	// Warmed domains, including dropped ones, must not be normalized again.
+	before := transforms.Load()
+	for domain, want := range map[string]bool{
+		"A.example.com":       true,
+		"dropped.example.com": false,
+	} {
+		has, err := db.DoesDbHaveDomain("test", domain)
+		if err != nil {
+			t.Fatalf("%q: unexpected err: %v", domain, err)
+		}
+		if has != want {
+			t.Fatalf("%q: got %v, want %v", domain, has, want)
+		}
+	}
+	if got := transforms.Load() - before; got != 0 {
+		t.Fatalf("expected warmed domains to be cached, but %d were normalized again", got)
+	}
+
+	// The cache is full, so other domains are normalized on every lookup, and failures are never cached.
+	for _, domain := range []string{"b.example.org", "b.example.org"} {
+		before = transforms.Load()
+		has, err := db.DoesDbHaveDomain("test", domain)
+		if err != nil {
+			t.Fatalf("%q: unexpected err: %v", domain, err)
+		}
+		if !has {
+			t.Fatalf("%q: expected match", domain)
+		}
+		if got := transforms.Load() - before; got != 1 {
+			t.Fatalf("%q: expected domain to be normalized, got %d normalizations", domain, got)
+		}
+	}
+	if _, err = db.DoesDbHaveDomain("test", "bad_domain!"); err == nil {
+		t.Fatal("expected normalization error for invalid domain")
+	}
+
+	// Snapshots share the cache.
+	snapshot, err := db.Snapshot()
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	before = transforms.Load()
+	if has, err := snapshot.DoesDbHaveDomain("test", "A.example.com"); err != nil || !has {
+		t.Fatalf("got %v, %v, want true, nil", has, err)
+	}
+	if got := transforms.Load() - before; got != 0 {
+		t.Fatalf("expected snapshot to use the cache, but the domain was normalized %d times", got)
+	}
+}
+
+func TestSnapshot_IsolatedFromUpdates(t *testing.T) {
+	var mu sync.Mutex
+	contents := "old.example.com\n"
+	db, err := NewDomainDb(Options{
+		StorageDriver: NewMemoryStorageDriver(),
+		TempDir:       t.TempDir(),
+		Logger:        slog.New(slog.DiscardHandler),
+		Sources: map[string]*DataSource{
+			"test": {
+				RefreshInterval: time.Hour,
+				Get: func() (io.ReadCloser, error) {
+					mu.Lock()
+					defer mu.Unlock()
+					return io.NopCloser(strings.NewReader(contents)), nil
+				},
+			},
+			"other": {
+				RefreshInterval: time.Hour,
+				Get: func() (io.ReadCloser, error) {
+					return io.NopCloser(strings.NewReader("other.example.com\n")), nil
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create DomainDb: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	snapshot, err := db.Snapshot()
+	if err != nil {
+		t.Fatalf("failed to take snapshot: %v", err)
+	}
+
+	// assertSnapshot checks that the snapshot still has the state from when it was taken.
+	assertSnapshot := func() {
+		t.Helper()
+
+		for dbName, domains := range map[string]map[string]bool{
+			"test":  {"old.example.com": true, "new.example.com": false, "replaced.example.com": false},
+			"other": {"other.example.com": true, "override.example.com": false},
+		} {
+			for domain, want := range domains {
+				has, err := snapshot.DoesDbHaveDomain(dbName, domain)
+				if err != nil {
+					t.Fatalf("%s %q: unexpected err: %v", dbName, domain, err)
+				}
+				if has != want {
+					t.Fatalf("%s %q: snapshot got %t, want %t", dbName, domain, has, want)
+				}
+			}
+		}
+	}
+
+	// assertLive checks a lookup in the live DomainDb.
+	assertLive := func(dbName string, domain string, want bool) {
+		t.Helper()
+
+		has, err := db.DoesDbHaveDomain(dbName, domain)
+		if err != nil {
+			t.Fatalf("%s %q: unexpected err: %v", dbName, domain, err)
+		}
+		if has != want {
+			t.Fatalf("%s %q: got %t, want %t", dbName, domain, has, want)
+		}
+	}
+
+	// Download a new version of the list.
+	mu.Lock()
+	contents = "new.example.com\n"
+	mu.Unlock()
+	if err = db.DownloadAndLoadDatabase("test"); err != nil {
+		t.Fatalf("failed to update database: %v", err)
+	}
+	assertLive("test", "old.example.com", false)
+	assertLive("test", "new.example.com", true)
+	assertSnapshot()
+
+	// Replace the list outright.
+	if err = db.ReplaceDomains("test", []string{"replaced.example.com"}); err != nil {
+		t.Fatalf("failed to replace domains: %v", err)
+	}
+	assertLive("test", "new.example.com", false)
+	assertLive("test", "replaced.example.com", true)
+	assertSnapshot()
+
+	// Overrides added and databases disabled after the snapshot was taken do not apply to it.
+	if err = db.AddTemporaryOverride("other", "override.example.com", true, time.Hour); err != nil {
+		t.Fatalf("failed to add override: %v", err)
+	}
+	assertLive("other", "override.example.com", true)
+	if err = db.SetDatabaseEnabled("other", false); err != nil {
+		t.Fatalf("failed to disable database: %v", err)
+	}
+	assertLive("other", "other.example.com", false)
+	assertSnapshot()
+}
+
+func TestSearchDomains(t *testing.T) {
+	db, err := NewDomainDb(Options{
+		StorageDriver: NewMemoryStorageDriver(),
+		TempDir:       t.TempDir(),
+		Logger:        slog.New(slog.DiscardHandler),
+		StartupPolicy: StartupPolicyBestEffort,
+		Sources: map[string]*DataSource{
+			"test": {
+				RefreshInterval: time.Hour,
+				Get: func() (io.ReadCloser, error) {
+					return io.NopCloser(strings.NewReader("mail.example.com\nexample.com\nexample.org\nbücher.de\nads.example.com\n")), nil
+				},
+			},
+			"broken": {
+				RefreshInterval: time.Hour,
+				Get: func() (io.ReadCloser, error) {
+					return nil, errors.New("source is down")
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create DomainDb: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	for _, tc := range []struct {
+		query string
+		limit int
+		want  []string
+	}{
+		{"example", 0, []string{"ads.example.com", "example.com", "example.org", "mail.example.com"}},
+		{".example.com", 0, []string{"ads.example.com", "mail.example.com"}},
+		{" EXAMPLE.COM ", 0, []string{"ads.example.com", "example.com", "mail.example.com"}},
+		{"example", 2, []string{"ads.example.com", "example.com"}},
+		{"example", -1, []string{"ads.example.com", "example.com", "example.org", "mail.example.com"}},
+		{"example", 10, []string{"ads.example.com", "example.com", "example.org", "mail.example.com"}},
+		{"xn--bcher", 0, []string{"xn--bcher-kva.de"}},
+		{"", 0, []string{"ads.example.com", "example.com", "example.org", "mail.example.com", "xn--bcher-kva.de"}},
+		{"nothing", 0, []string{}},
+	} {
+		got, err := db.SearchDomains("test", tc.query, tc.limit)
+		if err != nil {
+			t.Fatalf("%q (limit %d): unexpected err: %v", tc.query, tc.limit, err)
+		}
+		if !slices.Equal(got, tc.want) || got == nil {
+			t.Fatalf("%q (limit %d): got %#v, want %#v", tc.query, tc.limit, got, tc.want)
+		}
+	}
+
+	var notInitialized *NotInitializedError
+	if _, err = db.SearchDomains("broken", "example", 0); !errors.As(err, &notInitialized) {
+		t.Fatalf("got error %v for uninitialized database, want NotInitializedError", err)
+	}
+	var noSuchDb *NoSuchDatabaseError
+	if _, err = db.SearchDomains("missing", "example", 0); !errors.As(err, &noSuchDb) {
+		t.Fatalf("got error %v for missing database, want NoSuchDatabaseError", err)
+	}
+}
+
+func TestDomainCounts(t *testing.T) {
+	var mu sync.Mutex
+	lists := map[string]string{
+		"a": "one.com\ntwo.com\nthree.com\n",
+		"b": "two.com\nthree.com\nfour.com\n",
+		"c": "three.com\n",
+	}
+	sources := make(map[string]*DataSource, len(lists)+1)
+	for name := range lists {
+		sources[name] = &DataSource{
+			RefreshInterval: time.Hour,
+			Get: func() (io.ReadCloser, error) {
+				mu.Lock()
+				defer mu.Unlock()
+				return io.NopCloser(strings.NewReader(lists[name])), nil
+			},
+		}
+	}
+
+	// Databases that have not been initialized contribute zero.
+	sources["broken"] = &DataSource{
+		RefreshInterval: time.Hour,
+		Get: func() (io.ReadCloser, error) {
+			return nil, errors.New("source is down")
+		},
+	}
+
+	db, err := NewDomainDb(Options{
+		StorageDriver: NewMemoryStorageDriver(),
+		TempDir:       t.TempDir(),
+		Logger:        slog.New(slog.DiscardHandler),
+		StartupPolicy: StartupPolicyBestEffort,
+		Sources:       sources,
+	})
+	if err != nil {
+		t.Fatalf("failed to create DomainDb: %v", err)
+	}
+	defer func() {
+		_ = db.Close()
+	}()
+
+	if got := db.TotalDomainCount(); got != 7 {
+		t.Fatalf("got total count %d, want 7", got)
+	}
+	if got := db.UniqueDomainCount(); got != 4 {
+		t.Fatalf("got unique count %d, want 4", got)
+	}
+
+	// Counts reflect the new contents after an update, which also makes "c" the largest database.
+	mu.Lock()
+	lists["c"] = "one.com\nfive.com\nsix.com\nseven.com\n"
+	mu.Unlock()
+	if err = db.DownloadAndLoadDatabase("c"); err != nil {
+		t.Fatalf("failed to update database: %v", err)
+	}
+
+	if got := db.TotalDomainCount(); got != 10 {
+		t.Fatalf("got total count %d after update, want 10", got)
+	}
+	if got := db.UniqueDomainCount(); got != 7 {
+		t.Fatalf("got unique count %d after update, want 7", got)
+	}
+}
+
+func TestMatchAll(t *testing.T) {
+	lists := map[string]string{
+		"exact":    "a.example.com\n",
+		"wildcard": "*.example.com\n",
+		"negated":  "example.org\n",
+		"disabled": "a.example.com\n",
+		"override": "example.net\n",
+		"none":     "example.net\n",
+	}
+	sources := make(map[string]*DataSource, len(lists))
+	for name, contents := range lists {
+		sources[name] = &DataSource{
+			RefreshInterval: time.Hour,
+			Negate:          name == "negated",
+			Get: func() (io.ReadCloser, error) {
+				return io.NopCloser(strings.NewReader(contents)), nil
+			},
+		}
+	}
+
+	// A database that failed to load is skipped rather than failing the whole call.
+	sources["broken"] = &DataSource{
+		RefreshInterval: time.Hour,
+		Negate:          true,
+		Get: func() (io.ReadCloser, error) {
+			return nil, errors.New("source is down")
+		},
+	}
+
+	db, err := NewDomainDb(Options{
+		StorageDriver: NewMemoryStorageDriver(),
+		TempDir:       t.TempDir(),
+		Logger:        slog.New(slog.DiscardHandler),
+		StartupPolicy: StartupPolicyBestEffort,
+		Sources:       sources,
+	})
+	if err != nil {
+		t.Fatalf("failed to create DomainDb: %v", err)
+	}
+	defer func() {
+		_ = db.Close()
+	}()
+
+	var notInitialized *NotInitializedError
+	if _, err = db.DoesDbHaveDomain("broken", "a.example.com"); !errors.As(err, &notInitialized) {
+		t.Fatalf("got error %v for broken database, want NotInitializedError", err)
+	}
+
+	if err = db.SetDatabaseEnabled("disabled", false); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if err = db.AddTemporaryOverride("override", "a.example.com", true, time.Hour); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	want := []Match{
+		{Database: "exact", Type: MatchExact, Matched: "a.example.com"},
+		{Database: "negated", Type: MatchNone},
+		{Database: "override", Type: MatchExact, Matched: "a.example.com", Overridden: true},
+		{Database: "wildcard", Type: MatchWildcard, Matched: "*.example.com"},
+	}
+
+	got, err := db.MatchAll("A.Example.com")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+
+	snapshot, err := db.Snapshot()
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	got, err = snapshot.MatchAll("A.Example.com")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("snapshot: got %+v, want %+v", got, want)
+	}
+
+	// The negated database matches every domain that is not in its list.
+	got, err = db.MatchAll("example.edu")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if want := []Match{{Database: "negated", Type: MatchNone}}; !slices.Equal(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+	got, err = db.MatchAll("example.org")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if got == nil || len(got) != 0 {
+		t.Fatalf("expected empty matches, got %#v", got)
+	}
+
+	if _, err = db.MatchAll("bad_domain!"); err == nil {
+		t.Fatal("expected normalization error for invalid domain")
+	}
+}
+
+func TestCheckDomain(t *testing.T) {
+	db, err := NewDomainDb(Options{
+		StorageDriver: NewMemoryStorageDriver(),
+		TempDir:       t.TempDir(),
+		Logger:        slog.New(slog.DiscardHandler),
+		StartupPolicy: StartupPolicyBestEffort,
+		Sources: map[string]*DataSource{
+			"ads": {
+				RefreshInterval: time.Hour,
+				Get: func() (io.ReadCloser, error) {
+					return io.NopCloser(strings.NewReader("ads.example.com\n*.tracker.com\n")), nil
+				},
+			},
+			"malware": {
+				RefreshInterval: time.Hour,
+				Get: func() (io.ReadCloser, error) {
+					return io.NopCloser(strings.NewReader("bad.example.com\nx.tracker.com\n")), nil
+				},
+			},
+			"broken": {
+				RefreshInterval: time.Hour,
+				Get: func() (io.ReadCloser, error) {
+					return nil, errors.New("source is down")
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create DomainDb: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	for _, tc := range []struct {
+		domain string
+		want   map[string]bool
+	}{
+		{"ADS.example.com", map[string]bool{"ads": true, "malware": false}},
+		{"bad.example.com", map[string]bool{"ads": false, "malware": true}},
+		{"x.tracker.com", map[string]bool{"ads": true, "malware": true}},
+		{"example.com", map[string]bool{"ads": false, "malware": false}},
+	} {
+		got, err := db.CheckDomain(tc.domain, "ads", "malware")
+		if err != nil {
+			t.Fatalf("%q: unexpected err: %v", tc.domain, err)
+		}
+		if !maps.Equal(got, tc.want) {
+			t.Fatalf("%q: got %v, want %v", tc.domain, got, tc.want)
+		}
+	}
+
+	// Only the requested databases are checked.
+	got, err := db.CheckDomain("bad.example.com", "malware")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if want := map[string]bool{"malware": true}; !maps.Equal(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	// With no database names, all databases are checked, so an uninitialized one fails the call.
+	var notInitialized *NotInitializedError
+	if _, err = db.CheckDomain("bad.example.com"); !errors.As(err, &notInitialized) {
+		t.Fatalf("got error %v with all databases, want NotInitializedError", err)
+	}
+
+	// Missing databases are reported before anything is looked up.
+	var noSuchDb *NoSuchDatabaseError
+	if _, err = db.CheckDomain("bad.example.com", "ads", "missing"); !errors.As(err, &noSuchDb) || noSuchDb.Name != "missing" {
+		t.Fatalf("got error %v, want NoSuchDatabaseError for missing", err)
+	}
+
+	if _, err = db.CheckDomain("bad_domain!", "ads"); err == nil {
+		t.Fatal("expected normalization error for invalid domain")
+	}
+}
+
+func TestLookupDomain(t *testing.T) {
+	db, err := NewDomainDb(Options{
+		StorageDriver: NewMemoryStorageDriver(),
+		TempDir:       t.TempDir(),
+		Logger:        slog.New(slog.DiscardHandler),
+		Sources: map[string]*DataSource{
+			"block": {
+				RefreshInterval: time.Hour,
+				Get: func() (io.ReadCloser, error) {
+					return io.NopCloser(strings.NewReader("example.com\n*.gov\nbücher.de\n")), nil
+				},
+			},
+			"allow": {
+				RefreshInterval: time.Hour,
+				Negate:          true,
+				Get: func() (io.ReadCloser, error) {
+					return io.NopCloser(strings.NewReader("example.com\n")), nil
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create DomainDb: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	for _, tc := range []struct {
+		db      string
+		domain  string
+		matched string
+		found   bool
+	}{
+		{"block", "Example.com", "example.com", true},
+		{"block", "a.b.gov", "*.gov", true},
+		{"block", "bücher.de", "xn--bcher-kva.de", true},
+		{"block", "sub.example.com", "", false},
+		{"block", "gov", "", false},
+
+		// A negated database has no entry for the domains it matches, and the entries it has do not match.
+		{"allow", "example.org", "", true},
+		{"allow", "example.com", "", false},
+	} {
+		matched, found, err := db.LookupDomain(tc.db, tc.domain)
+		if err != nil {
+			t.Fatalf("%s %q: unexpected err: %v", tc.db, tc.domain, err)
+		}
+		if matched != tc.matched || found != tc.found {
+			t.Fatalf("%s %q: got (%q, %t), want (%q, %t)", tc.db, tc.domain, matched, found, tc.matched, tc.found)
+		}
+	}
+
+	// A domain forced by an override reports itself, since there is no list entry.
+	if err = db.AddTemporaryOverride("block", "other.com", true, time.Hour); err != nil {
+		t.Fatalf("failed to add override: %v", err)
+	}
+	if matched, found, err := db.LookupDomain("block", "OTHER.com"); err != nil || matched != "other.com" || !found {
+		t.Fatalf("got (%q, %t, %v) for overridden domain, want (\"other.com\", true)", matched, found, err)
+	}
+
+	var noSuchDb *NoSuchDatabaseError
+	if _, _, err = db.LookupDomain("missing", "example.com"); !errors.As(err, &noSuchDb) {
+		t.Fatalf("got error %v for missing database, want NoSuchDatabaseError", err)
+	}
+}
+
+func TestSetDatabaseEnabled(t *testing.T) {
+	var downloads atomic.Int32
+	db, err := NewDomainDb(Options{
+		StorageDriver: NewMemoryStorageDriver(),
+		TempDir:       t.TempDir(),
+		Logger:        slog.New(slog.DiscardHandler),
+		Sources: map[string]*DataSource{
+			"test": {
+				RefreshInterval: 5 * time.Millisecond,
+				Get: func() (io.ReadCloser, error) {
+					downloads.Add(1)
+					return io.NopCloser(strings.NewReader("example.com\n")), nil
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create DomainDb: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	assertLookup := func(want bool) {
+		t.Helper()
+
+		has, err := db.DoesDbHaveDomain("test", "example.com")
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		if has != want {
+			t.Fatalf("got %t, want %t", has, want)
+		}
+	}
+
+	if enabled, err := db.IsDatabaseEnabled("test"); err != nil || !enabled {
+		t.Fatalf("got (%t, %v), want databases to be enabled by default", enabled, err)
+	}
+	assertLookup(true)
+
+	if err = db.SetDatabaseEnabled("test", false); err != nil {
+		t.Fatalf("failed to disable database: %v", err)
+	}
+	if enabled, err := db.IsDatabaseEnabled("test"); err != nil || enabled {
+		t.Fatalf("got (%t, %v), want the database to be disabled", enabled, err)
+	}
+	assertLookup(false)
+
+	// Scheduled updates are skipped while the database is disabled.
+	// Wait for an update that was already in progress to finish first.
+	time.Sleep(20 * time.Millisecond)
+	before := downloads.Load()
+	time.Sleep(50 * time.Millisecond)
+	if got := downloads.Load(); got != before {
+		t.Fatalf("got %d downloads while disabled, want %d", got, before)
+	}
+
+	// The loaded domains were kept, and updates resume once the database is enabled again.
+	if err = db.SetDatabaseEnabled("test", true); err != nil {
+		t.Fatalf("failed to enable database: %v", err)
+	}
+	assertLookup(true)
+	deadline := time.Now().Add(5 * time.Second)
+	for downloads.Load() == before {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for updates to resume")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	var noSuchDb *NoSuchDatabaseError
+	if err = db.SetDatabaseEnabled("missing", false); !errors.As(err, &noSuchDb) {
+		t.Fatalf("got error %v for missing database, want NoSuchDatabaseError", err)
+	}
+}
+
+func TestExplain(t *testing.T) {
+	list := func(contents string) func() (io.ReadCloser, error) {
+		return func() (io.ReadCloser, error) {
+			return io.NopCloser(strings.NewReader(contents)), nil
+		}
+	}
+
+	db, err := NewDomainDb(Options{
+		StorageDriver: NewMemoryStorageDriver(),
+		TempDir:       t.TempDir(),
+		Logger:        slog.New(slog.DiscardHandler),
+		StartupPolicy: StartupPolicyBestEffort,
+		Sources: map[string]*DataSource{
+			"exact":    {RefreshInterval: time.Hour, Get: list("a.example.com\n")},
+			"wildcard": {RefreshInterval: time.Hour, Get: list("*.example.com\n")},
+			"none":     {RefreshInterval: time.Hour, Get: list("example.org\n")},
+			"custom": {
+				RefreshInterval: time.Hour,
+				Get:             list("example.com\n"),
+				NewMatcher: func() Matcher {
+					return &suffixMatcher{}
+				},
+			},
+			"negated":         {RefreshInterval: time.Hour, Negate: true, Get: list("*.example.com\n")},
+			"negated-miss":    {RefreshInterval: time.Hour, Negate: true, Get: list("example.org\n")},
+			"disabled":        {RefreshInterval: time.Hour, Get: list("a.example.com\n")},
+			"overridden":      {RefreshInterval: time.Hour, Get: list("a.example.com\n")},
+			"not-initialized": {RefreshInterval: time.Hour, Get: func() (io.ReadCloser, error) { return nil, errors.New("source is down") }},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create DomainDb: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	if err = db.SetDatabaseEnabled("disabled", false); err != nil {
+		t.Fatalf("failed to disable database: %v", err)
+	}
+	if err = db.AddTemporaryOverride("overridden", "a.example.com", false, time.Hour); err != nil {
+		t.Fatalf("failed to add override: %v", err)
+	}
+
+	res, err := db.Explain("A.Example.com")
+	if err != nil {
+		t.Fatalf("failed to explain: %v", err)
+	}
+	if res.Domain != "A.Example.com" || res.Normalized != "a.example.com" {
+		t.Fatalf("got domain %q normalized to %q, want %q normalized to %q", res.Domain, res.Normalized, "A.Example.com", "a.example.com")
+	}
+
+	want := []DatabaseExplanation{
+		{Name: "custom", Initialized: true, Enabled: true, Found: true, MatchType: MatchCustom, Matched: "a.example.com"},
+		{Name: "disabled", Initialized: true},
+		{Name: "exact", Initialized: true, Enabled: true, Found: true, MatchType: MatchExact, Matched: "a.example.com"},
+
+		// MatchType and Matched are not inverted, so they show why a negated database did not match.
+		{Name: "negated", Initialized: true, Enabled: true, Negated: true, MatchType: MatchWildcard, Matched: "*.example.com"},
+		{Name: "negated-miss", Initialized: true, Enabled: true, Negated: true, Found: true, MatchType: MatchNone},
+
+		{Name: "none", Initialized: true, Enabled: true, MatchType: MatchNone},
+		{Name: "not-initialized", Enabled: true},
+
+		// The override decides Found, but MatchType and Matched still describe the list.
+		{Name: "overridden", Initialized: true, Enabled: true, Overridden: true, MatchType: MatchExact, Matched: "a.example.com"},
+
+		{Name: "wildcard", Initialized: true, Enabled: true, Found: true, MatchType: MatchWildcard, Matched: "*.example.com"},
+	}
+	if !slices.Equal(res.Databases, want) {
+		t.Fatalf("got %+v, want %+v", res.Databases, want)
+	}
+}
+
+func TestRefreshOnStartup(t *testing.T) {
+	lastUpdated := time.Now().Add(-time.Minute)
+
+	// open creates a DomainDb with a fresh cached copy of the "test" database, and returns when its first update is scheduled.
+	open := func(refreshOnStartup bool) (*DomainDb, time.Time) {
+		t.Helper()
+
+		storage := NewMemoryStorageDriver()
+		if err := storage.WriteDatabase("test", io.NopCloser(strings.NewReader("cached.example.com\n"))); err != nil {
+			t.Fatalf("failed to write cached database: %v", err)
+		}
+		if err := storage.WriteCheckpoints(&AllCheckpoints{Checkpoints: map[string]Checkpoint{
+			"test": {LastUpdatedUnix: lastUpdated.Unix()},
+		}}); err != nil {
+			t.Fatalf("failed to write checkpoints: %v", err)
+		}
+
+		db, err := NewDomainDb(Options{
+			StorageDriver:    storage,
+			TempDir:          t.TempDir(),
+			Logger:           slog.New(slog.DiscardHandler),
+			RefreshOnStartup: refreshOnStartup,
+			Sources: map[string]*DataSource{
+				"test": {
+					RefreshInterval: time.Hour,
+					Get: func() (io.ReadCloser, error) {
+						return io.NopCloser(strings.NewReader("downloaded.example.com\n")), nil
+					},
+				},
+			},
+		})
+		if err != nil {
+			t.Fatalf("failed to create DomainDb: %v", err)
+		}
+		t.Cleanup(func() {
+			_ = db.Close()
+		})
+
+		// Initialization only waits for the cache, and the refresh happens in the background.
+		if stats, err := db.LastLoadStats("test"); err != nil || stats.Source != LoadSourceCache {
+			t.Fatalf("got load stats %+v (%v), want the database to be loaded from cache", stats, err)
+		}
+
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			if next := db.dbs["test"].NextUpdateNano.Load(); next != 0 {
+				return db, time.Unix(0, next)
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("timed out waiting for the first update to be scheduled")
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	// By default, a fresh cache keeps its schedule of last update plus RefreshInterval.
+	_, next := open(false)
+	if want := lastUpdated.Add(time.Hour); next.Sub(want).Abs() > time.Second {
+		t.Fatalf("got first update at %v without RefreshOnStartup, want %v", next, want)
+	}
+
+	// With RefreshOnStartup, even a fresh cache is downloaded again within the jitter of startup.
+	startTs := time.Now()
+	_, next = open(true)
+	if next.Before(startTs) || next.After(startTs.Add(refreshOnStartupMaxJitter+time.Second)) {
+		t.Fatalf("got first update at %v with RefreshOnStartup, want within %v of startup at %v", next, refreshOnStartupMaxJitter, startTs)
+	}
+}
+
+func TestLastLoadStats_Source(t *testing.T) {
+	storage := NewMemoryStorageDriver()
+
+	open := func() *DomainDb {
+		t.Helper()
+
+		db, err := NewDomainDb(Options{
+			StorageDriver: storage,
+			TempDir:       t.TempDir(),
+			Logger:        slog.New(slog.DiscardHandler),
+			Sources: map[string]*DataSource{
+				"test": {
+					RefreshInterval: time.Hour,
+					Get: func() (io.ReadCloser, error) {
+						return io.NopCloser(strings.NewReader("a.example.com\nb.example.com\n")), nil
+					},
+				},
+			},
+		})
+		if err != nil {
+			t.Fatalf("failed to create DomainDb: %v", err)
+		}
+		t.Cleanup(func() {
+			_ = db.Close()
+		})
+		return db
+	}
+
+	assertSource := func(db *DomainDb, want LoadSource) {
+		t.Helper()
+
+		stats, err := db.LastLoadStats("test")
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		if stats.Source != want {
+			t.Fatalf("got source %v, want %v", stats.Source, want)
+		}
+	}
+
+	// With nothing cached, the database is downloaded on startup.
+	db := open()
+	assertSource(db, LoadSourceDownload)
+	if err := db.Close(); err != nil {
+		t.Fatalf("failed to close DomainDb: %v", err)
+	}
+
+	// On restart, the fresh copy cached by the first instance is loaded instead.
+	db = open()
+	assertSource(db, LoadSourceCache)
+
+	// Contents provided by the caller are manual, whichever method provided them.
+	if err := db.ReplaceDomains("test", []string{"c.example.com"}); err != nil {
+		t.Fatalf("failed to replace domains: %v", err)
+	}
+	assertSource(db, LoadSourceManual)
+
+	db = open()
+	if err := db.LoadFromReader("test", strings.NewReader("d.example.com\n")); err != nil {
+		t.Fatalf("failed to load from reader: %v", err)
+	}
+	assertSource(db, LoadSourceManual)
+
+	for src, want := range map[LoadSource]string{
+		LoadSourceNone:     "none",
+		LoadSourceCache:    "cache",
+		LoadSourceDownload: "download",
+		LoadSourceManual:   "manual",
+		LoadSource(-1):     "unknown",
+	} {
+		if got := src.String(); got != want {
+			t.Errorf("got %q for LoadSource(%d), want %q", got, src, want)
+		}
+	}
+}
+
+func TestDomainTransform_FlushAndReload(t *testing.T) {
+	cases := map[string]struct {
+		transform  func(domain string) (string, bool)
+		downloaded string
+		reloaded   string
+	}{
+		// The documented requirement: transforming the flushed domains again changes nothing.
+		"idempotent": {
+			transform: func(domain string) (string, bool) {
+				for strings.HasPrefix(domain, "www.") {
+					domain = strings.TrimPrefix(domain, "www.")
+				}
+				return domain, true
+			},
+			downloaded: "example.com",
+			reloaded:   "example.com",
+		},
+
+		// Strips another label from the flushed domains on every reload, which is why it is not allowed.
+		"not idempotent": {
+			transform: func(domain string) (string, bool) {
+				return strings.TrimPrefix(domain, "www."), true
+			},
+			downloaded: "www.example.com",
+			reloaded:   "example.com",
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			storage := NewMemoryStorageDriver()
+
+			open := func() *DomainDb {
+				t.Helper()
+
+				db, err := NewDomainDb(Options{
+					StorageDriver:   storage,
+					TempDir:         t.TempDir(),
+					Logger:          slog.New(slog.DiscardHandler),
+					DomainTransform: c.transform,
+					Sources: map[string]*DataSource{
+						"test": {
+							RefreshInterval: time.Hour,
+							Get: func() (io.ReadCloser, error) {
+								return io.NopCloser(strings.NewReader("www.www.example.com\n")), nil
+							},
+						},
+					},
+				})
+				if err != nil {
+					t.Fatalf("failed to create DomainDb: %v", err)
+				}
+				t.Cleanup(func() {
+					_ = db.Close()
+				})
+				return db
+			}
+
+			domain := func(db *DomainDb) string {
+				t.Helper()
+
+				set := db.dbs["test"].view().Domains
+				if len(set) != 1 {
+					t.Fatalf("got %d domains, want 1", len(set))
+				}
+				for domain := range set {
+					return domain
+				}
+				return ""
+			}
+
+			db := open()
+			if got := domain(db); got != c.downloaded {
+				t.Fatalf("got %q after download, want %q", got, c.downloaded)
+			}
+			if err := db.Flush(); err != nil {
+				t.Fatalf("failed to flush: %v", err)
+			}
+			if err := db.Close(); err != nil {
+				t.Fatalf("failed to close DomainDb: %v", err)
+			}
+
+			db = open()
+			if stats, err := db.LastLoadStats("test"); err != nil || stats.Source != LoadSourceCache {
+				t.Fatalf("got load stats %+v (%v), want the database to be loaded from cache", stats, err)
+			}
+			if got := domain(db); got != c.reloaded {
+				t.Fatalf("got %q after reloading the flushed copy, want %q", got, c.reloaded)
+			}
+		})
+	}
+}
@@ -0,0 +1,557 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.10
+// 	protoc        (unknown)
+// source: domaindbpb/domaindb.proto
+
+package domaindbpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type CheckRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// The database name.
+	Db string `protobuf:"bytes,1,opt,name=db,proto3" json:"db,omitempty"`
+	// The domain to check.
+	Domain        string `protobuf:"bytes,2,opt,name=domain,proto3" json:"domain,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CheckRequest) Reset() {
+	*x = CheckRequest{}
+	mi := &file_domaindbpb_domaindb_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CheckRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CheckRequest) ProtoMessage() {}
+
+func (x *CheckRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_domaindbpb_domaindb_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CheckRequest.ProtoReflect.Descriptor instead.
+func (*CheckRequest) Descriptor() ([]byte, []int) {
+	return file_domaindbpb_domaindb_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *CheckRequest) GetDb() string {
+	if x != nil {
+		return x.Db
+	}
+	return ""
+}
+
+func (x *CheckRequest) GetDomain() string {
+	if x != nil {
+		return x.Domain
+	}
+	return ""
+}
+
+type CheckResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Whether the domain matched the database.
+	Matched       bool `protobuf:"varint,1,opt,name=matched,proto3" json:"matched,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CheckResponse) Reset() {
+	*x = CheckResponse{}
+	mi := &file_domaindbpb_domaindb_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CheckResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CheckResponse) ProtoMessage() {}
+
+func (x *CheckResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_domaindbpb_domaindb_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CheckResponse.ProtoReflect.Descriptor instead.
+func (*CheckResponse) Descriptor() ([]byte, []int) {
+	return file_domaindbpb_domaindb_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *CheckResponse) GetMatched() bool {
+	if x != nil {
+		return x.Matched
+	}
+	return false
+}
+
+type CheckBatchRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// The database name.
+	Db string `protobuf:"bytes,1,opt,name=db,proto3" json:"db,omitempty"`
+	// The domains to check.
+	Domains       []string `protobuf:"bytes,2,rep,name=domains,proto3" json:"domains,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CheckBatchRequest) Reset() {
+	*x = CheckBatchRequest{}
+	mi := &file_domaindbpb_domaindb_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CheckBatchRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CheckBatchRequest) ProtoMessage() {}
+
+func (x *CheckBatchRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_domaindbpb_domaindb_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CheckBatchRequest.ProtoReflect.Descriptor instead.
+func (*CheckBatchRequest) Descriptor() ([]byte, []int) {
+	return file_domaindbpb_domaindb_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *CheckBatchRequest) GetDb() string {
+	if x != nil {
+		return x.Db
+	}
+	return ""
+}
+
+func (x *CheckBatchRequest) GetDomains() []string {
+	if x != nil {
+		return x.Domains
+	}
+	return nil
+}
+
+type CheckBatchResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// The result for each domain, in the same order as the request.
+	Results       []*CheckResult `protobuf:"bytes,1,rep,name=results,proto3" json:"results,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CheckBatchResponse) Reset() {
+	*x = CheckBatchResponse{}
+	mi := &file_domaindbpb_domaindb_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CheckBatchResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CheckBatchResponse) ProtoMessage() {}
+
+func (x *CheckBatchResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_domaindbpb_domaindb_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CheckBatchResponse.ProtoReflect.Descriptor instead.
+func (*CheckBatchResponse) Descriptor() ([]byte, []int) {
+	return file_domaindbpb_domaindb_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *CheckBatchResponse) GetResults() []*CheckResult {
+	if x != nil {
+		return x.Results
+	}
+	return nil
+}
+
+type CheckResult struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// The domain that was checked, as it was passed in.
+	Domain string `protobuf:"bytes,1,opt,name=domain,proto3" json:"domain,omitempty"`
+	// Whether the domain matched the database.
+	Matched bool `protobuf:"varint,2,opt,name=matched,proto3" json:"matched,omitempty"`
+	// If not empty, the domain could not be checked (for example, because it is not a valid domain name), and matched is false.
+	Error         string `protobuf:"bytes,3,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CheckResult) Reset() {
+	*x = CheckResult{}
+	mi := &file_domaindbpb_domaindb_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CheckResult) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CheckResult) ProtoMessage() {}
+
+func (x *CheckResult) ProtoReflect() protoreflect.Message {
+	mi := &file_domaindbpb_domaindb_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CheckResult.ProtoReflect.Descriptor instead.
+func (*CheckResult) Descriptor() ([]byte, []int) {
+	return file_domaindbpb_domaindb_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *CheckResult) GetDomain() string {
+	if x != nil {
+		return x.Domain
+	}
+	return ""
+}
+
+func (x *CheckResult) GetMatched() bool {
+	if x != nil {
+		return x.Matched
+	}
+	return false
+}
+
+func (x *CheckResult) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+type StatsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StatsRequest) Reset() {
+	*x = StatsRequest{}
+	mi := &file_domaindbpb_domaindb_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StatsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StatsRequest) ProtoMessage() {}
+
+func (x *StatsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_domaindbpb_domaindb_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StatsRequest.ProtoReflect.Descriptor instead.
+func (*StatsRequest) Descriptor() ([]byte, []int) {
+	return file_domaindbpb_domaindb_proto_rawDescGZIP(), []int{5}
+}
+
+type StatsResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// The status of each database, sorted by database name.
+	Databases     []*DatabaseStats `protobuf:"bytes,1,rep,name=databases,proto3" json:"databases,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StatsResponse) Reset() {
+	*x = StatsResponse{}
+	mi := &file_domaindbpb_domaindb_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StatsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StatsResponse) ProtoMessage() {}
+
+func (x *StatsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_domaindbpb_domaindb_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StatsResponse.ProtoReflect.Descriptor instead.
+func (*StatsResponse) Descriptor() ([]byte, []int) {
+	return file_domaindbpb_domaindb_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *StatsResponse) GetDatabases() []*DatabaseStats {
+	if x != nil {
+		return x.Databases
+	}
+	return nil
+}
+
+type DatabaseStats struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// The database name.
+	Name string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	// Whether the database is enabled.
+	Enabled bool `protobuf:"varint,2,opt,name=enabled,proto3" json:"enabled,omitempty"`
+	// Whether the database has been initialized.
+	// If false, the remaining fields are unset.
+	Initialized bool `protobuf:"varint,3,opt,name=initialized,proto3" json:"initialized,omitempty"`
+	// Where the database was last loaded from ("cache" or "download").
+	LoadSource string `protobuf:"bytes,4,opt,name=load_source,json=loadSource,proto3" json:"load_source,omitempty"`
+	// The time the database was last loaded.
+	LoadedAt *timestamppb.Timestamp `protobuf:"bytes,5,opt,name=loaded_at,json=loadedAt,proto3" json:"loaded_at,omitempty"`
+	// The number of unique domains in the database.
+	UniqueDomains int64 `protobuf:"varint,6,opt,name=unique_domains,json=uniqueDomains,proto3" json:"unique_domains,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DatabaseStats) Reset() {
+	*x = DatabaseStats{}
+	mi := &file_domaindbpb_domaindb_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DatabaseStats) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DatabaseStats) ProtoMessage() {}
+
+func (x *DatabaseStats) ProtoReflect() protoreflect.Message {
+	mi := &file_domaindbpb_domaindb_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DatabaseStats.ProtoReflect.Descriptor instead.
+func (*DatabaseStats) Descriptor() ([]byte, []int) {
+	return file_domaindbpb_domaindb_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *DatabaseStats) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *DatabaseStats) GetEnabled() bool {
+	if x != nil {
+		return x.Enabled
+	}
+	return false
+}
+
+func (x *DatabaseStats) GetInitialized() bool {
+	if x != nil {
+		return x.Initialized
+	}
+	return false
+}
+
+func (x *DatabaseStats) GetLoadSource() string {
+	if x != nil {
+		return x.LoadSource
+	}
+	return ""
+}
+
+func (x *DatabaseStats) GetLoadedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.LoadedAt
+	}
+	return nil
+}
+
+func (x *DatabaseStats) GetUniqueDomains() int64 {
+	if x != nil {
+		return x.UniqueDomains
+	}
+	return 0
+}
+
+var File_domaindbpb_domaindb_proto protoreflect.FileDescriptor
+
+const file_domaindbpb_domaindb_proto_rawDesc = "" +
+	"\n" +
+	"\x19domaindbpb/domaindb.proto\x12\vdomaindb.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"6\n" +
+	"\fCheckRequest\x12\x0e\n" +
+	"\x02db\x18\x01 \x01(\tR\x02db\x12\x16\n" +
+	"\x06domain\x18\x02 \x01(\tR\x06domain\")\n" +
+	"\rCheckResponse\x12\x18\n" +
+	"\amatched\x18\x01 \x01(\bR\amatched\"=\n" +
+	"\x11CheckBatchRequest\x12\x0e\n" +
+	"\x02db\x18\x01 \x01(\tR\x02db\x12\x18\n" +
+	"\adomains\x18\x02 \x03(\tR\adomains\"H\n" +
+	"\x12CheckBatchResponse\x122\n" +
+	"\aresults\x18\x01 \x03(\v2\x18.domaindb.v1.CheckResultR\aresults\"U\n" +
+	"\vCheckResult\x12\x16\n" +
+	"\x06domain\x18\x01 \x01(\tR\x06domain\x12\x18\n" +
+	"\amatched\x18\x02 \x01(\bR\amatched\x12\x14\n" +
+	"\x05error\x18\x03 \x01(\tR\x05error\"\x0e\n" +
+	"\fStatsRequest\"I\n" +
+	"\rStatsResponse\x128\n" +
+	"\tdatabases\x18\x01 \x03(\v2\x1a.domaindb.v1.DatabaseStatsR\tdatabases\"\xe0\x01\n" +
+	"\rDatabaseStats\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x18\n" +
+	"\aenabled\x18\x02 \x01(\bR\aenabled\x12 \n" +
+	"\vinitialized\x18\x03 \x01(\bR\vinitialized\x12\x1f\n" +
+	"\vload_source\x18\x04 \x01(\tR\n" +
+	"loadSource\x127\n" +
+	"\tloaded_at\x18\x05 \x01(\v2\x1a.google.protobuf.TimestampR\bloadedAt\x12%\n" +
+	"\x0eunique_domains\x18\x06 \x01(\x03R\runiqueDomains2\xd9\x01\n" +
+	"\bDomainDb\x12>\n" +
+	"\x05Check\x12\x19.domaindb.v1.CheckRequest\x1a\x1a.domaindb.v1.CheckResponse\x12M\n" +
+	"\n" +
+	"CheckBatch\x12\x1e.domaindb.v1.CheckBatchRequest\x1a\x1f.domaindb.v1.CheckBatchResponse\x12>\n" +
+	"\x05Stats\x12\x19.domaindb.v1.StatsRequest\x1a\x1a.domaindb.v1.StatsResponseB9Z7github.com/termermc/go-domaindb/domaindbgrpc/domaindbpbb\x06proto3"
+
+var (
+	file_domaindbpb_domaindb_proto_rawDescOnce sync.Once
+	file_domaindbpb_domaindb_proto_rawDescData []byte
+)
+
+func file_domaindbpb_domaindb_proto_rawDescGZIP() []byte {
+	file_domaindbpb_domaindb_proto_rawDescOnce.Do(func() {
+		file_domaindbpb_domaindb_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_domaindbpb_domaindb_proto_rawDesc), len(file_domaindbpb_domaindb_proto_rawDesc)))
+	})
+	return file_domaindbpb_domaindb_proto_rawDescData
+}
+
+var file_domaindbpb_domaindb_proto_msgTypes = make([]protoimpl.MessageInfo, 8)
+var file_domaindbpb_domaindb_proto_goTypes = []any{
+	(*CheckRequest)(nil),          // 0: domaindb.v1.CheckRequest
+	(*CheckResponse)(nil),         // 1: domaindb.v1.CheckResponse
+	(*CheckBatchRequest)(nil),     // 2: domaindb.v1.CheckBatchRequest
+	(*CheckBatchResponse)(nil),    // 3: domaindb.v1.CheckBatchResponse
+	(*CheckResult)(nil),           // 4: domaindb.v1.CheckResult
+	(*StatsRequest)(nil),          // 5: domaindb.v1.StatsRequest
+	(*StatsResponse)(nil),         // 6: domaindb.v1.StatsResponse
+	(*DatabaseStats)(nil),         // 7: domaindb.v1.DatabaseStats
+	(*timestamppb.Timestamp)(nil), // 8: google.protobuf.Timestamp
+}
+var file_domaindbpb_domaindb_proto_depIdxs = []int32{
+	4, // 0: domaindb.v1.CheckBatchResponse.results:type_name -> domaindb.v1.CheckResult
+	7, // 1: domaindb.v1.StatsResponse.databases:type_name -> domaindb.v1.DatabaseStats
+	8, // 2: domaindb.v1.DatabaseStats.loaded_at:type_name -> google.protobuf.Timestamp
+	0, // 3: domaindb.v1.DomainDb.Check:input_type -> domaindb.v1.CheckRequest
+	2, // 4: domaindb.v1.DomainDb.CheckBatch:input_type -> domaindb.v1.CheckBatchRequest
+	5, // 5: domaindb.v1.DomainDb.Stats:input_type -> domaindb.v1.StatsRequest
+	1, // 6: domaindb.v1.DomainDb.Check:output_type -> domaindb.v1.CheckResponse
+	3, // 7: domaindb.v1.DomainDb.CheckBatch:output_type -> domaindb.v1.CheckBatchResponse
+	6, // 8: domaindb.v1.DomainDb.Stats:output_type -> domaindb.v1.StatsResponse
+	6, // [6:9] is the sub-list for method output_type
+	3, // [3:6] is the sub-list for method input_type
+	3, // [3:3] is the sub-list for extension type_name
+	3, // [3:3] is the sub-list for extension extendee
+	0, // [0:3] is the sub-list for field type_name
+}
+
+func init() { file_domaindbpb_domaindb_proto_init() }
+func file_domaindbpb_domaindb_proto_init() {
+	if File_domaindbpb_domaindb_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_domaindbpb_domaindb_proto_rawDesc), len(file_domaindbpb_domaindb_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   8,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_domaindbpb_domaindb_proto_goTypes,
+		DependencyIndexes: file_domaindbpb_domaindb_proto_depIdxs,
+		MessageInfos:      file_domaindbpb_domaindb_proto_msgTypes,
+	}.Build()
+	File_domaindbpb_domaindb_proto = out.File
+	file_domaindbpb_domaindb_proto_goTypes = nil
+	file_domaindbpb_domaindb_proto_depIdxs = nil
+}
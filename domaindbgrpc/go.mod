@@ -0,0 +1,24 @@
+module github.com/termermc/go-domaindb/domaindbgrpc
+
+go 1.25.1
+
+// The domaindb module is required at a commit of this repository, since it has no tagged release yet.
+// To build against the domaindb module in the working tree instead, create a workspace in the repository root, like CI does:
+//
+//	go work init . ./domaindbgrpc
+//	go work edit -replace github.com/termermc/go-domaindb@v0.0.0-20261017054808-d9ea818f6653=./
+require (
+	github.com/termermc/go-domaindb v0.0.0-20261017054808-d9ea818f6653
+	google.golang.org/grpc v1.84.0
+	google.golang.org/protobuf v1.36.11
+)
+
+require (
+	github.com/klauspost/compress v1.20.1 // indirect
+	github.com/puzpuzpuz/xsync/v4 v4.2.0 // indirect
+	golang.org/x/net v0.57.0 // indirect
+	golang.org/x/sys v0.47.0 // indirect
+	golang.org/x/text v0.40.0 // indirect
+	golang.org/x/time v0.15.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20260706201446-f0a921348800 // indirect
+)
@@ -0,0 +1,133 @@
+// Package domaindbgrpc exposes a domaindb.DomainDb over gRPC.
+// It is a separate module so that users who do not need gRPC do not depend on it.
+//
+// The service definition is in domaindbpb/domaindb.proto.
+// Register a Server with a gRPC server using domaindbpb.RegisterDomainDbServer.
+package domaindbgrpc
+
+//go:generate buf generate
+
+import (
+	"context"
+	"errors"
+
+	"github.com/termermc/go-domaindb"
+	"github.com/termermc/go-domaindb/domaindbgrpc/domaindbpb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Server implements domaindbpb.DomainDbServer by looking up domains in a DomainDb.
+// Create an instance with NewServer.
+type Server struct {
+	domaindbpb.UnimplementedDomainDbServer
+
+	db *domaindb.DomainDb
+}
+
+// NewServer creates a new Server that looks up domains in the specified DomainDb.
+func NewServer(db *domaindb.DomainDb) *Server {
+	return &Server{
+		db: db,
+	}
+}
+
+// Check returns whether a domain was found in a database.
+func (s *Server) Check(_ context.Context, req *domaindbpb.CheckRequest) (*domaindbpb.CheckResponse, error) {
+	matched, err := s.db.DoesDbHaveDomain(req.GetDb(), req.GetDomain())
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	return &domaindbpb.CheckResponse{
+		Matched: matched,
+	}, nil
+}
+
+// CheckBatch returns whether each of several domains was found in a database.
+// The domains are checked against a snapshot, so that they are all checked against the same state of the database.
+// Domains that cannot be checked do not fail the whole batch; their errors are reported in their results instead.
+func (s *Server) CheckBatch(_ context.Context, req *domaindbpb.CheckBatchRequest) (*domaindbpb.CheckBatchResponse, error) {
+	snapshot, err := s.db.Snapshot()
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	res := &domaindbpb.CheckBatchResponse{
+		Results: make([]*domaindbpb.CheckResult, 0, len(req.GetDomains())),
+	}
+	for _, domain := range req.GetDomains() {
+		result := &domaindbpb.CheckResult{
+			Domain: domain,
+		}
+
+		matched, err := snapshot.DoesDbHaveDomain(req.GetDb(), domain)
+		if err != nil {
+			var noSuchDb *domaindb.NoSuchDatabaseError
+			var notInitialized *domaindb.NotInitializedError
+			if errors.As(err, &noSuchDb) || errors.As(err, &notInitialized) {
+				// These apply to every domain in the batch.
+				return nil, toStatusError(err)
+			}
+
+			result.Error = err.Error()
+		} else {
+			result.Matched = matched
+		}
+
+		res.Results = append(res.Results, result)
+	}
+
+	return res, nil
+}
+
+// Stats returns the status of every database.
+func (s *Server) Stats(_ context.Context, _ *domaindbpb.StatsRequest) (*domaindbpb.StatsResponse, error) {
+	names := s.db.DatabaseNames()
+	res := &domaindbpb.StatsResponse{
+		Databases: make([]*domaindbpb.DatabaseStats, 0, len(names)),
+	}
+	for _, name := range names {
+		enabled, err := s.db.IsDatabaseEnabled(name)
+		if err != nil {
+			return nil, toStatusError(err)
+		}
+
+		dbStats := &domaindbpb.DatabaseStats{
+			Name:    name,
+			Enabled: enabled,
+		}
+
+		stats, err := s.db.LastLoadStats(name)
+		var notInitialized *domaindb.NotInitializedError
+		if err != nil && !errors.As(err, &notInitialized) {
+			return nil, toStatusError(err)
+		}
+		if err == nil {
+			dbStats.Initialized = true
+			dbStats.LoadSource = stats.Source.String()
+			dbStats.LoadedAt = timestamppb.New(stats.LoadedAt)
+			dbStats.UniqueDomains = int64(stats.UniqueDomains)
+		}
+
+		res.Databases = append(res.Databases, dbStats)
+	}
+
+	return res, nil
+}
+
+// toStatusError converts an error returned by DomainDb to a gRPC status error with a matching code.
+func toStatusError(err error) error {
+	var noSuchDb *domaindb.NoSuchDatabaseError
+	var notInitialized *domaindb.NotInitializedError
+
+	code := codes.InvalidArgument
+	if errors.As(err, &noSuchDb) {
+		code = codes.NotFound
+	} else if errors.As(err, &notInitialized) || errors.Is(err, domaindb.ErrDbClosed) {
+		code = codes.Unavailable
+	}
+
+	return status.Error(code, err.Error())
+}
@@ -0,0 +1,163 @@
+package domaindbgrpc_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/termermc/go-domaindb"
+	"github.com/termermc/go-domaindb/domaindbgrpc"
+	"github.com/termermc/go-domaindb/domaindbgrpc/domaindbpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func newTestClient(t *testing.T) domaindbpb.DomainDbClient {
+	t.Helper()
+
+	storage, err := domaindb.NewFsStorageDriver(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create storage driver: %v", err)
+	}
+
+	db, err := domaindb.NewDomainDb(domaindb.Options{
+		StorageDriver: storage,
+		Logger:        slog.New(slog.DiscardHandler),
+		StartupPolicy: domaindb.StartupPolicyBestEffort,
+		Sources: map[string]*domaindb.DataSource{
+			"disposable": {
+				RefreshInterval: time.Hour,
+				Get: func() (io.ReadCloser, error) {
+					return io.NopCloser(strings.NewReader("foo.com\n")), nil
+				},
+			},
+
+			// Fails to load, so it stays uninitialized.
+			"broken": {
+				RefreshInterval: time.Hour,
+				Get: func() (io.ReadCloser, error) {
+					return nil, errors.New("source is down")
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create DomainDb: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	listener := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer()
+	domaindbpb.RegisterDomainDbServer(server, domaindbgrpc.NewServer(db))
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+
+	return domaindbpb.NewDomainDbClient(conn)
+}
+
+func TestServer_Check(t *testing.T) {
+	client := newTestClient(t)
+
+	res, err := client.Check(t.Context(), &domaindbpb.CheckRequest{Db: "disposable", Domain: "FOO.com"})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if !res.GetMatched() {
+		t.Fatal("expected domain to match")
+	}
+
+	_, err = client.Check(t.Context(), &domaindbpb.CheckRequest{Db: "missing", Domain: "foo.com"})
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("expected NotFound, got %v", err)
+	}
+}
+
+func TestServer_CheckBatch(t *testing.T) {
+	client := newTestClient(t)
+
+	res, err := client.CheckBatch(t.Context(), &domaindbpb.CheckBatchRequest{
+		Db:      "disposable",
+		Domains: []string{"foo.com", "bar.com", "bad_domain!"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	results := res.GetResults()
+	if len(results) != 3 {
+		t.Fatalf("got %d results, want 3", len(results))
+	}
+	for i, domain := range []string{"foo.com", "bar.com", "bad_domain!"} {
+		if got := results[i].GetDomain(); got != domain {
+			t.Fatalf("got result %d for %q, want %q", i, got, domain)
+		}
+	}
+	if !results[0].GetMatched() || results[0].GetError() != "" {
+		t.Fatalf("got %v, want a match without an error", results[0])
+	}
+	if results[1].GetMatched() || results[1].GetError() != "" {
+		t.Fatalf("got %v, want no match without an error", results[1])
+	}
+	if results[2].GetMatched() || results[2].GetError() == "" {
+		t.Fatalf("got %v, want an error for the invalid domain", results[2])
+	}
+
+	// Errors that apply to the whole database fail the batch.
+	for db, wantCode := range map[string]codes.Code{
+		"missing": codes.NotFound,
+		"broken":  codes.Unavailable,
+	} {
+		_, err = client.CheckBatch(t.Context(), &domaindbpb.CheckBatchRequest{
+			Db:      db,
+			Domains: []string{"foo.com", "bad_domain!"},
+		})
+		if got := status.Code(err); got != wantCode {
+			t.Fatalf("%s: got %v, want %v", db, err, wantCode)
+		}
+	}
+}
+
+func TestServer_Stats(t *testing.T) {
+	client := newTestClient(t)
+
+	res, err := client.Stats(t.Context(), &domaindbpb.StatsRequest{})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	dbs := res.GetDatabases()
+	if len(dbs) != 2 {
+		t.Fatalf("got %d databases, want 2", len(dbs))
+	}
+	if dbs[0].GetName() != "broken" || dbs[0].GetInitialized() {
+		t.Fatalf("unexpected stats for broken database: %v", dbs[0])
+	}
+	if dbs[1].GetName() != "disposable" || !dbs[1].GetInitialized() || dbs[1].GetUniqueDomains() != 1 {
+		t.Fatalf("unexpected stats for disposable database: %v", dbs[1])
+	}
+}
@@ -0,0 +1,147 @@
+// Package domaindbhttp provides a ready-made HTTP handler for looking up domains in a domaindb.DomainDb.
+// It is a separate package so that users who do not need it do not depend on it.
+package domaindbhttp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/termermc/go-domaindb"
+)
+
+// CheckResponse is the JSON response body of the /check endpoint.
+type CheckResponse struct {
+	// Whether the domain matched the database.
+	Matched bool `json:"matched"`
+}
+
+// StatusResponse is the JSON response body of the /status endpoint.
+type StatusResponse struct {
+	// The status of each database, sorted by database name.
+	Databases []DatabaseStatus `json:"databases"`
+}
+
+// DatabaseStatus is the status of a single database, as returned by the /status endpoint.
+type DatabaseStatus struct {
+	// The database name.
+	Name string `json:"name"`
+
+	// Whether the database is enabled.
+	Enabled bool `json:"enabled"`
+
+	// Whether the database has been initialized.
+	// If false, the remaining fields are zero.
+	Initialized bool `json:"initialized"`
+
+	// Where the database was last loaded from ("cache" or "download").
+	LoadSource string `json:"load_source,omitempty"`
+
+	// The time the database was last loaded.
+	LoadedAt time.Time `json:"loaded_at,omitzero"`
+
+	// The number of unique domains in the database.
+	UniqueDomains int `json:"unique_domains"`
+}
+
+// ErrorResponse is the JSON response body returned when a request fails.
+type ErrorResponse struct {
+	// A description of the error.
+	Error string `json:"error"`
+}
+
+// NewHandler creates an HTTP handler that serves lookups from the DomainDb.
+//
+// It serves the following endpoints:
+//   - GET /check?db=<database name>&domain=<domain>: returns a CheckResponse
+//   - GET /status: returns a StatusResponse
+//
+// Errors are returned as an ErrorResponse with an appropriate status code:
+// 400 for missing parameters or invalid domains, 404 for databases that do not exist, and 503 for databases that have not been initialized, a closed DomainDb, or lookups that were cancelled.
+//
+// Lookups are made with the request's context, so a lookup waiting on DataSource.OnProbableMatch stops when the client disconnects.
+//
+// To serve the endpoints under a prefix, use http.StripPrefix.
+func NewHandler(db *domaindb.DomainDb) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /check", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		dbName := query.Get("db")
+		domain := query.Get("domain")
+		if dbName == "" || domain == "" {
+			writeJson(w, http.StatusBadRequest, ErrorResponse{Error: `the "db" and "domain" query parameters are required`})
+			return
+		}
+
+		matched, err := db.DoesDbHaveDomainContext(r.Context(), dbName, domain)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		writeJson(w, http.StatusOK, CheckResponse{Matched: matched})
+	})
+
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, r *http.Request) {
+		names := db.DatabaseNames()
+		res := StatusResponse{
+			Databases: make([]DatabaseStatus, 0, len(names)),
+		}
+		for _, name := range names {
+			enabled, err := db.IsDatabaseEnabled(name)
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+
+			status := DatabaseStatus{
+				Name:    name,
+				Enabled: enabled,
+			}
+
+			stats, err := db.LastLoadStats(name)
+			var notInitialized *domaindb.NotInitializedError
+			if err != nil && !errors.As(err, &notInitialized) {
+				writeError(w, err)
+				return
+			}
+			if err == nil {
+				status.Initialized = true
+				status.LoadSource = stats.Source.String()
+				status.LoadedAt = stats.LoadedAt
+				status.UniqueDomains = stats.UniqueDomains
+			}
+
+			res.Databases = append(res.Databases, status)
+		}
+
+		writeJson(w, http.StatusOK, res)
+	})
+
+	return mux
+}
+
+// writeError writes an ErrorResponse for the error, with a status code that matches the type of error.
+func writeError(w http.ResponseWriter, err error) {
+	var noSuchDb *domaindb.NoSuchDatabaseError
+	var notInitialized *domaindb.NotInitializedError
+
+	status := http.StatusBadRequest
+	if errors.As(err, &noSuchDb) {
+		status = http.StatusNotFound
+	} else if errors.As(err, &notInitialized) || errors.Is(err, domaindb.ErrDbClosed) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		status = http.StatusServiceUnavailable
+	}
+
+	writeJson(w, status, ErrorResponse{Error: err.Error()})
+}
+
+// writeJson writes the value as a JSON response with the specified status code.
+func writeJson(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
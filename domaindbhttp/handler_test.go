@@ -0,0 +1,131 @@
+package domaindbhttp_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/termermc/go-domaindb"
+	"github.com/termermc/go-domaindb/domaindbhttp"
+)
+
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	storage, err := domaindb.NewFsStorageDriver(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create storage driver: %v", err)
+	}
+
+	db, err := domaindb.NewDomainDb(domaindb.Options{
+		StorageDriver: storage,
+		Logger:        slog.New(slog.DiscardHandler),
+		Sources: map[string]*domaindb.DataSource{
+			"disposable": {
+				RefreshInterval: time.Hour,
+				Get: func() (io.ReadCloser, error) {
+					return io.NopCloser(strings.NewReader("foo.com\n")), nil
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create DomainDb: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	server := httptest.NewServer(domaindbhttp.NewHandler(db))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func getJson(t *testing.T, url string, wantStatus int, v any) {
+	t.Helper()
+
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode != wantStatus {
+		t.Fatalf("got status %d, want %d", resp.StatusCode, wantStatus)
+	}
+	if err = json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+}
+
+func TestHandler_Check(t *testing.T) {
+	server := newTestServer(t)
+
+	cases := map[string]bool{
+		"foo.com": true,
+		"FOO.COM": true,
+		"bar.com": false,
+	}
+	for domain, want := range cases {
+		var res domaindbhttp.CheckResponse
+		getJson(t, server.URL+"/check?db=disposable&domain="+domain, http.StatusOK, &res)
+		if res.Matched != want {
+			t.Fatalf("%q: got %v, want %v", domain, res.Matched, want)
+		}
+	}
+}
+
+func TestHandler_CheckErrors(t *testing.T) {
+	server := newTestServer(t)
+
+	cases := map[string]int{
+		"/check?db=disposable":                    http.StatusBadRequest,
+		"/check?db=disposable&domain=bad_domain!": http.StatusBadRequest,
+		"/check?db=missing&domain=foo.com":        http.StatusNotFound,
+	}
+	for path, wantStatus := range cases {
+		var res domaindbhttp.ErrorResponse
+		getJson(t, server.URL+path, wantStatus, &res)
+		if res.Error == "" {
+			t.Fatalf("%s: expected error message", path)
+		}
+	}
+}
+
+func TestHandler_CheckCancelled(t *testing.T) {
+	server := newTestServer(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := httptest.NewRequestWithContext(ctx, http.MethodGet, "/check?db=disposable&domain=foo.com", nil)
+	rec := httptest.NewRecorder()
+	server.Config.Handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("got status %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+}
+
+func TestHandler_Status(t *testing.T) {
+	server := newTestServer(t)
+
+	var res domaindbhttp.StatusResponse
+	getJson(t, server.URL+"/status", http.StatusOK, &res)
+
+	if len(res.Databases) != 1 {
+		t.Fatalf("got %d databases, want 1", len(res.Databases))
+	}
+	status := res.Databases[0]
+	if status.Name != "disposable" || !status.Enabled || !status.Initialized || status.UniqueDomains != 1 || status.LoadSource != "download" {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+}
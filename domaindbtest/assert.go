@@ -0,0 +1,90 @@
+package domaindbtest
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/termermc/go-domaindb"
+)
+
+// NewDomainDb creates a DomainDb for tests with a database for each entry in lists.
+// The key is the database name and the value is the contents of its list, in the same format as a downloaded list.
+// It uses a domaindb.MemoryStorageDriver, so no disk is touched, and it is closed when the test finishes.
+// If options are provided, they are used as the base options; StorageDriver and Sources are always overwritten.
+func NewDomainDb(t testing.TB, lists map[string]string, options ...domaindb.Options) *domaindb.DomainDb {
+	t.Helper()
+
+	var opts domaindb.Options
+	if len(options) > 0 {
+		opts = options[0]
+	}
+
+	opts.StorageDriver = domaindb.NewMemoryStorageDriver()
+	opts.Sources = make(map[string]*domaindb.DataSource, len(lists))
+	for name, contents := range lists {
+		opts.Sources[name] = &domaindb.DataSource{
+			RefreshInterval: 24 * time.Hour,
+			Get: func() (io.ReadCloser, error) {
+				return io.NopCloser(strings.NewReader(contents)), nil
+			},
+		}
+	}
+
+	db, err := domaindb.NewDomainDb(opts)
+	if err != nil {
+		t.Fatalf("failed to create DomainDb: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	return db
+}
+
+// AssertInDb asserts that the domain is found in the database with the specified name.
+// The failure message includes the normalized form of the domain, which is what is actually looked up.
+func AssertInDb(t testing.TB, db domaindb.ReadOnlyDomainDb, dbName string, domain string) {
+	t.Helper()
+	assertVerdict(t, db, dbName, domain, true)
+}
+
+// AssertNotInDb asserts that the domain is not found in the database with the specified name.
+// The failure message includes the normalized form of the domain, which is what is actually looked up.
+func AssertNotInDb(t testing.TB, db domaindb.ReadOnlyDomainDb, dbName string, domain string) {
+	t.Helper()
+	assertVerdict(t, db, dbName, domain, false)
+}
+
+// assertVerdict asserts whether the domain is found in the database with the specified name.
+func assertVerdict(t testing.TB, db domaindb.ReadOnlyDomainDb, dbName string, domain string, want bool) {
+	t.Helper()
+
+	res, err := db.Explain(domain)
+	if err != nil {
+		t.Errorf("failed to look up domain %q in database %q: %v", domain, dbName, err)
+		return
+	}
+
+	for _, explanation := range res.Databases {
+		if explanation.Name != dbName {
+			continue
+		}
+
+		if !explanation.Initialized {
+			t.Errorf("failed to look up domain %q in database %q: database is not initialized", domain, dbName)
+		} else if explanation.Found != want {
+			if want {
+				t.Errorf("expected domain %q (normalized: %q) to be in database %q, but it was not", domain, res.Normalized, dbName)
+			} else if explanation.Negated {
+				t.Errorf("expected domain %q (normalized: %q) not to be in database %q, but it matched because it is not in the negated list", domain, res.Normalized, dbName)
+			} else {
+				t.Errorf("expected domain %q (normalized: %q) not to be in database %q, but it matched entry %q", domain, res.Normalized, dbName, explanation.Matched)
+			}
+		}
+		return
+	}
+
+	t.Errorf("failed to look up domain %q: %v", domain, domaindb.NewNoSuchDatabaseError(dbName))
+}
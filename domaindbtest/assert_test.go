@@ -0,0 +1,44 @@
+package domaindbtest_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/termermc/go-domaindb/domaindbtest"
+)
+
+// recorder records assertion failures instead of failing the test.
+type recorder struct {
+	testing.TB
+	errors []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...any) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func TestAssertInDb(t *testing.T) {
+	db := domaindbtest.NewDomainDb(t, map[string]string{
+		"blocklist": "bücher.de\n",
+	})
+
+	r := &recorder{TB: t}
+	domaindbtest.AssertInDb(r, db, "blocklist", "BÜCHER.de")
+	domaindbtest.AssertNotInDb(r, db, "blocklist", "example.com")
+	if len(r.errors) != 0 {
+		t.Fatalf("unexpected assertion failures: %v", r.errors)
+	}
+
+	domaindbtest.AssertInDb(r, db, "blocklist", "example.com")
+	domaindbtest.AssertNotInDb(r, db, "blocklist", "bücher.de")
+	domaindbtest.AssertInDb(r, db, "missing", "example.com")
+	if len(r.errors) != 3 {
+		t.Fatalf("got %d assertion failures, want 3: %v", len(r.errors), r.errors)
+	}
+	if !strings.Contains(r.errors[1], `"xn--bcher-kva.de"`) {
+		t.Fatalf("expected failure message to include normalized domain, got %q", r.errors[1])
+	}
+}
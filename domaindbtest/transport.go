@@ -0,0 +1,127 @@
+// Package domaindbtest provides utilities for testing code that uses domaindb.
+package domaindbtest
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Response is a canned response returned by Transport.
+type Response struct {
+	// The status code of the response.
+	// If 0, defaults to 200.
+	StatusCode int
+
+	// The response body.
+	Body string
+
+	// Additional response headers.
+	// Content-Length is set automatically from Body unless it is already specified.
+	Header http.Header
+
+	// If not nil, the request fails with this error instead of returning a response.
+	Err error
+}
+
+// Transport is an http.RoundTripper that returns canned responses for URLs instead of making real requests.
+// It is intended for unit tests of code that downloads data sources.
+// Use NewTransport to create an instance, and Transport.Client to get an HTTP client that can be passed to domaindb.Options.
+//
+// It is safe to use a single instance of Transport across multiple goroutines.
+type Transport struct {
+	mu        sync.Mutex
+	responses map[string][]Response
+	requests  map[string]int
+}
+
+// NewTransport creates a new Transport with no canned responses.
+func NewTransport() *Transport {
+	return &Transport{
+		responses: make(map[string][]Response),
+		requests:  make(map[string]int),
+	}
+}
+
+// Respond sets the responses for the specified URL.
+// Responses are returned in order, one per request; once only one response is left, it is returned for all subsequent requests.
+// Requests for URLs without responses get a 404 response.
+func (t *Transport) Respond(url string, responses ...Response) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.responses[url] = responses
+}
+
+// RequestCount returns the number of requests that have been made for the specified URL.
+func (t *Transport) RequestCount(url string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.requests[url]
+}
+
+// Client returns an HTTP client that uses the Transport.
+func (t *Transport) Client() *http.Client {
+	return &http.Client{
+		Transport: t,
+	}
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	url := req.URL.String()
+
+	t.mu.Lock()
+	t.requests[url]++
+	var res Response
+	queue, has := t.responses[url]
+	if !has || len(queue) == 0 {
+		res = Response{
+			StatusCode: http.StatusNotFound,
+			Body:       "not found",
+		}
+	} else {
+		res = queue[0]
+		if len(queue) > 1 {
+			t.responses[url] = queue[1:]
+		}
+	}
+	t.mu.Unlock()
+
+	if res.Err != nil {
+		return nil, res.Err
+	}
+
+	statusCode := res.StatusCode
+	if statusCode == 0 {
+		statusCode = http.StatusOK
+	}
+
+	header := make(http.Header)
+	for k, v := range res.Header {
+		header[k] = v
+	}
+
+	contentLength := int64(len(res.Body))
+	if cl := header.Get("Content-Length"); cl != "" {
+		if parsed, err := strconv.ParseInt(cl, 10, 64); err == nil {
+			contentLength = parsed
+		}
+	} else {
+		header.Set("Content-Length", strconv.FormatInt(contentLength, 10))
+	}
+
+	return &http.Response{
+		Status:        strconv.Itoa(statusCode) + " " + http.StatusText(statusCode),
+		StatusCode:    statusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(strings.NewReader(res.Body)),
+		ContentLength: contentLength,
+		Request:       req,
+	}, nil
+}
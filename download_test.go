@@ -0,0 +1,963 @@
+package domaindb_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/termermc/go-domaindb"
+	"github.com/termermc/go-domaindb/domaindbtest"
+)
+
+func mustParseUrl(t *testing.T, str string) *url.URL {
+	t.Helper()
+
+	res, err := url.Parse(str)
+	if err != nil {
+		t.Fatalf("failed to parse URL %q: %v", str, err)
+	}
+	return res
+}
+
+// newDownloadTestDb creates a DomainDb with a single database named "test" that downloads from the specified URLs using the transport.
+func newDownloadTestDb(t *testing.T, transport *domaindbtest.Transport, mode domaindb.UrlMode, urls ...string) *domaindb.DomainDb {
+	t.Helper()
+
+	storage, err := domaindb.NewFsStorageDriver(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create storage driver: %v", err)
+	}
+
+	parsed := make([]*url.URL, 0, len(urls))
+	for _, u := range urls {
+		parsed = append(parsed, mustParseUrl(t, u))
+	}
+
+	db, err := domaindb.NewDomainDb(domaindb.Options{
+		StorageDriver: storage,
+		Logger:        slog.New(slog.DiscardHandler),
+		HttpClient:    transport.Client(),
+		Sources: map[string]*domaindb.DataSource{
+			"test": {
+				RefreshInterval: time.Hour,
+				Urls:            parsed,
+				UrlMode:         mode,
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create DomainDb: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	return db
+}
+
+func assertHas(t *testing.T, db *domaindb.DomainDb, domain string, want bool) {
+	t.Helper()
+
+	has, err := db.DoesDbHaveDomain("test", domain)
+	if err != nil {
+		t.Fatalf("%q: unexpected err: %v", domain, err)
+	}
+	if has != want {
+		t.Fatalf("%q: got %t, want %t", domain, has, want)
+	}
+}
+
+func TestDownload_ConcatenatesUrlsAndSkipsFailures(t *testing.T) {
+	transport := domaindbtest.NewTransport()
+	transport.Respond("https://a.test/list.txt", domaindbtest.Response{Body: "a.example.com"})
+	transport.Respond("https://b.test/list.txt", domaindbtest.Response{StatusCode: 500, Body: "oops"})
+	transport.Respond("https://c.test/list.txt", domaindbtest.Response{Body: "c.example.com\n"})
+
+	db := newDownloadTestDb(t, transport, domaindb.UrlModeConcatenate,
+		"https://a.test/list.txt",
+		"https://b.test/list.txt",
+		"https://c.test/list.txt",
+	)
+
+	assertHas(t, db, "a.example.com", true)
+	assertHas(t, db, "c.example.com", true)
+}
+
+func TestDownload_FailoverStopsAtFirstWorkingMirror(t *testing.T) {
+	transport := domaindbtest.NewTransport()
+	transport.Respond("https://a.test/list.txt", domaindbtest.Response{StatusCode: 503})
+	transport.Respond("https://b.test/list.txt", domaindbtest.Response{Body: "b.example.com\n"})
+	transport.Respond("https://c.test/list.txt", domaindbtest.Response{Body: "c.example.com\n"})
+
+	db := newDownloadTestDb(t, transport, domaindb.UrlModeFailover,
+		"https://a.test/list.txt",
+		"https://b.test/list.txt",
+		"https://c.test/list.txt",
+	)
+
+	assertHas(t, db, "b.example.com", true)
+	assertHas(t, db, "c.example.com", false)
+	if n := transport.RequestCount("https://c.test/list.txt"); n != 0 {
+		t.Fatalf("expected no requests to the last mirror, got %d", n)
+	}
+}
+
+func TestDownload_ContentLengthMismatch(t *testing.T) {
+	transport := domaindbtest.NewTransport()
+	transport.Respond("https://a.test/list.txt", domaindbtest.Response{
+		Body:   "a.example.com\n",
+		Header: map[string][]string{"Content-Length": {"1000"}},
+	})
+
+	storage, err := domaindb.NewFsStorageDriver(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create storage driver: %v", err)
+	}
+
+	_, err = domaindb.NewDomainDb(domaindb.Options{
+		StorageDriver: storage,
+		Logger:        slog.New(slog.DiscardHandler),
+		HttpClient:    transport.Client(),
+		Sources: map[string]*domaindb.DataSource{
+			"test": {
+				RefreshInterval: time.Hour,
+				Urls:            []*url.URL{mustParseUrl(t, "https://a.test/list.txt")},
+			},
+		},
+	})
+	if !errors.Is(err, domaindb.ErrContentLengthMismatch) {
+		t.Fatalf("expected ErrContentLengthMismatch, got %v", err)
+	}
+}
+
+func TestDownload_MethodAndBody(t *testing.T) {
+	const query = `{"category":"disposable"}`
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Method != http.MethodPost || string(body) != query {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte("a.example.com\n"))
+	}))
+	defer server.Close()
+
+	storage, err := domaindb.NewFsStorageDriver(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create storage driver: %v", err)
+	}
+
+	db, err := domaindb.NewDomainDb(domaindb.Options{
+		StorageDriver: storage,
+		Logger:        slog.New(slog.DiscardHandler),
+		Sources: map[string]*domaindb.DataSource{
+			"test": {
+				RefreshInterval: time.Hour,
+				Urls:            []*url.URL{mustParseUrl(t, server.URL)},
+				Method:          http.MethodPost,
+				Body:            []byte(query),
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create DomainDb: %v", err)
+	}
+	defer func() {
+		_ = db.Close()
+	}()
+
+	assertHas(t, db, "a.example.com", true)
+}
+
+// newPaginatedTestDb creates a DomainDb with a single database named "test" that downloads from a paginated source.
+// NextPage follows the "Next-Page" response header.
+func newPaginatedTestDb(t *testing.T, transport *domaindbtest.Transport, maxPages int) (*domaindb.DomainDb, error) {
+	t.Helper()
+
+	storage, err := domaindb.NewFsStorageDriver(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create storage driver: %v", err)
+	}
+
+	db, err := domaindb.NewDomainDb(domaindb.Options{
+		StorageDriver: storage,
+		Logger:        slog.New(slog.DiscardHandler),
+		HttpClient:    transport.Client(),
+		Sources: map[string]*domaindb.DataSource{
+			"test": {
+				RefreshInterval: time.Hour,
+				Urls:            []*url.URL{mustParseUrl(t, "https://a.test/list?page=1")},
+				NextPage: func(resp *http.Response) (*url.URL, bool) {
+					next := resp.Header.Get("Next-Page")
+					if next == "" {
+						return nil, false
+					}
+					u, err := url.Parse(next)
+					return u, err == nil
+				},
+				MaxPages: maxPages,
+			},
+		},
+	})
+	if err == nil {
+		t.Cleanup(func() {
+			_ = db.Close()
+		})
+	}
+
+	return db, err
+}
+
+func TestDownload_Pagination(t *testing.T) {
+	transport := domaindbtest.NewTransport()
+	transport.Respond("https://a.test/list?page=1", domaindbtest.Response{Body: "a.example.com", Header: http.Header{"Next-Page": {"?page=2"}}})
+	transport.Respond("https://a.test/list?page=2", domaindbtest.Response{Body: "b.example.com", Header: http.Header{"Next-Page": {"/list?page=3"}}})
+	transport.Respond("https://a.test/list?page=3", domaindbtest.Response{Body: "c.example.com\n"})
+
+	db, err := newPaginatedTestDb(t, transport, 0)
+	if err != nil {
+		t.Fatalf("failed to create DomainDb: %v", err)
+	}
+
+	assertHas(t, db, "a.example.com", true)
+	assertHas(t, db, "b.example.com", true)
+	assertHas(t, db, "c.example.com", true)
+}
+
+func TestDownload_PaginationErrors(t *testing.T) {
+	t.Run("later page fails", func(t *testing.T) {
+		transport := domaindbtest.NewTransport()
+		transport.Respond("https://a.test/list?page=1", domaindbtest.Response{Body: "a.example.com\n", Header: http.Header{"Next-Page": {"?page=2"}}})
+		transport.Respond("https://a.test/list?page=2", domaindbtest.Response{StatusCode: 500})
+
+		if _, err := newPaginatedTestDb(t, transport, 0); err == nil {
+			t.Fatal("expected error, got nil")
+		}
+	})
+
+	t.Run("loop", func(t *testing.T) {
+		transport := domaindbtest.NewTransport()
+		transport.Respond("https://a.test/list?page=1", domaindbtest.Response{Body: "a.example.com\n", Header: http.Header{"Next-Page": {"?page=2"}}})
+		transport.Respond("https://a.test/list?page=2", domaindbtest.Response{Body: "b.example.com\n", Header: http.Header{"Next-Page": {"?page=1"}}})
+
+		if _, err := newPaginatedTestDb(t, transport, 0); !errors.Is(err, domaindb.ErrPaginationLoop) {
+			t.Fatalf("expected ErrPaginationLoop, got %v", err)
+		}
+	})
+
+	t.Run("too many pages", func(t *testing.T) {
+		transport := domaindbtest.NewTransport()
+		transport.Respond("https://a.test/list?page=1", domaindbtest.Response{Body: "a.example.com\n", Header: http.Header{"Next-Page": {"?page=2"}}})
+		transport.Respond("https://a.test/list?page=2", domaindbtest.Response{Body: "b.example.com\n", Header: http.Header{"Next-Page": {"?page=3"}}})
+
+		if _, err := newPaginatedTestDb(t, transport, 2); !errors.Is(err, domaindb.ErrTooManyPages) {
+			t.Fatalf("expected ErrTooManyPages, got %v", err)
+		}
+	})
+}
+
+func TestDownload_HostRateLimit(t *testing.T) {
+	transport := domaindbtest.NewTransport()
+	transport.Respond("https://a.test/1.txt", domaindbtest.Response{Body: "one.example.com\n"})
+	transport.Respond("https://a.test/2.txt", domaindbtest.Response{Body: "two.example.com\n"})
+	transport.Respond("https://a.test/3.txt", domaindbtest.Response{Body: "three.example.com\n"})
+	transport.Respond("https://b.test/1.txt", domaindbtest.Response{Body: "other.example.com\n"})
+
+	storage, err := domaindb.NewFsStorageDriver(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create storage driver: %v", err)
+	}
+
+	const requestsPerSecond = 20
+	startTs := time.Now()
+	db, err := domaindb.NewDomainDb(domaindb.Options{
+		StorageDriver:         storage,
+		Logger:                slog.New(slog.DiscardHandler),
+		HttpClient:            transport.Client(),
+		HostRequestsPerSecond: requestsPerSecond,
+		Sources: map[string]*domaindb.DataSource{
+			"test": {
+				RefreshInterval: time.Hour,
+				Urls: []*url.URL{
+					mustParseUrl(t, "https://a.test/1.txt"),
+					mustParseUrl(t, "https://b.test/1.txt"),
+					mustParseUrl(t, "https://a.test/2.txt"),
+					mustParseUrl(t, "https://a.test/3.txt"),
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create DomainDb: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	// The first request to a.test is allowed immediately, and the other two must wait for the limiter.
+	if elapsed, minElapsed := time.Since(startTs), 2*time.Second/requestsPerSecond; elapsed < minElapsed {
+		t.Fatalf("download took %s, expected at least %s because of the host rate limit", elapsed, minElapsed)
+	}
+
+	for _, domain := range []string{"one.example.com", "two.example.com", "three.example.com", "other.example.com"} {
+		assertHas(t, db, domain, true)
+	}
+}
+
+func TestDownload_Compression(t *testing.T) {
+	var gzipped bytes.Buffer
+	gzipWriter := gzip.NewWriter(&gzipped)
+	_, _ = gzipWriter.Write([]byte("gzip.example.com\n"))
+	_ = gzipWriter.Close()
+
+	encoder, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatalf("failed to create zstd encoder: %v", err)
+	}
+	zstdBody := encoder.EncodeAll([]byte("zstd.example.com\n"), nil)
+	zstdDetectedBody := encoder.EncodeAll([]byte("detected.example.com\n"), nil)
+	_ = encoder.Close()
+
+	transport := domaindbtest.NewTransport()
+	transport.Respond("https://a.test/list.txt.gz", domaindbtest.Response{Body: gzipped.String()})
+	transport.Respond("https://b.test/list.zst", domaindbtest.Response{Body: string(zstdBody)})
+	transport.Respond("https://c.test/list", domaindbtest.Response{Body: string(zstdDetectedBody)})
+	transport.Respond("https://d.test/list.txt", domaindbtest.Response{Body: "plain.example.com\n"})
+
+	storage, err := domaindb.NewFsStorageDriver(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create storage driver: %v", err)
+	}
+
+	db, err := domaindb.NewDomainDb(domaindb.Options{
+		StorageDriver: storage,
+		Logger:        slog.New(slog.DiscardHandler),
+		HttpClient:    transport.Client(),
+		Sources: map[string]*domaindb.DataSource{
+			"test": {
+				RefreshInterval: time.Hour,
+				Compression:     domaindb.CompressionAuto,
+				Urls: []*url.URL{
+					mustParseUrl(t, "https://a.test/list.txt.gz"),
+					mustParseUrl(t, "https://b.test/list.zst"),
+					mustParseUrl(t, "https://c.test/list"),
+					mustParseUrl(t, "https://d.test/list.txt"),
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create DomainDb: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	for _, domain := range []string{"gzip.example.com", "zstd.example.com", "detected.example.com", "plain.example.com"} {
+		assertHas(t, db, domain, true)
+	}
+}
+
+// newHangingTestDb creates a DomainDb with a single database named "test" whose source serves "old.example.com" for the initial load.
+// Subsequent requests write a partial list, send on started, and then hang until the request is cancelled.
+func newHangingTestDb(t *testing.T) (db *domaindb.DomainDb, started <-chan struct{}) {
+	t.Helper()
+
+	startedChan := make(chan struct{}, 1)
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			_, _ = w.Write([]byte("old.example.com\n"))
+			return
+		}
+
+		_, _ = w.Write([]byte("new.example.com\n"))
+		w.(http.Flusher).Flush()
+		startedChan <- struct{}{}
+		<-r.Context().Done()
+	}))
+	t.Cleanup(server.Close)
+
+	storage, err := domaindb.NewFsStorageDriver(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create storage driver: %v", err)
+	}
+
+	db, err = domaindb.NewDomainDb(domaindb.Options{
+		StorageDriver: storage,
+		Logger:        slog.New(slog.DiscardHandler),
+		Sources: map[string]*domaindb.DataSource{
+			"test": {
+				RefreshInterval: time.Hour,
+				Urls:            []*url.URL{mustParseUrl(t, server.URL)},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create DomainDb: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	return db, startedChan
+}
+
+// readCache returns the cached copy of the "test" database.
+func readCache(t *testing.T, db *domaindb.DomainDb) string {
+	t.Helper()
+
+	reader, err := db.ReadRawDatabase("test")
+	if err != nil {
+		t.Fatalf("failed to read cached database: %v", err)
+	}
+	defer func() {
+		_ = reader.Close()
+	}()
+
+	got, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("failed to read cached database: %v", err)
+	}
+	return string(got)
+}
+
+func TestDownload_CancelMidDownload(t *testing.T) {
+	db, started := newHangingTestDb(t)
+	cacheBefore := readCache(t, db)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+
+	err := db.DownloadAndLoadDatabaseContext(ctx, "test")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got err %v, want context.Canceled", err)
+	}
+
+	assertHas(t, db, "old.example.com", true)
+	assertHas(t, db, "new.example.com", false)
+	if got := readCache(t, db); got != cacheBefore {
+		t.Fatalf("got cached database %q, want %q", got, cacheBefore)
+	}
+}
+
+func TestDownload_ContextDeadline(t *testing.T) {
+	db, _ := newHangingTestDb(t)
+	cacheBefore := readCache(t, db)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err := db.DownloadAndLoadDatabaseContext(ctx, "test")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got err %v, want context.DeadlineExceeded", err)
+	}
+
+	assertHas(t, db, "old.example.com", true)
+	if got := readCache(t, db); got != cacheBefore {
+		t.Fatalf("got cached database %q, want %q", got, cacheBefore)
+	}
+}
+
+// archiveTestMembers are the members of the archives served by TestDownload_Archive, in archive order.
+var archiveTestMembers = []struct {
+	name string
+	body string
+}{
+	{"lists/a.txt", "a.example.com"},
+	{"other/b.txt", "b.example.com\n"},
+	{"lists/c.txt", "c.example.com\n"},
+}
+
+func TestDownload_Archive(t *testing.T) {
+	var tarGzipped bytes.Buffer
+	gzipWriter := gzip.NewWriter(&tarGzipped)
+	tarWriter := tar.NewWriter(gzipWriter)
+	_ = tarWriter.WriteHeader(&tar.Header{Name: "lists/", Typeflag: tar.TypeDir, Mode: 0o755})
+	for _, member := range archiveTestMembers {
+		_ = tarWriter.WriteHeader(&tar.Header{Name: member.name, Typeflag: tar.TypeReg, Mode: 0o644, Size: int64(len(member.body))})
+		_, _ = tarWriter.Write([]byte(member.body))
+	}
+	_ = tarWriter.Close()
+	_ = gzipWriter.Close()
+
+	var zipped bytes.Buffer
+	zipWriter := zip.NewWriter(&zipped)
+	_, _ = zipWriter.Create("lists/")
+	for _, member := range archiveTestMembers {
+		w, _ := zipWriter.Create(member.name)
+		_, _ = w.Write([]byte(member.body))
+	}
+	_ = zipWriter.Close()
+
+	transport := domaindbtest.NewTransport()
+	transport.Respond("https://a.test/lists.tar.gz", domaindbtest.Response{Body: tarGzipped.String()})
+	transport.Respond("https://b.test/lists.zip", domaindbtest.Response{Body: zipped.String()})
+
+	newDb := func(src *domaindb.DataSource) (*domaindb.DomainDb, error) {
+		src.RefreshInterval = time.Hour
+
+		db, err := domaindb.NewDomainDb(domaindb.Options{
+			StorageDriver: domaindb.NewMemoryStorageDriver(),
+			Logger:        slog.New(slog.DiscardHandler),
+			HttpClient:    transport.Client(),
+			TempDir:       t.TempDir(),
+			Sources: map[string]*domaindb.DataSource{
+				"test": src,
+			},
+		})
+		if err == nil {
+			t.Cleanup(func() {
+				_ = db.Close()
+			})
+		}
+		return db, err
+	}
+
+	for name, src := range map[string]*domaindb.DataSource{
+		"tar": {
+			Urls:           []*url.URL{mustParseUrl(t, "https://a.test/lists.tar.gz")},
+			Compression:    domaindb.CompressionAuto,
+			Archive:        domaindb.ArchiveFormatTar,
+			ArchiveMembers: []string{"lists/*.txt"},
+		},
+		"zip": {
+			Urls:           []*url.URL{mustParseUrl(t, "https://b.test/lists.zip")},
+			Archive:        domaindb.ArchiveFormatZip,
+			ArchiveMembers: []string{"lists/*.txt"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			db, err := newDb(src)
+			if err != nil {
+				t.Fatalf("failed to create DomainDb: %v", err)
+			}
+
+			// The first member does not end in a newline, so this also checks that members are not joined.
+			assertHas(t, db, "a.example.com", true)
+			assertHas(t, db, "b.example.com", false)
+			assertHas(t, db, "c.example.com", true)
+		})
+	}
+
+	t.Run("padded tar", func(t *testing.T) {
+		// Tar archives created by tar(1) are padded to a 10240 byte record, and the padding is part of the Content-Length.
+		var padded bytes.Buffer
+		tarWriter := tar.NewWriter(&padded)
+		for _, member := range archiveTestMembers {
+			_ = tarWriter.WriteHeader(&tar.Header{Name: member.name, Typeflag: tar.TypeReg, Mode: 0o644, Size: int64(len(member.body))})
+			_, _ = tarWriter.Write([]byte(member.body))
+		}
+		_ = tarWriter.Close()
+		padded.Write(make([]byte, 10240-padded.Len()))
+
+		transport.Respond("https://c.test/lists.tar", domaindbtest.Response{
+			Body:   padded.String(),
+			Header: http.Header{"Content-Length": []string{strconv.Itoa(padded.Len())}},
+		})
+
+		db, err := newDb(&domaindb.DataSource{
+			Urls:           []*url.URL{mustParseUrl(t, "https://c.test/lists.tar")},
+			Archive:        domaindb.ArchiveFormatTar,
+			ArchiveMembers: []string{"lists/*.txt"},
+		})
+		if err != nil {
+			t.Fatalf("failed to create DomainDb: %v", err)
+		}
+
+		assertHas(t, db, "a.example.com", true)
+		assertHas(t, db, "c.example.com", true)
+	})
+
+	t.Run("no matching members", func(t *testing.T) {
+		_, err := newDb(&domaindb.DataSource{
+			Urls:           []*url.URL{mustParseUrl(t, "https://b.test/lists.zip")},
+			Archive:        domaindb.ArchiveFormatZip,
+			ArchiveMembers: []string{"missing.txt"},
+		})
+		if !errors.Is(err, domaindb.ErrNoArchiveMembers) {
+			t.Fatalf("got err %v, want ErrNoArchiveMembers", err)
+		}
+	})
+
+	t.Run("no member patterns", func(t *testing.T) {
+		_, err := newDb(&domaindb.DataSource{
+			Urls:    []*url.URL{mustParseUrl(t, "https://b.test/lists.zip")},
+			Archive: domaindb.ArchiveFormatZip,
+		})
+		if err == nil {
+			t.Fatal("expected error for archive source without member patterns")
+		}
+	})
+}
+
+func TestDownload_ContentEncoding(t *testing.T) {
+	var gzipped bytes.Buffer
+	gzipWriter := gzip.NewWriter(&gzipped)
+	_, _ = gzipWriter.Write([]byte("encoded.example.com\n"))
+	_ = gzipWriter.Close()
+
+	var acceptEncoding atomic.Value
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		acceptEncoding.Store(r.Header.Get("Accept-Encoding"))
+
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			_, _ = w.Write([]byte("plain.example.com\n"))
+			return
+		}
+
+		w.Header().Set("Content-Encoding", "gzip")
+		_, _ = w.Write(gzipped.Bytes())
+	}))
+	t.Cleanup(server.Close)
+
+	newDb := func(options domaindb.Options) *domaindb.DomainDb {
+		options.StorageDriver = domaindb.NewMemoryStorageDriver()
+		options.Logger = slog.New(slog.DiscardHandler)
+		options.Sources = map[string]*domaindb.DataSource{
+			"test": {
+				RefreshInterval: time.Hour,
+				Urls:            []*url.URL{mustParseUrl(t, server.URL)},
+			},
+		}
+
+		db, err := domaindb.NewDomainDb(options)
+		if err != nil {
+			t.Fatalf("failed to create DomainDb: %v", err)
+		}
+		t.Cleanup(func() {
+			_ = db.Close()
+		})
+		return db
+	}
+
+	// A transport that does not request or decode gzip on its own.
+	noCompressionClient := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+
+	t.Run("default client", func(t *testing.T) {
+		db := newDb(domaindb.Options{})
+		assertHas(t, db, "encoded.example.com", true)
+	})
+
+	t.Run("transport without compression", func(t *testing.T) {
+		db := newDb(domaindb.Options{HttpClient: noCompressionClient})
+		if got := acceptEncoding.Load(); got != "gzip" {
+			t.Fatalf("got Accept-Encoding %q, want %q", got, "gzip")
+		}
+		assertHas(t, db, "encoded.example.com", true)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		db := newDb(domaindb.Options{HttpClient: noCompressionClient, DisableHttpCompression: true})
+		if got := acceptEncoding.Load(); got != "" {
+			t.Fatalf("got Accept-Encoding %q, want none", got)
+		}
+		assertHas(t, db, "plain.example.com", true)
+	})
+}
+
+// roundTripFunc is an http.RoundTripper implemented by a function.
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestDownload_RequestIsWellFormed(t *testing.T) {
+	var got *http.Request
+	var gotBody []byte
+	client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		got = req
+		gotBody, _ = io.ReadAll(req.Body)
+
+		return &http.Response{
+			StatusCode:    http.StatusOK,
+			Header:        make(http.Header),
+			Body:          io.NopCloser(strings.NewReader("example.com\n")),
+			ContentLength: -1,
+			Request:       req,
+		}, nil
+	})}
+
+	db, err := domaindb.NewDomainDb(domaindb.Options{
+		StorageDriver: domaindb.NewMemoryStorageDriver(),
+		Logger:        slog.New(slog.DiscardHandler),
+		HttpClient:    client,
+		Sources: map[string]*domaindb.DataSource{
+			"test": {
+				RefreshInterval: time.Hour,
+				Method:          http.MethodPost,
+				Body:            []byte(`{"format":"plain"}`),
+				Urls:            []*url.URL{mustParseUrl(t, "https://a.test/list")},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create DomainDb: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	if got == nil {
+		t.Fatal("expected a request to be made")
+	}
+	if got.Header == nil {
+		t.Fatal("expected request Header map to be non-nil")
+	}
+	if got.Proto != "HTTP/1.1" || got.Host != "a.test" {
+		t.Errorf("got Proto %q and Host %q, want %q and %q", got.Proto, got.Host, "HTTP/1.1", "a.test")
+	}
+	if got.Method != http.MethodPost || string(gotBody) != `{"format":"plain"}` || got.ContentLength != int64(len(gotBody)) || got.GetBody == nil {
+		t.Errorf("got %s request with body %q and Content-Length %d, want POST with the source body", got.Method, gotBody, got.ContentLength)
+	}
+	assertHas(t, db, "example.com", true)
+}
+
+func TestDownload_SourceChangeInvalidatesCache(t *testing.T) {
+	transport := domaindbtest.NewTransport()
+	transport.Respond("https://old.test/list.txt", domaindbtest.Response{Body: "old.example.com\n"})
+	transport.Respond("https://new.test/list.txt", domaindbtest.Response{Body: "new.example.com\n"})
+
+	storage := domaindb.NewMemoryStorageDriver()
+
+	// open creates a DomainDb with a single database named "test" that downloads from srcUrl, and closes it once the test finishes.
+	open := func(srcUrl string, keepCache bool) *domaindb.DomainDb {
+		t.Helper()
+
+		db, err := domaindb.NewDomainDb(domaindb.Options{
+			StorageDriver:           storage,
+			TempDir:                 t.TempDir(),
+			Logger:                  slog.New(slog.DiscardHandler),
+			HttpClient:              transport.Client(),
+			KeepCacheOnSourceChange: keepCache,
+			Sources: map[string]*domaindb.DataSource{
+				"test": {
+					RefreshInterval: time.Hour,
+					Urls:            []*url.URL{mustParseUrl(t, srcUrl)},
+				},
+			},
+		})
+		if err != nil {
+			t.Fatalf("failed to create DomainDb: %v", err)
+		}
+		t.Cleanup(func() {
+			_ = db.Close()
+		})
+
+		return db
+	}
+
+	_ = open("https://old.test/list.txt", false).Close()
+
+	// With KeepCacheOnSourceChange, the cached copy of the old source is still loaded.
+	db := open("https://new.test/list.txt", true)
+	assertHas(t, db, "old.example.com", true)
+	_ = db.Close()
+
+	// Otherwise, the database is downloaded from its new source, since the change is still detected.
+	db = open("https://new.test/list.txt", false)
+	assertHas(t, db, "old.example.com", false)
+	assertHas(t, db, "new.example.com", true)
+	_ = db.Close()
+
+	// Once downloaded, the cached copy matches the sources again.
+	db = open("https://new.test/list.txt", false)
+	if got := transport.RequestCount("https://new.test/list.txt"); got != 1 {
+		t.Fatalf("got %d requests to the new source, want 1", got)
+	}
+	stats, err := db.LastLoadStats("test")
+	if err != nil {
+		t.Fatalf("failed to get load stats: %v", err)
+	}
+	if stats.Source != domaindb.LoadSourceCache {
+		t.Fatalf("got load source %v, want cache", stats.Source)
+	}
+}
+
+func TestDownload_MaxCacheAge(t *testing.T) {
+	const srcUrl = "https://list.test/list.txt"
+	transport := domaindbtest.NewTransport()
+	transport.Respond(srcUrl, domaindbtest.Response{Body: "old.example.com\n"})
+
+	storage := domaindb.NewMemoryStorageDriver()
+
+	// open creates a DomainDb with a single database named "test" that is cached for at most an hour, and closes it once the test finishes.
+	open := func() *domaindb.DomainDb {
+		t.Helper()
+
+		db, err := domaindb.NewDomainDb(domaindb.Options{
+			StorageDriver: storage,
+			TempDir:       t.TempDir(),
+			Logger:        slog.New(slog.DiscardHandler),
+			HttpClient:    transport.Client(),
+			Sources: map[string]*domaindb.DataSource{
+				"test": {
+					RefreshInterval: 24 * time.Hour,
+					MaxCacheAge:     time.Hour,
+					Urls:            []*url.URL{mustParseUrl(t, srcUrl)},
+				},
+			},
+		})
+		if err != nil {
+			t.Fatalf("failed to create DomainDb: %v", err)
+		}
+		t.Cleanup(func() {
+			_ = db.Close()
+		})
+
+		return db
+	}
+
+	// ageCache makes the cached copy look like it was downloaded two hours ago.
+	ageCache := func() {
+		t.Helper()
+
+		checkpoints, err := storage.ReadCheckpoints()
+		if err != nil {
+			t.Fatalf("failed to read checkpoints: %v", err)
+		}
+		chkPnt := checkpoints.Checkpoints["test"]
+		chkPnt.LastUpdatedUnix = time.Now().Add(-2 * time.Hour).Unix()
+		checkpoints.Checkpoints["test"] = chkPnt
+		if err = storage.WriteCheckpoints(checkpoints); err != nil {
+			t.Fatalf("failed to write checkpoints: %v", err)
+		}
+	}
+
+	_ = open().Close()
+	transport.Respond(srcUrl, domaindbtest.Response{Body: "new.example.com\n"})
+
+	// A fresh cache is reused.
+	db := open()
+	assertHas(t, db, "old.example.com", true)
+	if got := transport.RequestCount(srcUrl); got != 1 {
+		t.Fatalf("got %d requests with a fresh cache, want 1", got)
+	}
+	_ = db.Close()
+
+	// A stale cache is downloaded again.
+	ageCache()
+	db = open()
+	assertHas(t, db, "old.example.com", false)
+	assertHas(t, db, "new.example.com", true)
+	if got := transport.RequestCount(srcUrl); got != 2 {
+		t.Fatalf("got %d requests with a stale cache, want 2", got)
+	}
+	stats, err := db.LastLoadStats("test")
+	if err != nil {
+		t.Fatalf("failed to get load stats: %v", err)
+	}
+	if stats.Source != domaindb.LoadSourceDownload {
+		t.Fatalf("got load source %v for a stale cache, want download", stats.Source)
+	}
+	_ = db.Close()
+
+	// If downloading a stale cache fails, the stale cache is loaded anyway.
+	ageCache()
+	transport.Respond(srcUrl, domaindbtest.Response{Err: errors.New("connection refused")})
+	db = open()
+	assertHas(t, db, "new.example.com", true)
+	if got := transport.RequestCount(srcUrl); got != 3 {
+		t.Fatalf("got %d requests with a stale cache whose download fails, want 3", got)
+	}
+}
+
+func TestDownload_SourceTimeout(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			_, _ = w.Write([]byte("old.example.com\n"))
+			return
+		}
+
+		// Later responses stall partway through the body, so the timeout must cover reading it.
+		_, _ = w.Write([]byte("new.example.com\n"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	t.Cleanup(server.Close)
+
+	db, err := domaindb.NewDomainDb(domaindb.Options{
+		StorageDriver: domaindb.NewMemoryStorageDriver(),
+		TempDir:       t.TempDir(),
+		Logger:        slog.New(slog.DiscardHandler),
+		Sources: map[string]*domaindb.DataSource{
+			"test": {
+				RefreshInterval: time.Hour,
+				Timeout:         100 * time.Millisecond,
+				Urls:            []*url.URL{mustParseUrl(t, server.URL)},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create DomainDb: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	startTs := time.Now()
+	err = db.DownloadAndLoadDatabase("test")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got err %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(startTs); elapsed > 5*time.Second {
+		t.Fatalf("download took %v, want it to stop after the source timeout", elapsed)
+	}
+
+	assertHas(t, db, "old.example.com", true)
+	assertHas(t, db, "new.example.com", false)
+}
+
+func TestDownload_ProxyUrl(t *testing.T) {
+	var proxied atomic.Value
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Requests for plain HTTP URLs are sent to the proxy with the full target URL.
+		proxied.Store(r.URL.String())
+		_, _ = w.Write([]byte("example.com\n"))
+	}))
+	t.Cleanup(proxy.Close)
+
+	db, err := domaindb.NewDomainDb(domaindb.Options{
+		StorageDriver: domaindb.NewMemoryStorageDriver(),
+		TempDir:       t.TempDir(),
+		Logger:        slog.New(slog.DiscardHandler),
+		ProxyUrl:      mustParseUrl(t, proxy.URL),
+		Sources: map[string]*domaindb.DataSource{
+			"test": {
+				RefreshInterval: time.Hour,
+				Urls:            []*url.URL{mustParseUrl(t, "http://list.test/list.txt")},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create DomainDb: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	if got, _ := proxied.Load().(string); got != "http://list.test/list.txt" {
+		t.Fatalf("got proxied request for %q, want the source URL", got)
+	}
+	assertHas(t, db, "example.com", true)
+}
@@ -0,0 +1,118 @@
+package domaindb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/termermc/go-domaindb/normalize"
+)
+
+// maxLocalPartSize is the maximum length of the local part of an email address, in bytes, as specified by RFC 5321.
+const maxLocalPartSize = 64
+
+// DatabaseMode determines what kind of entries a database stores.
+type DatabaseMode int
+
+const (
+	// DatabaseModeDomain stores domain names, which are looked up with methods like DomainDb.DoesDbHaveDomain.
+	DatabaseModeDomain DatabaseMode = iota
+
+	// DatabaseModeEmail stores full email addresses, which are looked up with DomainDb.DoesDbHaveEmail.
+	// Each line of the source must contain a single address like "user@example.com".
+	// The domain part is normalized like any other domain, and the local part is normalized according to DataSource.EmailRules.
+	// Wildcard entries are not supported, and domain lookup methods do not match the addresses in email databases.
+	DatabaseModeEmail
+)
+
+// EmailRules determine how the local parts of email addresses are normalized in a database with DatabaseModeEmail.
+// They are applied to both loaded and queried addresses, so matching stays symmetric.
+// The right rules vary by email provider; the zero value only lowercases local parts.
+type EmailRules struct {
+	// If true, local parts are compared case-sensitively.
+	// By default, local parts are lowercased, which matches the behavior of almost all providers even though RFC 5321 allows them to be case-sensitive.
+	CaseSensitiveLocalPart bool
+
+	// If true, plus-addressing tags are removed from local parts, so "user+tag@example.com" is treated as "user@example.com".
+	StripPlusTag bool
+
+	// If true, dots are removed from local parts, so "first.last@example.com" is treated as "firstlast@example.com".
+	// Some providers, such as Gmail, ignore dots in local parts.
+	IgnoreDots bool
+}
+
+// normalizeEmail normalizes an email address.
+// The domain part goes through normalizeAndTransform, and the local part is normalized according to the rules.
+// If the transform drops the domain part, returns an empty string.
+func normalizeEmail(normalizer *normalize.DomainNormalizer, transform func(string) (string, bool), rules EmailRules, email string) (string, error) {
+	email = strings.TrimSpace(email)
+
+	at := strings.LastIndexByte(email, '@')
+	if at < 0 {
+		return "", errors.New("email address has no @")
+	}
+	localPart, domain := email[:at], email[at+1:]
+
+	if rules.StripPlusTag {
+		if plus := strings.IndexByte(localPart, '+'); plus >= 0 {
+			localPart = localPart[:plus]
+		}
+	}
+	if rules.IgnoreDots {
+		localPart = strings.ReplaceAll(localPart, ".", "")
+	}
+	if !rules.CaseSensitiveLocalPart {
+		localPart = strings.ToLower(localPart)
+	}
+
+	if localPart == "" {
+		return "", errors.New("email address has empty local part")
+	}
+	if len(localPart) > maxLocalPartSize {
+		return "", fmt.Errorf("local part length %d exceeds %d bytes", len(localPart), maxLocalPartSize)
+	}
+	if strings.IndexFunc(localPart, func(r rune) bool { return unicode.IsSpace(r) || unicode.IsControl(r) || r == '@' }) >= 0 {
+		return "", fmt.Errorf("local part %q contains invalid characters", localPart)
+	}
+
+	normalizedDomain, err := normalizeAndTransform(normalizer, transform, domain)
+	if err != nil {
+		return "", err
+	}
+	if normalizedDomain == "" {
+		return "", nil
+	}
+
+	return localPart + "@" + normalizedDomain, nil
+}
+
+// DoesDbHaveEmail returns whether an email address was found in the specified email database.
+// The address is normalized according to the database's DataSource.EmailRules before it is looked up.
+// If the database does not exist, returns a NoSuchDatabaseError.
+// If the database is not an email database (see DatabaseModeEmail), returns ErrNotEmailDatabase.
+// If the database has not been initialized, returns a NotInitializedError.
+// If the DomainDb instance has been closed, returns ErrDbClosed.
+func (s *DomainDb) DoesDbHaveEmail(dbName string, email string) (bool, error) {
+	if !s.isRunning.Load() {
+		return false, ErrDbClosed
+	}
+
+	data, has := s.dbs[dbName]
+	if !has {
+		return false, NewNoSuchDatabaseError(dbName)
+	}
+
+	if data.Src.Mode != DatabaseModeEmail {
+		return false, fmt.Errorf(`cannot look up email address in database "%s": %w`, dbName, ErrNotEmailDatabase)
+	}
+
+	normalized, err := normalizeEmail(s.normalizer, s.transform, data.Src.EmailRules, email)
+	if err != nil {
+		return false, err
+	}
+
+	_, found, err := data.lookupNormalized(context.Background(), dbName, normalized)
+	return found, err
+}
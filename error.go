@@ -15,9 +15,46 @@ var ErrDataSourceNoSource = errors.New("data source has no sources: len(Urls) ==
 // ErrAllUrlsFailed is returned when all URLs in a data source failed.
 var ErrAllUrlsFailed = errors.New("all URLs in data source failed")
 
+// ErrContentLengthMismatch is returned when the number of bytes received from a source URL does not match the response's Content-Length header.
+// This usually means the download was truncated.
+var ErrContentLengthMismatch = errors.New("number of bytes received did not match Content-Length")
+
+// ErrTooManyPages is returned when a paginated source has more pages than DataSource.MaxPages.
+var ErrTooManyPages = errors.New("source has too many pages")
+
+// ErrPaginationLoop is returned when a page of a paginated source links to a page that was already downloaded.
+var ErrPaginationLoop = errors.New("source pagination links to an already downloaded page")
+
+// ErrCorruptCheckpoints is returned by StorageDriver.ReadCheckpoints when the saved checkpoints could not be decoded.
+var ErrCorruptCheckpoints = errors.New("saved checkpoints are corrupt")
+
+// ErrTooManyDomains is returned when a database load exceeds DataSource.MaxDomains.
+var ErrTooManyDomains = errors.New("database has too many domains")
+
+// ErrNotEmailDatabase is returned when an email address is looked up in a database that does not have DatabaseModeEmail.
+var ErrNotEmailDatabase = errors.New("database is not an email database")
+
+// ErrCategoryDatabase is returned when a category database is loaded directly, rather than through its source database.
+// See DataSource.Categories.
+var ErrCategoryDatabase = errors.New("database is a category database")
+
+// ErrCategoriesNotWritable is returned when the in-memory contents of a database with DataSource.Categories would have to be written to storage, but its source has a custom DataSource.CategoryParser.
+// Categories can only be written in the format of ParseCommaCategory, which a custom parser may not be able to read back.
+var ErrCategoriesNotWritable = errors.New("categories of database cannot be written in the format of its source")
+
+// ErrNoArchiveMembers is returned when none of the members of a source's archive match DataSource.ArchiveMembers.
+var ErrNoArchiveMembers = errors.New("no archive members matched")
+
+// ErrUnsupportedByStorageDriver is returned when an operation requires an optional interface that the storage driver does not implement, such as DatabaseDeleter or DatabaseLister.
+var ErrUnsupportedByStorageDriver = errors.New("operation is not supported by storage driver")
+
 // ErrDbClosed is returned when an operation is attempted on a closed database.
 var ErrDbClosed = errors.New("domain database closed")
 
+// errDownloadSkipped is returned internally when a download is skipped because of DataSource.MinDownloadInterval.
+// It is never returned by exported functions.
+var errDownloadSkipped = errors.New("download skipped because database was downloaded recently")
+
 // ErrDbNameTooLong is returned when a database name exceeds DbNameMaxSize bytes.
 var ErrDbNameTooLong = fmt.Errorf("database name too long, must be at most %d bytes long", DbNameMaxSize)
 
@@ -120,17 +120,14 @@ func main() {
 	}
 
 	for _, domain := range domains {
-		looksDisposable, err := domainDb.DoesDbHaveDomain(DbDisposable, domain)
+		// CheckDomain normalizes the domain once and checks it against each database.
+		// If you only need to check a single database, you can use DoesDbHaveDomain instead.
+		res, err := domainDb.CheckDomain(domain, DbDisposable, DbDisposableFalsePositive)
 		if err != nil {
 			panic(err)
 		}
 
-		looksLikeFalsePositive, err := domainDb.DoesDbHaveDomain(DbDisposableFalsePositive, domain)
-		if err != nil {
-			panic(err)
-		}
-
-		fmt.Printf("%s looks disposable: %t, looks like false positive: %t\n", domain, looksDisposable, looksLikeFalsePositive)
+		fmt.Printf("%s looks disposable: %t, looks like false positive: %t\n", domain, res[DbDisposable], res[DbDisposableFalsePositive])
 	}
 
 	// In this example, the program terminates.
@@ -0,0 +1,135 @@
+package domaindb
+
+import "context"
+
+// ExplainResult is a report of how a domain matched against every database.
+// It is returned by DomainDb.Explain.
+type ExplainResult struct {
+	// The domain that was explained, as it was passed in.
+	Domain string
+
+	// The normalized form of the domain that was looked up.
+	// Empty if Options.DomainTransform dropped the domain.
+	Normalized string
+
+	// The results for each database, sorted by database name.
+	Databases []DatabaseExplanation
+}
+
+// DatabaseExplanation is the result of matching a domain against a single database.
+type DatabaseExplanation struct {
+	// The database name.
+	Name string
+
+	// Whether the database has been initialized.
+	// Uninitialized databases never match.
+	Initialized bool
+
+	// Whether the database is enabled.
+	// Disabled databases never match.
+	Enabled bool
+
+	// Whether the database's match results are inverted (see DataSource.Negate).
+	Negated bool
+
+	// Whether the domain matched the database.
+	// If Negated is true, this is true only if the domain is not in the list.
+	// If Overridden is true, this is the override's verdict instead.
+	Found bool
+
+	// Whether Found was forced by a temporary override (see DomainDb.AddTemporaryOverride).
+	// MatchType and Matched still describe the list, but matches are not confirmed with DataSource.OnProbableMatch, since lookups do not confirm overridden domains.
+	Overridden bool
+
+	// How the domain matched an entry in the list.
+	// MatchNone if it did not match.
+	// This is not inverted by Negated, so it shows why a negated database did not match.
+	MatchType MatchType
+
+	// The stored entry that the domain matched.
+	// Empty if it did not match.
+	// Like MatchType, this is not inverted by Negated.
+	Matched string
+
+	// The error returned by DataSource.OnProbableMatch while confirming a match, or nil if it succeeded or was not called.
+	// If not nil, Found is false and MatchType is MatchNone.
+	// Always nil if Overridden is true.
+	ConfirmErr error
+}
+
+// Explain matches a domain against every database and returns a report of the results.
+// Unlike the other lookup methods, databases that have not been initialized do not cause an error; they are reported as not initialized instead.
+// This is intended for debugging and diagnostics; use CheckDomain if you only need to know whether the domain matched.
+// If the DomainDb instance has been closed, returns ErrDbClosed.
+func (s *DomainDb) Explain(domain string) (ExplainResult, error) {
+	if !s.isRunning.Load() {
+		return ExplainResult{}, ErrDbClosed
+	}
+
+	normalized, err := s.lookupCache.normalize(s.normalizer, s.transform, domain)
+	if err != nil {
+		return ExplainResult{}, err
+	}
+
+	res := ExplainResult{
+		Domain:     domain,
+		Normalized: normalized,
+		Databases:  make([]DatabaseExplanation, 0, len(s.dbs)),
+	}
+	for _, name := range sortedNames(s.dbs) {
+		res.Databases = append(res.Databases, s.dbs[name].view().explain(name, normalized))
+	}
+
+	return res, nil
+}
+
+// Explain matches a domain against every database as they were at the time the snapshot was taken.
+// See DomainDb.Explain for details.
+func (s *DomainDbSnapshot) Explain(domain string) (ExplainResult, error) {
+	normalized, err := s.lookupCache.normalize(s.normalizer, s.transform, domain)
+	if err != nil {
+		return ExplainResult{}, err
+	}
+
+	res := ExplainResult{
+		Domain:     domain,
+		Normalized: normalized,
+		Databases:  make([]DatabaseExplanation, 0, len(s.dbs)),
+	}
+	for _, name := range sortedNames(s.dbs) {
+		res.Databases = append(res.Databases, s.dbs[name].explain(name, normalized))
+	}
+
+	return res, nil
+}
+
+// explain matches the already-normalized domain against the database.
+func (data snapshotDb) explain(name string, normalized string) DatabaseExplanation {
+	res := DatabaseExplanation{
+		Name:        name,
+		Initialized: data.Has && data.Domains != nil,
+		Enabled:     !data.Disabled,
+		Negated:     data.Negate,
+	}
+	if !res.Initialized || !res.Enabled {
+		return res
+	}
+
+	matched, matchType := matchEntry(data.Domains, data.Matcher, normalized)
+
+	// Overrides take precedence, so like a lookup, the match is not confirmed with DataSource.OnProbableMatch.
+	if present, ok := overrideVerdict(data.Overrides, normalized); ok {
+		res.Matched, res.MatchType = matched, matchType
+		res.Found = present
+		res.Overridden = true
+		return res
+	}
+
+	res.Matched, res.MatchType, res.ConfirmErr = confirmMatch(context.Background(), data.OnProbableMatch, normalized, matched, matchType)
+	res.Found = (res.MatchType != MatchNone) != data.Negate
+	if res.ConfirmErr != nil {
+		res.Found = false
+	}
+
+	return res
+}
@@ -0,0 +1,55 @@
+package domaindb
+
+import (
+	"context"
+	"sync"
+)
+
+// downloadFlight coalesces concurrent downloads of a single database, so that callers that request a download while one is already in progress share its result instead of starting another.
+// The zero value is ready to use.
+type downloadFlight struct {
+	mu   sync.Mutex
+	call *flightCall
+}
+
+// flightCall is a download in progress.
+type flightCall struct {
+	done chan struct{}
+	err  error
+
+	// The number of callers sharing the download, not counting the caller that started it.
+	// Protected by downloadFlight.mu.
+	shared int
+}
+
+// do runs fn, unless a call started by another caller is already in progress, in which case it waits for that call and returns its result instead.
+// If ctx is done before the shared call finishes, returns the context's error without waiting further; the shared call keeps running.
+func (f *downloadFlight) do(ctx context.Context, fn func() error) error {
+	f.mu.Lock()
+	if call := f.call; call != nil {
+		call.shared++
+		f.mu.Unlock()
+
+		select {
+		case <-call.done:
+			return call.err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	call := &flightCall{done: make(chan struct{})}
+	f.call = call
+	f.mu.Unlock()
+
+	defer func() {
+		f.mu.Lock()
+		f.call = nil
+		f.mu.Unlock()
+
+		close(call.done)
+	}()
+
+	call.err = fn()
+	return call.err
+}
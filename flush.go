@@ -0,0 +1,127 @@
+package domaindb
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+)
+
+// Flush writes the current in-memory state of all initialized databases to storage, and saves the checkpoints.
+// Each database is written as a sorted, newline-separated list of its normalized domains, replacing its cached copy.
+// The domains are written after Options.DomainTransform was applied to them, which is why the transform must be idempotent.
+// Domains of databases with DataSource.Categories are written as "domain,category" lines, so that their category databases are restored when the cached copy is loaded.
+// Databases that have not been initialized are skipped, as are category databases, since their domains are part of their source database.
+// Databases whose source has a custom DataSource.CategoryParser cannot be written, so they fail with an error wrapping ErrCategoriesNotWritable.
+//
+// Flush is safe to call concurrently with lookups and updates.
+// If an update finishes while Flush is running, the cached copy of that database may be either the flushed or updated version; both are valid.
+//
+// Only the loaded domains are written.
+// Temporary state, such as databases disabled with SetDatabaseEnabled, is not persisted.
+//
+// Errors for individual databases do not stop the remaining databases from being written; they are joined and returned together.
+// If the DomainDb instance has been closed, returns ErrDbClosed.
+func (s *DomainDb) Flush() error {
+	if !s.isRunning.Load() {
+		return ErrDbClosed
+	}
+
+	var errs []error
+
+	for _, name := range sortedNames(s.dbs) {
+		// Category databases are part of the cached copy of their source database.
+		if s.dbs[name].Parent != "" {
+			continue
+		}
+
+		data := s.dbs[name]
+		view := data.view()
+		if !view.Has || view.Domains == nil {
+			continue
+		}
+		if err := checkCategoriesWritable(name, data.Src); err != nil {
+			errs = append(errs, fmt.Errorf(`failed to flush database with name "%s": %w`, name, err))
+			continue
+		}
+
+		categories := make(map[string]map[string]struct{}, len(data.CategoryDbs))
+		for category, categoryData := range data.CategoryDbs {
+			categories[category] = categoryData.view().Domains
+		}
+
+		if err := s.writeCache(name, newCategorizedDomainSetReader(view.Domains, data.Src.Categories, categories)); err != nil {
+			errs = append(errs, fmt.Errorf(`failed to flush database with name "%s": %w`, name, err))
+		}
+	}
+
+	s.checkpointsMu.Lock()
+	err := s.storage.WriteCheckpoints(s.checkpoints)
+	s.checkpointsMu.Unlock()
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to flush checkpoints: %w", err))
+	}
+
+	return errors.Join(errs...)
+}
+
+// newDomainSetReader returns a reader that produces a sorted, newline-separated list of the domains in the set.
+// The domains are written by a separate goroutine, which stops when the reader is closed.
+// The set must not be modified while it is being read.
+func newDomainSetReader(domains map[string]struct{}) io.ReadCloser {
+	return newCategorizedDomainSetReader(domains, nil, nil)
+}
+
+// newCategorizedDomainSetReader is like newDomainSetReader, but domains that are in the sets of categories are written as a "domain,category" line for each category, in the order of categories.
+// This is the format read by ParseCommaCategory, so the categories are restored when the list is loaded.
+// Domains that are not in any category are written on their own.
+// None of the sets may be modified while they are being read.
+func newCategorizedDomainSetReader(domains map[string]struct{}, categories []string, categorySets map[string]map[string]struct{}) io.ReadCloser {
+	pipeReader, pipeWriter := io.Pipe()
+
+	go func() {
+		w := bufio.NewWriter(pipeWriter)
+		writeLine := func(domain string, category string) error {
+			if _, err := w.WriteString(domain); err != nil {
+				return err
+			}
+			if category != "" {
+				if err := w.WriteByte(','); err != nil {
+					return err
+				}
+				if _, err := w.WriteString(category); err != nil {
+					return err
+				}
+			}
+			return w.WriteByte('\n')
+		}
+
+		for _, domain := range slices.Sorted(maps.Keys(domains)) {
+			categorized := false
+			for _, category := range categories {
+				if _, has := categorySets[category][domain]; !has {
+					continue
+				}
+
+				categorized = true
+				if err := writeLine(domain, category); err != nil {
+					_ = pipeWriter.CloseWithError(err)
+					return
+				}
+			}
+
+			if !categorized {
+				if err := writeLine(domain, ""); err != nil {
+					_ = pipeWriter.CloseWithError(err)
+					return
+				}
+			}
+		}
+
+		_ = pipeWriter.CloseWithError(w.Flush())
+	}()
+
+	return pipeReader
+}
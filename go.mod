@@ -3,8 +3,9 @@ module github.com/termermc/go-domaindb
 go 1.25.1
 
 require (
+	github.com/klauspost/compress v1.20.1
 	github.com/puzpuzpuz/xsync/v4 v4.2.0
 	golang.org/x/net v0.44.0
+	golang.org/x/text v0.29.0
+	golang.org/x/time v0.15.0
 )
-
-require golang.org/x/text v0.29.0 // indirect
@@ -0,0 +1,370 @@
+package domaindb
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/puzpuzpuz/xsync/v4"
+	"github.com/termermc/go-domaindb/normalize"
+)
+
+// LoadFromReader parses a newline-separated list of domains from r and swaps it in as the contents of the database with the specified name.
+// The list is parsed the same way as a downloaded list, using the database's DataSource parsing options.
+// Parsing happens before the swap, so if it fails, the currently loaded contents are kept.
+//
+// This is a one-shot load: it does not persist the list to the StorageDriver or update the database's checkpoint, and it does not affect update scheduling.
+// To persist the loaded contents, call Flush afterward; otherwise a restart reverts to the cached copy, and the next scheduled update replaces them as usual.
+// Use ReplaceDomains instead if you have a slice of domains and want them persisted.
+//
+// If the database does not exist, returns a NoSuchDatabaseError.
+// If the database is a category database (see DataSource.Categories), returns an error wrapping ErrCategoryDatabase.
+// If the DomainDb instance has been closed, returns ErrDbClosed.
+func (s *DomainDb) LoadFromReader(dbName string, r io.Reader) error {
+	if !s.isRunning.Load() {
+		return ErrDbClosed
+	}
+
+	data, has := s.dbs[dbName]
+	if !has {
+		return NewNoSuchDatabaseError(dbName)
+	}
+	if err := checkNotCategoryDb(dbName, data); err != nil {
+		return err
+	}
+
+	if err := s.loadDomainsFromReader(r, dbName, LoadSourceManual); err != nil {
+		return fmt.Errorf(`failed to load database with name "%s" from reader: %w`, dbName, err)
+	}
+
+	return nil
+}
+
+// maxKeptLoadFailures is the maximum number of normalization failures kept by domainSetBuilder.
+// Only the first few failures are kept so that errors that wrap them do not become huge.
+const maxKeptLoadFailures = 10
+
+// domainSetBuilder builds a new domain set for a database from individual entries, and swaps it in once it is complete.
+// It applies the same normalization, limits and accounting regardless of where the entries come from.
+// Create an instance with DomainDb.newDomainSetBuilder.
+type domainSetBuilder struct {
+	s       *DomainDb
+	name    string
+	data    *dbSrcMap
+	startTs time.Time
+
+	domains map[string]struct{}
+
+	// The custom matcher being built, or nil if the source has no DataSource.NewMatcher.
+	// Category databases are not given one.
+	matcher Matcher
+
+	// The domain sets and line counts of each category, if the source has DataSource.Categories.
+	// Both are nil otherwise.
+	categories    map[string]map[string]struct{}
+	categoryLines map[string]int
+
+	// Normalization results of the previous load, and of this load so far.
+	// Both are nil if DataSource.CacheNormalization is false.
+	prevNormCache map[string]string
+	normCache     map[string]string
+
+	// The first normalization failures, up to maxKeptLoadFailures.
+	failures []error
+
+	failureCount   int
+	goodLines      int
+	truncatedLines int
+}
+
+// newDetachedDbSrcMap creates a database for src that is not one of the DomainDb's databases, so that entries can be parsed with src's parsing options without affecting the database with the specified name.
+// If src has categories, the database has category databases, so that category lines are parsed and the builder collects their domains.
+// Custom matchers and normalization caches belong to the configured database, so the detached database uses neither.
+func newDetachedDbSrcMap(name string, src *DataSource) *dbSrcMap {
+	cpy := *src
+	cpy.NewMatcher = nil
+	cpy.CacheNormalization = false
+
+	data := &dbSrcMap{
+		Src: &cpy,
+		Mu:  xsync.NewRBMutex(),
+	}
+	if len(cpy.Categories) > 0 {
+		data.CategoryDbs = make(map[string]*dbSrcMap, len(cpy.Categories))
+		for _, category := range cpy.Categories {
+			data.CategoryDbs[category] = &dbSrcMap{Src: &cpy, Mu: xsync.NewRBMutex(), Parent: name}
+		}
+	}
+
+	return data
+}
+
+// newDomainSetBuilder creates a new domainSetBuilder for the database with the specified name.
+// Assumes the database name exists; checking the database name is the responsibility of the caller.
+func (s *DomainDb) newDomainSetBuilder(name string) *domainSetBuilder {
+	return s.newDomainSetBuilderFor(name, s.dbs[name])
+}
+
+// newDomainSetBuilderFor is like newDomainSetBuilder, but builds the set for data, which does not need to be one of the DomainDb's databases.
+// If it is not, the builder can still be used to parse entries, but it must not be finished.
+func (s *DomainDb) newDomainSetBuilderFor(name string, data *dbSrcMap) *domainSetBuilder {
+	b := &domainSetBuilder{
+		s:       s,
+		name:    name,
+		data:    data,
+		startTs: time.Now(),
+
+		domains:  make(map[string]struct{}, expectedDomainCount(data)),
+		failures: make([]error, 0, maxKeptLoadFailures),
+	}
+
+	if data.Src.NewMatcher != nil {
+		b.matcher = data.Src.NewMatcher()
+	}
+
+	if len(data.CategoryDbs) > 0 {
+		b.categories = make(map[string]map[string]struct{}, len(data.CategoryDbs))
+		b.categoryLines = make(map[string]int, len(data.CategoryDbs))
+		for category := range data.CategoryDbs {
+			b.categories[category] = make(map[string]struct{})
+		}
+	}
+
+	if data.Src.CacheNormalization {
+		tok := data.Mu.RLock()
+		b.prevNormCache = data.NormCache
+		data.Mu.RUnlock(tok)
+
+		b.normCache = make(map[string]string, len(b.prevNormCache))
+	}
+
+	return b
+}
+
+// expectedDomainCount returns the number of unique domains the next load of a database is expected to have, so that its domain set can be allocated up front.
+// Lists usually change little between loads, so the previous load's count is used if there was one, including domains that were removed by deduplication.
+// Otherwise, DataSource.DomainCountHint is used.
+// The result never exceeds DataSource.MaxDomains.
+func expectedDomainCount(data *dbSrcMap) int {
+	tok := data.Mu.RLock()
+	count := data.LastLoad.UniqueDomains + data.LastLoad.DedupedDomains
+	data.Mu.RUnlock(tok)
+
+	if count == 0 {
+		count = max(data.Src.DomainCountHint, 0)
+	}
+	if data.Src.MaxDomains > 0 {
+		count = min(count, data.Src.MaxDomains)
+	}
+
+	return count
+}
+
+// normalizeUncached normalizes and transforms an entry according to the database's mode.
+func (b *domainSetBuilder) normalizeUncached(entry string) (string, error) {
+	if b.data.Src.Mode == DatabaseModeEmail {
+		return normalizeEmail(b.s.normalizer, b.s.transform, b.data.Src.EmailRules, entry)
+	}
+
+	return normalizeAndTransform(b.s.normalizer, b.s.transform, entry)
+}
+
+// normalize normalizes and transforms an entry, using the normalization cache if it is enabled.
+// Failures are not cached, since they are expected to be rare and are reported on every load.
+func (b *domainSetBuilder) normalize(entry string) (string, error) {
+	if b.normCache == nil {
+		return b.normalizeUncached(entry)
+	}
+
+	if normalized, has := b.normCache[entry]; has {
+		return normalized, nil
+	}
+	normalized, has := b.prevNormCache[entry]
+	if !has {
+		var err error
+		normalized, err = b.normalizeUncached(entry)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	b.normCache[entry] = normalized
+	return normalized, nil
+}
+
+// add normalizes an entry and adds it to the set.
+// rawLine is the line the entry came from, which is passed to Options.OnNormalizeFailure.
+// Entries that fail normalization are logged and counted, but do not cause an error.
+// Returns an error only if the load must be aborted, such as when DataSource.MaxDomains is exceeded.
+func (b *domainSetBuilder) add(rawLine string, entry string) error {
+	s := b.s
+	src := b.data.Src
+
+	var category string
+	if b.categories != nil {
+		entry, category = src.parseCategory(entry)
+	}
+
+	// Wildcard entries are stored with their prefix, and only the rest of the entry is normalized.
+	// Email databases do not support wildcards.
+	prefix := ""
+	if src.Mode == DatabaseModeDomain {
+		if rest, wildcard := normalize.CutWildcard(entry); wildcard {
+			prefix = wildcardPrefix
+			entry = rest
+		}
+	}
+
+	// Normalize the domain before putting it into the map.
+	normalized, err := b.normalize(entry)
+	if err != nil {
+		s.logger.Log(context.Background(), slog.LevelError, "failed to normalize domain name",
+			"domain_name", entry,
+			"error", err,
+		)
+		b.failureCount++
+		if s.onNormalizeFailure != nil && b.failureCount <= maxNormalizeFailureCallbacks {
+			s.onNormalizeFailure(b.name, rawLine, err)
+		}
+		if len(b.failures) < maxKeptLoadFailures {
+			b.failures = append(b.failures, fmt.Errorf(`failed to normalize domain name "%s": %w`, entry, err))
+		}
+		return nil
+	}
+
+	if normalized == "" {
+		// Dropped by Options.DomainTransform.
+		return nil
+	}
+	normalized = prefix + normalized
+
+	if src.MaxDomains > 0 && len(b.domains) >= src.MaxDomains {
+		if _, has := b.domains[normalized]; !has {
+			if !src.TruncateAtMaxDomains {
+				return fmt.Errorf(`database has more than %d unique domains (see DataSource.MaxDomains): %w`, src.MaxDomains, ErrTooManyDomains)
+			}
+
+			// Keep going so that the rest of the source is still read and cached, but do not load the domain.
+			b.truncatedLines++
+			return nil
+		}
+	}
+
+	if b.matcher != nil {
+		if _, has := b.domains[normalized]; !has {
+			b.matcher.Add(normalized)
+		}
+	}
+	b.domains[normalized] = struct{}{}
+	if set, has := b.categories[category]; has {
+		set[normalized] = struct{}{}
+		b.categoryLines[category]++
+	}
+
+	b.goodLines++
+
+	return nil
+}
+
+// mostlyFailed returns whether more entries have failed normalization than have succeeded so far.
+func (b *domainSetBuilder) mostlyFailed() bool {
+	return b.failureCount > b.goodLines
+}
+
+// finish swaps the built set in as the database's domains and records the load stats.
+// bytesRead is the number of bytes the entries were read from, if any.
+// The builder must not be used after it is finished.
+func (b *domainSetBuilder) finish(source LoadSource, bytesRead int64) LoadStats {
+	ctx := context.Background()
+	s := b.s
+
+	if b.truncatedLines > 0 {
+		s.logger.Log(ctx, slog.LevelWarn, "database exceeded its max domains and was truncated",
+			"database_name", b.name,
+			"max_domains", b.data.Src.MaxDomains,
+			"truncated_lines", b.truncatedLines,
+		)
+	}
+
+	// An empty list from a source almost always means it is misconfigured or broken, rather than legitimately empty.
+	// Manual loads are exempt because the caller chose the contents.
+	if len(b.domains) == 0 && source != LoadSourceManual {
+		s.logger.Log(ctx, slog.LevelWarn, "database loaded successfully but contains no domains; its source may be misconfigured or broken",
+			"database_name", b.name,
+			"load_source", source.String(),
+			"failed_lines", b.failureCount,
+			"truncated_lines", b.truncatedLines,
+		)
+	}
+
+	uniqueDomains := len(b.domains)
+	deduped := 0
+	if _, has := s.dedupeRanks[b.name]; has {
+		// Held until the new domains are swapped in and removed from later databases.
+		s.dedupeMu.Lock()
+		defer s.dedupeMu.Unlock()
+
+		deduped = s.dedupeEarlier(b.name, b.domains)
+	}
+
+	stats := LoadStats{
+		Source:         source,
+		LoadedAt:       time.Now(),
+		Duration:       time.Since(b.startTs),
+		Bytes:          bytesRead,
+		DomainLines:    b.goodLines,
+		UniqueDomains:  len(b.domains),
+		DuplicateLines: b.goodLines - uniqueDomains,
+		DedupedDomains: deduped,
+		FailedLines:    b.failureCount,
+		TruncatedLines: b.truncatedLines,
+	}
+
+	s.logger.Log(ctx, slog.LevelDebug, "finished loading database",
+		"database_name", b.name,
+		"load_source", stats.Source.String(),
+		"bytes_read", stats.Bytes,
+		"duration", stats.Duration,
+		"domain_lines", stats.DomainLines,
+		"unique_domains", stats.UniqueDomains,
+		"duplicate_lines", stats.DuplicateLines,
+		"deduped_domains", stats.DedupedDomains,
+		"failed_lines", stats.FailedLines,
+		"truncated_lines", stats.TruncatedLines,
+	)
+
+	b.data.Mu.Lock()
+	b.data.Has = true
+	b.data.Domains = b.domains
+	b.data.Matcher = b.matcher
+	b.data.LastLoad = stats
+	if b.normCache != nil {
+		// Entries that were not seen in this load are dropped along with the previous cache.
+		b.data.NormCache = b.normCache
+	}
+	b.data.Mu.Unlock()
+
+	if _, has := s.dedupeRanks[b.name]; has {
+		s.dedupeLater(b.name, b.domains)
+	}
+
+	for category, data := range b.data.CategoryDbs {
+		categoryStats := stats
+		categoryStats.DomainLines = b.categoryLines[category]
+		categoryStats.UniqueDomains = len(b.categories[category])
+		categoryStats.DuplicateLines = categoryStats.DomainLines - categoryStats.UniqueDomains
+		categoryStats.FailedLines = 0
+		categoryStats.TruncatedLines = 0
+		categoryStats.DedupedDomains = 0
+
+		data.Mu.Lock()
+		data.Has = true
+		data.Domains = b.categories[category]
+		data.LastLoad = categoryStats
+		data.Mu.Unlock()
+	}
+
+	return stats
+}
@@ -0,0 +1,128 @@
+package domaindb
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+// benchmarkListSize is the number of domains in the list loaded by the load benchmarks, which is typical of a large blocklist.
+const benchmarkListSize = 200_000
+
+// newBenchmarkList returns a list of benchmarkListSize unique domains, along with a few comments and duplicates like a real list has.
+func newBenchmarkList() string {
+	var sb strings.Builder
+	sb.WriteString("# Benchmark list\n")
+	for i := range benchmarkListSize {
+		_, _ = fmt.Fprintf(&sb, "host-%d.example-%d.com\n", i, i%1000)
+		if i%100 == 0 {
+			_, _ = fmt.Fprintf(&sb, "HOST-%d.example-%d.com\n", i, i%1000)
+		}
+	}
+	return sb.String()
+}
+
+// newBenchmarkDb creates a DomainDb with an empty database named "bench" that is meant to be filled with LoadFromReader.
+func newBenchmarkDb(b *testing.B, domainCountHint int) *DomainDb {
+	b.Helper()
+
+	db, err := NewDomainDb(Options{
+		StorageDriver: NewMemoryStorageDriver(),
+		TempDir:       b.TempDir(),
+		Logger:        slog.New(slog.DiscardHandler),
+		Sources: map[string]*DataSource{
+			"bench": {
+				RefreshInterval: time.Hour,
+				DomainCountHint: domainCountHint,
+				Get: func() (io.ReadCloser, error) {
+					return io.NopCloser(strings.NewReader("")), nil
+				},
+			},
+		},
+	})
+	if err != nil {
+		b.Fatalf("failed to create DomainDb: %v", err)
+	}
+	b.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	return db
+}
+
+// BenchmarkLoadFromReader measures loading a large list.
+// "unsized" loads into a set that grows as domains are added, like a first load without DataSource.DomainCountHint.
+// "reload" loads into a set that is sized from the previous load, like a scheduled update.
+func BenchmarkLoadFromReader(b *testing.B) {
+	list := newBenchmarkList()
+
+	b.Run("unsized", func(b *testing.B) {
+		db := newBenchmarkDb(b, 0)
+		data := db.dbs["bench"]
+
+		b.ReportAllocs()
+		for b.Loop() {
+			// Forget the previous load, so that the set is not sized from it.
+			data.Mu.Lock()
+			data.LastLoad = LoadStats{}
+			data.Mu.Unlock()
+
+			if err := db.LoadFromReader("bench", strings.NewReader(list)); err != nil {
+				b.Fatalf("failed to load: %v", err)
+			}
+		}
+	})
+
+	b.Run("reload", func(b *testing.B) {
+		db := newBenchmarkDb(b, benchmarkListSize)
+
+		b.ReportAllocs()
+		for b.Loop() {
+			if err := db.LoadFromReader("bench", strings.NewReader(list)); err != nil {
+				b.Fatalf("failed to load: %v", err)
+			}
+		}
+	})
+}
+
+func TestExpectedDomainCount(t *testing.T) {
+	db, err := NewDomainDb(Options{
+		StorageDriver: NewMemoryStorageDriver(),
+		TempDir:       t.TempDir(),
+		Logger:        slog.New(slog.DiscardHandler),
+		Sources: map[string]*DataSource{
+			"hinted": {
+				RefreshInterval: time.Hour,
+				DomainCountHint: 1000,
+				MaxDomains:      500,
+				Get: func() (io.ReadCloser, error) {
+					return io.NopCloser(strings.NewReader("a.example.com\nb.example.com\n")), nil
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create DomainDb: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	data := db.dbs["hinted"]
+
+	// After a load, its count takes precedence over the hint.
+	if got := expectedDomainCount(data); got != 2 {
+		t.Fatalf("got %d after load, want 2", got)
+	}
+
+	// Before the first load, the hint is used, limited to MaxDomains.
+	data.Mu.Lock()
+	data.LastLoad = LoadStats{}
+	data.Mu.Unlock()
+	if got := expectedDomainCount(data); got != 500 {
+		t.Fatalf("got %d before first load, want 500", got)
+	}
+}
@@ -0,0 +1,96 @@
+package domaindb
+
+import (
+	"sync/atomic"
+
+	"github.com/puzpuzpuz/xsync/v4"
+	"github.com/termermc/go-domaindb/normalize"
+)
+
+// lookupCache caches the normalized form of queried domains, see Options.LookupCacheSize.
+// Normalization and Options.DomainTransform are deterministic, so entries never become stale and are never evicted.
+// Once the cache is full, new domains are no longer added, so the domains that were queried first, or warmed with DomainDb.WarmCache, stay cached.
+// A nil *lookupCache is valid and caches nothing.
+type lookupCache struct {
+	size    int64
+	count   atomic.Int64
+	entries *xsync.Map[string, string]
+}
+
+// newLookupCache creates a lookupCache that holds up to size domains.
+// If size is 0 or negative, returns nil.
+func newLookupCache(size int) *lookupCache {
+	if size <= 0 {
+		return nil
+	}
+
+	return &lookupCache{
+		size:    int64(size),
+		entries: xsync.NewMap[string, string](),
+	}
+}
+
+// normalize is like normalizeAndTransform, but returns the cached result for domain if there is one, and caches the result otherwise.
+// Failures are not cached, so that invalid input cannot fill the cache.
+func (c *lookupCache) normalize(normalizer *normalize.DomainNormalizer, transform func(string) (string, bool), domain string) (string, error) {
+	if c == nil {
+		return normalizeAndTransform(normalizer, transform, domain)
+	}
+
+	if normalized, has := c.entries.Load(domain); has {
+		return normalized, nil
+	}
+
+	normalized, err := normalizeAndTransform(normalizer, transform, domain)
+	if err != nil {
+		return "", err
+	}
+
+	c.add(domain, normalized)
+	return normalized, nil
+}
+
+// add caches the normalized form of domain, unless the cache is full.
+func (c *lookupCache) add(domain string, normalized string) {
+	// Reserve a slot first, so that concurrent adds cannot overfill the cache.
+	if c.count.Add(1) > c.size {
+		c.count.Add(-1)
+		return
+	}
+
+	if _, loaded := c.entries.LoadOrStore(domain, normalized); loaded {
+		c.count.Add(-1)
+	}
+}
+
+// full returns whether the cache cannot hold any more domains.
+func (c *lookupCache) full() bool {
+	return c.count.Load() >= c.size
+}
+
+// WarmCache normalizes the specified domains and adds them to the lookup cache, so that the first lookups of them are as fast as later ones.
+// This is useful right after startup for the domains that are queried most often.
+// Domains are added in order until the cache is full, and domains that fail normalization are skipped.
+//
+// If Options.LookupCacheSize is 0, there is no lookup cache and this does nothing.
+// If the DomainDb instance has been closed, returns ErrDbClosed.
+func (s *DomainDb) WarmCache(domains []string) error {
+	if !s.isRunning.Load() {
+		return ErrDbClosed
+	}
+
+	if s.lookupCache == nil {
+		return nil
+	}
+
+	for _, domain := range domains {
+		if s.lookupCache.full() {
+			break
+		}
+
+		// Failures are not cached, so the error is not needed.
+		_, _ = s.lookupCache.normalize(s.normalizer, s.transform, domain)
+	}
+
+	return nil
+}
@@ -0,0 +1,150 @@
+package domaindb
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/termermc/go-domaindb/normalize"
+)
+
+// wildcardPrefix is the prefix of wildcard entries.
+// A wildcard entry like "*.gov" matches every subdomain of "gov" at any depth, such as "a.gov" and "a.b.gov", but not "gov" itself.
+const wildcardPrefix = normalize.WildcardPrefix
+
+// MatchType is the way a domain matched an entry in a database.
+type MatchType int
+
+const (
+	// MatchNone means the domain did not match any entry.
+	MatchNone MatchType = iota
+
+	// MatchExact means the domain matched an entry exactly.
+	MatchExact
+
+	// MatchWildcard means the domain matched a wildcard entry, like "*.gov".
+	MatchWildcard
+
+	// MatchCustom means the domain was matched by a custom Matcher (see DataSource.NewMatcher).
+	MatchCustom
+)
+
+func (t MatchType) String() string {
+	switch t {
+	case MatchNone:
+		return "none"
+	case MatchExact:
+		return "exact"
+	case MatchWildcard:
+		return "wildcard"
+	case MatchCustom:
+		return "custom"
+	default:
+		return "unknown"
+	}
+}
+
+// matchDomain looks up an already-normalized domain in a loaded domain set.
+// Returns the stored entry that matched and how it matched.
+// If there was no match, returns MatchNone.
+//
+// Exact entries take precedence over wildcard entries, and more specific wildcard entries take precedence over less specific ones.
+// For example, "a.b.gov" is checked against "a.b.gov", then "*.b.gov", then "*.gov".
+// Precedence only affects which entry is reported as matched; whether the domain matched is the same either way.
+func matchDomain(domains map[string]struct{}, normalized string) (string, MatchType) {
+	if _, has := domains[normalized]; has {
+		return normalized, MatchExact
+	}
+
+	// Build wildcard keys in a stack buffer, since domain names are at most 253 bytes long unless the normalizer raises the limit, in which case append grows the key as needed.
+	// Indexing a map with a converted byte slice does not allocate.
+	var buf [256]byte
+	key := append(buf[:0], wildcardPrefix...)
+	for i := 0; i < len(normalized); i++ {
+		if normalized[i] != '.' {
+			continue
+		}
+
+		key = append(key[:len(wildcardPrefix)], normalized[i+1:]...)
+		if _, has := domains[string(key)]; has {
+			return string(key), MatchWildcard
+		}
+	}
+
+	return "", MatchNone
+}
+
+// matchEntry looks up an already-normalized domain in a loaded database.
+// If matcher is not nil, it decides the match instead of the domain set, and the domain itself is reported as the matched entry.
+// Otherwise, this is the same as matchDomain.
+func matchEntry(domains map[string]struct{}, matcher Matcher, normalized string) (string, MatchType) {
+	if matcher != nil {
+		if matcher.Has(normalized) {
+			return normalized, MatchCustom
+		}
+		return "", MatchNone
+	}
+
+	return matchDomain(domains, normalized)
+}
+
+// confirmMatch asks confirm, which is DataSource.OnProbableMatch, whether a match reported by a custom matcher is real.
+// Other matches are exact, so they are returned as-is, as are all matches if confirm is nil.
+// If confirm denies the match, returns MatchNone.
+// If ctx is done before confirm returns, returns an error wrapping the context's error, and confirm is left running in the background.
+func confirmMatch(ctx context.Context, confirm func(domain string) (bool, error), normalized string, matched string, matchType MatchType) (string, MatchType, error) {
+	if confirm == nil || matchType != MatchCustom {
+		return matched, matchType, nil
+	}
+
+	confirmed, err := callWithContext(ctx, func() (bool, error) {
+		return confirm(normalized)
+	})
+	if err != nil {
+		return "", MatchNone, fmt.Errorf(`failed to confirm probable match of domain "%s" (see DataSource.OnProbableMatch): %w`, normalized, err)
+	}
+	if !confirmed {
+		return "", MatchNone, nil
+	}
+
+	return matched, matchType, nil
+}
+
+// lookupVerdict returns whether a domain that matched an entry with matchType should be treated as found.
+// If negate is true, the verdict is inverted: the domain is found only if it does not match.
+// The stored entry that matched is only returned if the domain was found and negate is false, since a negated database has no entry for the domains it matches.
+func lookupVerdict(matched string, matchType MatchType, negate bool) (string, bool) {
+	if negate {
+		return "", matchType == MatchNone
+	}
+
+	return matched, matchType != MatchNone
+}
+
+// callWithContext calls fn and returns its result, or the context's error if ctx is done first.
+// fn cannot be interrupted, so if ctx is done first, it keeps running in the background and its result is discarded.
+// Contexts that can never be done, like context.Background(), call fn directly.
+func callWithContext(ctx context.Context, fn func() (bool, error)) (bool, error) {
+	if ctx.Done() == nil {
+		return fn()
+	}
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	type result struct {
+		ok  bool
+		err error
+	}
+	resCh := make(chan result, 1)
+	go func() {
+		ok, err := fn()
+		resCh <- result{ok, err}
+	}()
+
+	select {
+	case res := <-resCh:
+		return res.ok, res.err
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+}
@@ -0,0 +1,127 @@
+package domaindb
+
+import (
+	"context"
+	"errors"
+)
+
+// Match describes how a domain was found in a single database.
+// It is returned by DomainDb.MatchAll.
+type Match struct {
+	// The database name.
+	Database string
+
+	// How the domain matched an entry in the database.
+	// MatchNone if the database is negated (see DataSource.Negate), since a negated database matches domains that are not in its list.
+	// MatchExact if the match was forced by a temporary override, since overrides apply to the domain itself.
+	Type MatchType
+
+	// The stored entry that the domain matched, like "*.gov" for a wildcard match.
+	// Empty if the database is negated.
+	// The normalized domain if the match was forced by a temporary override.
+	Matched string
+
+	// Whether the match was forced by a temporary override (see DomainDb.AddTemporaryOverride).
+	Overridden bool
+}
+
+// MatchAll normalizes a domain once and returns the databases it was found in, sorted by database name.
+// Whether a domain is found in a database is the same as with CheckDomain, so disabled databases never match and negated databases match domains that are not in their lists.
+// Unlike CheckDomain, it also returns how the domain matched each database, like Explain, which is useful for classifying domains in a single call.
+// Databases that have not been initialized yet are skipped, so that a single database that failed to load does not break classification with the rest.
+// If none of the databases match, returns an empty slice.
+// If the DomainDb instance has been closed, returns ErrDbClosed.
+func (s *DomainDb) MatchAll(domain string) ([]Match, error) {
+	if !s.isRunning.Load() {
+		return nil, ErrDbClosed
+	}
+
+	normalized, err := s.lookupCache.normalize(s.normalizer, s.transform, domain)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]Match, 0)
+	for _, name := range sortedNames(s.dbs) {
+		match, found, err := s.dbs[name].view().match(context.Background(), name, normalized)
+		if isNotInitialized(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			res = append(res, match)
+		}
+	}
+
+	return res, nil
+}
+
+// MatchAll normalizes a domain once and returns the databases it was found in at the time the snapshot was taken.
+// Databases that were not initialized when the snapshot was taken are skipped.
+// See DomainDb.MatchAll for details.
+func (s *DomainDbSnapshot) MatchAll(domain string) ([]Match, error) {
+	normalized, err := s.lookupCache.normalize(s.normalizer, s.transform, domain)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]Match, 0)
+	for _, name := range s.DatabaseNames() {
+		match, found, err := s.dbs[name].match(context.Background(), name, normalized)
+		if isNotInitialized(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			res = append(res, match)
+		}
+	}
+
+	return res, nil
+}
+
+// match looks up the already-normalized domain in the database.
+// Returns how the domain matched and whether it was found.
+// It is the single implementation of matching behind every lookup, so all lookups follow the same rules: disabled databases never match, temporary overrides take precedence over the list, and matches are inverted if the database is negated.
+// Matches reported by a custom matcher are confirmed with ctx (see confirmMatch).
+// If the database was not initialized, returns a NotInitializedError.
+func (data snapshotDb) match(ctx context.Context, name string, normalized string) (Match, bool, error) {
+	if data.Disabled {
+		return Match{}, false, nil
+	}
+
+	if !data.Has || data.Domains == nil {
+		return Match{}, false, NewNotInitializedError(name)
+	}
+
+	if present, ok := overrideVerdict(data.Overrides, normalized); ok {
+		if !present {
+			return Match{}, false, nil
+		}
+		return Match{Database: name, Type: MatchExact, Matched: overrideMatched(normalized, present), Overridden: true}, true, nil
+	}
+
+	matched, matchType := matchEntry(data.Domains, data.Matcher, normalized)
+	matched, matchType, err := confirmMatch(ctx, data.OnProbableMatch, normalized, matched, matchType)
+	if err != nil {
+		return Match{}, false, err
+	}
+
+	// A negated database is only found if the domain did not match, so matchType is MatchNone whenever it is found.
+	matched, found := lookupVerdict(matched, matchType, data.Negate)
+	if !found {
+		return Match{}, false, nil
+	}
+
+	return Match{Database: name, Type: matchType, Matched: matched}, true, nil
+}
+
+// isNotInitialized returns whether err is a NotInitializedError.
+func isNotInitialized(err error) bool {
+	var notInitialized *NotInitializedError
+	return errors.As(err, &notInitialized)
+}
@@ -0,0 +1,17 @@
+package domaindb
+
+// Matcher is a custom membership backend for a database, such as a specialized data structure or a client of an external service.
+// Configure a database with one using DataSource.NewMatcher.
+// A Matcher may be approximate, such as a Bloom filter, as long as it never misses an entry; use DataSource.OnProbableMatch to confirm its matches.
+//
+// Entries and domains passed to a Matcher are already normalized and transformed.
+// Wildcard entries (see Options.Sources) are passed with their "*." prefix, so a Matcher that supports them must check parent domains in Has itself.
+type Matcher interface {
+	// Add adds an entry while the Matcher is being built.
+	// It is called once for each unique entry of a load, from a single goroutine, before the Matcher is used for lookups.
+	Add(entry string)
+
+	// Has returns whether the domain matches the database.
+	// It is called concurrently from multiple goroutines once the Matcher is in use, but never concurrently with Add.
+	Has(domain string) bool
+}
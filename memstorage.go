@@ -0,0 +1,108 @@
+package domaindb
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"sync"
+	"syscall"
+)
+
+// MemoryStorageDriver implements StorageDriver by storing databases and checkpoints in memory.
+// Nothing is persisted, so every process starts with an empty cache.
+// It is intended for tests, and for short-lived processes that do not benefit from caching.
+// Use NewMemoryStorageDriver to create an instance.
+//
+// It is safe to use a single instance of MemoryStorageDriver across multiple goroutines.
+type MemoryStorageDriver struct {
+	mu          sync.Mutex
+	databases   map[string][]byte
+	checkpoints *AllCheckpoints
+}
+
+// NewMemoryStorageDriver creates a new, empty MemoryStorageDriver.
+func NewMemoryStorageDriver() *MemoryStorageDriver {
+	return &MemoryStorageDriver{
+		databases: make(map[string][]byte),
+	}
+}
+
+func (s *MemoryStorageDriver) WriteDatabase(name string, input io.ReadCloser) error {
+	defer func() {
+		_ = input.Close()
+	}()
+
+	if len(name) > DbNameMaxSize {
+		return ErrDbNameTooLong
+	}
+
+	data, err := io.ReadAll(input)
+	if err != nil {
+		return fmt.Errorf(`failed to read input for writing database "%s": %w`, name, err)
+	}
+
+	s.mu.Lock()
+	s.databases[name] = data
+	s.mu.Unlock()
+
+	return nil
+}
+
+func (s *MemoryStorageDriver) ReadDatabase(name string) (io.ReadCloser, error) {
+	s.mu.Lock()
+	data, has := s.databases[name]
+	s.mu.Unlock()
+
+	if !has {
+		return nil, fmt.Errorf(`no database with name "%s" in memory: %w`, name, syscall.ENOENT)
+	}
+
+	// Stored data is never modified, only replaced, so it can be read without copying it.
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (s *MemoryStorageDriver) DeleteDatabase(name string) error {
+	s.mu.Lock()
+	delete(s.databases, name)
+	s.mu.Unlock()
+
+	return nil
+}
+
+func (s *MemoryStorageDriver) ListDatabases() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return slices.Sorted(maps.Keys(s.databases)), nil
+}
+
+func (s *MemoryStorageDriver) WriteCheckpoints(checkpoints *AllCheckpoints) error {
+	// Copy the checkpoints, because the caller may modify them after this function returns.
+	cpy := &AllCheckpoints{
+		Checkpoints: maps.Clone(checkpoints.Checkpoints),
+	}
+	if cpy.Checkpoints == nil {
+		cpy.Checkpoints = make(map[string]Checkpoint)
+	}
+
+	s.mu.Lock()
+	s.checkpoints = cpy
+	s.mu.Unlock()
+
+	return nil
+}
+
+func (s *MemoryStorageDriver) ReadCheckpoints() (*AllCheckpoints, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.checkpoints == nil {
+		return nil, fmt.Errorf("no checkpoints in memory: %w", syscall.ENOENT)
+	}
+
+	return &AllCheckpoints{
+		Checkpoints: maps.Clone(s.checkpoints.Checkpoints),
+	}, nil
+}
@@ -1,7 +1,10 @@
 package domaindb
 
 import (
+	"context"
 	"io"
+	"maps"
+	"slices"
 
 	"github.com/termermc/go-domaindb/normalize"
 )
@@ -14,6 +17,55 @@ func (n noOpReadCloser) Close() error {
 	return nil
 }
 
+// sortedNames returns the keys of a map keyed by database name, sorted by name.
+// All APIs that enumerate databases use this order, so that their output is stable across calls and processes.
+func sortedNames[V any](m map[string]V) []string {
+	return slices.Sorted(maps.Keys(m))
+}
+
+// countingReader counts the number of bytes read from the underlying reader.
+type countingReader struct {
+	io.Reader
+
+	// The number of bytes read so far.
+	N int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.N += int64(n)
+	return n, err
+}
+
+// spoolWriter writes to the underlying writer until a write fails, and records the error instead of returning it.
+// This lets data be copied to a secondary destination, like a temporary file, without failing the primary read.
+type spoolWriter struct {
+	w io.Writer
+
+	// The first write error, if any.
+	err error
+}
+
+func (w *spoolWriter) Write(p []byte) (int, error) {
+	if w.err == nil {
+		_, w.err = w.w.Write(p)
+	}
+	return len(p), nil
+}
+
+// contextReader fails with the context's error once the context is done, instead of reading from the underlying reader.
+type contextReader struct {
+	ctx context.Context
+	io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.Reader.Read(p)
+}
+
 // NormalizeDomainName normalizes the provided domain name by making it lowercase and converting any non-ASCII characters to ASCII punycode.
 //
 // Deprecated: Use normalize.DomainNormalizer instead.
@@ -3,9 +3,11 @@ package normalize
 import (
 	"errors"
 	"fmt"
+	"net/netip"
 	"strings"
 
 	"golang.org/x/net/idna"
+	"golang.org/x/text/unicode/norm"
 )
 
 // DomainNormalizer normalizes domain names to their canonical form.
@@ -14,42 +16,151 @@ import (
 type DomainNormalizer struct {
 	profile     *idna.Profile
 	dotReplacer *strings.Replacer
+
+	stripWWW    bool
+	allowIP     bool
+	relaxedBidi bool
+
+	maxLabelLength int
+	maxTotalLength int
+}
+
+const (
+	// MaxLabelLength is the maximum length of a label in a normalized domain name, as specified by RFC 1035.
+	// It is the default limit, which can be changed with WithMaxLabelLength.
+	MaxLabelLength = 63
+
+	// MaxTotalLength is the maximum length of a normalized domain name, excluding the trailing dot, as specified by RFC 1035.
+	// It is the default limit, which can be changed with WithMaxTotalLength.
+	MaxTotalLength = 253
+)
+
+// IPAddressError is returned when the input to DomainNormalizer.NormalizeDomain is an IPv4 or IPv6 address literal rather than a domain name.
+// IP addresses are rejected by default; use WithAllowIP to normalize them instead.
+type IPAddressError struct {
+	// The parsed IP address.
+	Addr netip.Addr
+}
+
+func (err *IPAddressError) Error() string {
+	return fmt.Sprintf("input is an IP address, not a domain name: %s", err.Addr)
+}
+
+// Option configures a DomainNormalizer.
+// Pass options to NewDomainNormalizer.
+type Option func(n *DomainNormalizer)
+
+// WithStripWWW makes the normalizer remove a leading "www." label from normalized domain names.
+// The label is only removed if at least two labels remain, so "www.com" is left as-is.
+// This changes matching semantics, so it is disabled by default.
+func WithStripWWW() Option {
+	return func(n *DomainNormalizer) {
+		n.stripWWW = true
+	}
+}
+
+// WithAllowIP makes the normalizer accept IPv4 and IPv6 address literals instead of rejecting them with IPAddressError.
+// Accepted addresses are converted to their canonical textual form, as returned by netip.Addr.String.
+// IPv6 addresses may be enclosed in square brackets, which are removed, and IPv4-mapped IPv6 addresses are converted to IPv4.
+// This is useful for lists that mix IP addresses in with domain names.
+func WithAllowIP() Option {
+	return func(n *DomainNormalizer) {
+		n.allowIP = true
+	}
+}
+
+// WithRelaxedBidi makes the normalizer skip the Bidi Rule (RFC 5893), which rejects labels that mix right-to-left and left-to-right characters in certain ways, such as "aא.com".
+// Some real lists include such domains, so this trades strictness for coverage.
+// Mixed-direction labels can be displayed confusingly, which is why the rule is enforced by default.
+func WithRelaxedBidi() Option {
+	return func(n *DomainNormalizer) {
+		n.relaxedBidi = true
+	}
+}
+
+// WithMaxLabelLength changes the maximum length of a label in a normalized domain name, which is MaxLabelLength by default.
+// If max is 0 or negative, the length of labels is not limited, but empty labels are still rejected.
+// Names with longer labels are not valid DNS names, so this is only useful for databases of names that are never resolved, such as internal naming schemes.
+func WithMaxLabelLength(max int) Option {
+	return func(n *DomainNormalizer) {
+		n.maxLabelLength = max
+	}
+}
+
+// WithMaxTotalLength changes the maximum length of a normalized domain name, which is MaxTotalLength by default.
+// If max is 0 or negative, the length of domain names is not limited.
+// Like WithMaxLabelLength, raising the limit is only useful for names that are never resolved.
+func WithMaxTotalLength(max int) Option {
+	return func(n *DomainNormalizer) {
+		n.maxTotalLength = max
+	}
 }
 
 // NewDomainNormalizer constructs a normalizer with a configured UTS #46 profile.
 // The profile performs Map+Validate for lookup and registration with modern rules.
-func NewDomainNormalizer() *DomainNormalizer {
-	p := idna.New(
-		idna.ValidateForRegistration(),
-		idna.MapForLookup(),
-		idna.BidiRule(),
-		idna.Transitional(false),
-		// Use STD3 rules to prevent underscores and other disallowed runes in ASCII
-		idna.StrictDomainName(true),
-	)
+// Options can be passed to change the default behavior.
+func NewDomainNormalizer(opts ...Option) *DomainNormalizer {
+	n := &DomainNormalizer{
+		maxLabelLength: MaxLabelLength,
+		maxTotalLength: MaxTotalLength,
+	}
+	for _, opt := range opts {
+		opt(n)
+	}
+
+	var profileOpts []idna.Option
+	if n.relaxedBidi {
+		// ValidateForRegistration always enables the Bidi Rule, so enable the rest of its checks individually.
+		profileOpts = []idna.Option{
+			idna.MapForLookup(),
+			idna.ValidateLabels(true),
+			idna.VerifyDNSLength(true),
+			idna.Transitional(false),
+			// Use STD3 rules to prevent underscores and other disallowed runes in ASCII
+			idna.StrictDomainName(true),
+		}
+	} else {
+		profileOpts = []idna.Option{
+			idna.ValidateForRegistration(),
+			idna.MapForLookup(),
+			idna.BidiRule(),
+			idna.Transitional(false),
+			// Use STD3 rules to prevent underscores and other disallowed runes in ASCII
+			idna.StrictDomainName(true),
+		}
+	}
+	if n.maxLabelLength != MaxLabelLength || n.maxTotalLength != MaxTotalLength {
+		// The profile enforces the RFC limits, so leave length checks to NormalizeDomain.
+		// Empty labels are rejected by NormalizeDomain before conversion either way.
+		profileOpts = append(profileOpts, idna.VerifyDNSLength(false))
+	}
+	n.profile = idna.New(profileOpts...)
 
 	// Prebuild replacer for Unicode dot-like characters.
-	dots := strings.NewReplacer(
+	n.dotReplacer = strings.NewReplacer(
 		"。", ".",
 		"．", ".",
 		"｡", ".",
 	)
 
-	return &DomainNormalizer{
-		profile:     p,
-		dotReplacer: dots,
-	}
+	return n
 }
 
 // NormalizeDomain normalizes a domain name:
 // - Trims surrounding whitespace
 // - Maps Unicode dot-like chars to '.'
 // - Strips default-ignorable zero-width/bidi control chars
+// - Applies Unicode NFC normalization, so decomposed and precomposed forms (e.g. "e"+U+0301 and "é") are equivalent
+// - Rejects IPv4 and IPv6 literals with IPAddressError, or canonicalizes them if WithAllowIP was specified
 // - Removes a trailing dot
 // - Applies UTS #46 mapping and ASCII (Punycode) conversion
 // - Lowercases output (ASCII)
-// - Validates total (<=253) and label (1..63) lengths and forbids empty labels
+// - Validates total (<=253) and label (1..63) lengths and forbids empty labels; the limits can be changed with WithMaxTotalLength and WithMaxLabelLength
+// - Removes a leading "www." label if WithStripWWW was specified
 // Returns the normalized ASCII domain without a trailing dot.
+//
+// Single-label names without a dot, such as "localhost" or internal short host names, are accepted and normalized like any other label.
+// They are subject to the same STD3 rules, so a name like "host_1" is still rejected.
 func (n *DomainNormalizer) NormalizeDomain(input string) (string, error) {
 	// Trim typical surrounding whitespace first
 	s := strings.TrimSpace(input)
@@ -66,6 +177,14 @@ func (n *DomainNormalizer) NormalizeDomain(input string) (string, error) {
 		return "", errors.New("empty domain after stripping invisibles")
 	}
 
+	// Compose combining characters, so that decomposed input is handled the same as precomposed input.
+	// This runs after stripping invisibles, since an invisible character between a base character and a combining mark prevents them from composing.
+	s = norm.NFC.String(s)
+
+	if ip, isIP, err := n.checkIP(s); isIP {
+		return ip, err
+	}
+
 	// Remove a single trailing dot if present (FQDN marker)
 	if strings.HasSuffix(s, ".") {
 		s = strings.TrimSuffix(s, ".")
@@ -100,23 +219,135 @@ func (n *DomainNormalizer) NormalizeDomain(input string) (string, error) {
 	}
 	ascii = strings.ToLower(ascii)
 
+	// Catch IP addresses that only became recognizable after mapping (e.g. full-width digits)
+	if ip, isIP, err := n.checkIP(ascii); isIP {
+		return ip, err
+	}
+
 	// Enforce label and total length constraints
 	labels := strings.Split(ascii, ".")
 	for _, lbl := range labels {
-		if l := len(lbl); l == 0 || l > 63 {
-			return "", fmt.Errorf("label %q length %d out of range 1..63", lbl, len(lbl))
+		if len(lbl) == 0 {
+			return "", errors.New("domain contains empty label")
+		}
+		if n.maxLabelLength > 0 && len(lbl) > n.maxLabelLength {
+			return "", fmt.Errorf("label %q length %d out of range 1..%d", lbl, len(lbl), n.maxLabelLength)
 		}
 		if !isLDHOrPunycode(lbl) {
 			return "", fmt.Errorf("label %q contains invalid ASCII characters", lbl)
 		}
 	}
-	if len(ascii) > 253 {
-		return "", fmt.Errorf("domain length %d exceeds 253 characters", len(ascii))
+	if n.maxTotalLength > 0 && len(ascii) > n.maxTotalLength {
+		return "", fmt.Errorf("domain length %d exceeds %d characters", len(ascii), n.maxTotalLength)
+	}
+
+	if n.stripWWW && strings.HasPrefix(ascii, "www.") && strings.Count(ascii, ".") >= 2 {
+		ascii = strings.TrimPrefix(ascii, "www.")
 	}
 
 	return ascii, nil
 }
 
+// DisplayForm normalizes a domain name and converts it to its Unicode form for display to users.
+// Punycode labels are decoded (e.g. "xn--bcher-kva.de" becomes "bücher.de"), and ASCII-only domains are returned in their normalized form.
+// The result is meant for display only; use NormalizeDomain for comparisons and storage.
+func (n *DomainNormalizer) DisplayForm(domain string) (string, error) {
+	ascii, err := n.NormalizeDomain(domain)
+	if err != nil {
+		return "", err
+	}
+
+	uni, err := idna.Display.ToUnicode(ascii)
+	if err != nil {
+		return "", fmt.Errorf("idna toUnicode: %w", err)
+	}
+
+	return uni, nil
+}
+
+// RoundTripEqual normalizes a domain name to its ASCII form, converts that back to Unicode, normalizes the result again, and reports whether both normalized forms are equal.
+// In other words, it checks that normalization is stable for the domain, so that a domain shown to users in its Unicode form still matches when it is entered again.
+// Returns an error only if the domain cannot be normalized in the first place; if the Unicode form cannot be normalized, returns false.
+//
+// With the default options, normalization is expected to be stable for every domain that can be normalized.
+// Domains that are not stable are:
+//   - with WithStripWWW, domains that start with more than one "www." label (e.g. "www.www.example.com" normalizes to "www.example.com", which normalizes to "example.com")
+func (n *DomainNormalizer) RoundTripEqual(domain string) (bool, error) {
+	ascii, err := n.NormalizeDomain(domain)
+	if err != nil {
+		return false, err
+	}
+
+	uni, err := idna.Display.ToUnicode(ascii)
+	if err != nil {
+		return false, nil
+	}
+
+	again, err := n.NormalizeDomain(uni)
+	if err != nil {
+		return false, nil
+	}
+
+	return again == ascii, nil
+}
+
+// WildcardPrefix is the prefix of a domain pattern that matches every subdomain of the domain that follows it, such as "*.example.com".
+const WildcardPrefix = "*."
+
+// CutWildcard removes a leading WildcardPrefix from a domain pattern, after trimming surrounding whitespace.
+// Returns the rest of the pattern, which is not normalized, and whether the prefix was present.
+func CutWildcard(pattern string) (domain string, wildcard bool) {
+	return strings.CutPrefix(strings.TrimSpace(pattern), WildcardPrefix)
+}
+
+// NormalizeDomainPattern normalizes a domain pattern, which is a domain name with an optional leading WildcardPrefix.
+// The domain after the prefix is normalized with NormalizeDomain, and returned without the prefix along with whether the pattern was a wildcard.
+// Only a single leading wildcard label is supported, so patterns like "*.*.example.com" and "a.*.example.com" are rejected, as are wildcard IP addresses.
+func (n *DomainNormalizer) NormalizeDomainPattern(pattern string) (normalized string, wildcard bool, err error) {
+	domain, wildcard := CutWildcard(pattern)
+	if wildcard && strings.TrimSpace(domain) == "" {
+		return "", false, errors.New("wildcard pattern has no domain")
+	}
+
+	normalized, err = n.NormalizeDomain(domain)
+	if err != nil {
+		return "", false, err
+	}
+
+	if wildcard {
+		if _, err := netip.ParseAddr(normalized); err == nil {
+			return "", false, fmt.Errorf("wildcard pattern %q cannot match an IP address", pattern)
+		}
+	}
+
+	return normalized, wildcard, nil
+}
+
+// RoundTripEqual is like DomainNormalizer.RoundTripEqual, using a normalizer with the default options.
+func RoundTripEqual(domain string) (bool, error) {
+	return NewDomainNormalizer().RoundTripEqual(domain)
+}
+
+// checkIP checks whether s is an IP address literal.
+// If it is, returns true along with either the canonical form of the address or an IPAddressError, depending on whether IP addresses are allowed.
+func (n *DomainNormalizer) checkIP(s string) (string, bool, error) {
+	if len(s) > 2 && s[0] == '[' && s[len(s)-1] == ']' {
+		s = s[1 : len(s)-1]
+	}
+
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return "", false, nil
+	}
+	addr = addr.Unmap()
+
+	if !n.allowIP {
+		return "", true, &IPAddressError{Addr: addr}
+	}
+
+	return addr.String(), true, nil
+}
+
 // stripInvisibleChars removes a minimal safe set of default-ignorable and control
 // characters that can be used for obfuscation in domains.
 func stripInvisibleChars(s string) string {
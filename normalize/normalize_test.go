@@ -1,6 +1,7 @@
 package normalize
 
 import (
+	"errors"
 	"testing"
 )
 
@@ -187,6 +188,52 @@ func TestNormalizeDomain_LabelLengthAndTotalLength(t *testing.T) {
 	}
 }
 
+func TestNormalizeDomain_CustomLengthLimits(t *testing.T) {
+	lbl100 := makeStr('a', 100)
+	long := lbl100 + "." + lbl100 + "." + lbl100 + ".com"
+
+	relaxed := NewDomainNormalizer(WithMaxLabelLength(100), WithMaxTotalLength(400))
+	got, err := relaxed.NormalizeDomain(long)
+	if err != nil {
+		t.Fatalf("unexpected err with raised limits: %v", err)
+	}
+	if got != long {
+		t.Fatalf("got %q, want %q", got, long)
+	}
+	if _, err = relaxed.DisplayForm(long); err != nil {
+		t.Fatalf("unexpected err from DisplayForm with raised limits: %v", err)
+	}
+
+	// The raised limits still apply, as does other validation.
+	for _, in := range []string{
+		makeStr('a', 101) + ".com",
+		lbl100 + "." + lbl100 + "." + lbl100 + "." + lbl100 + ".com",
+		"a..com",
+		"ex_ample.com",
+	} {
+		if _, err = relaxed.NormalizeDomain(in); err == nil {
+			t.Fatalf("%q: expected error with raised limits, got nil", in)
+		}
+	}
+
+	unlimited := NewDomainNormalizer(WithMaxLabelLength(0), WithMaxTotalLength(0))
+	huge := makeStr('a', 1000) + "." + makeStr('b', 1000)
+	if _, err = unlimited.NormalizeDomain(huge); err != nil {
+		t.Fatalf("unexpected err without limits: %v", err)
+	}
+
+	lowered := NewDomainNormalizer(WithMaxLabelLength(10), WithMaxTotalLength(20))
+	for in, wantErr := range map[string]bool{
+		"abcdefghij.com":         false,
+		"abcdefghijk.com":        true,
+		"abcdefghij.abcdefg.com": true,
+	} {
+		if _, err = lowered.NormalizeDomain(in); (err != nil) != wantErr {
+			t.Fatalf("%q: got err %v, want error: %v", in, err, wantErr)
+		}
+	}
+}
+
 func TestNormalizeDomain_STD3_UnderscoreRejected(t *testing.T) {
 	n := newN()
 
@@ -282,3 +329,346 @@ func TestNormalizeDomain_SanityCheck(t *testing.T) {
 		}
 	}
 }
+
+func TestNormalizeDomain_SingleLabel(t *testing.T) {
+	n := newN()
+
+	cases := map[string]string{
+		"localhost":   "localhost",
+		"LOCALHOST.":  "localhost",
+		"intranet":    "intranet",
+		"my-host":     "my-host",
+		"b\u00fccher": "xn--bcher-kva",
+	}
+	for in, want := range cases {
+		got, err := n.NormalizeDomain(in)
+		if err != nil {
+			t.Fatalf("%q: unexpected err: %v", in, err)
+		}
+		if got != want {
+			t.Fatalf("%q: got %q, want %q", in, got, want)
+		}
+	}
+
+	// STD3 rules still apply to single labels.
+	if _, err := n.NormalizeDomain("host_1"); err == nil {
+		t.Fatal("expected error for single label with underscore")
+	}
+}
+
+func TestNormalizeDomain_StripWWW(t *testing.T) {
+	n := NewDomainNormalizer(WithStripWWW())
+
+	cases := map[string]string{
+		"www.example.com":     "example.com",
+		"WWW.Example.COM":     "example.com",
+		"www.sub.example.com": "sub.example.com",
+		"example.com":         "example.com",
+		"www.com":             "www.com",
+		"wwwexample.com":      "wwwexample.com",
+	}
+	for in, want := range cases {
+		got, err := n.NormalizeDomain(in)
+		if err != nil {
+			t.Fatalf("%q: unexpected err: %v", in, err)
+		}
+		if got != want {
+			t.Fatalf("%q: got %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestNormalizeDomain_StripWWWDisabledByDefault(t *testing.T) {
+	n := newN()
+
+	got, err := n.NormalizeDomain("www.example.com")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if want := "www.example.com"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestNormalizeDomain_IPRejectedByDefault(t *testing.T) {
+	n := newN()
+
+	cases := []string{
+		"192.168.1.1",
+		"::1",
+		"[::1]",
+		"2001:DB8::1",
+		"::ffff:10.0.0.1",
+		"１９２.１６８.１.１", // full-width digits
+	}
+	for _, in := range cases {
+		_, err := n.NormalizeDomain(in)
+		var ipErr *IPAddressError
+		if !errors.As(err, &ipErr) {
+			t.Fatalf("%q: expected IPAddressError, got %v", in, err)
+		}
+	}
+}
+
+func TestNormalizeDomain_AllowIP(t *testing.T) {
+	n := NewDomainNormalizer(WithAllowIP())
+
+	cases := map[string]string{
+		"192.168.1.1":     "192.168.1.1",
+		" 10.0.0.1 ":      "10.0.0.1",
+		"::1":             "::1",
+		"[::1]":           "::1",
+		"2001:DB8:0:0::1": "2001:db8::1",
+		"::ffff:10.0.0.1": "10.0.0.1",
+		"１９２.１６８.１.１":     "192.168.1.1",
+	}
+	for in, want := range cases {
+		got, err := n.NormalizeDomain(in)
+		if err != nil {
+			t.Fatalf("%q: unexpected err: %v", in, err)
+		}
+		if got != want {
+			t.Fatalf("%q: got %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestNormalizeDomain_NumericLabelsNotIP(t *testing.T) {
+	n := newN()
+
+	// Numeric labels that do not form an IP address are still domain names
+	cases := []string{
+		"123.example.com",
+		"1.2.3.4.example.com",
+	}
+	for _, in := range cases {
+		if _, err := n.NormalizeDomain(in); err != nil {
+			t.Fatalf("%q: unexpected err: %v", in, err)
+		}
+	}
+}
+
+func TestDisplayForm(t *testing.T) {
+	n := newN()
+
+	cases := map[string]string{
+		"xn--bcher-kva.de": "bücher.de",
+		"BÜCHER.DE":        "bücher.de",
+		"Example.COM":      "example.com",
+	}
+	for in, want := range cases {
+		got, err := n.DisplayForm(in)
+		if err != nil {
+			t.Fatalf("%q: unexpected err: %v", in, err)
+		}
+		if got != want {
+			t.Fatalf("%q: got %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestNormalizeDomain_PunycodeAndUnicodeEquivalent(t *testing.T) {
+	n := newN()
+
+	// Each group contains the same domain in different forms, which must all normalize to the first one.
+	groups := [][]string{
+		{"xn--bcher-kva.de", "bücher.de", "BÜCHER.DE", "XN--BCHER-KVA.DE"},
+		{"xn--9ca99n.net", "дé.net", "ДÉ.net"},
+		{"xn--n3h.net", "☃.net", "xn--n3h.NET"},
+	}
+	for _, group := range groups {
+		want := group[0]
+		for _, in := range group {
+			got, err := n.NormalizeDomain(in)
+			if err != nil {
+				t.Fatalf("%q: unexpected err: %v", in, err)
+			}
+			if got != want {
+				t.Fatalf("%q: got %q, want %q", in, got, want)
+			}
+		}
+	}
+}
+
+func TestRoundTripEqual_Stable(t *testing.T) {
+	inputs := []string{
+		"example.com",
+		"Example.COM.",
+		"bücher.de",
+		"xn--bcher-kva.de",
+		"дé.net",
+		"☃.net",
+		"faß.de",
+		"example。com",
+		"exa\u200bmple.com",
+		"www.example.com",
+		"123.example.com",
+	}
+	for _, in := range inputs {
+		stable, err := RoundTripEqual(in)
+		if err != nil {
+			t.Fatalf("%q: unexpected err: %v", in, err)
+		}
+		if !stable {
+			t.Fatalf("%q: expected normalization to be stable", in)
+		}
+	}
+}
+
+func TestRoundTripEqual_Errors(t *testing.T) {
+	if _, err := RoundTripEqual("ex_ample.com"); err == nil {
+		t.Fatal("expected error for invalid domain, got nil")
+	}
+}
+
+func TestRoundTripEqual_StripWWWNotStable(t *testing.T) {
+	n := NewDomainNormalizer(WithStripWWW())
+
+	stable, err := n.RoundTripEqual("www.www.example.com")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if stable {
+		t.Fatal("expected repeated www. labels not to be stable with WithStripWWW")
+	}
+
+	stable, err = n.RoundTripEqual("www.example.com")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if !stable {
+		t.Fatal("expected single www. label to be stable with WithStripWWW")
+	}
+}
+
+func TestNormalizeDomain_BidiRule(t *testing.T) {
+	// Labels that mix right-to-left and left-to-right characters in ways the Bidi Rule rejects
+	mixed := map[string]string{
+		"aא.com":    "xn--a-0hc.com",
+		"1א.com":    "xn--1-0hc.com",
+		"a.א1a.com": "a.xn--1a-uld.com",
+	}
+
+	strict := newN()
+	relaxed := NewDomainNormalizer(WithRelaxedBidi())
+	for in, want := range mixed {
+		if _, err := strict.NormalizeDomain(in); err == nil {
+			t.Fatalf("%q: expected error with Bidi Rule, got nil", in)
+		}
+
+		got, err := relaxed.NormalizeDomain(in)
+		if err != nil {
+			t.Fatalf("%q: unexpected err with relaxed Bidi Rule: %v", in, err)
+		}
+		if got != want {
+			t.Fatalf("%q: got %q, want %q", in, got, want)
+		}
+	}
+
+	// Other validation must still apply
+	for _, in := range []string{"ex_ample.com", "-example.com", "example..com"} {
+		if _, err := relaxed.NormalizeDomain(in); err == nil {
+			t.Fatalf("%q: expected error with relaxed Bidi Rule, got nil", in)
+		}
+	}
+
+	// Pure right-to-left labels are valid either way
+	for _, n := range []*DomainNormalizer{strict, relaxed} {
+		got, err := n.NormalizeDomain("אבג.com")
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		if want := "xn--4dbcd.com"; got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	}
+}
+
+func TestNormalizeDomain_NFCAndNFDEquivalent(t *testing.T) {
+	normalizers := map[string]*DomainNormalizer{
+		"default":      newN(),
+		"relaxed bidi": NewDomainNormalizer(WithRelaxedBidi()),
+		"strip www":    NewDomainNormalizer(WithStripWWW()),
+		"allow ip":     NewDomainNormalizer(WithAllowIP()),
+	}
+
+	cases := []struct {
+		nfc  string
+		nfd  string
+		want string
+	}{
+		{"\u00e9.com", "e\u0301.com", "xn--9ca.com"},
+		{"caf\u00e9.example", "cafe\u0301.example", "xn--caf-dma.example"},
+		{"b\u00fccher.de", "bu\u0308cher.de", "xn--bcher-kva.de"},
+		// Two combining marks on one base character.
+		{"ti\u1ebfng.vn", "tie\u0302\u0301ng.vn", "xn--ting-hv5a.vn"},
+		// A dot-like character right after a combining mark.
+		{"caf\u00e9\u3002example", "cafe\u0301\u3002example", "xn--caf-dma.example"},
+	}
+	for name, n := range normalizers {
+		for _, c := range cases {
+			for _, in := range []string{c.nfc, c.nfd} {
+				got, err := n.NormalizeDomain(in)
+				if err != nil {
+					t.Fatalf("%s: %q: unexpected err: %v", name, in, err)
+				}
+				if got != c.want {
+					t.Fatalf("%s: %q: got %q, want %q", name, in, got, c.want)
+				}
+			}
+		}
+	}
+}
+
+func TestDisplayForm_NFD(t *testing.T) {
+	n := newN()
+
+	got, err := n.DisplayForm("cafe\u0301.example")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if want := "caf\u00e9.example"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestNormalizeDomainPattern(t *testing.T) {
+	n := newN()
+
+	cases := []struct {
+		in       string
+		want     string
+		wildcard bool
+	}{
+		{"Example.COM", "example.com", false},
+		{"*.Example.COM", "example.com", true},
+		{" *.bücher.de. ", "xn--bcher-kva.de", true},
+		{"*.gov", "gov", true},
+	}
+	for _, c := range cases {
+		got, wildcard, err := n.NormalizeDomainPattern(c.in)
+		if err != nil {
+			t.Fatalf("%q: unexpected err: %v", c.in, err)
+		}
+		if got != c.want || wildcard != c.wildcard {
+			t.Fatalf("%q: got (%q, %t), want (%q, %t)", c.in, got, wildcard, c.want, c.wildcard)
+		}
+	}
+
+	for _, in := range []string{"*", "*.", "*. ", "*.*.example.com", "a.*.example.com", "**.example.com"} {
+		if _, _, err := n.NormalizeDomainPattern(in); err == nil {
+			t.Fatalf("%q: expected error", in)
+		}
+	}
+}
+
+func TestNormalizeDomainPattern_IP(t *testing.T) {
+	n := NewDomainNormalizer(WithAllowIP())
+
+	if got, wildcard, err := n.NormalizeDomainPattern("10.0.0.1"); err != nil || got != "10.0.0.1" || wildcard {
+		t.Fatalf("got (%q, %t, %v), want the address without a wildcard", got, wildcard, err)
+	}
+	if _, _, err := n.NormalizeDomainPattern("*.10.0.0.1"); err == nil {
+		t.Fatal("expected error for wildcard IP address")
+	}
+}
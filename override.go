@@ -0,0 +1,209 @@
+package domaindb
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"time"
+)
+
+// overrideSweepInterval is the time between sweeps that remove expired temporary overrides.
+// Expired overrides are ignored by lookups regardless, so this only bounds how long they take up memory.
+const overrideSweepInterval = time.Minute
+
+// temporaryOverride is a verdict for a single domain that takes precedence over a database's list until it expires.
+type temporaryOverride struct {
+	Present   bool
+	ExpiresAt time.Time
+}
+
+// overrideVerdict returns the verdict of the unexpired override for the already-normalized domain, and whether there is one.
+func overrideVerdict(overrides map[string]temporaryOverride, normalized string) (present bool, ok bool) {
+	if len(overrides) == 0 {
+		return false, false
+	}
+
+	override, has := overrides[normalized]
+	if !has || !time.Now().Before(override.ExpiresAt) {
+		return false, false
+	}
+
+	return override.Present, true
+}
+
+// overrideMatched returns the matched entry reported for a domain whose verdict was forced by an override.
+// The domain itself is reported if it is present, since there is no list entry to report.
+func overrideMatched(normalized string, present bool) string {
+	if present {
+		return normalized
+	}
+	return ""
+}
+
+// AddTemporaryOverride forces the result of lookups of a domain in the specified database for the duration of ttl, after which lookups revert to the list.
+// If present is true, the domain is found in the database; otherwise, it is not found, even if it is in the list.
+// This is useful for hotfixing a false positive or false negative without waiting for the source to be fixed.
+// For email databases (see DatabaseModeEmail), domain is an email address instead, which is normalized according to DataSource.EmailRules like the addresses passed to DoesDbHaveEmail.
+//
+// The override applies to the domain exactly, not to its subdomains, and it is not inverted by DataSource.Negate.
+// It applies to every lookup method, including Explain and snapshots taken while it is in effect, but disabled databases still never match.
+// Adding an override for a domain that already has one replaces it.
+// Overrides are kept in memory only, so they do not survive a restart.
+// Expired overrides are removed in the background.
+//
+// If ttl is not positive, returns an error.
+// If the database does not exist, returns a NoSuchDatabaseError.
+// If the DomainDb instance has been closed, returns ErrDbClosed.
+func (s *DomainDb) AddTemporaryOverride(dbName string, domain string, present bool, ttl time.Duration) error {
+	if !s.isRunning.Load() {
+		return ErrDbClosed
+	}
+
+	if ttl <= 0 {
+		return fmt.Errorf("temporary override TTL must be positive, got %s", ttl)
+	}
+
+	data, has := s.dbs[dbName]
+	if !has {
+		return NewNoSuchDatabaseError(dbName)
+	}
+
+	normalized, err := s.normalizeOverride(data, domain)
+	if err != nil {
+		return err
+	}
+	if normalized == "" {
+		return fmt.Errorf(`domain "%s" was dropped by Options.DomainTransform, so it cannot be overridden`, domain)
+	}
+
+	if err := s.startOverrideSweeper(); err != nil {
+		return err
+	}
+
+	expiresAt := time.Now().Add(ttl)
+
+	data.Mu.Lock()
+	// Overrides are copied on write so that snapshots can share them.
+	overrides := maps.Clone(data.Overrides)
+	if overrides == nil {
+		overrides = make(map[string]temporaryOverride, 1)
+	}
+	overrides[normalized] = temporaryOverride{
+		Present:   present,
+		ExpiresAt: expiresAt,
+	}
+	data.Overrides = overrides
+	data.Mu.Unlock()
+
+	s.logger.Log(context.Background(), slog.LevelInfo, "added temporary override",
+		"database_name", dbName,
+		"domain", normalized,
+		"present", present,
+		"expires_at", expiresAt,
+	)
+
+	return nil
+}
+
+// RemoveTemporaryOverride removes the temporary override of a domain in the specified database before it expires, so that lookups revert to the list.
+// Does nothing if the domain has no override.
+// For email databases (see DatabaseModeEmail), domain is an email address instead, like with AddTemporaryOverride.
+// If the database does not exist, returns a NoSuchDatabaseError.
+// If the DomainDb instance has been closed, returns ErrDbClosed.
+func (s *DomainDb) RemoveTemporaryOverride(dbName string, domain string) error {
+	if !s.isRunning.Load() {
+		return ErrDbClosed
+	}
+
+	data, has := s.dbs[dbName]
+	if !has {
+		return NewNoSuchDatabaseError(dbName)
+	}
+
+	normalized, err := s.normalizeOverride(data, domain)
+	if err != nil {
+		return err
+	}
+
+	data.Mu.Lock()
+	defer data.Mu.Unlock()
+
+	if _, has := data.Overrides[normalized]; !has {
+		return nil
+	}
+
+	overrides := maps.Clone(data.Overrides)
+	delete(overrides, normalized)
+	data.Overrides = overrides
+
+	return nil
+}
+
+// normalizeOverride normalizes the domain of an override the same way lookups in the database normalize it, so that the override matches them.
+// For email databases, the domain is an email address, normalized like in DoesDbHaveEmail.
+func (s *DomainDb) normalizeOverride(data *dbSrcMap, domain string) (string, error) {
+	if data.Src.Mode == DatabaseModeEmail {
+		return normalizeEmail(s.normalizer, s.transform, data.Src.EmailRules, domain)
+	}
+
+	return normalizeAndTransform(s.normalizer, s.transform, domain)
+}
+
+// startOverrideSweeper starts the background goroutine that removes expired temporary overrides, if it has not been started yet.
+// It is started lazily, since most instances never add an override.
+// If the DomainDb instance has been closed, returns ErrDbClosed.
+func (s *DomainDb) startOverrideSweeper() error {
+	s.lifecycleMu.Lock()
+	defer s.lifecycleMu.Unlock()
+
+	if !s.isRunning.Load() {
+		return ErrDbClosed
+	}
+	if s.overrideSweeperStarted {
+		return nil
+	}
+	s.overrideSweeperStarted = true
+
+	s.updaters.Go(func() {
+		ticker := time.NewTicker(overrideSweepInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-s.closing:
+				return
+			case now := <-ticker.C:
+				s.sweepOverrides(now)
+			}
+		}
+	})
+
+	return nil
+}
+
+// sweepOverrides removes the temporary overrides that expired at or before now from all databases.
+func (s *DomainDb) sweepOverrides(now time.Time) {
+	for name, data := range s.dbs {
+		data.Mu.Lock()
+		overrides := maps.Clone(data.Overrides)
+		maps.DeleteFunc(overrides, func(_ string, override temporaryOverride) bool {
+			return !now.Before(override.ExpiresAt)
+		})
+		removed := len(data.Overrides) - len(overrides)
+		if removed > 0 {
+			if len(overrides) == 0 {
+				overrides = nil
+			}
+			data.Overrides = overrides
+		}
+		data.Mu.Unlock()
+
+		if removed > 0 {
+			s.logger.Log(context.Background(), slog.LevelDebug, "removed expired temporary overrides",
+				"database_name", name,
+				"removed", removed,
+			)
+		}
+	}
+}
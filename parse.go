@@ -0,0 +1,99 @@
+package domaindb
+
+import (
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+// defaultCommentPrefixes are the comment prefixes used when DataSource.CommentPrefixes is nil.
+var defaultCommentPrefixes = []string{"#"}
+
+// DefaultCommentPrefixes returns the comment prefixes used when DataSource.CommentPrefixes is nil.
+// The returned slice is a copy, so modifying it has no effect.
+func DefaultCommentPrefixes() []string {
+	return slices.Clone(defaultCommentPrefixes)
+}
+
+// commentPrefixes returns the comment prefixes to use for the data source.
+// Returns nil if comments are disabled.
+func (src *DataSource) commentPrefixes() []string {
+	if src.DisableComments {
+		return nil
+	}
+	if src.CommentPrefixes == nil {
+		return defaultCommentPrefixes
+	}
+
+	return src.CommentPrefixes
+}
+
+// parseLine extracts the domain name from a single line of a data source.
+// Returns the domain name to normalize and whether the line contained one.
+// Lines that are empty or are comments do not contain a domain name.
+func (src *DataSource) parseLine(line string) (string, bool) {
+	prefixes := src.commentPrefixes()
+
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return "", false
+	}
+
+	for _, prefix := range prefixes {
+		if prefix != "" && strings.HasPrefix(line, prefix) {
+			return "", false
+		}
+	}
+
+	if src.StripInlineComments && len(prefixes) > 0 {
+		line = strings.TrimSpace(stripInlineComment(line, prefixes))
+		if line == "" {
+			return "", false
+		}
+	}
+
+	if src.FirstTokenOnly {
+		line = firstToken(line)
+	}
+
+	return line, true
+}
+
+// firstToken returns the first whitespace-delimited token of a line that has been trimmed of surrounding whitespace.
+// If the first token is an IP address and is followed by another token, as in a hosts file, the second token is returned instead.
+func firstToken(line string) string {
+	i := strings.IndexAny(line, " \t")
+	if i == -1 {
+		return line
+	}
+
+	token := line[:i]
+	if _, err := netip.ParseAddr(token); err != nil {
+		return token
+	}
+
+	rest := strings.TrimLeft(line[i:], " \t")
+	if j := strings.IndexAny(rest, " \t"); j != -1 {
+		rest = rest[:j]
+	}
+
+	return rest
+}
+
+// stripInlineComment removes a trailing comment from a line.
+// A comment prefix is only treated as the start of an inline comment if it is preceded by whitespace.
+func stripInlineComment(line string, prefixes []string) string {
+	for i := 1; i < len(line); i++ {
+		if line[i-1] != ' ' && line[i-1] != '\t' {
+			continue
+		}
+
+		for _, prefix := range prefixes {
+			if prefix != "" && strings.HasPrefix(line[i:], prefix) {
+				return line[:i]
+			}
+		}
+	}
+
+	return line
+}
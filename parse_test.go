@@ -0,0 +1,105 @@
+package domaindb
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseLine(t *testing.T) {
+	cases := []struct {
+		name string
+		src  DataSource
+		line string
+		want string
+		ok   bool
+	}{
+		{"plain", DataSource{}, "example.com", "example.com", true},
+		{"surrounding whitespace", DataSource{}, " \texample.com \t", "example.com", true},
+		{"empty", DataSource{}, "", "", false},
+		{"whitespace only", DataSource{}, " \t ", "", false},
+		{"default comment", DataSource{}, "# example.com", "", false},
+		{"indented comment", DataSource{}, "   #example.com", "", false},
+		{"inline comment kept by default", DataSource{}, "example.com # note", "example.com # note", true},
+		{"hash inside token", DataSource{}, "example.com#note", "example.com#note", true},
+
+		{"custom prefixes", DataSource{CommentPrefixes: []string{"!", "//"}}, "! note", "", false},
+		{"custom prefixes second", DataSource{CommentPrefixes: []string{"!", "//"}}, "// note", "", false},
+		{"custom prefixes replace default", DataSource{CommentPrefixes: []string{"!"}}, "#example.com", "#example.com", true},
+		{"empty prefixes", DataSource{CommentPrefixes: []string{}}, "# example.com", "# example.com", true},
+		{"empty prefix ignored", DataSource{CommentPrefixes: []string{""}}, "example.com", "example.com", true},
+
+		{"strip inline", DataSource{StripInlineComments: true}, "example.com # note", "example.com", true},
+		{"strip inline tab", DataSource{StripInlineComments: true}, "example.com\t#note", "example.com", true},
+		{"strip inline hash inside token", DataSource{StripInlineComments: true}, "example.com#note", "example.com#note", true},
+		{"strip inline custom prefix", DataSource{StripInlineComments: true, CommentPrefixes: []string{";"}}, "example.com ; note # not a comment", "example.com", true},
+		{"strip inline only uses custom prefixes", DataSource{StripInlineComments: true, CommentPrefixes: []string{"!"}}, "# ! note", "#", true},
+
+		{"first token", DataSource{FirstTokenOnly: true}, "example.com 0.0.0.0", "example.com", true},
+		{"first token tab", DataSource{FirstTokenOnly: true}, "example.com\tblocked", "example.com", true},
+		{"first token single", DataSource{FirstTokenOnly: true}, "example.com", "example.com", true},
+		{"first token comment", DataSource{FirstTokenOnly: true}, "# example.com note", "", false},
+		{"first token keeps inline comment token", DataSource{FirstTokenOnly: true}, "example.com#note extra", "example.com#note", true},
+		{"first token hosts", DataSource{FirstTokenOnly: true}, "0.0.0.0 example.com", "example.com", true},
+		{"first token hosts ipv6", DataSource{FirstTokenOnly: true}, "::1\t example.com", "example.com", true},
+		{"first token hosts aliases", DataSource{FirstTokenOnly: true}, "127.0.0.1 example.com www.example.com", "example.com", true},
+		{"first token lone ip", DataSource{FirstTokenOnly: true}, "0.0.0.0", "0.0.0.0", true},
+		{"first token annotated with ip", DataSource{FirstTokenOnly: true}, "example.com 0.0.0.0 1.1.1.1", "example.com", true},
+
+		{"disable comments", DataSource{DisableComments: true}, "# example.com", "# example.com", true},
+		{"disable comments ignores custom prefixes", DataSource{DisableComments: true, CommentPrefixes: []string{"!"}}, "!example.com", "!example.com", true},
+		{"disable comments ignores strip inline", DataSource{DisableComments: true, StripInlineComments: true}, "example.com # note", "example.com # note", true},
+		{"disable comments first token", DataSource{DisableComments: true, FirstTokenOnly: true}, "#example.com # note", "#example.com", true},
+
+		{"strip inline then first token", DataSource{StripInlineComments: true, FirstTokenOnly: true}, "example.com 0.0.0.0 # note", "example.com", true},
+		{"strip inline then first token hosts", DataSource{StripInlineComments: true, FirstTokenOnly: true}, "0.0.0.0 example.com # ads", "example.com", true},
+		{"strip inline then first token custom prefix", DataSource{StripInlineComments: true, FirstTokenOnly: true, CommentPrefixes: []string{"!"}}, "example.com ! note", "example.com", true},
+	}
+	for _, c := range cases {
+		got, ok := c.src.parseLine(c.line)
+		if got != c.want || ok != c.ok {
+			t.Errorf("%s: parseLine(%q) = (%q, %v), want (%q, %v)", c.name, c.line, got, ok, c.want, c.ok)
+		}
+	}
+}
+
+func TestStripInlineComment(t *testing.T) {
+	cases := []struct {
+		line     string
+		prefixes []string
+		want     string
+	}{
+		{"example.com", []string{"#"}, "example.com"},
+		{"example.com # note", []string{"#"}, "example.com "},
+		{"example.com\t# note", []string{"#"}, "example.com\t"},
+		{"example.com#note", []string{"#"}, "example.com#note"},
+		{"example.com a#b # note", []string{"#"}, "example.com a#b "},
+		{"#note", []string{"#"}, "#note"},
+		{"example.com // note", []string{"#", "//"}, "example.com "},
+		{"example.com / note", []string{"//"}, "example.com / note"},
+		{"example.com # note", []string{""}, "example.com # note"},
+		{"example.com # note", nil, "example.com # note"},
+		{"", []string{"#"}, ""},
+		{" ", []string{"#"}, " "},
+	}
+	for _, c := range cases {
+		if got := stripInlineComment(c.line, c.prefixes); got != c.want {
+			t.Errorf("stripInlineComment(%q, %q) = %q, want %q", c.line, c.prefixes, got, c.want)
+		}
+	}
+}
+
+func TestDefaultCommentPrefixes(t *testing.T) {
+	prefixes := DefaultCommentPrefixes()
+	if !slices.Equal(prefixes, []string{"#"}) {
+		t.Fatalf("got %q, want [#]", prefixes)
+	}
+
+	// Modifying the returned slice does not change the defaults.
+	prefixes[0] = "!"
+	if got, _ := (&DataSource{}).parseLine("# example.com"); got != "" {
+		t.Fatalf("got %q after modifying the returned prefixes, want the line to still be a comment", got)
+	}
+	if got := DefaultCommentPrefixes(); !slices.Equal(got, []string{"#"}) {
+		t.Fatalf("got %q after modifying the returned prefixes, want [#]", got)
+	}
+}
@@ -0,0 +1,36 @@
+package domaindb
+
+import (
+	"context"
+	"log/slog"
+)
+
+// PauseUpdates stops all background downloads until ResumeUpdates is called, without tearing down the DomainDb instance.
+// This is useful during maintenance, such as a storage migration or an incident with a source.
+//
+// While updates are paused, updaters keep their schedule but skip each scheduled update, and databases that failed their initial load with StartupPolicyBestEffort are not retried.
+// Lookups, the loaded domains and manual operations like ReplaceDomains and DownloadAndLoadDatabase are not affected.
+// Updates that are already in progress when PauseUpdates is called are allowed to finish.
+func (s *DomainDb) PauseUpdates() {
+	if s.updatesPaused.Swap(true) {
+		return
+	}
+
+	s.logger.Log(context.Background(), slog.LevelInfo, "paused background updates")
+}
+
+// ResumeUpdates resumes background downloads after PauseUpdates.
+// Updaters resume on their original schedule, so each database is next updated at its next scheduled time rather than immediately; updates that were skipped while paused are not made up for.
+func (s *DomainDb) ResumeUpdates() {
+	if !s.updatesPaused.Swap(false) {
+		return
+	}
+
+	s.logger.Log(context.Background(), slog.LevelInfo, "resumed background updates")
+}
+
+// UpdatesPaused returns whether background updates are paused.
+// See PauseUpdates for details.
+func (s *DomainDb) UpdatesPaused() bool {
+	return s.updatesPaused.Load()
+}
@@ -0,0 +1,73 @@
+package domaindb
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+)
+
+// PreviewUpdate downloads and parses the database with the specified name, and returns how its contents would change if it were loaded, without loading it.
+// added holds the domains in the downloaded list that are not currently loaded, and removed holds the currently loaded domains that are not in the downloaded list.
+// Both are sorted, and wildcard entries appear in their stored form, like "*.gov".
+// If the database has not been initialized yet, every domain in the downloaded list is reported as added.
+//
+// The download is parsed with the same options as a normal load, including Options.Dedupe, but it is not swapped in, written to storage or counted as a download.
+// In particular, it does not affect DataSource.MinDownloadInterval, LastLoadStats or the database's checkpoint.
+// Domains of category databases (see DataSource.Categories) are not included.
+//
+// If the database does not exist, returns a NoSuchDatabaseError.
+// If the database is a category database, returns an error wrapping ErrCategoryDatabase.
+// If the DomainDb instance has been closed, returns ErrDbClosed.
+func (s *DomainDb) PreviewUpdate(name string) (added []string, removed []string, err error) {
+	if !s.isRunning.Load() {
+		return nil, nil, ErrDbClosed
+	}
+
+	data, has := s.dbs[name]
+	if !has {
+		return nil, nil, NewNoSuchDatabaseError(name)
+	}
+	if err = checkNotCategoryDb(name, data); err != nil {
+		return nil, nil, err
+	}
+
+	var parsed map[string]struct{}
+	err = s.downloadDatabase(context.Background(), name, data, func(reader io.Reader) error {
+		builder, _, err := s.parseDomains(reader, name, newDetachedDbSrcMap(name, data.Src))
+		if err != nil {
+			return fmt.Errorf(`failed to parse database with name "%s": %w`, name, err)
+		}
+
+		parsed = builder.domains
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if _, has := s.dedupeRanks[name]; has {
+		s.dedupeMu.Lock()
+		s.dedupeEarlier(name, parsed)
+		s.dedupeMu.Unlock()
+	}
+
+	tok := data.Mu.RLock()
+	current := data.Domains
+	data.Mu.RUnlock(tok)
+
+	for domain := range parsed {
+		if _, has := current[domain]; !has {
+			added = append(added, domain)
+		}
+	}
+	for domain := range current {
+		if _, has := parsed[domain]; !has {
+			removed = append(removed, domain)
+		}
+	}
+	slices.Sort(added)
+	slices.Sort(removed)
+
+	return added, removed, nil
+}
@@ -0,0 +1,90 @@
+package domaindb
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// hostLimiters limits the rate of requests to each host.
+// Limiters are created on demand for each host, and are never removed, since the set of hosts is bounded by the configured sources.
+type hostLimiters struct {
+	limit rate.Limit
+	burst int
+
+	mu       sync.Mutex
+	limiters map[string]*rate.Limiter
+}
+
+// newHostLimiters creates a new hostLimiters instance with the specified number of requests per second and burst size for each host.
+// If requestsPerSecond is not positive, returns nil, which does not limit requests.
+func newHostLimiters(requestsPerSecond float64, burst int) *hostLimiters {
+	if requestsPerSecond <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = 1
+	}
+
+	return &hostLimiters{
+		limit:    rate.Limit(requestsPerSecond),
+		burst:    burst,
+		limiters: make(map[string]*rate.Limiter),
+	}
+}
+
+// get returns the limiter for the specified host, creating it if necessary.
+func (l *hostLimiters) get(host string) *rate.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	limiter, has := l.limiters[host]
+	if !has {
+		limiter = rate.NewLimiter(l.limit, l.burst)
+		l.limiters[host] = limiter
+	}
+
+	return limiter
+}
+
+// waitForHost waits until a request to the URL's host is allowed by Options.HostRequestsPerSecond.
+// Logs when the request is delayed.
+// If ctx is cancelled while waiting, returns the context's error.
+// If the DomainDb instance is closed while waiting, returns ErrDbClosed.
+func (s *DomainDb) waitForHost(ctx context.Context, reqUrl *url.URL) error {
+	if s.hostLimiters == nil {
+		return nil
+	}
+
+	host := reqUrl.Hostname()
+	reservation := s.hostLimiters.get(host).Reserve()
+	delay := reservation.Delay()
+	if delay <= 0 {
+		return nil
+	}
+
+	s.logger.Log(ctx, slog.LevelInfo, "delaying request because of host rate limit",
+		"source_url", reqUrl,
+		"host", host,
+		"delay", delay,
+	)
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		reservation.Cancel()
+		return fmt.Errorf(`stopped waiting for host rate limit of host "%s": %w`, host, ctx.Err())
+	case <-s.closing:
+		reservation.Cancel()
+		return fmt.Errorf(`stopped waiting for host rate limit of host "%s": %w`, host, ErrDbClosed)
+	}
+}
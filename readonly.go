@@ -0,0 +1,26 @@
+package domaindb
+
+// ReadOnlyDomainDb is the subset of DomainDb methods that only read databases.
+// It can be passed to components that should be able to perform lookups, but should not be able to trigger downloads, mutate databases, or close the instance.
+//
+// Both DomainDb and DomainDbSnapshot implement ReadOnlyDomainDb.
+type ReadOnlyDomainDb interface {
+	// DoesDbHaveDomain returns whether a domain was found in the specified domain database.
+	// See DomainDb.DoesDbHaveDomain for details.
+	DoesDbHaveDomain(dbName string, domain string) (bool, error)
+
+	// CheckDomain normalizes a domain once and returns whether it was found in each of the specified domain databases.
+	// See DomainDb.CheckDomain for details.
+	CheckDomain(domain string, dbNames ...string) (map[string]bool, error)
+
+	// LookupDomain returns the stored entry in the specified domain database that the domain matched, and whether it matched at all.
+	// See DomainDb.LookupDomain for details.
+	LookupDomain(dbName string, domain string) (matched string, found bool, err error)
+
+	// Explain matches a domain against every database and returns a report of the results.
+	// See DomainDb.Explain for details.
+	Explain(domain string) (ExplainResult, error)
+}
+
+var _ ReadOnlyDomainDb = (*DomainDb)(nil)
+var _ ReadOnlyDomainDb = (*DomainDbSnapshot)(nil)
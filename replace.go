@@ -0,0 +1,81 @@
+package domaindb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// ReplaceDomains atomically replaces the entire contents of the database with the specified name.
+// The domains are normalized the same way as lines of a downloaded list, including wildcard entries and Options.DomainTransform.
+// The new set is built before it is swapped in, so concurrent lookups see either the old contents or the new contents, never a mix.
+//
+// Domains that fail normalization are logged and skipped rather than failing the whole replacement.
+// The number of failures is available as LoadStats.FailedLines from LastLoadStats.
+// DataSource.MaxDomains still applies.
+// If the database has DataSource.Categories, each domain may be followed by its category, like a line of the list (see DataSource.CategoryParser), and its category databases are replaced too.
+//
+// The new contents are also written to storage and the database's checkpoint is updated, so a restart does not revert to the previous cached copy.
+// Like with Flush, they are written after Options.DomainTransform was applied to them.
+// If writing to storage fails, the in-memory contents are still replaced and the error is returned.
+// Note that the next scheduled update, if any, replaces the contents with a freshly downloaded list as usual.
+//
+// If the database does not exist, returns a NoSuchDatabaseError.
+// If the database has categories and its source has a custom DataSource.CategoryParser, the categories cannot be written to storage, so returns an error wrapping ErrCategoriesNotWritable without replacing anything.
+// If the database is a category database, returns an error wrapping ErrCategoryDatabase.
+// If the DomainDb instance has been closed, returns ErrDbClosed.
+func (s *DomainDb) ReplaceDomains(dbName string, domains []string) error {
+	if !s.isRunning.Load() {
+		return ErrDbClosed
+	}
+
+	data, has := s.dbs[dbName]
+	if !has {
+		return NewNoSuchDatabaseError(dbName)
+	}
+	if err := checkNotCategoryDb(dbName, data); err != nil {
+		return err
+	}
+	if err := checkCategoriesWritable(dbName, data.Src); err != nil {
+		return err
+	}
+
+	builder := s.newDomainSetBuilder(dbName)
+	for _, domain := range domains {
+		if err := builder.add(domain, strings.TrimSpace(domain)); err != nil {
+			return fmt.Errorf(`failed to replace domains of database with name "%s": %w`, dbName, err)
+		}
+	}
+	newDomains := builder.domains
+	newCategories := builder.categories
+	stats := builder.finish(LoadSourceManual, 0)
+
+	if stats.FailedLines > 0 {
+		s.logger.Log(context.Background(), slog.LevelWarn, "some domains failed normalization while replacing database contents",
+			"database_name", dbName,
+			"failed_domains", stats.FailedLines,
+		)
+	}
+
+	var errs []error
+
+	if err := s.writeCache(dbName, newCategorizedDomainSetReader(newDomains, data.Src.Categories, newCategories)); err != nil {
+		errs = append(errs, fmt.Errorf(`failed to write replaced database with name "%s" to storage: %w`, dbName, err))
+	}
+
+	s.checkpointsMu.Lock()
+	s.checkpoints.Checkpoints[dbName] = Checkpoint{
+		LastUpdatedUnix:   time.Now().Unix(),
+		SourceFingerprint: data.SourceFingerprint,
+	}
+	err := s.storage.WriteCheckpoints(s.checkpoints)
+	s.checkpointsMu.Unlock()
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to save checkpoints after replacing database: %w", err))
+	}
+
+	return errors.Join(errs...)
+}
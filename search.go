@@ -0,0 +1,53 @@
+package domaindb
+
+import (
+	"slices"
+	"strings"
+)
+
+// SearchDomains returns the domains in the specified domain database that contain the query, sorted alphabetically.
+// At most limit domains are returned; if limit is 0 or less, all matching domains are returned.
+//
+// Domains are stored in their normalized ASCII (Punycode) form, so the query is only lowercased and is matched against that form.
+// To search for a suffix, include the leading dot in the query (e.g. ".example.com").
+//
+// This performs a linear scan over every domain in the database, so it is meant for admin and investigation workflows, not for hot paths.
+// If the database does not exist, returns a NoSuchDatabaseError.
+// If the database has not been initialized, returns a NotInitializedError.
+// If the DomainDb instance has been closed, returns ErrDbClosed.
+func (s *DomainDb) SearchDomains(dbName string, query string, limit int) ([]string, error) {
+	if !s.isRunning.Load() {
+		return nil, ErrDbClosed
+	}
+
+	data, has := s.dbs[dbName]
+	if !has {
+		return nil, NewNoSuchDatabaseError(dbName)
+	}
+
+	tok := data.Mu.RLock()
+	loaded := data.Has
+	domains := data.Domains
+	data.Mu.RUnlock(tok)
+
+	if !loaded {
+		return nil, NewNotInitializedError(dbName)
+	}
+
+	query = strings.ToLower(strings.TrimSpace(query))
+
+	res := make([]string, 0)
+	for domain := range domains {
+		if strings.Contains(domain, query) {
+			res = append(res, domain)
+		}
+	}
+
+	// Sort before truncating so that the same results are returned for the same query.
+	slices.Sort(res)
+	if limit > 0 && len(res) > limit {
+		res = res[:limit]
+	}
+
+	return res, nil
+}
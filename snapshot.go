@@ -0,0 +1,167 @@
+package domaindb
+
+import (
+	"context"
+	"time"
+
+	"github.com/termermc/go-domaindb/normalize"
+)
+
+type snapshotDb struct {
+	Has      bool
+	Disabled bool
+	Negate   bool
+	Domains  map[string]struct{}
+	Matcher  Matcher
+
+	// See DataSource.OnProbableMatch.
+	OnProbableMatch func(domain string) (bool, error)
+
+	// Shared with the dbSrcMap, see dbSrcMap.Overrides.
+	Overrides map[string]temporaryOverride
+}
+
+// view returns the current state of the database as a snapshotDb.
+func (data *dbSrcMap) view() snapshotDb {
+	tok := data.Mu.RLock()
+	defer data.Mu.RUnlock(tok)
+
+	return snapshotDb{
+		Has:      data.Has,
+		Disabled: data.Disabled.Load(),
+		Negate:   data.Src.Negate,
+		Domains:  data.Domains,
+		Matcher:  data.Matcher,
+
+		OnProbableMatch: data.Src.OnProbableMatch,
+
+		Overrides: data.Overrides,
+	}
+}
+
+// DomainDbSnapshot is an immutable point-in-time copy of all databases in a DomainDb.
+// Background updates to the DomainDb it was created from are not visible in the snapshot.
+//
+// Create an instance with DomainDb.Snapshot.
+// It is safe to use a single instance of DomainDbSnapshot across multiple goroutines.
+type DomainDbSnapshot struct {
+	normalizer *normalize.DomainNormalizer
+	transform  func(string) (string, bool)
+	takenAt    time.Time
+
+	// Shared with the DomainDb, see Options.LookupCacheSize.
+	lookupCache *lookupCache
+
+	dbs map[string]snapshotDb
+}
+
+// Snapshot returns an immutable point-in-time copy of all databases.
+// The snapshot shares the underlying domain sets with the DomainDb rather than copying them, because domain sets are never modified after they are loaded; updates replace them instead.
+// Lookups on the snapshot do not contend with locks on the DomainDb.
+// If the DomainDb instance has been closed, returns ErrDbClosed.
+func (s *DomainDb) Snapshot() (*DomainDbSnapshot, error) {
+	if !s.isRunning.Load() {
+		return nil, ErrDbClosed
+	}
+
+	dbs := make(map[string]snapshotDb, len(s.dbs))
+	for name, data := range s.dbs {
+		dbs[name] = data.view()
+	}
+
+	return &DomainDbSnapshot{
+		normalizer: s.normalizer,
+		transform:  s.transform,
+		takenAt:    time.Now(),
+
+		lookupCache: s.lookupCache,
+
+		dbs: dbs,
+	}, nil
+}
+
+// TakenAt returns the time the snapshot was taken.
+func (s *DomainDbSnapshot) TakenAt() time.Time {
+	return s.takenAt
+}
+
+// DatabaseNames returns the names of all databases in the snapshot, sorted by name.
+func (s *DomainDbSnapshot) DatabaseNames() []string {
+	return sortedNames(s.dbs)
+}
+
+// DoesDbHaveDomain returns whether a domain was found in the specified domain database at the time the snapshot was taken.
+// If the database does not exist, returns a NoSuchDatabaseError.
+// If the database had not been initialized when the snapshot was taken, returns a NotInitializedError.
+func (s *DomainDbSnapshot) DoesDbHaveDomain(dbName string, domain string) (bool, error) {
+	data, has := s.dbs[dbName]
+	if !has {
+		return false, NewNoSuchDatabaseError(dbName)
+	}
+
+	normalized, err := s.lookupCache.normalize(s.normalizer, s.transform, domain)
+	if err != nil {
+		return false, err
+	}
+
+	_, found, err := data.lookupNormalized(dbName, normalized)
+	return found, err
+}
+
+// LookupDomain returns the stored entry in the specified domain database that the domain matched at the time the snapshot was taken.
+// See DomainDb.LookupDomain for details.
+func (s *DomainDbSnapshot) LookupDomain(dbName string, domain string) (matched string, found bool, err error) {
+	data, has := s.dbs[dbName]
+	if !has {
+		return "", false, NewNoSuchDatabaseError(dbName)
+	}
+
+	normalized, err := s.lookupCache.normalize(s.normalizer, s.transform, domain)
+	if err != nil {
+		return "", false, err
+	}
+
+	return data.lookupNormalized(dbName, normalized)
+}
+
+// CheckDomain normalizes a domain once and returns whether it was found in each of the specified domain databases at the time the snapshot was taken.
+// See DomainDb.CheckDomain for details.
+func (s *DomainDbSnapshot) CheckDomain(domain string, dbNames ...string) (map[string]bool, error) {
+	if len(dbNames) == 0 {
+		dbNames = s.DatabaseNames()
+	}
+
+	for _, name := range dbNames {
+		if _, has := s.dbs[name]; !has {
+			return nil, NewNoSuchDatabaseError(name)
+		}
+	}
+
+	normalized, err := s.lookupCache.normalize(s.normalizer, s.transform, domain)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make(map[string]bool, len(dbNames))
+	for _, name := range dbNames {
+		_, has, err := s.dbs[name].lookupNormalized(name, normalized)
+		if err != nil {
+			return nil, err
+		}
+
+		res[name] = has
+	}
+
+	return res, nil
+}
+
+// lookupNormalized looks up the already-normalized domain in the database.
+// Returns the stored entry that matched and whether there was a match.
+// Databases that were disabled when the snapshot was taken never match.
+// Temporary overrides that were in effect when the snapshot was taken take precedence over the list until they expire.
+// Matches are inverted if the database's source has DataSource.Negate set.
+// If the database was not initialized, returns a NotInitializedError.
+func (data snapshotDb) lookupNormalized(name string, normalized string) (string, bool, error) {
+	match, found, err := data.match(context.Background(), name, normalized)
+	return match.Matched, found, err
+}
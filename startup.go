@@ -0,0 +1,56 @@
+package domaindb
+
+// StartupPolicy determines how NewDomainDb handles databases that fail their initial load.
+// See Options.StartupPolicy.
+type StartupPolicy int
+
+const (
+	// StartupPolicyDefault is StartupPolicyFailOnAnyError, unless the deprecated Options.PartialInit is true, in which case it is StartupPolicyBestEffort.
+	StartupPolicyDefault StartupPolicy = iota
+
+	// StartupPolicyFailOnAnyError makes NewDomainDb fail if any database fails its initial load.
+	StartupPolicyFailOnAnyError
+
+	// StartupPolicyBestEffort makes NewDomainDb succeed with whichever databases loaded, and retry the rest in the background.
+	// Databases that failed stay uninitialized, so lookups in them return NotInitializedError, while the databases that loaded successfully serve lookups normally.
+	// They are retried every Options.PartialInitRetryInterval until they load, after which they are updated on their normal schedule.
+	// This keeps a failure of a non-critical list from taking down the whole service.
+	// Failed loads are logged as warnings.
+	// Use DomainDb.NotReadyDatabases to find out which databases have not loaded yet.
+	StartupPolicyBestEffort
+)
+
+func (p StartupPolicy) String() string {
+	switch p {
+	case StartupPolicyDefault:
+		return "default"
+	case StartupPolicyFailOnAnyError:
+		return "fail_on_any_error"
+	case StartupPolicyBestEffort:
+		return "best_effort"
+	default:
+		return "unknown"
+	}
+}
+
+// NotReadyDatabases returns the names of databases that have not been initialized yet, sorted by name.
+// Lookups in these databases return NotInitializedError.
+// With StartupPolicyBestEffort, these are the databases that failed their initial load and are still being retried.
+// Databases are also not ready while they are loading for the first time with Options.LoadDatabasesInBackground.
+// Returns an empty slice once every database is ready.
+func (s *DomainDb) NotReadyDatabases() []string {
+	notReady := make([]string, 0)
+	for _, name := range sortedNames(s.dbs) {
+		data := s.dbs[name]
+
+		tok := data.Mu.RLock()
+		has := data.Has
+		data.Mu.RUnlock(tok)
+
+		if !has {
+			notReady = append(notReady, name)
+		}
+	}
+
+	return notReady
+}
@@ -0,0 +1,212 @@
+package domaindb
+
+import (
+	"time"
+)
+
+// LoadSource is where a database was loaded from.
+type LoadSource int
+
+const (
+	// LoadSourceNone means the database has not been loaded.
+	LoadSourceNone LoadSource = iota
+
+	// LoadSourceCache means the database was loaded from its cached copy in storage.
+	LoadSourceCache
+
+	// LoadSourceDownload means the database was freshly downloaded from its data source.
+	LoadSourceDownload
+
+	// LoadSourceManual means the database's contents were provided directly by the caller, such as with DomainDb.ReplaceDomains or DomainDb.LoadFromReader.
+	LoadSourceManual
+)
+
+func (src LoadSource) String() string {
+	switch src {
+	case LoadSourceNone:
+		return "none"
+	case LoadSourceCache:
+		return "cache"
+	case LoadSourceDownload:
+		return "download"
+	case LoadSourceManual:
+		return "manual"
+	default:
+		return "unknown"
+	}
+}
+
+// LoadStats are statistics about a single load of a database.
+// They can be used as a data quality signal; for example, a sudden drop in the ratio of unique domains to domain lines can indicate a regression in a source.
+type LoadStats struct {
+	// Where the database was loaded from.
+	// Useful for diagnosing stale data (loaded from an old cache) or slow startups (downloaded everything).
+	Source LoadSource
+
+	// The time the load finished.
+	LoadedAt time.Time
+
+	// How long the load took.
+	// For downloads, this includes the time spent downloading, because data is parsed as it is downloaded.
+	Duration time.Duration
+
+	// The number of bytes that were read.
+	Bytes int64
+
+	// The number of lines that contained a valid domain name.
+	// Empty lines, comments and lines that failed normalization are not counted.
+	DomainLines int
+
+	// The number of unique domains that were loaded.
+	UniqueDomains int
+
+	// The number of domain lines that were collapsed because they normalized to a domain that was already loaded.
+	// This includes lines that are the same domain in a different form, such as the Unicode and Punycode forms of an internationalized domain name; only the normalized (Punycode) form is stored.
+	// This is DomainLines - UniqueDomains, minus DedupedDomains when the database was loaded.
+	DuplicateLines int
+
+	// The number of unique domains that were not loaded, or were later removed, because they are in an earlier database in Options.Dedupe.
+	// These domains are not counted in UniqueDomains.
+	DedupedDomains int
+
+	// The number of lines that failed normalization.
+	FailedLines int
+
+	// The number of domain lines that were not loaded because the database reached DataSource.MaxDomains.
+	// Only non-zero if DataSource.TruncateAtMaxDomains is true.
+	// These lines are not counted in DomainLines.
+	TruncatedLines int
+}
+
+// LastLoadStats returns statistics about the last successful load of the database with the specified name.
+// If the database does not exist, returns a NoSuchDatabaseError.
+// If the database has not been initialized, returns a NotInitializedError.
+// If the DomainDb instance has been closed, returns ErrDbClosed.
+func (s *DomainDb) LastLoadStats(name string) (LoadStats, error) {
+	if !s.isRunning.Load() {
+		return LoadStats{}, ErrDbClosed
+	}
+
+	data, has := s.dbs[name]
+	if !has {
+		return LoadStats{}, NewNoSuchDatabaseError(name)
+	}
+
+	tok := data.Mu.RLock()
+	defer data.Mu.RUnlock(tok)
+
+	if !data.Has {
+		return LoadStats{}, NewNotInitializedError(name)
+	}
+
+	return data.LastLoad, nil
+}
+
+// TotalDomainCount returns the total number of domains loaded across all databases.
+// Domains are counted per database, so a domain that is present in multiple databases is counted once for each database.
+// Use UniqueDomainCount to count each distinct domain only once.
+// Databases that have not been initialized contribute zero.
+func (s *DomainDb) TotalDomainCount() int {
+	total := 0
+	for _, data := range s.dbs {
+		tok := data.Mu.RLock()
+		total += len(data.Domains)
+		data.Mu.RUnlock(tok)
+	}
+
+	return total
+}
+
+// UniqueDomainCount returns the number of distinct domains loaded across all databases.
+// A domain that is present in multiple databases is only counted once.
+// This is more expensive than TotalDomainCount because it needs to build a set of all domains.
+// Databases that have not been initialized contribute zero.
+func (s *DomainDb) UniqueDomainCount() int {
+	sets := make([]map[string]struct{}, 0, len(s.dbs))
+	largestIdx := -1
+	for _, data := range s.dbs {
+		tok := data.Mu.RLock()
+		domains := data.Domains
+		data.Mu.RUnlock(tok)
+
+		sets = append(sets, domains)
+		if largestIdx == -1 || len(domains) > len(sets[largestIdx]) {
+			largestIdx = len(sets) - 1
+		}
+	}
+	if largestIdx == -1 {
+		return 0
+	}
+
+	// Count the largest set in full, and only collect domains from the other sets that are not in it.
+	largest := sets[largestIdx]
+	extra := make(map[string]struct{})
+	for i, domains := range sets {
+		if i == largestIdx {
+			continue
+		}
+
+		for domain := range domains {
+			if _, has := largest[domain]; !has {
+				extra[domain] = struct{}{}
+			}
+		}
+	}
+
+	return len(largest) + len(extra)
+}
+
+// Constants used by EstimateMemory.
+const (
+	// The size of a string header (pointer and length) on 64-bit platforms.
+	estimateStringHeaderBytes = 16
+
+	// The per-slot overhead of a map, in addition to the key: one control byte.
+	estimateMapSlotOverheadBytes = 1
+
+	// The maximum load factor of a map, as a fraction.
+	// Maps grow before they are full, so there are more slots than entries.
+	estimateMapLoadFactorNum   = 7
+	estimateMapLoadFactorDenom = 8
+)
+
+// EstimateMemory returns a rough estimate of the number of bytes of memory used by the loaded domains of the database with the specified name.
+// It is intended as a signal for capacity planning, not as an exact measurement.
+//
+// The estimate is the sum of the bytes of every domain, plus a string header and map slot for each domain, accounting for the map's maximum load factor.
+// The actual usage may be higher, because:
+//   - a map may have up to twice as many slots as it needs right after it grows
+//   - allocations are rounded up to the allocator's size classes
+//   - the estimate assumes a 64-bit platform
+//
+// It does not include memory that is not specific to the database, such as the normalizer.
+// If the database does not exist, returns a NoSuchDatabaseError.
+// If the database has not been initialized, returns a NotInitializedError.
+// If the DomainDb instance has been closed, returns ErrDbClosed.
+func (s *DomainDb) EstimateMemory(dbName string) (int64, error) {
+	if !s.isRunning.Load() {
+		return 0, ErrDbClosed
+	}
+
+	data, has := s.dbs[dbName]
+	if !has {
+		return 0, NewNoSuchDatabaseError(dbName)
+	}
+
+	tok := data.Mu.RLock()
+	initialized := data.Has
+	domains := data.Domains
+	data.Mu.RUnlock(tok)
+
+	if !initialized {
+		return 0, NewNotInitializedError(dbName)
+	}
+
+	var keyBytes int64
+	for domain := range domains {
+		keyBytes += int64(len(domain))
+	}
+
+	slots := int64(len(domains)) * estimateMapLoadFactorDenom / estimateMapLoadFactorNum
+	return keyBytes + slots*(estimateStringHeaderBytes+estimateMapSlotOverheadBytes), nil
+}
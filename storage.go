@@ -8,6 +8,8 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"syscall"
 )
 
@@ -15,6 +17,7 @@ import (
 type StorageDriver interface {
 	// WriteDatabase opens the database file with the specified name for writing.
 	// The reader will be closed by the function regardless of whether an error occurs.
+	// If reading the input fails, for example because the data failed to parse while it was being written, the function must return an error and keep the previously written copy intact.
 	WriteDatabase(name string, input io.ReadCloser) error
 
 	// ReadDatabase opens the database file with the specified name for reading.
@@ -29,13 +32,36 @@ type StorageDriver interface {
 	// ReadCheckpoints reads and returns all checkpoints.
 	// The returned checkpoints will never be nil if there is no error.
 	// If checkpoints have not been saved yet, the function will return syscall.ENOENT.
+	// If the saved checkpoints could not be decoded, the function should return an error that wraps ErrCorruptCheckpoints.
 	ReadCheckpoints() (*AllCheckpoints, error)
 }
 
+// DatabaseDeleter is an optional interface for storage drivers that can delete cached databases.
+// DomainDb.ClearCache and DomainDb.ClearAllCache require it, and return an error wrapping ErrUnsupportedByStorageDriver if the storage driver does not implement it.
+type DatabaseDeleter interface {
+	// DeleteDatabase deletes the cached copy of the database with the specified name.
+	// If there is no cached database with the specified name, the function must return nil.
+	DeleteDatabase(name string) error
+}
+
+// DatabaseLister is an optional interface for storage drivers that can list cached databases.
+// DomainDb.ReadCachedDatabases requires it, and returns an error wrapping ErrUnsupportedByStorageDriver if the storage driver does not implement it.
+type DatabaseLister interface {
+	// ListDatabases returns the names of all cached databases, sorted by name.
+	// This includes databases that are not configured, such as ones left over from a previous configuration.
+	ListDatabases() ([]string, error)
+}
+
+var _ DatabaseDeleter = (*FsStorageDriver)(nil)
+var _ DatabaseDeleter = (*MemoryStorageDriver)(nil)
+var _ DatabaseLister = (*FsStorageDriver)(nil)
+var _ DatabaseLister = (*MemoryStorageDriver)(nil)
+
 const fsPermBits = 0644
 const checkpointsFilename = "checkpoints.json"
 
 // FsStorageDriver implements StorageDriver by storing databases and checkpoints inside a data directory.
+// Each database is stored in a file named after the URL query-escaped database name with a ".txt" suffix (e.g. "my%2Flist.txt" for "my/list"), and checkpoints are stored in "checkpoints.json".
 // Use NewFsStorageDriver to create an instance.
 type FsStorageDriver struct {
 	dataDir string
@@ -69,8 +95,16 @@ func NewFsStorageDriver(dataDir string) (*FsStorageDriver, error) {
 	}, nil
 }
 
-// Returns the filename for the specified DB type.
-// If the type valid is invalid, returns ErrInvalidIpdbType.
+// dbNameToFilename returns the filename of the cached copy of the database with the specified name.
+//
+// The filename is url.QueryEscape(name) + ".txt".
+// QueryEscape only leaves ASCII letters, digits, '-', '_', '.' and '~' unescaped, so the filename never contains a path separator, and because escaping is reversible, two different names can never map to the same filename.
+// The ".txt" suffix ensures that the filename is never "." or "..", and never collides with the checkpoints file or temporary files, which end in ".json" and ".tmp".
+//
+// Names are limited to DbNameMaxSize bytes, and escaping at most triples the length, so filenames stay well within the 255-byte limit of common filesystems without needing to hash long names.
+// Note that on case-insensitive filesystems, names that differ only in ASCII letter case share a filename.
+//
+// If the name is longer than DbNameMaxSize, returns ErrDbNameTooLong.
 func (s *FsStorageDriver) dbNameToFilename(name string) (string, error) {
 	if len(name) > DbNameMaxSize {
 		return "", ErrDbNameTooLong
@@ -79,6 +113,9 @@ func (s *FsStorageDriver) dbNameToFilename(name string) (string, error) {
 	return url.QueryEscape(name) + ".txt", nil
 }
 
+// WriteDatabase writes the database to a temporary file, and then renames it over the cached copy once it has been written in full.
+// Renaming is atomic, so if writing fails or the process crashes, the previous cached copy is kept intact, and no partial file is ever visible.
+// Each write uses its own temporary file, so concurrent writes of the same database do not interfere; the last one to finish wins.
 func (s *FsStorageDriver) WriteDatabase(name string, input io.ReadCloser) error {
 	defer func() {
 		_ = input.Close()
@@ -90,39 +127,35 @@ func (s *FsStorageDriver) WriteDatabase(name string, input io.ReadCloser) error
 	}
 
 	filePath := filepath.Join(s.dataDir, filename)
-	bakFilePath := filepath.Join(s.dataDir, filename+".bak")
-
-	backedUp := false
 
-	// Move existing file to backup if it exists.
-	if _, err = os.Stat(filePath); err == nil {
-		err = os.Rename(filePath, bakFilePath)
-		if err != nil {
-			return fmt.Errorf(`failed to move existing file "%s" to backup path "%s": %w`, filePath, bakFilePath, err)
-		}
-
-		backedUp = true
+	// Escaped names never contain '*', so the pattern's only wildcard is the one for the random part.
+	file, err := os.CreateTemp(s.dataDir, filename+".*.tmp")
+	if err != nil {
+		return fmt.Errorf(`failed to create temporary file in "%s" for writing database "%s": %w`, s.dataDir, name, err)
 	}
+	tmpFilePath := file.Name()
 
-	err = nil
-
-	if backedUp {
-		// If the function returns with an error, try to restore the backup.
-		defer func() {
-			if err != nil {
-				_ = os.Rename(bakFilePath, filePath)
-			}
-		}()
+	// CreateTemp creates files that only the current user can read.
+	if err = file.Chmod(fsPermBits); err != nil {
+		_ = file.Close()
+		_ = os.Remove(tmpFilePath)
+		return fmt.Errorf(`failed to set permissions of temporary file "%s" for writing database "%s": %w`, tmpFilePath, name, err)
 	}
 
-	file, err := os.OpenFile(filePath, os.O_CREATE|os.O_WRONLY, fsPermBits)
+	_, err = io.Copy(file, input)
+	closeErr := file.Close()
 	if err != nil {
-		return fmt.Errorf(`failed to open file "%s" for writing database "%s": %w`, filePath, name, err)
+		_ = os.Remove(tmpFilePath)
+		return fmt.Errorf(`failed to copy input to temporary file "%s" for writing database "%s": %w`, tmpFilePath, name, err)
+	}
+	if closeErr != nil {
+		_ = os.Remove(tmpFilePath)
+		return fmt.Errorf(`failed to close temporary file "%s" after writing database "%s": %w`, tmpFilePath, name, closeErr)
 	}
 
-	_, err = io.Copy(file, input)
-	if err != nil {
-		return fmt.Errorf(`failed to copy input to file "%s" for writing database "%s": %w`, filePath, name, err)
+	if err = os.Rename(tmpFilePath, filePath); err != nil {
+		_ = os.Remove(tmpFilePath)
+		return fmt.Errorf(`failed to move temporary file "%s" to "%s" for writing database "%s": %w`, tmpFilePath, filePath, name, err)
 	}
 
 	return nil
@@ -144,9 +177,51 @@ func (s *FsStorageDriver) ReadDatabase(name string) (io.ReadCloser, error) {
 	return file, nil
 }
 
+func (s *FsStorageDriver) DeleteDatabase(name string) error {
+	filename, err := s.dbNameToFilename(name)
+	if err != nil {
+		return err
+	}
+
+	filePath := filepath.Join(s.dataDir, filename)
+
+	if err = os.Remove(filePath); err != nil && !errors.Is(err, syscall.ENOENT) {
+		return fmt.Errorf(`failed to remove file "%s" for database "%s": %w`, filePath, name, err)
+	}
+
+	return nil
+}
+
+// ListDatabases lists the files in the data directory with a ".txt" suffix.
+// Files whose names are not exactly how FsStorageDriver escapes database names are skipped, since it did not write them.
+func (s *FsStorageDriver) ListDatabases() ([]string, error) {
+	entries, err := os.ReadDir(s.dataDir)
+	if err != nil {
+		return nil, fmt.Errorf(`failed to read data directory "%s" for listing databases: %w`, s.dataDir, err)
+	}
+
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		escaped, isDb := strings.CutSuffix(entry.Name(), ".txt")
+		if !isDb || !entry.Type().IsRegular() {
+			continue
+		}
+
+		name, err := url.QueryUnescape(escaped)
+		if err != nil || len(name) > DbNameMaxSize || url.QueryEscape(name) != escaped {
+			continue
+		}
+
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	return names, nil
+}
+
 func (s *FsStorageDriver) WriteCheckpoints(checkpoints *AllCheckpoints) error {
 	filePath := filepath.Join(s.dataDir, checkpointsFilename)
-	file, err := os.OpenFile(filePath, syscall.O_CREAT|syscall.O_WRONLY, fsPermBits)
+	file, err := os.OpenFile(filePath, syscall.O_CREAT|syscall.O_WRONLY|syscall.O_TRUNC, fsPermBits)
 	if err != nil {
 		return fmt.Errorf(`failed to open file "%s" for writing checkpoints: %w`, filePath, err)
 	}
@@ -171,11 +246,18 @@ func (s *FsStorageDriver) ReadCheckpoints() (*AllCheckpoints, error) {
 		return nil, fmt.Errorf(`failed to open file "%s" for reading checkpoints: %w`, filePath, err)
 	}
 
+	defer func() {
+		_ = file.Close()
+	}()
+
 	var res AllCheckpoints
 	dec := json.NewDecoder(file)
 	err = dec.Decode(&res)
 	if err != nil {
-		return nil, fmt.Errorf(`failed to decode checkpoints from JSON file at "%s": %w`, filePath, err)
+		return nil, fmt.Errorf(`failed to decode checkpoints from JSON file at "%s": %w: %w`, filePath, ErrCorruptCheckpoints, err)
+	}
+	if res.Checkpoints == nil {
+		res.Checkpoints = make(map[string]Checkpoint)
 	}
 
 	return &res, nil
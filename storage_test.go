@@ -0,0 +1,398 @@
+package domaindb
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestFsStorageDriver_FilenamesAreDistinctAndSafe(t *testing.T) {
+	s, err := NewFsStorageDriver(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create storage driver: %v", err)
+	}
+
+	names := []string{
+		"disposable",
+		"disposable.txt",
+		"a/b",
+		"a%2Fb",
+		"../../etc/passwd",
+		".",
+		"..",
+		"",
+		"a b",
+		"a+b",
+		"checkpoints",
+		"checkpoints.json",
+		"bücher",
+		"b%C3%BCcher",
+		strings.Repeat("ü", DbNameMaxSize/2),
+	}
+
+	seen := make(map[string]string, len(names))
+	for _, name := range names {
+		filename, err := s.dbNameToFilename(name)
+		if err != nil {
+			t.Fatalf("%q: unexpected err: %v", name, err)
+		}
+
+		if strings.ContainsAny(filename, `/\`) {
+			t.Fatalf("%q: filename %q contains a path separator", name, filename)
+		}
+		if filename == "." || filename == ".." || filename == checkpointsFilename {
+			t.Fatalf("%q: filename %q is reserved", name, filename)
+		}
+		if len(filename) > 255 {
+			t.Fatalf("%q: filename %q is too long", name, filename)
+		}
+		if other, has := seen[filename]; has {
+			t.Fatalf("%q and %q map to the same filename %q", name, other, filename)
+		}
+		seen[filename] = name
+	}
+}
+
+func TestFsStorageDriver_NameTooLong(t *testing.T) {
+	s, err := NewFsStorageDriver(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create storage driver: %v", err)
+	}
+
+	if _, err = s.dbNameToFilename(strings.Repeat("a", DbNameMaxSize+1)); err != ErrDbNameTooLong {
+		t.Fatalf("expected ErrDbNameTooLong, got %v", err)
+	}
+}
+
+func TestFsStorageDriver_WriteAndReadUnusualNames(t *testing.T) {
+	s, err := NewFsStorageDriver(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create storage driver: %v", err)
+	}
+
+	names := []string{"a/b", "../escape", "bücher"}
+	for _, name := range names {
+		if err = s.WriteDatabase(name, io.NopCloser(strings.NewReader(name))); err != nil {
+			t.Fatalf("%q: failed to write: %v", name, err)
+		}
+	}
+
+	for _, name := range names {
+		reader, err := s.ReadDatabase(name)
+		if err != nil {
+			t.Fatalf("%q: failed to read: %v", name, err)
+		}
+		got, err := io.ReadAll(reader)
+		_ = reader.Close()
+		if err != nil {
+			t.Fatalf("%q: failed to read: %v", name, err)
+		}
+		if string(got) != name {
+			t.Fatalf("%q: got %q", name, got)
+		}
+	}
+}
+
+func TestMemoryStorageDriver(t *testing.T) {
+	storage := NewMemoryStorageDriver()
+
+	if _, err := storage.ReadDatabase("test"); !errors.Is(err, syscall.ENOENT) {
+		t.Fatalf("expected syscall.ENOENT for missing database, got %v", err)
+	}
+	if _, err := storage.ReadCheckpoints(); !errors.Is(err, syscall.ENOENT) {
+		t.Fatalf("expected syscall.ENOENT for missing checkpoints, got %v", err)
+	}
+
+	options := Options{
+		StorageDriver: storage,
+		Logger:        slog.New(slog.DiscardHandler),
+		Sources: map[string]*DataSource{
+			"test": {
+				RefreshInterval: time.Hour,
+				Get: func() (io.ReadCloser, error) {
+					return io.NopCloser(strings.NewReader("example.com\n")), nil
+				},
+			},
+		},
+	}
+
+	db, err := NewDomainDb(options)
+	if err != nil {
+		t.Fatalf("failed to create DomainDb: %v", err)
+	}
+	if err = db.Close(); err != nil {
+		t.Fatalf("failed to close DomainDb: %v", err)
+	}
+
+	// The second instance must load the database from the in-memory cache.
+	options.Sources["test"].Get = func() (io.ReadCloser, error) {
+		return nil, errors.New("unexpected download")
+	}
+	db, err = NewDomainDb(options)
+	if err != nil {
+		t.Fatalf("failed to create DomainDb: %v", err)
+	}
+	defer func() {
+		_ = db.Close()
+	}()
+
+	stats, err := db.LastLoadStats("test")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if stats.Source != LoadSourceCache || stats.UniqueDomains != 1 {
+		t.Fatalf("expected database to be loaded from cache, got %+v", stats)
+	}
+}
+
+func TestFsStorageDriver_FailedWriteKeepsPreviousCopy(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewFsStorageDriver(dir)
+	if err != nil {
+		t.Fatalf("failed to create storage driver: %v", err)
+	}
+
+	readErr := errors.New("parse failed")
+	failing := func() io.ReadCloser {
+		return io.NopCloser(&failingReader{data: strings.NewReader("partial.example.com\n"), err: readErr})
+	}
+
+	// Without a previous copy, a failed write must not leave a partial one behind.
+	if err = s.WriteDatabase("test", failing()); !errors.Is(err, readErr) {
+		t.Fatalf("got err %v, want %v", err, readErr)
+	}
+	if _, err = s.ReadDatabase("test"); !errors.Is(err, syscall.ENOENT) {
+		t.Fatalf("got err %v, want ENOENT", err)
+	}
+
+	if err = s.WriteDatabase("test", io.NopCloser(strings.NewReader("good.example.com\n"))); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+	if err = s.WriteDatabase("test", failing()); !errors.Is(err, readErr) {
+		t.Fatalf("got err %v, want %v", err, readErr)
+	}
+
+	reader, err := s.ReadDatabase("test")
+	if err != nil {
+		t.Fatalf("failed to read: %v", err)
+	}
+	got, err := io.ReadAll(reader)
+	_ = reader.Close()
+	if err != nil {
+		t.Fatalf("failed to read: %v", err)
+	}
+	if want := "good.example.com\n"; string(got) != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+
+	// No temporary files may be left behind.
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("failed to read data directory: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected only the cached copy in the data directory, got %v", entries)
+	}
+}
+
+// chunkReader reads at most size bytes at a time from r, and yields to other goroutines between reads.
+type chunkReader struct {
+	r    io.Reader
+	size int
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	runtime.Gosched()
+	return r.r.Read(p[:min(len(p), r.size)])
+}
+
+func TestFsStorageDriver_ConcurrentWrites(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewFsStorageDriver(dir)
+	if err != nil {
+		t.Fatalf("failed to create storage driver: %v", err)
+	}
+
+	// Each writer writes a list made of a single repeated line, so a mix of writers is detectable.
+	const writers = 8
+	contents := make([]string, writers)
+	for i := range contents {
+		contents[i] = strings.Repeat(fmt.Sprintf("writer%d.example.com\n", i), 10000)
+	}
+
+	for range 10 {
+		var wg sync.WaitGroup
+		for _, content := range contents {
+			wg.Go(func() {
+				// Copy the content in small chunks, so that the writes overlap.
+				input := io.NopCloser(&chunkReader{r: strings.NewReader(content), size: 4096})
+				if err := s.WriteDatabase("test", input); err != nil {
+					t.Errorf("failed to write: %v", err)
+				}
+			})
+		}
+		wg.Wait()
+
+		reader, err := s.ReadDatabase("test")
+		if err != nil {
+			t.Fatalf("failed to read: %v", err)
+		}
+		got, err := io.ReadAll(reader)
+		_ = reader.Close()
+		if err != nil {
+			t.Fatalf("failed to read: %v", err)
+		}
+		if !slices.Contains(contents, string(got)) {
+			t.Fatalf("cached copy is a mix of concurrent writes (%d bytes)", len(got))
+		}
+	}
+
+	// No temporary files may be left behind.
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("failed to read data directory: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected only the cached copy in the data directory, got %v", entries)
+	}
+}
+
+func TestStorageDriver_DeleteDatabase(t *testing.T) {
+	fs, err := NewFsStorageDriver(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create storage driver: %v", err)
+	}
+
+	for name, s := range map[string]interface {
+		StorageDriver
+		DatabaseDeleter
+	}{
+		"fs":     fs,
+		"memory": NewMemoryStorageDriver(),
+	} {
+		if err := s.DeleteDatabase("a/b"); err != nil {
+			t.Fatalf("%s: failed to delete missing database: %v", name, err)
+		}
+
+		if err := s.WriteDatabase("a/b", io.NopCloser(strings.NewReader("example.com\n"))); err != nil {
+			t.Fatalf("%s: failed to write: %v", name, err)
+		}
+		if err := s.DeleteDatabase("a/b"); err != nil {
+			t.Fatalf("%s: failed to delete: %v", name, err)
+		}
+		if _, err := s.ReadDatabase("a/b"); !errors.Is(err, syscall.ENOENT) {
+			t.Fatalf("%s: expected syscall.ENOENT after delete, got %v", name, err)
+		}
+	}
+}
+
+func TestStorageDriver_ListDatabases(t *testing.T) {
+	dir := t.TempDir()
+	fs, err := NewFsStorageDriver(dir)
+	if err != nil {
+		t.Fatalf("failed to create storage driver: %v", err)
+	}
+
+	// Files that FsStorageDriver did not write as databases are not listed.
+	for _, filename := range []string{"a%20b.txt", "notes.md", "list.txt.tmp"} {
+		if err := os.WriteFile(filepath.Join(dir, filename), nil, fsPermBits); err != nil {
+			t.Fatalf("failed to write %s: %v", filename, err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "dir.txt"), 0755); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+
+	for name, s := range map[string]interface {
+		StorageDriver
+		DatabaseLister
+	}{
+		"fs":     fs,
+		"memory": NewMemoryStorageDriver(),
+	} {
+		if err := s.WriteCheckpoints(&AllCheckpoints{Checkpoints: map[string]Checkpoint{}}); err != nil {
+			t.Fatalf("%s: failed to write checkpoints: %v", name, err)
+		}
+		for _, db := range []string{"b", "a/b", "a"} {
+			if err := s.WriteDatabase(db, io.NopCloser(strings.NewReader("example.com\n"))); err != nil {
+				t.Fatalf("%s: failed to write %q: %v", name, db, err)
+			}
+		}
+
+		got, err := s.ListDatabases()
+		if err != nil {
+			t.Fatalf("%s: failed to list databases: %v", name, err)
+		}
+		if want := []string{"a", "a/b", "b"}; !slices.Equal(got, want) {
+			t.Fatalf("%s: got %q, want %q", name, got, want)
+		}
+	}
+}
+
+// minimalStorageDriver implements only StorageDriver, and none of the optional storage interfaces.
+type minimalStorageDriver struct {
+	storage StorageDriver
+}
+
+func (s minimalStorageDriver) WriteDatabase(name string, input io.ReadCloser) error {
+	return s.storage.WriteDatabase(name, input)
+}
+
+func (s minimalStorageDriver) ReadDatabase(name string) (io.ReadCloser, error) {
+	return s.storage.ReadDatabase(name)
+}
+
+func (s minimalStorageDriver) WriteCheckpoints(checkpoints *AllCheckpoints) error {
+	return s.storage.WriteCheckpoints(checkpoints)
+}
+
+func (s minimalStorageDriver) ReadCheckpoints() (*AllCheckpoints, error) {
+	return s.storage.ReadCheckpoints()
+}
+
+func TestOptionalStorageInterfaces(t *testing.T) {
+	db, err := NewDomainDb(Options{
+		StorageDriver: minimalStorageDriver{storage: NewMemoryStorageDriver()},
+		TempDir:       t.TempDir(),
+		Logger:        slog.New(slog.DiscardHandler),
+		Sources: map[string]*DataSource{
+			"test": {
+				RefreshInterval: time.Hour,
+				Get: func() (io.ReadCloser, error) {
+					return io.NopCloser(strings.NewReader("example.com\n")), nil
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create DomainDb: %v", err)
+	}
+	defer func() {
+		_ = db.Close()
+	}()
+
+	if err = db.ClearCache("test"); !errors.Is(err, ErrUnsupportedByStorageDriver) {
+		t.Fatalf("ClearCache: got err %v, want ErrUnsupportedByStorageDriver", err)
+	}
+	if err = db.ClearAllCache(); !errors.Is(err, ErrUnsupportedByStorageDriver) {
+		t.Fatalf("ClearAllCache: got err %v, want ErrUnsupportedByStorageDriver", err)
+	}
+	if _, err = db.ReadCachedDatabases(); !errors.Is(err, ErrUnsupportedByStorageDriver) {
+		t.Fatalf("ReadCachedDatabases: got err %v, want ErrUnsupportedByStorageDriver", err)
+	}
+
+	// The cached copy must be untouched.
+	if _, err = db.ReadRawDatabase("test"); err != nil {
+		t.Fatalf("expected cached copy to be kept, got %v", err)
+	}
+}
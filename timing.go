@@ -0,0 +1,56 @@
+package domaindb
+
+import (
+	"context"
+	"time"
+)
+
+// LookupTiming is how long each stage of a lookup took.
+// See DomainDb.DoesDbHaveDomainTimed.
+type LookupTiming struct {
+	// The time spent normalizing and transforming the domain.
+	Normalize time.Duration
+
+	// The time spent looking up the normalized domain in the database, including waiting for its lock.
+	// 0 if normalization failed.
+	Lookup time.Duration
+}
+
+// Total returns the total time spent on the lookup.
+func (t LookupTiming) Total() time.Duration {
+	return t.Normalize + t.Lookup
+}
+
+// DoesDbHaveDomainTimed is like DoesDbHaveDomain, but also returns how long normalization and the lookup itself took.
+// It is meant for profiling, such as sampling a fraction of lookups to see whether normalization dominates their cost.
+// Reading the clock adds overhead, so use DoesDbHaveDomain for lookups that do not need timing.
+//
+// The timing is returned even if there is an error, as long as the database exists and the DomainDb instance has not been closed.
+// If the database does not exist, returns a NoSuchDatabaseError.
+// If the database has not been initialized, returns a NotInitializedError.
+// If the DomainDb instance has been closed, returns ErrDbClosed.
+func (s *DomainDb) DoesDbHaveDomainTimed(dbName string, domain string) (bool, LookupTiming, error) {
+	var timing LookupTiming
+
+	if !s.isRunning.Load() {
+		return false, timing, ErrDbClosed
+	}
+
+	data, has := s.dbs[dbName]
+	if !has {
+		return false, timing, NewNoSuchDatabaseError(dbName)
+	}
+
+	start := time.Now()
+	normalized, err := s.lookupCache.normalize(s.normalizer, s.transform, domain)
+	timing.Normalize = time.Since(start)
+	if err != nil {
+		return false, timing, err
+	}
+
+	start = time.Now()
+	_, found, err := data.lookupNormalized(context.Background(), dbName, normalized)
+	timing.Lookup = time.Since(start)
+
+	return found, timing, err
+}
@@ -0,0 +1,27 @@
+package domaindb
+
+import (
+	"github.com/termermc/go-domaindb/normalize"
+)
+
+// normalizeAndTransform normalizes the domain, then applies the transform to it if the transform is not nil.
+// Loaded and queried domains must both go through this function, so that matching stays symmetric.
+// If the transform drops the domain, returns an empty string.
+// Normalized domains are never empty, so an empty string never matches any entry.
+func normalizeAndTransform(normalizer *normalize.DomainNormalizer, transform func(string) (string, bool), domain string) (string, error) {
+	normalized, err := normalizer.NormalizeDomain(domain)
+	if err != nil {
+		return "", err
+	}
+
+	if transform == nil {
+		return normalized, nil
+	}
+
+	transformed, keep := transform(normalized)
+	if !keep {
+		return "", nil
+	}
+
+	return transformed, nil
+}